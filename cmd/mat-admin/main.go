@@ -9,9 +9,12 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/remisb/mat/cmd/mat-admin/internal/conf"
+	"github.com/remisb/mat/internal/audit"
 	"github.com/remisb/mat/internal/auth"
 	"github.com/remisb/mat/internal/db"
 	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/org"
+	"github.com/remisb/mat/internal/restaurant"
 	"github.com/remisb/mat/internal/schema"
 	"github.com/remisb/mat/internal/user"
 	"os"
@@ -64,9 +67,15 @@ func run(cfg *conf.Config) error {
 	case "seed":
 		err = seed(cfg.Db)
 	case "useradd":
-		err = userAdd(cfg.Db, cfg.Args.Num(1), cfg.Args.Num(2))
+		err = userAdd(cfg.Db, cfg.Args.Num(1), cfg.Args.Num(2), cfg.Args.Num(3))
 	case "keygen":
 		err = keygen(cfg.Args.Num(1))
+	case "unlock":
+		err = unlock(cfg.Db, cfg.Args.Num(1), cfg.Args.Num(2))
+	case "purge":
+		err = purgeRestaurant(cfg.Db, cfg.Args.Num(1), cfg.Args.Num(2))
+	case "org":
+		err = orgCommand(cfg.Db, cfg.Args.Num(1), cfg.Args.Num(2))
 	default:
 		err = errors.New("Must specify a command")
 	}
@@ -104,7 +113,9 @@ func seed(cfg db.Config) error {
 	return nil
 }
 
-func userAdd(cfg db.Config, email, password string) error {
+// userAdd creates admin of the organization with passed ID. Without organization
+// super admin of the default organization is created.
+func userAdd(cfg db.Config, email, password, orgID string) error {
 	dbc, err := db.Open(cfg)
 	if err != nil {
 		return err
@@ -115,17 +126,27 @@ func userAdd(cfg db.Config, email, password string) error {
 		return errors.New("useradd command must be called with two additional arguments for email and password")
 	}
 
-	fmt.Printf("Admin user will be created with email %q and password %q\n", email, password)
+	roles := []string{auth.RoleUser, auth.RoleAdmin}
+	if orgID == "" {
+		roles = append(roles, auth.RoleSuperAdmin)
+	}
+
+	ctx := orgContext(orgID)
+	o, err := org.NewRepo(dbc).Retrieve(ctx, tenant(orgID))
+	if err != nil {
+		return errors.Wrap(err, "organization")
+	}
+
+	fmt.Printf("User with roles %v will be created in organization %q with email %q and password %q\n",
+		roles, o.Name, email, password)
 	confirm := askForConfirmation("Continue?")
 	if !confirm {
 		fmt.Println("Canceling")
 		return nil
 	}
 
-	ctx := context.Background()
-
 	userRepo := user.NewRepo(dbc)
-	u, err := userRepo.Create(ctx, "", email, password, []string{auth.RoleUser, auth.RoleAdmin}, time.Now())
+	u, err := userRepo.Create(ctx, "", email, password, roles, time.Now())
 	if err != nil {
 		return err
 	}
@@ -134,6 +155,148 @@ func userAdd(cfg db.Config, email, password string) error {
 	return nil
 }
 
+// unlock removes lock of the account locked after failed login attempts.
+func unlock(cfg db.Config, email, orgID string) error {
+	if email == "" {
+		return errors.New("unlock command must be called with additional argument for email")
+	}
+
+	dbc, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer dbc.Close()
+
+	userRepo := user.NewRepo(dbc)
+	auditRepo := audit.NewRepo(dbc)
+	err = auditRepo.InTx(orgContext(orgID), func(ctx context.Context) error {
+		if err := userRepo.Unlock(ctx, email); err != nil {
+			return err
+		}
+		ne := audit.NewEvent{Action: audit.ActionUnlock, TargetType: audit.TargetUser, TargetEmail: email}
+		return auditRepo.Record(ctx, ne)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Account unlocked:", email)
+	return nil
+}
+
+// purgeRestaurant permanently removes restaurant with all its menus and votes.
+// It reports what is going to be removed and asks for confirmation.
+func purgeRestaurant(cfg db.Config, restaurantID, orgID string) error {
+	if restaurantID == "" {
+		return errors.New("purge command must be called with additional argument for restaurant id")
+	}
+
+	dbc, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer dbc.Close()
+
+	ctx := orgContext(orgID)
+
+	restaurantRepo := restaurant.NewRepo(dbc)
+	report, err := restaurantRepo.PurgeReport(ctx, restaurantID)
+	if err != nil {
+		return err
+	}
+
+	state := "active"
+	if report.Restaurant.DeletedAt != nil {
+		state = "deleted at " + report.Restaurant.DeletedAt.Format(time.RFC3339)
+	}
+	fmt.Printf("Restaurant %q (%s, %s) will be permanently removed together with:\n",
+		report.Restaurant.Name, report.Restaurant.ID, state)
+	fmt.Printf("  menus: %d\n", report.Menus)
+	fmt.Printf("  votes: %d\n", report.Votes)
+	confirm := askForConfirmation("Continue?")
+	if !confirm {
+		fmt.Println("Canceling")
+		return nil
+	}
+
+	auditRepo := audit.NewRepo(dbc)
+	err = auditRepo.InTx(ctx, func(ctx context.Context) error {
+		if err := restaurantRepo.PurgeRestaurant(ctx, restaurantID); err != nil {
+			return err
+		}
+		ne := audit.NewEvent{Action: audit.ActionPurge, TargetType: audit.TargetRestaurant, TargetID: restaurantID,
+			Before: report}
+		return auditRepo.Record(ctx, ne)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Restaurant purged:", restaurantID)
+	return nil
+}
+
+// orgCommand manages organizations, create subcommand creates organization with
+// passed name and list subcommand prints existing organizations.
+func orgCommand(cfg db.Config, cmd, name string) error {
+	if cmd != "create" && cmd != "list" {
+		return errors.New("org command must be called with create or list subcommand")
+	}
+	if cmd == "create" && name == "" {
+		return errors.New("org create command must be called with additional argument for organization name")
+	}
+
+	dbc, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer dbc.Close()
+
+	ctx := context.Background()
+	orgRepo := org.NewRepo(dbc)
+
+	if cmd == "list" {
+		orgs, err := orgRepo.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, o := range orgs {
+			fmt.Printf("%s  %s\n", o.ID, o.Name)
+		}
+		return nil
+	}
+
+	var o *org.Organization
+	auditRepo := audit.NewRepo(dbc)
+	err = auditRepo.InTx(ctx, func(ctx context.Context) error {
+		if o, err = orgRepo.Create(ctx, org.NewOrganization{Name: name}, time.Now()); err != nil {
+			return err
+		}
+		ne := audit.NewEvent{OrgID: o.ID, Action: audit.ActionCreate, TargetType: audit.TargetOrg, TargetID: o.ID, After: o}
+		return auditRepo.Record(ctx, ne)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Organization created with id:", o.ID)
+	fmt.Println("Create its admin with: mat-admin useradd <email> <password>", o.ID)
+	return nil
+}
+
+// tenant returns passed organization ID or the default organization when it is empty.
+func tenant(orgID string) string {
+	if orgID == "" {
+		return org.DefaultID
+	}
+	return orgID
+}
+
+// orgContext returns context scoped to the organization commands are performed in.
+func orgContext(orgID string) context.Context {
+	return org.NewContext(context.Background(), tenant(orgID))
+}
+
 // keygen creates an x509 private key for signing auth tokens.
 func keygen(path string) error {
 	if path == "" {
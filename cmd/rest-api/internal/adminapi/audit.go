@@ -0,0 +1,80 @@
+package adminapi
+
+import (
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/db"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// handleAuditGet godoc
+// @Summary List audit events
+// @Description get recorded security audit events, the latest first, available only for admin
+// @Tags admin
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param actor query string false "ID of the user performed the action"
+// @Param action query string false "action like login, update or delete"
+// @Param target query string false "target type like user, restaurant or menu"
+// @Param targetId query string false "target ID"
+// @Param from query string false "period start date" Format(date)
+// @Param to query string false "period end date inclusive" Format(date)
+// @Param page query int false "page number starting from 1"
+// @Success 200 {array} audit.Event
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /admin/audit [get]
+func (s *Server) handleAuditGet(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := audit.Filter{
+		ActorID:    q.Get("actor"),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target"),
+		TargetID:   q.Get("targetId"),
+	}
+
+	from, err := web.QueryDate(r, "from", time.Time{})
+	if err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if !from.IsZero() {
+		f.From = &from
+	}
+
+	to, err := web.QueryDate(r, "to", time.Time{})
+	if err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if !to.IsZero() {
+		until := to.AddDate(0, 0, 1)
+		f.To = &until
+	}
+
+	page := 1
+	if value := q.Get("page"); value != "" {
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			web.RespondError(w, r, http.StatusBadRequest, "page should be a positive number")
+			return
+		}
+	}
+
+	events, err := s.auditRepo.List(r.Context(), f, page)
+	if err != nil {
+		switch err {
+		case db.ErrInvalidID:
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, events)
+}
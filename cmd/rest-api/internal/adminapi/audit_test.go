@@ -0,0 +1,93 @@
+package adminapi
+
+import (
+	"github.com/gavv/httpexpect/v2"
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/restaurantapi"
+	"github.com/remisb/mat/cmd/rest-api/internal/userapi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/restaurant"
+	"github.com/remisb/mat/internal/tests"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAudit(t *testing.T) {
+	adminTest := tests.NewTest(t)
+	t.Cleanup(adminTest.Cleanup)
+	web.Keys = adminTest.Keys
+	r := chi.NewRouter()
+
+	userServer := userapi.NewServer("testing", nil, adminTest.Dbx, userapi.Registration{})
+	restaurantServer := restaurantapi.NewServer("testing", nil, adminTest.Dbx)
+	adminServer := NewServer("testing", nil, adminTest.Dbx)
+	r.Route("/api/v1/", func(r chi.Router) {
+		r.Mount("/users", userServer.Router)
+		r.Mount("/restaurant", restaurantServer.Router)
+		r.Mount("/admin", adminServer.Router)
+	})
+
+	adminTest.SetupTestUsers(t)
+
+	testServer := httptest.NewServer(r)
+	e := httpexpect.New(t, testServer.URL)
+	authAdmin := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+adminTest.Admin.Token)
+	})
+
+	e.GET("/api/v1/users/token").
+		WithBasicAuth("user1@example.com", "wrong").
+		Expect().Status(http.StatusUnauthorized)
+
+	rest := authAdmin.POST("/api/v1/restaurant").
+		WithJSON(restaurant.NewRestaurant{Name: "Audited", Address: "Gedimino pr. 1, Vilnius"}).
+		Expect().Status(http.StatusCreated).
+		JSON().Object()
+	restaurantID := rest.Value("id").String().Raw()
+
+	e.GET("/api/v1/admin/audit").
+		WithHeader("Authorization", "Bearer "+adminTest.User.Token).
+		Expect().Status(http.StatusForbidden)
+
+	failed := authAdmin.GET("/api/v1/admin/audit").
+		WithQuery("action", audit.ActionLoginFailed).
+		Expect().Status(http.StatusOK).
+		JSON().Array()
+	failed.Length().Equal(1)
+	// email of failed login is stored as ID of the user
+	failed.Element(0).Object().ValueEqual("targetId", adminTest.User1.UserID)
+	failed.Element(0).Object().Value("ip").String().NotEmpty()
+
+	created := authAdmin.GET("/api/v1/admin/audit").
+		WithQuery("target", audit.TargetRestaurant).
+		WithQuery("targetId", restaurantID).
+		Expect().Status(http.StatusOK).
+		JSON().Array()
+	created.Length().Equal(1)
+	event := created.Element(0).Object()
+	event.ValueEqual("action", audit.ActionCreate)
+	event.ValueEqual("actorId", adminTest.Admin.UserID)
+	event.Value("before").Null()
+	event.Path("$.after.name").Equal("Audited")
+
+	authAdmin.GET("/api/v1/admin/audit").
+		WithQuery("actor", adminTest.Admin.UserID).
+		WithQuery("from", "2020-03-01").
+		WithQuery("to", "2020-03-31").
+		Expect().Status(http.StatusOK).
+		JSON().Array().Empty()
+
+	authAdmin.GET("/api/v1/admin/audit").
+		WithQuery("page", "0").
+		Expect().Status(http.StatusBadRequest)
+
+	// recorded events can not be changed
+	if _, err := adminTest.Dbx.Exec(`UPDATE audit_event SET action = 'none'`); err == nil {
+		t.Error("expected audit events update to fail")
+	}
+	if _, err := adminTest.Dbx.Exec(`DELETE FROM audit_event`); err == nil {
+		t.Error("expected audit events delete to fail")
+	}
+}
@@ -0,0 +1,108 @@
+package adminapi
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/org"
+	"net/http"
+	"time"
+)
+
+// handleOrgsGet godoc
+// @Summary List organizations
+// @Description get all organizations, available only for super admin
+// @Tags admin
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {array} org.Organization
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /admin/orgs [get]
+func (s *Server) handleOrgsGet(w http.ResponseWriter, r *http.Request) {
+	orgs, err := s.orgRepo.List(r.Context())
+	if err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	web.Respond(w, r, http.StatusOK, orgs)
+}
+
+// handleOrgCreate godoc
+// @Summary Create organization
+// @Description create a new organization, its admins are created with X-Org-ID header, available only for super admin
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param org body org.NewOrganization true "New organization"
+// @Success 201 {object} org.Organization
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /admin/orgs [post]
+func (s *Server) handleOrgCreate(w http.ResponseWriter, r *http.Request) {
+	var no org.NewOrganization
+	if err := web.DecodeBody(r, &no); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read organization from request", err)
+		return
+	}
+
+	// creation is recorded in the new organization so its admins see it
+	var o *org.Organization
+	err := web.RecordAudit(s.auditRepo, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if o, err = s.orgRepo.Create(ctx, no, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{OrgID: o.ID, Action: audit.ActionCreate, TargetType: audit.TargetOrg,
+			TargetID: o.ID, After: o}, nil
+	})
+	if err != nil {
+		switch err {
+		case org.ErrInvalidName:
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		case org.ErrOrgExists:
+			web.RespondError(w, r, http.StatusConflict, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	web.Respond(w, r, http.StatusCreated, o)
+}
+
+// handleOrgGet godoc
+// @Summary Get organization
+// @Description get organization by ID, available only for super admin
+// @Tags admin
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param orgID path string true "Organization ID"
+// @Success 200 {object} org.Organization
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /admin/orgs/{orgID} [get]
+func (s *Server) handleOrgGet(w http.ResponseWriter, r *http.Request) {
+	o, err := s.orgRepo.Retrieve(r.Context(), chi.URLParam(r, "orgID"))
+	if err != nil {
+		switch err {
+		case db.ErrInvalidID:
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		case db.ErrNotFound:
+			web.RespondError(w, r, http.StatusNotFound, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	web.Respond(w, r, http.StatusOK, o)
+}
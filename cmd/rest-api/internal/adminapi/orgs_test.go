@@ -0,0 +1,150 @@
+package adminapi
+
+import (
+	"github.com/gavv/httpexpect/v2"
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/restaurantapi"
+	"github.com/remisb/mat/cmd/rest-api/internal/userapi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/org"
+	"github.com/remisb/mat/internal/restaurant"
+	"github.com/remisb/mat/internal/tests"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOrganizations(t *testing.T) {
+	orgTest := tests.NewTest(t)
+	t.Cleanup(orgTest.Cleanup)
+	web.Keys = orgTest.Keys
+	r := chi.NewRouter()
+
+	userServer := userapi.NewServer("testing", nil, orgTest.Dbx, userapi.Registration{})
+	restaurantServer := restaurantapi.NewServer("testing", nil, orgTest.Dbx)
+	adminServer := NewServer("testing", nil, orgTest.Dbx)
+	r.Route("/api/v1/", func(r chi.Router) {
+		r.Mount("/users", userServer.Router)
+		r.Mount("/restaurant", restaurantServer.Router)
+		r.Mount("/admin", adminServer.Router)
+	})
+
+	orgTest.SetupTestUsers(t)
+
+	testServer := httptest.NewServer(r)
+	e := httpexpect.New(t, testServer.URL)
+	superAdmin := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+orgTest.Admin.Token)
+	})
+
+	const (
+		lokysID     = "5828612a-1f8a-403c-b6d1-6cb66fbf0c66"
+		lokysMenuID = "4058d981-0df1-45de-807e-b8e90bcb2d80"
+	)
+
+	superAdmin.GET("/api/v1/admin/orgs").
+		Expect().Status(http.StatusOK).
+		JSON().Array().Path("$..id").Array().Contains(org.DefaultID)
+
+	superAdmin.POST("/api/v1/admin/orgs").
+		WithJSON(org.NewOrganization{Name: " "}).
+		Expect().Status(http.StatusBadRequest)
+
+	orgB := superAdmin.POST("/api/v1/admin/orgs").
+		WithJSON(org.NewOrganization{Name: "Org B"}).
+		Expect().Status(http.StatusCreated).
+		JSON().Object()
+	orgBID := orgB.Value("id").String().Raw()
+
+	superAdmin.POST("/api/v1/admin/orgs").
+		WithJSON(org.NewOrganization{Name: "Org B"}).
+		Expect().Status(http.StatusConflict)
+
+	superAdmin.GET("/api/v1/admin/orgs/{orgID}", orgBID).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("name", "Org B")
+
+	superAdmin.GET("/api/v1/admin/orgs/{orgID}", "invalid").
+		Expect().Status(http.StatusBadRequest)
+
+	// super admin creates admin of the new organization
+	superAdmin.POST("/api/v1/users").
+		WithHeader(web.HeaderOrgID, orgBID).
+		WithJSON(user.NewUser{
+			Name:            "Org B Admin",
+			Email:           "admin@b.example.com",
+			Roles:           []string{auth.RoleAdmin, auth.RoleUser},
+			Password:        "gophers",
+			PasswordConfirm: "gophers",
+		}).
+		Expect().Status(http.StatusCreated).
+		JSON().Object().ValueEqual("orgId", orgBID)
+
+	adminBToken := e.GET("/api/v1/users/token").
+		WithBasicAuth("admin@b.example.com", "gophers").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("token").String().Raw()
+	adminB := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+adminBToken)
+	})
+
+	// data of the default organization is not visible
+	adminB.GET("/api/v1/restaurant").
+		Expect().Status(http.StatusOK).
+		JSON().Array().Empty()
+	adminB.GET("/api/v1/restaurant/{restaurantId}", lokysID).
+		Expect().Status(http.StatusNotFound)
+	adminB.POST("/api/v1/restaurant/{restaurantId}/menu/{menuId}/vote", lokysID, lokysMenuID).
+		Expect().Status(http.StatusNotFound)
+	adminB.GET("/api/v1/users/{userID}", orgTest.User.UserID).
+		Expect().Status(http.StatusNotFound)
+	adminB.GET("/api/v1/admin/audit").
+		Expect().Status(http.StatusOK).
+		JSON().Array().Path("$..orgId").Array().ContainsOnly(orgBID)
+
+	users := adminB.GET("/api/v1/users").
+		Expect().Status(http.StatusOK).
+		JSON().Array()
+	users.Length().Equal(1)
+	users.Element(0).Object().ValueEqual("email", "admin@b.example.com")
+
+	// organization admin can not switch organization or manage organizations
+	adminB.GET("/api/v1/restaurant").
+		WithHeader(web.HeaderOrgID, org.DefaultID).
+		Expect().Status(http.StatusForbidden)
+	adminB.GET("/api/v1/admin/orgs").
+		Expect().Status(http.StatusForbidden)
+	adminB.PUT("/api/v1/users/{userID}/roles", orgTest.Admin.UserID).
+		WithJSON(map[string][]string{"roles": {auth.RoleSuperAdmin}}).
+		Expect().Status(http.StatusNotFound)
+
+	restB := adminB.POST("/api/v1/restaurant").
+		WithJSON(restaurant.NewRestaurant{Name: "Org B Bistro", Address: "Gedimino pr. 2, Vilnius"}).
+		Expect().Status(http.StatusCreated).
+		JSON().Object()
+	restB.ValueEqual("orgId", orgBID)
+	restBID := restB.Value("id").String().Raw()
+
+	// restaurant of the new organization is not visible in the default organization
+	e.GET("/api/v1/restaurant/{restaurantId}", restBID).
+		WithHeader("Authorization", "Bearer "+orgTest.User.Token).
+		Expect().Status(http.StatusNotFound)
+	e.GET("/api/v1/restaurant/{restaurantId}", restBID).
+		Expect().Status(http.StatusNotFound)
+
+	// super admin can select organization
+	superAdmin.GET("/api/v1/restaurant/{restaurantId}", restBID).
+		WithHeader(web.HeaderOrgID, orgBID).
+		Expect().Status(http.StatusOK)
+
+	// only super admin can grant super admin role
+	adminBUsers := adminB.GET("/api/v1/users").
+		Expect().Status(http.StatusOK).
+		JSON().Array()
+	adminBID := adminBUsers.Element(0).Object().Value("id").String().Raw()
+	adminB.PUT("/api/v1/users/{userID}/roles", adminBID).
+		WithJSON(map[string][]string{"roles": {auth.RoleSuperAdmin, auth.RoleAdmin}}).
+		Expect().Status(http.StatusForbidden)
+}
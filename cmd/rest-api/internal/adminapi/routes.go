@@ -0,0 +1,34 @@
+package adminapi
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/authorize"
+)
+
+func (s *Server) initRoutes() {
+	if s.Router == nil {
+		// /api/v1/admin
+		admin := chi.NewMux()
+		admin.Use(web.CorsHandler)
+
+		authenticator := *s.authenticator
+		admin.Group(func(r chi.Router) {
+			r.Use(web.Verifier(authenticator.Keys(), authenticator))
+			r.Use(web.Authenticator)
+			r.Use(web.Tenant)
+			r.Use(web.RequireScope())
+
+			r.With(web.Allow(authorize.ObjectAudit, authorize.ActionRead)).
+				Get("/audit", s.handleAuditGet)
+			r.With(web.Allow(authorize.ObjectOrg, authorize.ActionRead)).
+				Get("/orgs", s.handleOrgsGet)
+			r.With(web.Allow(authorize.ObjectOrg, authorize.ActionCreate)).
+				Post("/orgs", s.handleOrgCreate)
+			r.With(web.Allow(authorize.ObjectOrg, authorize.ActionRead)).
+				Get("/orgs/{orgID}", s.handleOrgGet)
+		})
+
+		s.Router = admin
+	}
+}
@@ -0,0 +1,35 @@
+package adminapi
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/org"
+	"github.com/remisb/mat/internal/user"
+	"os"
+)
+
+// Server struct is an administration REST API server
+type Server struct {
+	auditRepo     *audit.Repo
+	orgRepo       *org.Repo
+	Router        *chi.Mux
+	build         string
+	authenticator *auth.Authenticator
+}
+
+// NewServer is a factory function which creates and initializes new administration REST API server.
+func NewServer(build string, shutdown chan os.Signal, db *sqlx.DB) *Server {
+	userRepo := user.NewRepo(db)
+	s := Server{
+		build:         build,
+		authenticator: auth.New(userRepo, nil, auth.NewTokenStore(db), web.Keys, nil),
+		auditRepo:     audit.NewRepo(db),
+		orgRepo:       org.NewRepo(db),
+	}
+
+	s.initRoutes()
+	return &s
+}
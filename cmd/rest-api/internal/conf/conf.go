@@ -1,10 +1,13 @@
 package conf
 
 import (
-	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/auth"
 	"github.com/remisb/mat/internal/conf"
 	"github.com/remisb/mat/internal/db"
 	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/mail"
+	"github.com/remisb/mat/internal/oidc"
+	"github.com/remisb/mat/internal/password"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"os"
@@ -21,7 +24,8 @@ const (
 
 var initConfigOnce sync.Once
 
-// SrvConfig has server configuration settings.
+// SrvConfig has server configuration settings. TrustedProxies lists proxy IPs
+// or CIDRs whose X-Forwarded-For header is used as client address.
 type SrvConfig struct {
 	Host            string
 	Port            int
@@ -30,6 +34,7 @@ type SrvConfig struct {
 	ReadTimeout     time.Duration
 	WriteTimeout    time.Duration
 	ShutdownTimeout time.Duration
+	TrustedProxies  []string
 }
 
 // NewSrvConfig factory function creates and initialize new SrvConfig.
@@ -49,19 +54,56 @@ func (sc SrvConfig) Addr() string {
 	return sc.Host + ":" + strconv.Itoa(sc.Port)
 }
 
-// AuthConfig structure stores authentication configuration settings.
+// AuthConfig structure stores authentication configuration settings. VerifyKeyFiles
+// maps key ID to PEM file of the key accepted for token verification, previous
+// signing keys are kept there during key rotation.
+//
+// Backends lists credential backends tried in order: local, local-admin (local
+// admins only, used as break-glass fallback) and ldap.
+//
+// Policy lists authorization rules as "allow|deny ROLE object action [condition]",
+// authorize.DefaultPolicy is used when it is empty.
+//
+// MFARoles lists roles which must sign in with TOTP two-factor authentication.
+// MFAKeyFile holds hex encoded 32 bytes key encrypting TOTP secrets of users,
+// two-factor authentication can not be enrolled when it is empty.
 type AuthConfig struct {
 	KeyID          string
 	PrivateKeyFile string
 	Algorithm      string
+	VerifyKeyFiles map[string]string
+	Backends       []string
+	Policy         []string
+	MFARoles       []string
+	MFAKeyFile     string
+	LDAP           auth.LDAPConfig
+}
+
+// RegisterConfig structure stores self-service registration settings.
+type RegisterConfig struct {
+	AllowedDomains []string
+	VerifyURL      string
+	ResetURL       string
+}
+
+// PasswordConfig structure stores password policy settings. BreachedFile lists
+// SHA-1 hashes of breached passwords one per line sorted by hash, check is
+// skipped when it is empty.
+type PasswordConfig struct {
+	MinLength    int
+	BreachedFile string
 }
 
 // Config structure to store application configuration settings.
 type Config struct {
-	Server SrvConfig
-	Auth   AuthConfig
-	Db     db.Config
-	Args   conf.Args
+	Server   SrvConfig
+	Auth     AuthConfig
+	Db       db.Config
+	Mail     mail.Config
+	OIDC     oidc.Config
+	Register RegisterConfig
+	Password PasswordConfig
+	Args     conf.Args
 }
 
 // NewConfig is a factory class initializes and creates new Config structure.
@@ -69,30 +111,69 @@ func NewConfig() *Config {
 	initCliFlags()
 
 	return &Config{
-		Server: srvConfig(),
-		Auth:   authConfig(),
-		Db:     dbConfig(),
-		Args:   conf.NewConfigArgs(os.Args[1:]),
+		Server:   srvConfig(),
+		Auth:     authConfig(),
+		Db:       dbConfig(),
+		Mail:     mailConfig(),
+		OIDC:     oidcConfig(),
+		Register: registerConfig(),
+		Password: passwordConfig(),
+		Args:     conf.NewConfigArgs(os.Args[1:]),
 	}
 }
 
 func srvConfig() SrvConfig {
-	return NewSrvConfig(
+	sc := NewSrvConfig(
 		viper.GetString("host"),
 		viper.GetInt("port"),
 		viper.GetString("log"),
 	)
+	sc.TrustedProxies = viper.GetStringSlice("trusted-proxies")
+	return sc
 }
 
 func authConfig() AuthConfig {
-	web.InitAuth()
 	return AuthConfig{
 		KeyID:          viper.GetString("auth-keyid"),
 		PrivateKeyFile: viper.GetString("auth-keyfile"),
 		Algorithm:      viper.GetString("auth-algorithm"),
+		VerifyKeyFiles: keyValues(viper.GetStringSlice("auth-verify-keys")),
+		Backends:       viper.GetStringSlice("auth-backends"),
+		Policy:         viper.GetStringSlice("auth-policy"),
+		MFARoles:       viper.GetStringSlice("auth-mfa-roles"),
+		MFAKeyFile:     viper.GetString("auth-mfa-keyfile"),
+		LDAP:           ldapConfig(),
 	}
 }
 
+func ldapConfig() auth.LDAPConfig {
+	return auth.LDAPConfig{
+		URL:                viper.GetString("ldap-url"),
+		StartTLS:           viper.GetBool("ldap-starttls"),
+		InsecureSkipVerify: viper.GetBool("ldap-insecure"),
+		BindDN:             viper.GetString("ldap-bind-dn"),
+		BindPassword:       viper.GetString("ldap-bind-password"),
+		BaseDN:             viper.GetString("ldap-base-dn"),
+		UserFilter:         viper.GetString("ldap-user-filter"),
+		AdminGroups:        viper.GetStringSlice("ldap-admin-groups"),
+		UserGroups:         viper.GetStringSlice("ldap-user-groups"),
+	}
+}
+
+// keyValues converts list of key=value strings into map.
+func keyValues(list []string) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range list {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			log.Sugar.Errorf("invalid value %q, expected key=value", kv)
+			continue
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m
+}
+
 func dbConfig() db.Config {
 	return db.Config{
 		Name:       viper.GetString("db-name"),
@@ -104,17 +185,71 @@ func dbConfig() db.Config {
 	}
 }
 
+func mailConfig() mail.Config {
+	return mail.Config{
+		Host:      viper.GetString("mail-host"),
+		Port:      viper.GetInt("mail-port"),
+		User:      viper.GetString("mail-user"),
+		Password:  viper.GetString("mail-password"),
+		From:      viper.GetString("mail-from"),
+		OutboxDir: viper.GetString("mail-outbox"),
+	}
+}
+
+func oidcConfig() oidc.Config {
+	return oidc.Config{
+		Issuer:       viper.GetString("oidc-issuer"),
+		ClientID:     viper.GetString("oidc-client-id"),
+		ClientSecret: viper.GetString("oidc-client-secret"),
+		RedirectURL:  viper.GetString("oidc-redirect-url"),
+		Scopes:       viper.GetStringSlice("oidc-scopes"),
+		GroupRoles:   keyValues(viper.GetStringSlice("oidc-group-roles")),
+	}
+}
+
+func registerConfig() RegisterConfig {
+	return RegisterConfig{
+		AllowedDomains: viper.GetStringSlice("register-domains"),
+		VerifyURL:      viper.GetString("register-verify-url"),
+		ResetURL:       viper.GetString("register-reset-url"),
+	}
+}
+
+func passwordConfig() PasswordConfig {
+	return PasswordConfig{
+		MinLength:    viper.GetInt("password-min-length"),
+		BreachedFile: viper.GetString("password-breached-file"),
+	}
+}
+
 func initCliFlags() {
 	initConfigOnce.Do(func() {
 		// setup cli flags
 		pflag.CommandLine.StringP("host", "h", "localhost", "api service host")
 		pflag.CommandLine.IntP("port", "p", 8080, "api service port")
 		pflag.CommandLine.StringP("log", "l", "restaurant-api.log", "api service log file")
+		pflag.StringSlice("trusted-proxies", nil, "Proxy IPs or CIDRs trusted to set X-Forwarded-For header")
 
 		// auth config flags
 		pflag.String("auth-keyid", "1", "Authenticator Key ID")
 		pflag.String("auth-keyfile", "private.pem", "Authenticator private key file")
 		pflag.String("auth-algorithm", "RS256", "Authenticator algorithm")
+		pflag.StringSlice("auth-verify-keys", nil, "Additional token verification keys as kid=path")
+		pflag.StringSlice("auth-backends", []string{"local"}, "Credential backends tried in order: local, local-admin, ldap")
+		pflag.StringSlice("auth-policy", nil, "Authorization policy rules as \"allow|deny ROLE object action [condition]\"")
+		pflag.StringSlice("auth-mfa-roles", nil, "Roles required to sign in with two-factor authentication, like ADMIN")
+		pflag.String("auth-mfa-keyfile", "", "File with hex encoded 32 bytes key encrypting two-factor authentication secrets")
+
+		// ldap config flags
+		pflag.String("ldap-url", "ldap://localhost:389", "LDAP server URL")
+		pflag.Bool("ldap-starttls", false, "LDAP use StartTLS")
+		pflag.Bool("ldap-insecure", false, "LDAP skip TLS certificate verification")
+		pflag.String("ldap-bind-dn", "", "LDAP service account DN")
+		pflag.String("ldap-bind-password", "", "LDAP service account password")
+		pflag.String("ldap-base-dn", "", "LDAP user search base DN")
+		pflag.String("ldap-user-filter", "", "LDAP user search filter, %s is replaced with email")
+		pflag.StringSlice("ldap-admin-groups", nil, "LDAP groups DN mapped to ADMIN role")
+		pflag.StringSlice("ldap-user-groups", nil, "LDAP groups DN allowed to sign in, all users when empty")
 
 		// db config flags
 		pflag.String("db-name", "postgres", "Database name")
@@ -123,6 +258,31 @@ func initCliFlags() {
 		pflag.String("db-user", "postgres", "Database user")
 		pflag.String("db-password", "postgres", "Database password")
 		pflag.Bool("db-tls-off", true, "Database disable TLS")
+
+		// mail config flags
+		pflag.String("mail-host", "localhost", "SMTP server host")
+		pflag.Int("mail-port", 25, "SMTP server port")
+		pflag.String("mail-user", "", "SMTP server user")
+		pflag.String("mail-password", "", "SMTP server password")
+		pflag.String("mail-from", "noreply@localhost", "Mail sender address")
+		pflag.String("mail-outbox", "", "Write mails into outbox directory instead of sending")
+
+		// single sign-on config flags
+		pflag.String("oidc-issuer", "", "OpenID Connect issuer URL, single sign-on is disabled when empty")
+		pflag.String("oidc-client-id", "", "OpenID Connect client ID")
+		pflag.String("oidc-client-secret", "", "OpenID Connect client secret")
+		pflag.String("oidc-redirect-url", "http://localhost:8090/api/v1/users/oidc/callback", "OpenID Connect redirect URL")
+		pflag.StringSlice("oidc-scopes", nil, "OpenID Connect scopes")
+		pflag.StringSlice("oidc-group-roles", nil, "Identity provider group to role mapping as group=ROLE")
+
+		// registration config flags
+		pflag.StringSlice("register-domains", nil, "Email domains allowed to register")
+		pflag.String("register-verify-url", "http://localhost:8090/api/v1/users/verify", "Email verification URL")
+		pflag.String("register-reset-url", "http://localhost:8090/reset-password", "Password reset page URL")
+
+		// password policy config flags
+		pflag.Int("password-min-length", password.DefaultMinLength, "Minimal password length")
+		pflag.String("password-breached-file", "", "File listing SHA-1 hashes of breached passwords sorted by hash")
 		pflag.Parse()
 
 		if err := viper.BindPFlags(pflag.CommandLine); err != nil {
@@ -137,11 +297,28 @@ func initCliFlags() {
 		// bind server conf
 		bindEnv("port")
 		bindEnv("log")
+		bindEnv("trusted-proxies")
 
 		// bind auth conf
 		bindEnv("auth-keyid")
 		bindEnv("auth-keyfile")
 		bindEnv("auth-algorithm")
+		bindEnv("auth-verify-keys")
+		bindEnv("auth-backends")
+		bindEnv("auth-policy")
+		bindEnv("auth-mfa-roles")
+		bindEnv("auth-mfa-keyfile")
+
+		// bind ldap conf
+		bindEnv("ldap-url")
+		bindEnv("ldap-starttls")
+		bindEnv("ldap-insecure")
+		bindEnv("ldap-bind-dn")
+		bindEnv("ldap-bind-password")
+		bindEnv("ldap-base-dn")
+		bindEnv("ldap-user-filter")
+		bindEnv("ldap-admin-groups")
+		bindEnv("ldap-user-groups")
 
 		// bind db conf
 		bindEnv("db-host")
@@ -151,6 +328,31 @@ func initCliFlags() {
 		bindEnv("db-password")
 		bindEnv("db-tls-off")
 
+		// bind mail conf
+		bindEnv("mail-host")
+		bindEnv("mail-port")
+		bindEnv("mail-user")
+		bindEnv("mail-password")
+		bindEnv("mail-from")
+		bindEnv("mail-outbox")
+
+		// bind single sign-on conf
+		bindEnv("oidc-issuer")
+		bindEnv("oidc-client-id")
+		bindEnv("oidc-client-secret")
+		bindEnv("oidc-redirect-url")
+		bindEnv("oidc-scopes")
+		bindEnv("oidc-group-roles")
+
+		// bind registration conf
+		bindEnv("register-domains")
+		bindEnv("register-verify-url")
+		bindEnv("register-reset-url")
+
+		// bind password policy conf
+		bindEnv("password-min-length")
+		bindEnv("password-breached-file")
+
 		// setup config file variables
 		viper.SetConfigName(configFileName)
 		viper.SetConfigType("yaml")
@@ -0,0 +1,189 @@
+package officeapi
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/office"
+	"net/http"
+	"time"
+)
+
+// handleOfficesGet godoc
+// @Summary List offices
+// @Description get offices of the organization
+// @Tags offices
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {array} office.Office
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /offices [get]
+func (s *Server) handleOfficesGet(w http.ResponseWriter, r *http.Request) {
+	offices, err := s.officeRepo.List(r.Context())
+	if err != nil {
+		respondOfficeError(w, r, err)
+		return
+	}
+	web.Respond(w, r, http.StatusOK, offices)
+}
+
+// handleOfficeCreate godoc
+// @Summary Create office
+// @Description create a new office with IANA timezone and optional vote cutoff time, allowed for admin
+// @Tags offices
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param office body office.NewOffice true "New office"
+// @Success 201 {object} office.Office
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /offices [post]
+func (s *Server) handleOfficeCreate(w http.ResponseWriter, r *http.Request) {
+	var no office.NewOffice
+	if err := web.DecodeBody(r, &no); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read office from request", err)
+		return
+	}
+
+	var o *office.Office
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if o, err = s.officeRepo.Create(ctx, no, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionCreate, TargetType: audit.TargetOffice, TargetID: o.ID, After: o}, nil
+	})
+	if err != nil {
+		respondOfficeError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusCreated, o)
+}
+
+// handleOfficeGet godoc
+// @Summary Get office
+// @Description get office by ID
+// @Tags offices
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param officeID path string true "Office ID"
+// @Success 200 {object} office.Office
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /offices/{officeID} [get]
+func (s *Server) handleOfficeGet(w http.ResponseWriter, r *http.Request) {
+	o, err := s.officeRepo.Retrieve(r.Context(), chi.URLParam(r, "officeID"))
+	if err != nil {
+		respondOfficeError(w, r, err)
+		return
+	}
+	web.Respond(w, r, http.StatusOK, o)
+}
+
+// handleOfficeUpdate godoc
+// @Summary Update office
+// @Description update office name, timezone, location or vote cutoff, empty vote cutoff removes it,
+// @Description allowed for admin
+// @Tags offices
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param officeID path string true "Office ID"
+// @Param office body office.UpdateOffice true "Office update"
+// @Success 200 {object} office.Office
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /offices/{officeID} [put]
+func (s *Server) handleOfficeUpdate(w http.ResponseWriter, r *http.Request) {
+	officeID := chi.URLParam(r, "officeID")
+
+	var uo office.UpdateOffice
+	if err := web.DecodeBody(r, &uo); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read office from request", err)
+		return
+	}
+
+	before, err := s.officeRepo.Retrieve(r.Context(), officeID)
+	if err != nil {
+		respondOfficeError(w, r, err)
+		return
+	}
+
+	var o *office.Office
+	err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if o, err = s.officeRepo.Update(ctx, officeID, uo, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionUpdate, TargetType: audit.TargetOffice, TargetID: o.ID,
+			Before: before, After: o}, nil
+	})
+	if err != nil {
+		respondOfficeError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, o)
+}
+
+// handleOfficeDelete godoc
+// @Summary Delete office
+// @Description delete office, its restaurants and users are left without office, allowed for admin
+// @Tags offices
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param officeID path string true "Office ID"
+// @Success 204
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /offices/{officeID} [delete]
+func (s *Server) handleOfficeDelete(w http.ResponseWriter, r *http.Request) {
+	officeID := chi.URLParam(r, "officeID")
+
+	before, err := s.officeRepo.Retrieve(r.Context(), officeID)
+	if err != nil {
+		respondOfficeError(w, r, err)
+		return
+	}
+
+	err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (audit.NewEvent, error) {
+		return audit.NewEvent{Action: audit.ActionDelete, TargetType: audit.TargetOffice, TargetID: officeID,
+			Before: before}, s.officeRepo.Delete(ctx, officeID)
+	})
+	if err != nil {
+		respondOfficeError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusNoContent, nil)
+}
+
+// respondOfficeError sends error response with status matching the office repository error.
+func respondOfficeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case db.ErrInvalidID, office.ErrInvalidName, office.ErrInvalidTimezone, office.ErrInvalidCutoff:
+		web.RespondError(w, r, http.StatusBadRequest, err)
+	case db.ErrNotFound:
+		web.RespondError(w, r, http.StatusNotFound, err)
+	case office.ErrOfficeExists:
+		web.RespondError(w, r, http.StatusConflict, err)
+	default:
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,136 @@
+package officeapi
+
+import (
+	"github.com/gavv/httpexpect/v2"
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/restaurantapi"
+	"github.com/remisb/mat/cmd/rest-api/internal/userapi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/office"
+	"github.com/remisb/mat/internal/restaurant"
+	"github.com/remisb/mat/internal/tests"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	restaurantLokysID = "5828612a-1f8a-403c-b6d1-6cb66fbf0c66"
+	menuLokysID       = "4058d981-0df1-45de-807e-b8e90bcb2d80"
+	menuLokysDate     = "2020-03-01"
+)
+
+func TestOffices(t *testing.T) {
+	officeTest := tests.NewTest(t)
+	t.Cleanup(officeTest.Cleanup)
+	web.Keys = officeTest.Keys
+	r := chi.NewRouter()
+
+	userServer := userapi.NewServer("testing", nil, officeTest.Dbx, userapi.Registration{})
+	restaurantServer := restaurantapi.NewServer("testing", nil, officeTest.Dbx)
+	officeServer := NewServer("testing", nil, officeTest.Dbx)
+	r.Route("/api/v1/", func(r chi.Router) {
+		r.Mount("/users", userServer.Router)
+		r.Mount("/restaurant", restaurantServer.Router)
+		r.Mount("/offices", officeServer.Router)
+	})
+
+	officeTest.SetupTestUsers(t)
+
+	testServer := httptest.NewServer(r)
+	e := httpexpect.New(t, testServer.URL)
+	authAdmin := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+officeTest.Admin.Token)
+	})
+	authUser := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+officeTest.User.Token)
+	})
+
+	// only admin manages offices
+	authUser.POST("/api/v1/offices").
+		WithJSON(office.NewOffice{Name: "Vilnius", Timezone: "Europe/Vilnius"}).
+		Expect().Status(http.StatusForbidden)
+	authAdmin.POST("/api/v1/offices").
+		WithJSON(office.NewOffice{Name: "Vilnius", Timezone: "Europe/Nowhere"}).
+		Expect().Status(http.StatusBadRequest)
+	cutoff := "00:00"
+	authAdmin.POST("/api/v1/offices").
+		WithJSON(office.NewOffice{Name: "Vilnius", Timezone: "Europe/Vilnius", VoteCutoff: &cutoff}).
+		Expect().Status(http.StatusCreated)
+	authAdmin.POST("/api/v1/offices").
+		WithJSON(office.NewOffice{Name: "Vilnius", Timezone: "Europe/Vilnius"}).
+		Expect().Status(http.StatusConflict)
+
+	vilnius := authUser.GET("/api/v1/offices").
+		Expect().Status(http.StatusOK).
+		JSON().Array().Element(0).Object()
+	vilnius.ValueEqual("timezone", "Europe/Vilnius").ValueEqual("voteCutoff", cutoff)
+	vilniusID := vilnius.Value("id").String().Raw()
+
+	nyID := authAdmin.POST("/api/v1/offices").
+		WithJSON(office.NewOffice{Name: "New York", Timezone: "America/New_York"}).
+		Expect().Status(http.StatusCreated).
+		JSON().Object().Value("id").String().Raw()
+
+	// restaurant catalog of the office
+	unknownOfficeID := menuLokysID
+	etag := authAdmin.GET("/api/v1/restaurant/{restaurantId}", restaurantLokysID).
+		Expect().Status(http.StatusOK).
+		Header("ETag").NotEmpty().Raw()
+	authAdmin.PUT("/api/v1/restaurant/{restaurantId}", restaurantLokysID).
+		WithHeader("If-Match", etag).
+		WithJSON(restaurant.UpdateRestaurant{OfficeID: &unknownOfficeID}).
+		Expect().Status(http.StatusBadRequest)
+	authAdmin.PUT("/api/v1/restaurant/{restaurantId}", restaurantLokysID).
+		WithHeader("If-Match", etag).
+		WithJSON(restaurant.UpdateRestaurant{OfficeID: &vilniusID}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("officeId", vilniusID)
+
+	e.GET("/api/v1/restaurant").
+		WithQuery("office", vilniusID).
+		Expect().Status(http.StatusOK).
+		JSON().Array().Path("$..id").Array().ContainsOnly(restaurantLokysID)
+	e.GET("/api/v1/restaurant").
+		WithQuery("office", nyID).
+		Expect().Status(http.StatusOK).
+		JSON().Array().Empty()
+	e.GET("/api/v1/restaurant/menus").
+		WithQuery("office", nyID).
+		WithQuery("date", menuLokysDate).
+		Expect().Status(http.StatusOK).
+		JSON().Array().Empty()
+	e.GET("/api/v1/restaurant/votes").
+		WithQuery("office", "invalid").
+		Expect().Status(http.StatusBadRequest)
+
+	// home office of the user
+	authUser.PATCH("/api/v1/users/me").
+		WithJSON(user.UpdateProfile{OfficeID: &nyID}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("officeId", nyID)
+
+	// votes for the past days are closed in the office with vote cutoff
+	vote := func() *httpexpect.Response {
+		return authUser.POST("/api/v1/restaurant/{restaurantId}/menu/{menuId}/vote", restaurantLokysID, menuLokysID).
+			WithQuery("date", menuLokysDate).
+			Expect()
+	}
+	vote().Status(http.StatusForbidden)
+
+	noCutoff := ""
+	authAdmin.PUT("/api/v1/offices/{officeID}", vilniusID).
+		WithJSON(office.UpdateOffice{VoteCutoff: &noCutoff}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().NotContainsKey("voteCutoff")
+	vote().Status(http.StatusCreated)
+
+	authAdmin.DELETE("/api/v1/offices/{officeID}", vilniusID).
+		Expect().Status(http.StatusNoContent)
+	authUser.GET("/api/v1/offices/{officeID}", vilniusID).
+		Expect().Status(http.StatusNotFound)
+	authAdmin.GET("/api/v1/restaurant/{restaurantId}", restaurantLokysID).
+		Expect().Status(http.StatusOK).
+		JSON().Object().NotContainsKey("officeId")
+}
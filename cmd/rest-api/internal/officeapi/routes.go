@@ -0,0 +1,36 @@
+package officeapi
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/authorize"
+)
+
+func (s *Server) initRoutes() {
+	if s.Router == nil {
+		// /api/v1/offices
+		offices := chi.NewMux()
+		offices.Use(web.CorsHandler)
+
+		authenticator := *s.authenticator
+		offices.Group(func(r chi.Router) {
+			r.Use(web.Verifier(authenticator.Keys(), authenticator))
+			r.Use(web.Authenticator)
+			r.Use(web.Tenant)
+			r.Use(web.RequireScope())
+
+			r.With(web.Allow(authorize.ObjectOffice, authorize.ActionRead)).
+				Get("/", s.handleOfficesGet)
+			r.With(web.Allow(authorize.ObjectOffice, authorize.ActionCreate)).
+				Post("/", s.handleOfficeCreate)
+			r.With(web.Allow(authorize.ObjectOffice, authorize.ActionRead)).
+				Get("/{officeID}", s.handleOfficeGet)
+			r.With(web.Allow(authorize.ObjectOffice, authorize.ActionUpdate)).
+				Put("/{officeID}", s.handleOfficeUpdate)
+			r.With(web.Allow(authorize.ObjectOffice, authorize.ActionDelete)).
+				Delete("/{officeID}", s.handleOfficeDelete)
+		})
+
+		s.Router = offices
+	}
+}
@@ -1,10 +1,12 @@
 package restaurantapi
 
 import (
+	"context"
 	"github.com/go-chi/chi"
-	"github.com/go-chi/jwtauth"
 	"github.com/pkg/errors"
 	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/authorize"
 	"github.com/remisb/mat/internal/db"
 	"github.com/remisb/mat/internal/restaurant"
 	"net/http"
@@ -72,9 +74,10 @@ func (s *Server) handleRestaurantMenusGet(w http.ResponseWriter, r *http.Request
 // @Security ApiKeyAuth
 // @Param restaurantId path string true "restaurant ID"
 // @Param menu body restaurant.UpdateMenu true "update menu"
-// @Success 200 {object} restaurant.Restaurant
+// @Success 201 {object} restaurant.Menu
 // @Failure 400 {object} web.APIError
 // @Failure 404 {object} web.APIError
+// @Failure 409 {object} web.APIError
 // @Failure 500 {object} web.APIError
 // @Router /restaurant/{restaurantId}/menu [post]
 func (s *Server) handleRestaurantMenuCreate(w http.ResponseWriter, r *http.Request) {
@@ -85,14 +88,13 @@ func (s *Server) handleRestaurantMenuCreate(w http.ResponseWriter, r *http.Reque
 	}
 
 	ctx := r.Context()
-	restaurantItem, err := s.restaurantRepo.GetRestaurant(ctx, restaurantID)
-	if err != nil {
-		web.RespondError(w, r, http.StatusInternalServerError, err)
+	if _, err := s.restaurantRepo.GetRestaurant(ctx, restaurantID); err != nil {
+		respondRestaurantError(w, r, restaurantID, err)
 		return
 	}
 
-	if restaurantItem == nil {
-		web.RespondError(w, r, http.StatusNotFound)
+	// only restaurant owner, editor or admin users can create menu
+	if !s.authorizeRestaurant(w, r, restaurantID, authorize.Resource{Object: authorize.ObjectMenu}, authorize.ActionCreate) {
 		return
 	}
 
@@ -102,39 +104,69 @@ func (s *Server) handleRestaurantMenuCreate(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	_, claims, err := jwtauth.FromContext(ctx)
-	if err != nil {
-		web.RespondError(w, r, http.StatusUnauthorized, web.ErrNoTokenFound)
+	// existing menu is replaced by PUT with If-Match version check
+	if updateMenu.ID != "" {
+		web.RespondError(w, r, http.StatusBadRequest, "menu id can not be set on create, use PUT to replace menu")
 		return
 	}
-
-	if claims == nil {
-		web.RespondError(w, r, http.StatusUnauthorized, web.ErrNoTokenFound)
+	if updateMenu.RestaurantID == "" {
+		updateMenu.RestaurantID = restaurantID
+	}
+	if updateMenu.RestaurantID != restaurantID {
+		web.RespondError(w, r, http.StatusBadRequest, "menu restaurantId does not match restaurant")
 		return
 	}
-	//restaurant.UpdateMenu
-	status := http.StatusOK
-	if updateMenu.ID == "" {
-		status = http.StatusCreated
+
+	// menu without date is added for the current day of the restaurant office
+	if updateMenu.Date.IsZero() {
+		o, ok := s.restaurantOffice(w, r, restaurantID)
+		if !ok {
+			return
+		}
+		if o != nil {
+			updateMenu.Date = o.Today(time.Now())
+		}
 	}
 
-	menu, err := s.restaurantRepo.CreateRestaurantMenu(ctx, updateMenu)
+	var menu *restaurant.Menu
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if menu, err = s.restaurantRepo.CreateRestaurantMenu(ctx, updateMenu); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionCreate, TargetType: audit.TargetMenu, TargetID: menu.ID, After: menu}, nil
+	})
 	if err != nil {
-		if err != restaurant.ErrMenuNotFound {
+		switch err {
+		case restaurant.ErrMenuExists:
+			err := web.NewRequestError(err, http.StatusConflict)
+			web.RespondError(w, r, http.StatusConflict, err)
+			return
+		default:
 			web.RespondError(w, r, http.StatusInternalServerError, err)
 			return
 		}
 	}
-
-	web.Respond(w, r, status, menu)
+	web.Respond(w, r, http.StatusCreated, menu)
 }
 
-// endpoint: get /api/v1/restaurant/menus?date=2020-03-01
+// endpoint: get /api/v1/restaurant/menus?date=2020-03-01&office=officeID
+//
+// menus of the office restaurant catalog are listed when office query parameter
+// is passed, date defaults to the current day of the office or home office of
+// the signed in user
 func (s *Server) handleMenusGet(w http.ResponseWriter, r *http.Request) {
 	// TODO add pagination
+	sc, ok := s.requestOffice(w, r)
+	if !ok {
+		return
+	}
 
-	parsedDate := parseURLDateDefaultNow(w, r, "date")
-	todayMenus, err := s.restaurantRepo.RetrieveMenusByDate(r.Context(), parsedDate)
+	parsedDate, err := web.QueryDate(r, "date", sc.today())
+	if err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	todayMenus, err := s.restaurantRepo.RetrieveMenusByDate(r.Context(), sc.filter, parsedDate)
 	if err != nil {
 		web.RespondError(w, r, http.StatusInternalServerError, err)
 		return
@@ -142,51 +174,132 @@ func (s *Server) handleMenusGet(w http.ResponseWriter, r *http.Request) {
 	web.Respond(w, r, http.StatusOK, todayMenus)
 }
 
-func parseURLDateDefaultNow(w http.ResponseWriter, r *http.Request, name string) time.Time {
-	var parsedDate time.Time
-	var err error
+func (s *Server) handleRestaurantMenuGet(w http.ResponseWriter, r *http.Request) {
+	restaurantID := chi.URLParam(r, "restaurantId")
+	menuID := chi.URLParam(r, "menuId")
 
-	date := r.URL.Query().Get(name)
-	if date != "" {
-		parsedDate, err = time.Parse("2006-01-02", date)
-		if err != nil {
-			err = errors.Wrap(err, "invalid date format")
-			web.RespondError(w, r, http.StatusBadRequest)
-			return time.Now()
+	menu, err := s.restaurantRepo.RetrieveRestaurantMenus(r.Context(), restaurantID, menuID)
+	if err != nil {
+		switch err {
+		case db.ErrInvalidID:
+			err := web.NewRequestError(err, http.StatusBadRequest)
+			web.RespondError(w, r, http.StatusBadRequest, err)
+			return
+		case db.ErrNotFound:
+			err := web.NewRequestError(restaurant.ErrMenuNotFound, http.StatusNotFound)
+			web.RespondError(w, r, http.StatusNotFound, err)
+			return
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+			return
 		}
 	}
 
-	if parsedDate.IsZero() {
-		parsedDate = time.Now()
+	if web.NotModified(w, r, menu.Version) {
+		return
 	}
-	return parsedDate
+	web.Respond(w, r, http.StatusOK, menu)
 }
 
-func (s *Server) handleRestaurantMenuGet(w http.ResponseWriter, r *http.Request) {
+// handleRestaurantMenuUpdate godoc
+// @Summary Update a restaurant menu
+// @Description update existing restaurant menu, If-Match header with menu ETag is required
+// @Tags restaurants,menus
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param restaurantId path string true "Restaurant ID"
+// @Param menuId path string true "Menu ID"
+// @Param If-Match header string true "Menu ETag"
+// @Param menu body restaurant.UpdateMenu true "update menu"
+// @Success 200 {object} restaurant.Menu
+// @Failure 400 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 412 {object} web.APIError
+// @Failure 428 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/{restaurantId}/menu/{menuId} [put]
+func (s *Server) handleRestaurantMenuUpdate(w http.ResponseWriter, r *http.Request) {
 	restaurantID := chi.URLParam(r, "restaurantId")
-	menuID := chi.URLParam(r, "menuId")
 
-	menu, err := s.restaurantRepo.RetrieveRestaurantMenus(r.Context(), restaurantID, menuID)
+	// only restaurant owner, editor or admin users can update menu
+	if !s.authorizeRestaurant(w, r, restaurantID, authorize.Resource{Object: authorize.ObjectMenu}, authorize.ActionUpdate) {
+		return
+	}
+
+	version, err := web.IfMatchVersion(r)
 	if err != nil {
-		web.RespondError(w, r, http.StatusInternalServerError, menu)
+		web.RespondPreconditionError(w, r, err)
 		return
 	}
 
+	var updateMenu restaurant.UpdateMenu
+	if err := web.DecodeBody(r, &updateMenu); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read updateMenu from request ", err)
+		return
+	}
+	updateMenu.RestaurantID = restaurantID
+	updateMenu.ID = chi.URLParam(r, "menuId")
+
+	// previous menu is kept only for audit, missing menu is reported by ReplaceMenu
+	before, _ := s.restaurantRepo.RetrieveMenu(r.Context(), updateMenu.ID)
+
+	var menu *restaurant.Menu
+	err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if menu, err = s.restaurantRepo.ReplaceMenu(ctx, updateMenu, version); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionUpdate, TargetType: audit.TargetMenu, TargetID: menu.ID,
+			Before: before, After: menu}, nil
+	})
+	if err != nil {
+		switch err {
+		case db.ErrVersionConflict:
+			err := web.NewRequestError(err, http.StatusPreconditionFailed)
+			web.RespondError(w, r, http.StatusPreconditionFailed, err)
+			return
+		case db.ErrInvalidID:
+			err := web.NewRequestError(err, http.StatusBadRequest)
+			web.RespondError(w, r, http.StatusBadRequest, err)
+			return
+		case db.ErrNotFound:
+			err := web.NewRequestError(restaurant.ErrMenuNotFound, http.StatusNotFound)
+			web.RespondError(w, r, http.StatusNotFound, err)
+			return
+		case restaurant.ErrRestaurantNotFound:
+			err := web.NewRequestError(err, http.StatusNotFound)
+			web.RespondError(w, r, http.StatusNotFound, err)
+			return
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	web.SetETag(w, menu.Version)
 	web.Respond(w, r, http.StatusOK, menu)
 }
 
 // handleRestaurantsGet godoc
 // @Summary List restaurant
-// @Description get restaurants
+// @Description get restaurants, only restaurants of the office catalog are listed when office is passed
 // @Tags restaurants
 // @Accept  json
 // @Produce  json
+// @Param office query string false "Office ID"
 // @Success 200 {array} restaurant.Restaurant
+// @Failure 400 {object} web.APIError
+// @Failure 404 {object} web.APIError
 // @Failure 500 {object} web.APIError
 // @Router /restaurant [get]
 func (s *Server) handleRestaurantsGet(w http.ResponseWriter, r *http.Request) {
 	// TODO add pagination
-	restaurants, err := s.restaurantRepo.RetrieveRestaurantList(r.Context())
+	sc, ok := s.requestOffice(w, r)
+	if !ok {
+		return
+	}
+
+	restaurants, err := s.restaurantRepo.RetrieveRestaurantList(r.Context(), sc.filter)
 	if err != nil {
 		web.RespondError(w, r, http.StatusInternalServerError, err)
 		return
@@ -197,22 +310,19 @@ func (s *Server) handleRestaurantsGet(w http.ResponseWriter, r *http.Request) {
 
 // handleRestaurantCreate godoc
 // @Summary Add a restaurant
-// @Description add new restaurant
+// @Description add new restaurant, allowed only for admin
 // @Tags restaurants
 // @Accept  json
 // @Produce  json
-// @Success 200 {object} restaurant.Restaurant
+// @Security ApiKeyAuth
+// @Success 201 {object} restaurant.Restaurant
+// @Failure 403 {object} web.APIError
 // @Failure 400 {object} web.APIError
 // @Failure 500 {object} web.APIError
 // @Router /restaurant [post]
 func (s *Server) handleRestaurantCreate(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	_, claims, err := jwtauth.FromContext(ctx)
-	if err != nil {
-		err := errors.New("error on getting claims from context")
-		web.RespondError(w, r, http.StatusInternalServerError, err)
-		return
-	}
+	// employees propose new restaurants with suggestions, admins create them directly
+	userID := web.Subject(r).UserID
 
 	var nr restaurant.NewRestaurant
 	if err := web.DecodeBody(r, &nr); err != nil {
@@ -225,14 +335,24 @@ func (s *Server) handleRestaurantCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	userID := claims["sub"].(string)
-
-	uDb, err := s.restaurantRepo.CreateRestaurant(ctx, nr, time.Now(), userID)
+	var uDb *restaurant.Restaurant
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if uDb, err = s.restaurantRepo.CreateRestaurant(ctx, nr, time.Now(), userID); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionCreate, TargetType: audit.TargetRestaurant, TargetID: uDb.ID,
+			After: uDb}, nil
+	})
+	if err == restaurant.ErrUnknownOffice {
+		web.RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
 		web.RespondError(w, r, http.StatusInternalServerError, err)
 		return
 	}
 
+	web.SetETag(w, uDb.Version)
 	web.Respond(w, r, http.StatusCreated, uDb)
 }
 
@@ -273,44 +393,82 @@ func (s *Server) handleRestaurantGet(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if web.NotModified(w, r, usr.Version) {
+		return
+	}
 	web.Respond(w, r, http.StatusOK, usr)
 }
 
+// handleRestaurantUpdate godoc
+// @Summary Update a restaurant
+// @Description update restaurant, If-Match header with restaurant ETag is required
+// @Tags restaurants
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param restaurantId path string true "Restaurant ID"
+// @Param If-Match header string true "Restaurant ETag"
+// @Param restaurant body restaurant.UpdateRestaurant true "update restaurant"
+// @Success 200 {object} restaurant.Restaurant
+// @Failure 400 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 412 {object} web.APIError
+// @Failure 428 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/{restaurantId} [put]
 func (s *Server) handleRestaurantUpdate() http.HandlerFunc {
-	// UpdateUser defines what information may be provided to modify an existing
-	// User. All fields are optional so clients can send just the fields they want
-	// changed. It uses pointer fields so we can differentiate between a field that
-	// was not provided and a field that was provided as explicitly blank. Normally
-	// we do not want to use pointers to basic types but we make exceptions around
-	// marshalling/unmarshalling.
-	type request struct {
-		Name            *string  `json:"name"`
-		Email           *string  `json:"email"`
-		Roles           []string `json:"roles"`
-		Password        *string  `json:"password"`
-		PasswordConfirm *string  `json:"password_confirm" validate:"omitempty,eqfield=Password"`
-	}
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 		restaurantID := chi.URLParam(r, "restaurantId")
 		if restaurantID == "" {
 			web.RespondError(w, r, http.StatusBadRequest, "restaurantID is undefined")
 			return
 		}
 
-		var updateUser request
-		err := web.DecodeBody(r, &updateUser)
+		version, err := web.IfMatchVersion(r)
 		if err != nil {
-			web.RespondError(w, r, http.StatusBadRequest, errors.Wrap(err, ""))
+			web.RespondPreconditionError(w, r, err)
 			return
 		}
 
-		if err := web.DecodeBody(r, &updateUser); err != nil {
+		var updateRestaurant restaurant.UpdateRestaurant
+		if err := web.DecodeBody(r, &updateRestaurant); err != nil {
+			web.RespondError(w, r, http.StatusBadRequest, "failed to read restaurant from request ", err)
+			return
+		}
 
+		before, err := s.restaurantRepo.GetRestaurant(ctx, restaurantID)
+		if err != nil {
+			respondRestaurantError(w, r, restaurantID, err)
+			return
 		}
+
+		// only restaurant owner or admin users can perform restaurant update
+		if !s.authorizeRestaurant(w, r, restaurantID, authorize.Resource{Object: authorize.ObjectRestaurant}, authorize.ActionUpdate) {
+			return
+		}
+
+		var updated *restaurant.Restaurant
+		err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+			if updated, err = s.restaurantRepo.UpdateRestaurant(ctx, restaurantID, updateRestaurant, version, time.Now()); err != nil {
+				return ne, err
+			}
+			return audit.NewEvent{Action: audit.ActionUpdate, TargetType: audit.TargetRestaurant, TargetID: restaurantID,
+				Before: before, After: updated}, nil
+		})
+		if err != nil {
+			respondRestaurantError(w, r, restaurantID, err)
+			return
+		}
+
+		web.SetETag(w, updated.Version)
+		web.Respond(w, r, http.StatusOK, updated)
 	}
 }
 
-// DeleteRestaurant removes the specified user from the system.
+// handleRestaurantDelete soft deletes the specified restaurant. Historical
+// menus and votes of the restaurant are kept.
 func (s *Server) handleRestaurantDelete() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -320,14 +478,35 @@ func (s *Server) handleRestaurantDelete() http.HandlerFunc {
 			return
 		}
 
-		err := s.restaurantRepo.DeleteRestaurant(ctx, restaurantID)
+		// only restaurant owner or admin users can delete restaurant
+		if !s.authorizeRestaurant(w, r, restaurantID, authorize.Resource{Object: authorize.ObjectRestaurant}, authorize.ActionDelete) {
+			return
+		}
+
+		version, err := web.IfMatchVersion(r)
+		if err != nil {
+			web.RespondPreconditionError(w, r, err)
+			return
+		}
+
+		// previous state is kept only for audit, missing restaurant is reported by DeleteRestaurant
+		before, _ := s.restaurantRepo.GetRestaurant(ctx, restaurantID)
+
+		err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (audit.NewEvent, error) {
+			return audit.NewEvent{Action: audit.ActionDelete, TargetType: audit.TargetRestaurant, TargetID: restaurantID,
+				Before: before}, s.restaurantRepo.DeleteRestaurant(ctx, restaurantID, version, time.Now())
+		})
 		if err != nil {
 			switch err {
+			case db.ErrVersionConflict:
+				err := web.NewRequestError(err, http.StatusPreconditionFailed)
+				web.RespondError(w, r, http.StatusPreconditionFailed, err)
+				return
 			case db.ErrInvalidID:
 				err := web.NewRequestError(err, http.StatusBadRequest)
 				web.RespondError(w, r, http.StatusBadRequest, err)
 				return
-			case db.ErrNotFound:
+			case restaurant.ErrRestaurantNotFound:
 				err := web.NewRequestError(err, http.StatusNotFound)
 				web.RespondError(w, r, http.StatusNotFound, err)
 				return
@@ -345,3 +524,79 @@ func (s *Server) handleRestaurantDelete() http.HandlerFunc {
 		web.Respond(w, r, http.StatusOK, nil)
 	}
 }
+
+// handleRestaurantRestore godoc
+// @Summary Restore a restaurant
+// @Description restore soft deleted restaurant, allowed only for admin
+// @Tags restaurants
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param restaurantId path string true "Restaurant ID"
+// @Success 200 {object} restaurant.Restaurant
+// @Failure 400 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/{restaurantId}/restore [post]
+func (s *Server) handleRestaurantRestore(w http.ResponseWriter, r *http.Request) {
+	restaurantID := chi.URLParam(r, "restaurantId")
+	var restored *restaurant.Restaurant
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if restored, err = s.restaurantRepo.RestoreRestaurant(ctx, restaurantID, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionRestore, TargetType: audit.TargetRestaurant, TargetID: restaurantID,
+			After: restored}, nil
+	})
+	if err != nil {
+		switch err {
+		case db.ErrInvalidID:
+			err := web.NewRequestError(err, http.StatusBadRequest)
+			web.RespondError(w, r, http.StatusBadRequest, err)
+			return
+		case restaurant.ErrRestaurantNotFound:
+			err := web.NewRequestError(err, http.StatusNotFound)
+			web.RespondError(w, r, http.StatusNotFound, err)
+			return
+		default:
+			err := errors.Wrapf(err, "Id: %s", restaurantID)
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	web.SetETag(w, restored.Version)
+	web.Respond(w, r, http.StatusOK, restored)
+}
+
+var errForbiddenMember = errors.New("action is allowed only for restaurant members with required role")
+
+func respondRestaurantError(w http.ResponseWriter, r *http.Request, restaurantID string, err error) {
+	switch err {
+	case db.ErrInvalidID:
+		err := web.NewRequestError(err, http.StatusBadRequest)
+		web.RespondError(w, r, http.StatusBadRequest, err)
+	case restaurant.ErrRestaurantNotFound:
+		err := web.NewRequestError(err, http.StatusNotFound)
+		web.RespondError(w, r, http.StatusNotFound, err)
+	case db.ErrForbidden:
+		err := web.NewRequestError(err, http.StatusForbidden)
+		web.RespondError(w, r, http.StatusForbidden, err)
+	case db.ErrVersionConflict:
+		err := web.NewRequestError(err, http.StatusPreconditionFailed)
+		web.RespondError(w, r, http.StatusPreconditionFailed, err)
+	case db.ErrNotFound, restaurant.ErrMemberNotFound, restaurant.ErrTransferNotFound:
+		err := web.NewRequestError(err, http.StatusNotFound)
+		web.RespondError(w, r, http.StatusNotFound, err)
+	case restaurant.ErrInvalidMemberRole, restaurant.ErrUnknownOffice:
+		err := web.NewRequestError(err, http.StatusBadRequest)
+		web.RespondError(w, r, http.StatusBadRequest, err)
+	case restaurant.ErrOwnerRemoval:
+		err := web.NewRequestError(err, http.StatusConflict)
+		web.RespondError(w, r, http.StatusConflict, err)
+	default:
+		err := errors.Wrapf(err, "Id: %s", restaurantID)
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+	}
+}
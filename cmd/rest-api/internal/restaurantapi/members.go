@@ -0,0 +1,233 @@
+package restaurantapi
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/authorize"
+	"github.com/remisb/mat/internal/restaurant"
+	"net/http"
+	"time"
+)
+
+// authorizeRestaurant checks Policy if the user sending request is allowed to perform
+// action on the resource of the restaurant. Restaurant member role of the user is loaded
+// before the check so deny rules with member conditions are applied as well.
+// Error response is sent and false is returned when user is not allowed to proceed.
+func (s *Server) authorizeRestaurant(w http.ResponseWriter, r *http.Request, restaurantID string, res authorize.Resource, action authorize.Action) bool {
+	sub := web.Subject(r)
+	if sub.UserID == "" {
+		web.RespondError(w, r, http.StatusUnauthorized, web.ErrNoTokenFound)
+		return false
+	}
+
+	// member conditions of the policy depend on restaurant role of the user
+	role, err := s.restaurantRepo.MemberRole(r.Context(), restaurantID, sub.UserID)
+	if err != nil {
+		respondRestaurantError(w, r, restaurantID, err)
+		return false
+	}
+
+	res.MemberRole = role
+	if web.Policy.Allowed(sub, res, action) {
+		return true
+	}
+
+	err = web.NewRequestError(errForbiddenMember, http.StatusForbidden)
+	web.RespondError(w, r, http.StatusForbidden, err)
+	return false
+}
+
+// handleMembersGet godoc
+// @Summary List restaurant members
+// @Description get restaurant owner and editors, allowed for restaurant members and admin
+// @Tags restaurants,members
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param restaurantId path string true "Restaurant ID"
+// @Success 200 {array} restaurant.Member
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/{restaurantId}/members [get]
+func (s *Server) handleMembersGet(w http.ResponseWriter, r *http.Request) {
+	restaurantID := chi.URLParam(r, "restaurantId")
+	if !s.authorizeRestaurant(w, r, restaurantID, authorize.Resource{Object: authorize.ObjectMember}, authorize.ActionRead) {
+		return
+	}
+
+	members, err := s.restaurantRepo.RetrieveMembers(r.Context(), restaurantID)
+	if err != nil {
+		respondRestaurantError(w, r, restaurantID, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, members)
+}
+
+// handleMemberInvite godoc
+// @Summary Invite restaurant member
+// @Description add user with specified email as restaurant editor, allowed for restaurant owner and admin
+// @Tags restaurants,members
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param restaurantId path string true "Restaurant ID"
+// @Param member body restaurant.NewMember true "new member"
+// @Success 201 {object} restaurant.Member
+// @Failure 400 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/{restaurantId}/members [post]
+func (s *Server) handleMemberInvite(w http.ResponseWriter, r *http.Request) {
+	restaurantID := chi.URLParam(r, "restaurantId")
+	if !s.authorizeRestaurant(w, r, restaurantID, authorize.Resource{Object: authorize.ObjectMember}, authorize.ActionCreate) {
+		return
+	}
+
+	var nm restaurant.NewMember
+	if err := web.DecodeBody(r, &nm); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read member from request ", err)
+		return
+	}
+
+	if nm.Email == "" {
+		web.RespondError(w, r, http.StatusBadRequest, "member email should not be empty.")
+		return
+	}
+
+	var member *restaurant.Member
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if member, err = s.restaurantRepo.AddMember(ctx, restaurantID, nm, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionCreate, TargetType: audit.TargetMember, TargetID: restaurantID,
+			After: member}, nil
+	})
+	if err != nil {
+		respondRestaurantError(w, r, restaurantID, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusCreated, member)
+}
+
+// handleMemberDelete godoc
+// @Summary Remove restaurant member
+// @Description remove restaurant editor, allowed for restaurant owner, admin and the member itself
+// @Tags restaurants,members
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param restaurantId path string true "Restaurant ID"
+// @Param userId path string true "User ID"
+// @Success 200
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/{restaurantId}/members/{userId} [delete]
+func (s *Server) handleMemberDelete(w http.ResponseWriter, r *http.Request) {
+	restaurantID := chi.URLParam(r, "restaurantId")
+	userID := chi.URLParam(r, "userId")
+
+	// members are allowed to leave restaurant by themselves
+	res := authorize.Resource{Object: authorize.ObjectMember, OwnerID: userID}
+	if !s.authorizeRestaurant(w, r, restaurantID, res, authorize.ActionDelete) {
+		return
+	}
+
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (audit.NewEvent, error) {
+		return audit.NewEvent{Action: audit.ActionDelete, TargetType: audit.TargetMember, TargetID: restaurantID,
+			Before: restaurant.Member{RestaurantID: restaurantID, UserID: userID}}, s.restaurantRepo.RemoveMember(ctx, restaurantID, userID)
+	})
+	if err != nil {
+		respondRestaurantError(w, r, restaurantID, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, nil)
+}
+
+// handleTransferStart godoc
+// @Summary Start restaurant ownership transfer
+// @Description start restaurant ownership transfer to another user, allowed for restaurant owner and admin
+// @Tags restaurants,members
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param restaurantId path string true "Restaurant ID"
+// @Success 202 {object} restaurant.OwnershipTransfer
+// @Failure 400 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/{restaurantId}/transfer [post]
+func (s *Server) handleTransferStart(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		UserID string `json:"userId" validate:"required"`
+	}
+
+	restaurantID := chi.URLParam(r, "restaurantId")
+	if !s.authorizeRestaurant(w, r, restaurantID, authorize.Resource{Object: authorize.ObjectRestaurant}, authorize.ActionTransfer) {
+		return
+	}
+
+	var req request
+	if err := web.DecodeBody(r, &req); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read transfer from request ", err)
+		return
+	}
+
+	var transfer *restaurant.OwnershipTransfer
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if transfer, err = s.restaurantRepo.StartOwnershipTransfer(ctx, restaurantID, req.UserID, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionTransfer, TargetType: audit.TargetRestaurant, TargetID: restaurantID,
+			After: transfer}, nil
+	})
+	if err != nil {
+		respondRestaurantError(w, r, restaurantID, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusAccepted, transfer)
+}
+
+// handleTransferAccept godoc
+// @Summary Accept restaurant ownership transfer
+// @Description accept pending restaurant ownership transfer, allowed only for the new owner
+// @Tags restaurants,members
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param restaurantId path string true "Restaurant ID"
+// @Success 200 {object} restaurant.Restaurant
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/{restaurantId}/transfer/accept [post]
+func (s *Server) handleTransferAccept(w http.ResponseWriter, r *http.Request) {
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	restaurantID := chi.URLParam(r, "restaurantId")
+	userID := claims.Subject
+	var rest *restaurant.Restaurant
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if rest, err = s.restaurantRepo.AcceptOwnershipTransfer(ctx, restaurantID, userID, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionUpdate, TargetType: audit.TargetRestaurant, TargetID: restaurantID,
+			After: rest}, nil
+	})
+	if err != nil {
+		respondRestaurantError(w, r, restaurantID, err)
+		return
+	}
+
+	web.SetETag(w, rest.Version)
+	web.Respond(w, r, http.StatusOK, rest)
+}
@@ -0,0 +1,84 @@
+package restaurantapi
+
+import (
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/office"
+	"net/http"
+	"time"
+)
+
+// officeScope is an office the request is performed for.
+type officeScope struct {
+	// office is passed with office query parameter or is the home office of the
+	// signed in user, nil when neither is known
+	office *office.Office
+	// filter is ID of the office passed with office query parameter, listings
+	// are limited to its restaurant catalog
+	filter string
+}
+
+// today returns the current day of the office, server time is used without office.
+func (sc officeScope) today() time.Time {
+	if sc.office == nil {
+		return time.Now()
+	}
+	return sc.office.Today(time.Now())
+}
+
+// requestOffice resolves office of the request from office query parameter or
+// home office of the signed in user. Error response is sent and false is returned
+// when passed office is not found.
+func (s *Server) requestOffice(w http.ResponseWriter, r *http.Request) (officeScope, bool) {
+	ctx := r.Context()
+	if officeID := r.URL.Query().Get("office"); officeID != "" {
+		o, err := s.officeRepo.Retrieve(ctx, officeID)
+		if err != nil {
+			respondOfficeError(w, r, err)
+			return officeScope{}, false
+		}
+		return officeScope{office: o, filter: o.ID}, true
+	}
+
+	claims, ok := auth.ClaimsFromContext(ctx)
+	if !ok {
+		return officeScope{}, true
+	}
+	o, err := s.officeRepo.HomeOffice(ctx, claims.Subject)
+	switch err {
+	case nil:
+		return officeScope{office: o}, true
+	case db.ErrNotFound, db.ErrInvalidID:
+		return officeScope{}, true
+	default:
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return officeScope{}, false
+	}
+}
+
+// restaurantOffice returns office of the restaurant catalog, nil is returned for
+// restaurant which is not listed in any office.
+func (s *Server) restaurantOffice(w http.ResponseWriter, r *http.Request, restaurantID string) (*office.Office, bool) {
+	o, err := s.officeRepo.RestaurantOffice(r.Context(), restaurantID)
+	switch err {
+	case nil:
+		return o, true
+	case db.ErrNotFound:
+		return nil, true
+	default:
+		respondOfficeError(w, r, err)
+		return nil, false
+	}
+}
+
+func respondOfficeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case db.ErrInvalidID:
+		web.RespondError(w, r, http.StatusBadRequest, err)
+	case db.ErrNotFound:
+		web.RespondError(w, r, http.StatusNotFound, err)
+	default:
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+	}
+}
@@ -18,6 +18,7 @@ const (
 	restaurantPaikisID  = "0ce90028-69cb-4e9c-9af0-7bbada50d5b6"
 	restaurantInvalidID = "Qce90028-69cb-4e9c-9af0-7bbada50d5b6"
 	restaurantNoFountID = "5cf37266-3473-4006-984f-9325122678b7"
+	restaurantRootID    = "71b8fb90-24eb-4012-9048-3ba210aac0f6"
 )
 
 var e *httpexpect.Expect
@@ -38,10 +39,10 @@ var restaurantTest *tests.Test
 func TestRestaurants(t *testing.T) {
 	restaurantTest = tests.NewTest(t)
 	t.Cleanup(restaurantTest.Cleanup)
-	web.InitAuth()
+	web.Keys = restaurantTest.Keys
 	r := chi.NewRouter()
 
-	userServer := userapi.NewServer("testing", nil, restaurantTest.Dbx)
+	userServer := userapi.NewServer("testing", nil, restaurantTest.Dbx, userapi.Registration{})
 	restaurantServer := NewServer("development", nil, restaurantTest.Dbx)
 	r.Route("/api/v1/", func(r chi.Router) {
 		r.Mount("/users", userServer.Router)
@@ -56,8 +57,11 @@ func TestRestaurants(t *testing.T) {
 	t.Run("restaurants get", TestGetRestaurants)
 	t.Run("restaurant get", TestGetRestaurant)
 	t.Run("restaurant create", TestCreateRestaurant)
+	t.Run("restaurant suggestions", TestRestaurantSuggestions)
 	t.Run("menus get", TestGetRestaurantMenus)
 	t.Run("menu get", TestRestaurantMenuRetrieval)
+	t.Run("restaurant members", TestRestaurantMembers)
+	t.Run("restaurant ownership transfer", TestRestaurantOwnershipTransfer)
 	t.Run("menu create", TestCreateMenu)
 	t.Run("menu update", TestUpdateMenu)
 
@@ -66,6 +70,9 @@ func TestRestaurants(t *testing.T) {
 	t.Run("vote get today votes by anonymous", TestGetTodayVotes)
 	t.Run("vote second per day is forbidden", TestVoteAuthorizedSecondPerDayForbidden)
 	t.Run("vote by user", TestVoteAuthorizedTwoPerDay)
+	t.Run("restaurant stats", TestRestaurantStats)
+	t.Run("restaurant update with stale etag", TestUpdateRestaurantETag)
+	t.Run("restaurant delete and restore", TestDeleteRestoreRestaurant)
 }
 
 func TestGetRestaurants(t *testing.T) {
@@ -158,17 +165,14 @@ func TestCreateRestaurant(t *testing.T) {
 		JSON().Object().
 		Path("$.error.message").Equal("no token found")
 
-	// get claims for test user 1s
-	restaurantObj := e.POST("/api/v1/restaurant").
+	// user should suggest restaurant instead of creating it
+	e.POST("/api/v1/restaurant").
 		WithHeader("Authorization", "Bearer "+restaurantTest.User.Token).
 		WithJSON(newRestaurant).
-		Expect().Status(http.StatusCreated).
-		JSON().Object()
-
-	assertRestaurantError(restaurantObj, newRestaurant)
+		Expect().Status(http.StatusForbidden)
 
 	// create restaurant with admin token
-	restaurantObj = e.POST("/api/v1/restaurant").
+	restaurantObj := e.POST("/api/v1/restaurant").
 		WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token).
 		WithJSON(newRestaurant).
 		Expect().Status(http.StatusCreated).
@@ -176,9 +180,9 @@ func TestCreateRestaurant(t *testing.T) {
 
 	assertRestaurantError(restaurantObj, newRestaurant)
 
-	// create restaurant with user token
+	// create second restaurant with admin token
 	restaurantObj = e.POST("/api/v1/restaurant").
-		WithHeader("Authorization", "Bearer "+restaurantTest.User.Token).
+		WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token).
 		WithJSON(newRestaurant2).
 		Expect().Status(http.StatusCreated).
 		JSON().Object()
@@ -196,7 +200,7 @@ func TestCreateRestaurant(t *testing.T) {
 func TestCreateMenu(t *testing.T) {
 
 	newMenu1 := newMenu{
-		RestaurantID: restaurantPaikisID,
+		RestaurantID: restaurantLokysID,
 		Menu:         "Menu test content 1 for 2030.03.24 for Lokys restaurant",
 		Date:         NewDate(2020, 3, 24),
 	}
@@ -211,12 +215,24 @@ func TestCreateMenu(t *testing.T) {
 	assertMenuEqual(menuObj, newMenu1)
 
 	newMenu2 := newMenu{
-		RestaurantID: restaurantPaikisID,
+		RestaurantID: restaurantLokysID,
 		Menu:         "Menu test content 1 for 2030.03.24 for Lokys restaurant",
 		Date:         NewDate(2020, 3, 25),
 	}
 
-	// user success
+	// not a restaurant member
+	e.POST("/api/v1/restaurant/{restaurantId}/menu", restaurantLokysID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.User1.Token).
+		WithJSON(newMenu2).
+		Expect().Status(http.StatusForbidden)
+
+	// menu of another restaurant
+	e.POST("/api/v1/restaurant/{restaurantId}/menu", restaurantPaikisID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token).
+		WithJSON(newMenu2).
+		Expect().Status(http.StatusBadRequest)
+
+	// restaurant editor success
 	menuObj = e.POST("/api/v1/restaurant/{restaurantId}/menu", restaurantLokysID).
 		WithHeader("Authorization", "Bearer "+restaurantTest.User.Token).
 		WithJSON(newMenu2).
@@ -226,6 +242,92 @@ func TestCreateMenu(t *testing.T) {
 	assertMenuEqual(menuObj, newMenu2)
 }
 
+func TestRestaurantMembers(t *testing.T) {
+	authAdmin := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token)
+	})
+	authUser := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+restaurantTest.User.Token)
+	})
+
+	// only owner or admin can invite members
+	authUser.POST("/api/v1/restaurant/{restaurantId}/members", restaurantLokysID).
+		WithJSON(restaurant.NewMember{Email: "user@example.com"}).
+		Expect().Status(http.StatusForbidden)
+
+	authAdmin.POST("/api/v1/restaurant/{restaurantId}/members", restaurantLokysID).
+		WithJSON(restaurant.NewMember{Email: "nobody@example.com"}).
+		Expect().Status(http.StatusNotFound)
+
+	authAdmin.POST("/api/v1/restaurant/{restaurantId}/members", restaurantLokysID).
+		WithJSON(restaurant.NewMember{Email: "user@example.com", Role: restaurant.MemberOwner}).
+		Expect().Status(http.StatusBadRequest)
+
+	member := authAdmin.POST("/api/v1/restaurant/{restaurantId}/members", restaurantLokysID).
+		WithJSON(restaurant.NewMember{Email: "user@example.com"}).
+		Expect().Status(http.StatusCreated).
+		JSON().Object()
+	member.ValueEqual("userId", restaurantTest.User.UserID)
+	member.ValueEqual("role", restaurant.MemberEditor)
+
+	// editor can list members, other users can not
+	members := authUser.GET("/api/v1/restaurant/{restaurantId}/members", restaurantLokysID).
+		Expect().Status(http.StatusOK).
+		JSON().Array()
+	members.Length().Equal(2)
+	members.Element(0).Object().ValueEqual("role", restaurant.MemberOwner)
+
+	e.GET("/api/v1/restaurant/{restaurantId}/members", restaurantLokysID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.User1.Token).
+		Expect().Status(http.StatusForbidden)
+
+	// owner can not be removed without ownership transfer
+	authAdmin.DELETE("/api/v1/restaurant/{restaurantId}/members/{userId}",
+		restaurantLokysID, restaurantTest.Admin.UserID).
+		Expect().Status(http.StatusConflict)
+
+	authAdmin.DELETE("/api/v1/restaurant/{restaurantId}/members/{userId}",
+		restaurantLokysID, restaurantTest.User1.UserID).
+		Expect().Status(http.StatusNotFound)
+}
+
+func TestRestaurantOwnershipTransfer(t *testing.T) {
+	authAdmin := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token)
+	})
+	authUser2 := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+restaurantTest.User2.Token)
+	})
+
+	authUser2.POST("/api/v1/restaurant/{restaurantId}/transfer", restaurantRootID).
+		WithJSON(map[string]string{"userId": restaurantTest.User2.UserID}).
+		Expect().Status(http.StatusForbidden)
+
+	authAdmin.POST("/api/v1/restaurant/{restaurantId}/transfer", restaurantRootID).
+		WithJSON(map[string]string{"userId": restaurantTest.User2.UserID}).
+		Expect().Status(http.StatusAccepted).
+		JSON().Object().ValueEqual("toUserId", restaurantTest.User2.UserID)
+
+	// only the new owner can accept transfer
+	e.POST("/api/v1/restaurant/{restaurantId}/transfer/accept", restaurantRootID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.User1.Token).
+		Expect().Status(http.StatusNotFound)
+
+	authUser2.POST("/api/v1/restaurant/{restaurantId}/transfer/accept", restaurantRootID).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("ownerUserId", restaurantTest.User2.UserID)
+
+	members := authUser2.GET("/api/v1/restaurant/{restaurantId}/members", restaurantRootID).
+		Expect().Status(http.StatusOK).
+		JSON().Array()
+	members.Length().Equal(2)
+
+	// previous owner stays as an editor and can leave restaurant
+	authAdmin.DELETE("/api/v1/restaurant/{restaurantId}/members/{userId}",
+		restaurantRootID, restaurantTest.Admin.UserID).
+		Expect().Status(http.StatusOK)
+}
+
 func assertMenuEqual(actual *httpexpect.Object, expected newMenu) {
 	actual.Value("id").NotNull()
 	actual.ValueEqual("restaurantId", expected.RestaurantID)
@@ -241,21 +343,44 @@ func TestUpdateMenu(t *testing.T) {
 		Date:         NewDate(2020, 3, 2),
 	}
 
-	// admin success
-	menuObj := e.POST("/api/v1/restaurant/{restaurantId}/menu", restaurantLokysID).
+	// menu of the date is already added, POST does not overwrite it
+	e.POST("/api/v1/restaurant/{restaurantId}/menu", restaurantLokysID).
 		WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token).
 		WithJSON(newMenuUpdate).
-		Expect().Status(http.StatusCreated).
-		JSON().Object()
+		Expect().Status(http.StatusConflict)
 
-	// date should not be updated
-	// votes count should not be updated
+	// menu id can not be passed to POST
+	withID := restaurant.UpdateMenu{ID: menuLokys2ID, Menu: newMenuUpdate.Menu, Date: newMenuUpdate.Date}
+	e.POST("/api/v1/restaurant/{restaurantId}/menu", restaurantPaikisID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token).
+		WithJSON(withID).
+		Expect().Status(http.StatusBadRequest)
+
+	etag := e.GET("/api/v1/restaurant/{restaurantId}/menu/{menuId}", restaurantLokysID, menuLokys2ID).
+		Expect().Status(http.StatusOK).
+		Header("ETag").NotEmpty().Raw()
+
+	// only restaurant owner, editor or admin can replace menu
+	update := restaurant.UpdateMenu{Menu: "Lokys menu for 2020-03-02 replaced"}
+	e.PUT("/api/v1/restaurant/{restaurantId}/menu/{menuId}", restaurantLokysID, menuLokys2ID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.User1.Token).
+		WithHeader("If-Match", etag).
+		WithJSON(update).
+		Expect().Status(http.StatusForbidden)
+
+	// menu of another restaurant
+	e.PUT("/api/v1/restaurant/{restaurantId}/menu/{menuId}", restaurantPaikisID, menuLokys2ID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token).
+		WithHeader("If-Match", etag).
+		WithJSON(update).
+		Expect().Status(http.StatusNotFound)
 
-	menuObj.Value("id").NotNull()
-	menuObj.ValueEqual("restaurantId", restaurantLokysID)
-	menuObj.ValueEqual("menu", newMenuUpdate.Menu)
-	menuObj.ValueEqual("date", newMenuUpdate.Date)
-	menuObj.ValueEqual("votes", 0)
+	e.PUT("/api/v1/restaurant/{restaurantId}/menu/{menuId}", restaurantLokysID, menuLokys2ID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token).
+		WithHeader("If-Match", etag).
+		WithJSON(update).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("menu", update.Menu)
 }
 
 func TestRestaurantMenuRetrieval(t *testing.T) {
@@ -280,6 +405,121 @@ func TestRestaurantMenuRetrieval(t *testing.T) {
 		JSON().Array().Length().Equal(0)
 }
 
+func TestUpdateRestaurantETag(t *testing.T) {
+	authAdmin := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token)
+	})
+
+	resp := e.GET("/api/v1/restaurant/{restaurantId}", restaurantLokysID).
+		Expect().Status(http.StatusOK)
+	etag := resp.Header("ETag").NotEmpty().Raw()
+
+	e.GET("/api/v1/restaurant/{restaurantId}", restaurantLokysID).
+		WithHeader("If-None-Match", etag).
+		Expect().Status(http.StatusNotModified)
+
+	name := "Lokys updated"
+	update := restaurant.UpdateRestaurant{Name: &name}
+
+	// If-Match is required
+	authAdmin.PUT("/api/v1/restaurant/{restaurantId}", restaurantLokysID).
+		WithJSON(update).
+		Expect().Status(http.StatusPreconditionRequired)
+
+	// only owner or admin can update restaurant
+	e.PUT("/api/v1/restaurant/{restaurantId}", restaurantLokysID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.User.Token).
+		WithHeader("If-Match", etag).
+		WithJSON(update).
+		Expect().Status(http.StatusForbidden)
+
+	updated := authAdmin.PUT("/api/v1/restaurant/{restaurantId}", restaurantLokysID).
+		WithHeader("If-Match", etag).
+		WithJSON(update).
+		Expect().Status(http.StatusOK)
+	updated.JSON().Object().ValueEqual("name", name)
+	updated.Header("ETag").NotEqual(etag)
+
+	// second update with the same etag is rejected
+	authAdmin.PATCH("/api/v1/restaurant/{restaurantId}", restaurantLokysID).
+		WithHeader("If-Match", etag).
+		WithJSON(update).
+		Expect().Status(http.StatusPreconditionFailed)
+
+	e.GET("/api/v1/restaurant/{restaurantId}", restaurantLokysID).
+		WithHeader("If-None-Match", etag).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("name", name)
+}
+
+func TestDeleteRestoreRestaurant(t *testing.T) {
+	authAdmin := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token)
+	})
+
+	count := e.GET("/api/v1/restaurant").
+		Expect().Status(http.StatusOK).
+		JSON().Array().Length().Raw()
+
+	menuID := authAdmin.POST("/api/v1/restaurant/{restaurantId}/menu", restaurantPaikisID).
+		WithJSON(newMenu{Menu: "Paikis menu for 2030-01-02", Date: NewDate(2030, 1, 2)}).
+		Expect().Status(http.StatusCreated).
+		JSON().Object().Value("id").String().Raw()
+
+	etag := e.GET("/api/v1/restaurant/{restaurantId}", restaurantPaikisID).
+		Expect().Status(http.StatusOK).
+		Header("ETag").NotEmpty().Raw()
+
+	authAdmin.DELETE("/api/v1/restaurant/{restaurantId}", restaurantPaikisID).
+		WithHeader("If-Match", etag).
+		Expect().Status(http.StatusOK)
+
+	// deleted restaurant is hidden
+	e.GET("/api/v1/restaurant/{restaurantId}", restaurantPaikisID).
+		Expect().Status(http.StatusNotFound).
+		JSON().Object().Path("$.error.message").Equal("Restaurant not found")
+	e.GET("/api/v1/restaurant").
+		Expect().Status(http.StatusOK).
+		JSON().Array().Length().Equal(count - 1)
+
+	// menus of deleted restaurant can not be read or voted for
+	e.GET("/api/v1/restaurant/{restaurantId}/menu/{menuId}", restaurantPaikisID, menuID).
+		Expect().Status(http.StatusNotFound)
+	authAdmin.POST("/api/v1/restaurant/{restaurantId}/menu/{menuId}/vote", restaurantPaikisID, menuID).
+		WithQuery("date", "2030-01-02").
+		Expect().Status(http.StatusNotFound)
+
+	// ownership of deleted restaurant can not be transferred
+	authAdmin.POST("/api/v1/restaurant/{restaurantId}/transfer", restaurantPaikisID).
+		WithJSON(map[string]string{"userId": restaurantTest.User2.UserID}).
+		Expect().Status(http.StatusNotFound)
+
+	// second delete of the same restaurant
+	authAdmin.DELETE("/api/v1/restaurant/{restaurantId}", restaurantPaikisID).
+		WithHeader("If-Match", etag).
+		Expect().Status(http.StatusNotFound)
+
+	// restore is allowed only for admin
+	e.POST("/api/v1/restaurant/{restaurantId}/restore", restaurantPaikisID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.User.Token).
+		Expect().Status(http.StatusForbidden)
+
+	authAdmin.POST("/api/v1/restaurant/{restaurantId}/restore", restaurantPaikisID).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("id", restaurantPaikisID)
+
+	e.GET("/api/v1/restaurant/{restaurantId}", restaurantPaikisID).
+		Expect().Status(http.StatusOK).
+		JSON().Object().NotContainsKey("deletedAt")
+	e.GET("/api/v1/restaurant").
+		Expect().Status(http.StatusOK).
+		JSON().Array().Length().Equal(count)
+
+	// restore of active restaurant
+	authAdmin.POST("/api/v1/restaurant/{restaurantId}/restore", restaurantPaikisID).
+		Expect().Status(http.StatusNotFound)
+}
+
 func assertRestaurantError(actual *httpexpect.Object, expected restaurant.NewRestaurant) {
 	actual.ValueEqual("name", expected.Name)
 	actual.ValueEqual("address", expected.Address)
@@ -291,3 +531,59 @@ func assertRestaurantError(actual *httpexpect.Object, expected restaurant.NewRes
 func NewDate(year int, month time.Month, day int) time.Time {
 	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
 }
+
+func TestRestaurantSuggestions(t *testing.T) {
+	newSuggestion := restaurant.NewSuggestion{
+		Name:    "Suggested restaurant",
+		Address: "suggested restaurant address",
+		Comment: "great soups",
+	}
+
+	e.POST("/api/v1/restaurant/suggestions").WithJSON(newSuggestion).
+		Expect().Status(http.StatusUnauthorized)
+
+	suggestion := e.POST("/api/v1/restaurant/suggestions").
+		WithHeader("Authorization", "Bearer "+restaurantTest.User.Token).
+		WithJSON(newSuggestion).
+		Expect().Status(http.StatusCreated).
+		JSON().Object()
+
+	suggestion.ValueEqual("name", newSuggestion.Name)
+	suggestion.ValueEqual("status", restaurant.SuggestionPending)
+	suggestionID := suggestion.Value("id").String().Raw()
+
+	e.POST("/api/v1/restaurant/suggestions/{suggestionId}/upvote", suggestionID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.User2.Token).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("upvotes", 1)
+
+	e.POST("/api/v1/restaurant/suggestions/{suggestionId}/upvote", suggestionID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.User2.Token).
+		Expect().Status(http.StatusConflict)
+
+	e.GET("/api/v1/restaurant/suggestions").
+		WithQuery("status", restaurant.SuggestionPending).
+		WithHeader("Authorization", "Bearer "+restaurantTest.User.Token).
+		Expect().Status(http.StatusOK).
+		JSON().Array().Length().Equal(1)
+
+	e.POST("/api/v1/restaurant/suggestions/{suggestionId}/approve", suggestionID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.User.Token).
+		Expect().Status(http.StatusForbidden)
+
+	e.POST("/api/v1/restaurant/suggestions/{suggestionId}/approve", suggestionID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token).
+		Expect().Status(http.StatusCreated).
+		JSON().Object().ValueEqual("name", newSuggestion.Name)
+
+	e.POST("/api/v1/restaurant/suggestions/{suggestionId}/reject", suggestionID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token).
+		WithJSON(map[string]string{"reason": "already approved"}).
+		Expect().Status(http.StatusConflict)
+
+	// suggester is notified on submit and approval
+	e.GET("/api/v1/users/notifications").
+		WithHeader("Authorization", "Bearer "+restaurantTest.User.Token).
+		Expect().Status(http.StatusOK).
+		JSON().Array().Length().Equal(2)
+}
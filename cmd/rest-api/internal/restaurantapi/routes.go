@@ -3,6 +3,8 @@ package restaurantapi
 import (
 	"github.com/go-chi/chi"
 	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/authorize"
 )
 
 func (s *Server) initRoutes() {
@@ -11,23 +13,65 @@ func (s *Server) initRoutes() {
 		restaurants := chi.NewMux()
 		restaurants.Use(web.CorsHandler)
 
-		restaurants.Get("/votes", s.handleMenuVotesGet)
-		restaurants.Get("/menus", s.handleMenusGet)
-		restaurants.Get("/", s.handleRestaurantsGet)
-		restaurants.Get("/{restaurantId}", s.handleRestaurantGet)
-		restaurants.Get("/{restaurantId}/menu", s.handleRestaurantMenusGet)
-		restaurants.Get("/{restaurantId}/menu/:menuId", s.handleRestaurantMenuGet)
+		authenticator := *s.authenticator
+		restaurants.Use(web.Verifier(authenticator.Keys(), authenticator))
+
+		// anonymous requests see restaurants of the default organization
+		restaurants.Group(func(r chi.Router) {
+			r.Use(web.OptionalAuthenticator)
+			r.Use(web.Tenant)
+
+			r.Get("/votes", s.handleMenuVotesGet)
+			r.Get("/menus", s.handleMenusGet)
+			r.Get("/", s.handleRestaurantsGet)
+			r.Get("/{restaurantId}", s.handleRestaurantGet)
+			r.Get("/{restaurantId}/menu", s.handleRestaurantMenusGet)
+			r.Get("/{restaurantId}/menu/{menuId}", s.handleRestaurantMenuGet)
+		})
 
-		auth := *s.authenticator
 		restaurants.Group(func(r chi.Router) {
-			r.Use(web.Verifier(auth.JWTAuth()))
 			r.Use(web.Authenticator)
+			r.Use(web.Tenant)
+
+			r.Group(func(r chi.Router) {
+				r.Use(web.RequireScope(auth.ScopeVotesWrite))
+
+				r.With(web.Allow(authorize.ObjectVote, authorize.ActionCreate)).
+					Post("/{restaurantId}/menu/{menuId}/vote", s.handleRestaurantMenuVotePost)
+				r.With(web.Allow(authorize.ObjectSuggestion, authorize.ActionCreate)).
+					Post("/suggestions", s.handleSuggestionCreate)
+				r.With(web.Allow(authorize.ObjectSuggestion, authorize.ActionUpvote)).
+					Post("/suggestions/{suggestionId}/upvote", s.handleSuggestionUpvote)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(web.RequireScope(auth.ScopeMenusRead, auth.ScopeRestaurantsManage))
+
+				r.With(web.Allow(authorize.ObjectSuggestion, authorize.ActionRead)).
+					Get("/suggestions", s.handleSuggestionsGet)
+				r.Get("/{restaurantId}/stats", s.handleRestaurantStatsGet)
+				r.Get("/{restaurantId}/members", s.handleMembersGet)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(web.RequireScope(auth.ScopeRestaurantsManage))
 
-			r.Post("/{restaurantId}/menu/{menuId}/vote", s.handleRestaurantMenuVotePost)
-			r.Post("/", s.handleRestaurantCreate)
-			r.Put("/{restaurantId}", s.handleRestaurantUpdate())
-			r.Delete("/{restaurantId}", s.handleRestaurantDelete())
-			r.Post("/{restaurantId}/menu", s.handleRestaurantMenuCreate)
+				r.With(web.Allow(authorize.ObjectSuggestion, authorize.ActionApprove)).
+					Post("/suggestions/{suggestionId}/approve", s.handleSuggestionApprove)
+				r.With(web.Allow(authorize.ObjectSuggestion, authorize.ActionReject)).
+					Post("/suggestions/{suggestionId}/reject", s.handleSuggestionReject)
+				r.With(web.Allow(authorize.ObjectRestaurant, authorize.ActionCreate)).
+					Post("/", s.handleRestaurantCreate)
+				r.Put("/{restaurantId}", s.handleRestaurantUpdate())
+				r.Patch("/{restaurantId}", s.handleRestaurantUpdate())
+				r.Delete("/{restaurantId}", s.handleRestaurantDelete())
+				r.With(web.Allow(authorize.ObjectRestaurant, authorize.ActionRestore)).
+					Post("/{restaurantId}/restore", s.handleRestaurantRestore)
+				r.Post("/{restaurantId}/menu", s.handleRestaurantMenuCreate)
+				r.Put("/{restaurantId}/menu/{menuId}", s.handleRestaurantMenuUpdate)
+				r.Post("/{restaurantId}/members", s.handleMemberInvite)
+				r.Delete("/{restaurantId}/members/{userId}", s.handleMemberDelete)
+				r.Post("/{restaurantId}/transfer", s.handleTransferStart)
+				r.Post("/{restaurantId}/transfer/accept", s.handleTransferAccept)
+			})
 		})
 
 		s.Router = restaurants
@@ -4,7 +4,10 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/jmoiron/sqlx"
 	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
 	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/notify"
+	"github.com/remisb/mat/internal/office"
 	"github.com/remisb/mat/internal/restaurant"
 	"github.com/remisb/mat/internal/user"
 	"os"
@@ -14,6 +17,9 @@ import (
 type Server struct {
 	//Router http.Handler
 	restaurantRepo *restaurant.Repo
+	officeRepo     *office.Repo
+	notifier       notify.Notifier
+	auditor        audit.Recorder
 	Router         *chi.Mux
 	build          string
 	authenticator  *auth.Authenticator
@@ -24,8 +30,11 @@ func NewServer(build string, shutdown chan os.Signal, db *sqlx.DB) *Server {
 	userRepo := user.NewRepo(db)
 	s := Server{
 		build:          build,
-		authenticator:  auth.New(userRepo, web.Auth),
+		authenticator:  auth.New(userRepo, nil, auth.NewTokenStore(db), web.Keys, nil),
 		restaurantRepo: restaurant.NewRepo(db),
+		officeRepo:     office.NewRepo(db),
+		notifier:       notify.NewRepo(db),
+		auditor:        audit.NewRepo(db),
 	}
 
 	s.initRoutes()
@@ -0,0 +1,60 @@
+package restaurantapi
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/authorize"
+	"net/http"
+	"time"
+)
+
+// statsDefaultPeriod is used when from query parameter is not provided.
+const statsDefaultPeriod = 30 * 24 * time.Hour
+
+// handleRestaurantStatsGet godoc
+// @Summary Restaurant statistics
+// @Description get restaurant votes per day, win rate, average rating and dietary tags performance,
+// @Description allowed for restaurant owner and admin
+// @Tags restaurants
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param restaurantId path string true "Restaurant ID"
+// @Param from query string false "period start date, default 30 days before to" Format(date)
+// @Param to query string false "period end date inclusive, default today" Format(date)
+// @Success 200 {object} restaurant.Stats
+// @Failure 400 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/{restaurantId}/stats [get]
+func (s *Server) handleRestaurantStatsGet(w http.ResponseWriter, r *http.Request) {
+	restaurantID := chi.URLParam(r, "restaurantId")
+	if !s.authorizeRestaurant(w, r, restaurantID, authorize.Resource{Object: authorize.ObjectRestaurant}, authorize.ActionStats) {
+		return
+	}
+
+	to, err := web.QueryDate(r, "to", time.Now())
+	if err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	from, err := web.QueryDate(r, "from", to.Add(-statsDefaultPeriod))
+	if err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if from.After(to) {
+		web.RespondError(w, r, http.StatusBadRequest, "from date should not be after to date")
+		return
+	}
+
+	stats, err := s.restaurantRepo.RestaurantStats(r.Context(), restaurantID, from, to)
+	if err != nil {
+		respondRestaurantError(w, r, restaurantID, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, stats)
+}
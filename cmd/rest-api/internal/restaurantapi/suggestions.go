@@ -0,0 +1,230 @@
+package restaurantapi
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/restaurant"
+	"net/http"
+	"time"
+)
+
+// handleSuggestionsGet godoc
+// @Summary List restaurant suggestions
+// @Description get restaurant suggestions, the most upvoted first
+// @Tags suggestions
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param status query string false "suggestion status: pending, approved or rejected"
+// @Success 200 {array} restaurant.Suggestion
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/suggestions [get]
+func (s *Server) handleSuggestionsGet(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	suggestions, err := s.restaurantRepo.RetrieveSuggestions(r.Context(), status)
+	if err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, suggestions)
+}
+
+// handleSuggestionCreate godoc
+// @Summary Suggest a restaurant
+// @Description add new restaurant suggestion for admin approval
+// @Tags suggestions
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param suggestion body restaurant.NewSuggestion true "new suggestion"
+// @Success 201 {object} restaurant.Suggestion
+// @Failure 400 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/suggestions [post]
+func (s *Server) handleSuggestionCreate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	var ns restaurant.NewSuggestion
+	if err := web.DecodeBody(r, &ns); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read suggestion from request", err)
+		return
+	}
+
+	if len(ns.Name) == 0 {
+		web.RespondError(w, r, http.StatusBadRequest, "name of the restaurant should not be empty.")
+		return
+	}
+
+	userID := claims.Subject
+	suggestion, err := s.restaurantRepo.CreateSuggestion(ctx, ns, userID, time.Now())
+	if err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.notify(ctx, suggestion.SuggestedBy, "Restaurant suggestion received",
+		fmt.Sprintf("Your suggestion of restaurant %q is waiting for approval.", suggestion.Name))
+
+	web.Respond(w, r, http.StatusCreated, suggestion)
+}
+
+// handleSuggestionUpvote godoc
+// @Summary Upvote restaurant suggestion
+// @Description upvote pending restaurant suggestion, one upvote per user
+// @Tags suggestions
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param suggestionId path string true "Suggestion ID"
+// @Success 200 {object} restaurant.Suggestion
+// @Failure 404 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/suggestions/{suggestionId}/upvote [post]
+func (s *Server) handleSuggestionUpvote(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	suggestionID := chi.URLParam(r, "suggestionId")
+	userID := claims.Subject
+	suggestion, err := s.restaurantRepo.UpvoteSuggestion(ctx, suggestionID, userID, time.Now())
+	if err != nil {
+		respondSuggestionError(w, r, suggestionID, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, suggestion)
+}
+
+// handleSuggestionApprove godoc
+// @Summary Approve restaurant suggestion
+// @Description create restaurant from pending suggestion, allowed only for admin
+// @Tags suggestions
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param suggestionId path string true "Suggestion ID"
+// @Success 201 {object} restaurant.Restaurant
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/suggestions/{suggestionId}/approve [post]
+func (s *Server) handleSuggestionApprove(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := web.Subject(r).UserID
+
+	suggestionID := chi.URLParam(r, "suggestionId")
+	var suggestion *restaurant.Suggestion
+	var rest *restaurant.Restaurant
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if suggestion, rest, err = s.restaurantRepo.ApproveSuggestion(ctx, suggestionID, userID, time.Now()); err != nil {
+			return ne, err
+		}
+		// created restaurant is recorded by its own event in the same transaction
+		ne = audit.NewEvent{Action: audit.ActionCreate, TargetType: audit.TargetRestaurant, TargetID: rest.ID, After: rest}
+		if err := s.auditor.Record(ctx, web.AuditEvent(r, ne)); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionApprove, TargetType: audit.TargetSuggestion, TargetID: suggestionID,
+			After: suggestion}, nil
+	})
+	if err != nil {
+		respondSuggestionError(w, r, suggestionID, err)
+		return
+	}
+
+	s.notify(ctx, suggestion.SuggestedBy, "Restaurant suggestion approved",
+		fmt.Sprintf("Your suggestion of restaurant %q was approved.", suggestion.Name))
+
+	web.SetETag(w, rest.Version)
+	web.Respond(w, r, http.StatusCreated, rest)
+}
+
+// handleSuggestionReject godoc
+// @Summary Reject restaurant suggestion
+// @Description reject pending suggestion with a reason, allowed only for admin
+// @Tags suggestions
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param suggestionId path string true "Suggestion ID"
+// @Success 200 {object} restaurant.Suggestion
+// @Failure 400 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /restaurant/suggestions/{suggestionId}/reject [post]
+func (s *Server) handleSuggestionReject(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Reason string `json:"reason" validate:"required"`
+	}
+
+	ctx := r.Context()
+
+	var req request
+	if err := web.DecodeBody(r, &req); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read reject reason from request", err)
+		return
+	}
+
+	if req.Reason == "" {
+		web.RespondError(w, r, http.StatusBadRequest, "reject reason should not be empty.")
+		return
+	}
+
+	suggestionID := chi.URLParam(r, "suggestionId")
+	var suggestion *restaurant.Suggestion
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if suggestion, err = s.restaurantRepo.RejectSuggestion(ctx, suggestionID, req.Reason, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionReject, TargetType: audit.TargetSuggestion, TargetID: suggestionID,
+			After: suggestion}, nil
+	})
+	if err != nil {
+		respondSuggestionError(w, r, suggestionID, err)
+		return
+	}
+
+	s.notify(ctx, suggestion.SuggestedBy, "Restaurant suggestion rejected",
+		fmt.Sprintf("Your suggestion of restaurant %q was rejected: %s", suggestion.Name, req.Reason))
+
+	web.Respond(w, r, http.StatusOK, suggestion)
+}
+
+// notify sends notification to the user. Failed notification does not fail the request.
+func (s *Server) notify(ctx context.Context, userID, subject, message string) {
+	if err := s.notifier.Notify(ctx, userID, subject, message); err != nil {
+		log.Sugar.Errorf("error on notifying user %s, error: %s", userID, err)
+	}
+}
+
+func respondSuggestionError(w http.ResponseWriter, r *http.Request, suggestionID string, err error) {
+	switch err {
+	case db.ErrInvalidID:
+		err := web.NewRequestError(err, http.StatusBadRequest)
+		web.RespondError(w, r, http.StatusBadRequest, err)
+	case restaurant.ErrSuggestionNotFound:
+		err := web.NewRequestError(err, http.StatusNotFound)
+		web.RespondError(w, r, http.StatusNotFound, err)
+	case restaurant.ErrSuggestionClosed, restaurant.ErrAlreadyUpvoted:
+		err := web.NewRequestError(err, http.StatusConflict)
+		web.RespondError(w, r, http.StatusConflict, err)
+	default:
+		err := errors.Wrapf(err, "Id: %s", suggestionID)
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+	}
+}
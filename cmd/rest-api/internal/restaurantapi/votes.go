@@ -1,20 +1,64 @@
 package restaurantapi
 
 import (
+	"context"
 	"github.com/go-chi/chi"
-	"github.com/go-chi/jwtauth"
 	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/authorize"
 	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/office"
+	"github.com/remisb/mat/internal/restaurant"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-// endpoint: GET /api/v1/restaurant/votes?date=2020-03-02
+// endpoint: GET /api/v1/restaurant/votes?date=2020-03-02&team=teamID&office=officeID
+//
+// results of the team poll are returned when team query parameter is passed,
+// they are available only for team members and users allowed to manage teams
+// menus of the office restaurant catalog are counted when office is passed, date
+// defaults to the current day of the office or home office of the signed in user
 func (s *Server) handleMenuVotesGet(w http.ResponseWriter, r *http.Request) {
 	// TODO add pagination
-	parsedDate := parseURLDateDefaultNow(w, r, "date")
-	menuVotes, err := s.restaurantRepo.MenuVotes(r.Context(), parsedDate)
+	teamID := r.URL.Query().Get("team")
+	var memberID string
+	if teamID != "" {
+		if _, ok := web.RequestClaims(w, r); !ok {
+			return
+		}
+		sub, ok := web.Authorize(w, r, authorize.Resource{Object: authorize.ObjectTeam}, authorize.ActionRead)
+		if !ok {
+			return
+		}
+		if !web.Policy.Allowed(sub, authorize.Resource{Object: authorize.ObjectTeam}, authorize.ActionUpdate) {
+			memberID = sub.UserID
+		}
+	}
+
+	sc, ok := s.requestOffice(w, r)
+	if !ok {
+		return
+	}
+
+	parsedDate, err := web.QueryDate(r, "date", sc.today())
 	if err != nil {
-		web.RespondError(w, r, http.StatusInternalServerError, err)
+		web.RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	menuVotes, err := s.restaurantRepo.MenuVotes(r.Context(), teamID, memberID, sc.filter, parsedDate)
+	if err != nil {
+		switch err {
+		case db.ErrInvalidID:
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		case restaurant.ErrNotTeamMember:
+			web.RespondError(w, r, http.StatusForbidden, err)
+		case db.ErrNotFound:
+			web.RespondError(w, r, http.StatusNotFound, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
 		return
 	}
 
@@ -27,30 +71,78 @@ func (s *Server) handleMenuVotesGet(w http.ResponseWriter, r *http.Request) {
 // one menu vote is allowed per day
 // removal / change or update of vote is not allowed
 // vote is allowed only for today's menu
+// optional menu rating from 1 to 5 can be passed with rating query parameter
+// vote in the team poll is added when team query parameter is passed, user votes
+// once per day in each of the teams
+// for restaurants of the office catalog date defaults to the current day of the
+// office and votes are closed after the office vote cutoff
+// deactivated users can not vote
 //
 // endpoint: POST /api/v1/restaurant/{restaurantId}/menu/{menuId}/vote
 //
 func (s *Server) handleRestaurantMenuVotePost(w http.ResponseWriter, r *http.Request) {
 	restaurantID := chi.URLParam(r, "restaurantId")
 	menuID := chi.URLParam(r, "menuId")
+	teamID := r.URL.Query().Get("team")
 
-	ctx := r.Context()
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+	userID := claims.Subject
+
+	o, ok := s.restaurantOffice(w, r, restaurantID)
+	if !ok {
+		return
+	}
 
-	_, claims, err := jwtauth.FromContext(ctx)
+	today := time.Now()
+	if o != nil {
+		today = o.Today(today)
+	}
+	parsedDate, err := web.QueryDate(r, "date", today)
 	if err != nil {
-		web.RespondError(w, r, http.StatusUnauthorized, web.ErrNoTokenFound)
+		web.RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if o != nil && o.VotingClosed(parsedDate, time.Now()) {
+		web.RespondError(w, r, http.StatusForbidden, office.ErrVotingClosed)
 		return
 	}
-	userID := claims["sub"].(string)
 
-	parsedDate := parseURLDateDefaultNow(w, r, "date")
+	var rating int
+	if value := r.URL.Query().Get("rating"); value != "" {
+		rating, err = strconv.Atoi(value)
+		if err != nil {
+			web.RespondError(w, r, http.StatusBadRequest, restaurant.ErrInvalidRating)
+			return
+		}
+	}
 
-	err = s.restaurantRepo.MenuVote(ctx, userID, restaurantID, menuID, parsedDate)
+	vote := map[string]interface{}{
+		"restaurantId": restaurantID,
+		"menuId":       menuID,
+		"teamId":       teamID,
+		"date":         parsedDate,
+		"rating":       rating,
+	}
+	err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (audit.NewEvent, error) {
+		return audit.NewEvent{Action: audit.ActionCreate, TargetType: audit.TargetVote, TargetID: menuID, After: vote},
+			s.restaurantRepo.MenuVote(ctx, userID, teamID, restaurantID, menuID, parsedDate, rating)
+	})
 	if err != nil {
-		if err == db.ErrAlreadyVoted {
+		if err == db.ErrAlreadyVoted || err == restaurant.ErrNotTeamMember || err == restaurant.ErrInactiveVoter {
 			web.RespondError(w, r, http.StatusForbidden, err)
 			return
 		}
+		if err == restaurant.ErrInvalidRating || err == restaurant.ErrNotInTeamPoll || err == db.ErrInvalidID {
+			web.RespondError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if err == db.ErrNotFound {
+			web.RespondError(w, r, http.StatusNotFound, err)
+			return
+		}
 		web.RespondError(w, r, http.StatusInternalServerError, err)
 		return
 	}
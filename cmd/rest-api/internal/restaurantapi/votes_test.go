@@ -100,6 +100,13 @@ func TestVoteAuthorizedSecondPerDayForbidden(t *testing.T) {
 		req.WithHeader("Authorization", "Bearer "+restaurantTest.User1.Token)
 	})
 
+	// vote with invalid date is rejected and not recorded
+	authUser1.POST("/api/v1/restaurant/{restaurantId}/menu/{menuId}/vote", restaurantLokysID, menuLokys1ID).
+		WithQuery("date", "13-03-2020").
+		Expect().Status(http.StatusBadRequest).
+		JSON().Object().
+		Path("$.error.message").String().Contains("date format")
+
 	authUser1.POST("/api/v1/restaurant/{restaurantId}/menu/{menuId}/vote", restaurantLokysID, menuLokys1ID).
 		WithQuery("date", "2020-03-13").
 		Expect().Status(http.StatusCreated).
@@ -170,3 +177,29 @@ func TestVoteAuthorizedTwoPerDay(t *testing.T) {
 		JSON().Object().
 		Path("$.error.message").Equal("user has already voted today")
 }
+
+func TestRestaurantStats(t *testing.T) {
+	// stats are available only for restaurant owner and admin
+	e.GET("/api/v1/restaurant/{restaurantId}/stats", restaurantLokysID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.User.Token).
+		Expect().Status(http.StatusForbidden)
+
+	e.GET("/api/v1/restaurant/{restaurantId}/stats", restaurantLokysID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token).
+		WithQuery("from", "2020-03-31").
+		WithQuery("to", "2020-03-01").
+		Expect().Status(http.StatusBadRequest)
+
+	stats := e.GET("/api/v1/restaurant/{restaurantId}/stats", restaurantLokysID).
+		WithHeader("Authorization", "Bearer "+restaurantTest.Admin.Token).
+		WithQuery("from", "2020-03-01").
+		WithQuery("to", "2020-03-31").
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+
+	stats.ValueEqual("restaurantId", restaurantLokysID)
+	stats.Value("votes").Number().Gt(0)
+	stats.Value("votesPerDay").Array().NotEmpty()
+	stats.Value("winRate").Number().InRange(0, 1)
+	stats.Value("tags").Array()
+}
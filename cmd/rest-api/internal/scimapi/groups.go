@@ -0,0 +1,274 @@
+package scimapi
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/scim"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"time"
+)
+
+// handleGroupsGet lists groups, there is a group for every role except super
+// admin role. Groups are paginated with 1-based startIndex and count query
+// parameters, only equality filter on displayName is supported.
+//
+// endpoint: GET /scim/v2/Groups?filter=displayName eq "ADMIN"
+func (s *Server) handleGroupsGet(w http.ResponseWriter, r *http.Request) {
+	startIndex, count, ok := pagination(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := scim.ParseFilter(r.URL.Query().Get("filter"))
+	if err == nil && filter.Attribute != "" && filter.Attribute != "displayname" {
+		err = scim.ErrInvalidFilter
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, scim.TypeInvalidFilter, err)
+		return
+	}
+
+	names, err := s.groupNames(r)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "", err)
+		return
+	}
+	if filter.Attribute != "" {
+		matched := make([]string, 0, 1)
+		for _, name := range names {
+			if name == filter.Value {
+				matched = append(matched, name)
+			}
+		}
+		names = matched
+	}
+
+	total := len(names)
+	from := startIndex - 1
+	if from > total {
+		from = total
+	}
+	to := from + count
+	if to > total {
+		to = total
+	}
+
+	groups := make([]scim.Group, 0, to-from)
+	for _, name := range names[from:to] {
+		g, err := s.group(r, name)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "", err)
+			return
+		}
+		groups = append(groups, *g)
+	}
+	respond(w, http.StatusOK, scim.NewListResponse(groups, len(groups), total, startIndex))
+}
+
+// handleGroupGet gets group with its members.
+//
+// endpoint: GET /scim/v2/Groups/{id}
+func (s *Server) handleGroupGet(w http.ResponseWriter, r *http.Request) {
+	g, ok := s.retrieveGroup(w, r)
+	if !ok {
+		return
+	}
+	respond(w, http.StatusOK, g)
+}
+
+// handleGroupReplace replaces members of the group, the role is granted to added
+// members and revoked from removed ones.
+//
+// endpoint: PUT /scim/v2/Groups/{id}
+func (s *Server) handleGroupReplace(w http.ResponseWriter, r *http.Request) {
+	var sg scim.Group
+	if err := web.DecodeBody(r, &sg); err != nil {
+		respondError(w, http.StatusBadRequest, scim.TypeInvalidSyntax, err)
+		return
+	}
+
+	g, ok := s.retrieveGroup(w, r)
+	if !ok {
+		return
+	}
+	if sg.DisplayName != "" && sg.DisplayName != g.DisplayName {
+		respondError(w, http.StatusBadRequest, scim.TypeMutability, scim.ErrImmutable)
+		return
+	}
+
+	s.updateMembers(w, r, g, sg.Members)
+}
+
+// handleGroupPatch adds or removes group members with patch operations.
+//
+// endpoint: PATCH /scim/v2/Groups/{id}
+func (s *Server) handleGroupPatch(w http.ResponseWriter, r *http.Request) {
+	var req scim.PatchRequest
+	if err := web.DecodeBody(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, scim.TypeInvalidSyntax, err)
+		return
+	}
+
+	g, ok := s.retrieveGroup(w, r)
+	if !ok {
+		return
+	}
+
+	patched := *g
+	patched.Members = append([]scim.Member(nil), g.Members...)
+	if err := patched.Patch(req.Operations); err != nil {
+		respondError(w, http.StatusBadRequest, scim.ErrorType(err), err)
+		return
+	}
+
+	s.updateMembers(w, r, g, patched.Members)
+}
+
+// updateMembers grants the group role to the users added to the group and
+// revokes it from the removed ones. All added users are checked before roles
+// are changed.
+func (s *Server) updateMembers(w http.ResponseWriter, r *http.Request, g *scim.Group, members []scim.Member) {
+	ctx := r.Context()
+	claims, _ := auth.ClaimsFromContext(ctx)
+
+	current := make(map[string]bool, len(g.Members))
+	for _, m := range g.Members {
+		current[m.Value] = true
+	}
+	wanted := make(map[string]bool, len(members))
+	for _, m := range members {
+		wanted[m.Value] = true
+	}
+
+	var changed []*user.User
+	for id := range wanted {
+		if current[id] {
+			continue
+		}
+		u, err := s.userRepo.RetrieveInOrg(ctx, id)
+		if err == db.ErrNotFound || err == db.ErrInvalidID {
+			respondError(w, http.StatusBadRequest, scim.TypeInvalidValue, errMemberNotFound)
+			return
+		}
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "", err)
+			return
+		}
+		changed = append(changed, u)
+	}
+	for id := range current {
+		if wanted[id] {
+			continue
+		}
+		u, err := s.userRepo.RetrieveInOrg(ctx, id)
+		if err != nil {
+			respondError(w, http.StatusInternalServerError, "", err)
+			return
+		}
+		changed = append(changed, u)
+	}
+
+	for _, u := range changed {
+		if isSuperAdmin(u.Roles) && !claims.HasRole(auth.RoleSuperAdmin) {
+			respondError(w, http.StatusForbidden, "", errSuperAdminUser)
+			return
+		}
+	}
+
+	for _, u := range changed {
+		roles := make([]string, 0, len(u.Roles)+1)
+		for _, role := range u.Roles {
+			if role != g.ID {
+				roles = append(roles, role)
+			}
+		}
+		if wanted[u.ID] {
+			roles = append(roles, g.ID)
+		}
+
+		err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+			updated, err := s.userRepo.AssignRoles(ctx, u.ID, roles, u.Version, time.Now())
+			if err != nil {
+				return ne, err
+			}
+			return audit.NewEvent{Action: audit.ActionAssignRoles, TargetType: audit.TargetUser, TargetID: u.ID,
+				Before: u, After: updated}, nil
+		})
+		if err != nil {
+			respondUserError(w, err)
+			return
+		}
+	}
+
+	updated, err := s.group(r, g.ID)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "", err)
+		return
+	}
+	respond(w, http.StatusOK, updated)
+}
+
+// retrieveGroup gets group of the request path. Error response is sent and false
+// is returned when there is no such group.
+func (s *Server) retrieveGroup(w http.ResponseWriter, r *http.Request) (*scim.Group, bool) {
+	id := chi.URLParam(r, "id")
+	names, err := s.groupNames(r)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "", err)
+		return nil, false
+	}
+
+	for _, name := range names {
+		if name == id {
+			g, err := s.group(r, name)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "", err)
+				return nil, false
+			}
+			return g, true
+		}
+	}
+	respondError(w, http.StatusNotFound, "", errGroupNotFound)
+	return nil, false
+}
+
+// groupNames returns names of the roles provisioned as groups.
+func (s *Server) groupNames(r *http.Request) ([]string, error) {
+	roles, err := s.userRepo.Roles(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if role.Name != auth.RoleSuperAdmin {
+			names = append(names, role.Name)
+		}
+	}
+	return names, nil
+}
+
+// group returns group of the role with organization users having the role as members.
+func (s *Server) group(r *http.Request, role string) (*scim.Group, error) {
+	users, err := s.userRepo.RoleMembers(r.Context(), role)
+	if err != nil {
+		return nil, err
+	}
+
+	g := scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          role,
+		DisplayName: role,
+		Members:     make([]scim.Member, 0, len(users)),
+		Meta:        &scim.Meta{ResourceType: "Group", Location: location(r, "Groups", role)},
+	}
+	for _, u := range users {
+		g.Members = append(g.Members, scim.Member{Value: u.ID, Display: u.Email})
+	}
+	return &g, nil
+}
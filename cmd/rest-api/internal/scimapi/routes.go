@@ -0,0 +1,48 @@
+package scimapi
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/authorize"
+)
+
+func (s *Server) initRoutes() {
+	if s.Router == nil {
+		// /scim/v2
+		router := chi.NewMux()
+
+		// identity providers use personal access tokens with scim scope
+		authenticator := *s.authenticator
+		router.Group(func(r chi.Router) {
+			r.Use(web.Verifier(authenticator.Keys(), authenticator))
+			r.Use(web.Authenticator)
+			r.Use(web.Tenant)
+			r.Use(web.RequireScope(auth.ScopeSCIM))
+
+			r.With(web.Allow(authorize.ObjectUser, authorize.ActionRead)).
+				Get("/Users", s.handleUsersGet)
+			r.With(web.Allow(authorize.ObjectUser, authorize.ActionCreate)).
+				Post("/Users", s.handleUserCreate)
+			r.With(web.Allow(authorize.ObjectUser, authorize.ActionRead)).
+				Get("/Users/{id}", s.handleUserGet)
+			r.With(web.Allow(authorize.ObjectUser, authorize.ActionUpdate)).
+				Put("/Users/{id}", s.handleUserReplace)
+			r.With(web.Allow(authorize.ObjectUser, authorize.ActionUpdate)).
+				Patch("/Users/{id}", s.handleUserPatch)
+			r.With(web.Allow(authorize.ObjectUser, authorize.ActionUpdate)).
+				Delete("/Users/{id}", s.handleUserDelete)
+
+			r.With(web.Allow(authorize.ObjectRole, authorize.ActionRead)).
+				Get("/Groups", s.handleGroupsGet)
+			r.With(web.Allow(authorize.ObjectRole, authorize.ActionRead)).
+				Get("/Groups/{id}", s.handleGroupGet)
+			r.With(web.Allow(authorize.ObjectRole, authorize.ActionUpdate)).
+				Put("/Groups/{id}", s.handleGroupReplace)
+			r.With(web.Allow(authorize.ObjectRole, authorize.ActionUpdate)).
+				Patch("/Groups/{id}", s.handleGroupPatch)
+		})
+
+		s.Router = router
+	}
+}
@@ -0,0 +1,141 @@
+package scimapi
+
+import (
+	"github.com/gavv/httpexpect/v2"
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/userapi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/scim"
+	"github.com/remisb/mat/internal/tests"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSCIM(t *testing.T) {
+	scimTest := tests.NewTest(t)
+	t.Cleanup(scimTest.Cleanup)
+	web.Keys = scimTest.Keys
+	r := chi.NewRouter()
+
+	userServer := userapi.NewServer("testing", nil, scimTest.Dbx, userapi.Registration{})
+	scimServer := NewServer("testing", nil, scimTest.Dbx)
+	r.Route("/api/v1/", func(r chi.Router) {
+		r.Mount("/users", userServer.Router)
+	})
+	r.Mount(Path, scimServer.Router)
+
+	scimTest.SetupTestUsers(t)
+
+	testServer := httptest.NewServer(r)
+	e := httpexpect.New(t, testServer.URL)
+
+	newToken := func(token string) *httpexpect.Expect {
+		pat := e.POST("/api/v1/users/me/tokens").
+			WithHeader("Authorization", "Bearer "+token).
+			WithJSON(map[string]interface{}{"name": "identity provider", "scopes": []string{"scim"}}).
+			Expect().Status(http.StatusCreated).
+			JSON().Object().Value("token").String().Raw()
+		return e.Builder(func(req *httpexpect.Request) {
+			req.WithHeader("Authorization", "Bearer "+pat)
+		})
+	}
+	provider := newToken(scimTest.Admin.Token)
+	userProvider := newToken(scimTest.User.Token)
+
+	jane := map[string]interface{}{
+		"schemas":  []string{scim.SchemaUser},
+		"userName": "jane@example.com",
+		"name":     map[string]string{"givenName": "Jane", "familyName": "Doe"},
+	}
+
+	// only admin tokens provision users
+	e.GET(Path + "/Users").Expect().Status(http.StatusUnauthorized)
+	userProvider.POST(Path + "/Users").WithJSON(jane).Expect().Status(http.StatusForbidden)
+
+	created := provider.POST(Path + "/Users").
+		WithJSON(jane).
+		Expect().Status(http.StatusCreated).
+		ContentType(scim.MediaType)
+	created.Header("Location").NotEmpty()
+	user := created.JSON().Object()
+	user.ValueEqual("displayName", "Jane Doe").ValueEqual("active", true)
+	janeID := user.Value("id").String().Raw()
+
+	provider.POST(Path+"/Users").
+		WithJSON(jane).
+		Expect().Status(http.StatusConflict).
+		JSON().Object().ValueEqual("scimType", scim.TypeUniqueness)
+
+	list := provider.GET(Path+"/Users").
+		WithQuery("filter", `userName eq "JANE@example.com"`).
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	list.ValueEqual("totalResults", 1)
+	list.Value("Resources").Array().Element(0).Object().ValueEqual("id", janeID)
+	provider.GET(Path+"/Users").
+		WithQuery("filter", `name.familyName co "Doe"`).
+		Expect().Status(http.StatusBadRequest)
+	provider.GET(Path+"/Users").
+		WithQuery("count", 1).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("itemsPerPage", 1)
+
+	// groups map to roles
+	provider.GET(Path + "/Groups/SUPER_ADMIN").Expect().Status(http.StatusNotFound)
+	provider.PATCH(Path + "/Groups/ADMIN").
+		WithJSON(map[string]interface{}{
+			"schemas":    []string{scim.SchemaPatchOp},
+			"Operations": []map[string]interface{}{{"op": "add", "path": "members", "value": []map[string]string{{"value": janeID}}}},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("members").Array().Path("$..value").Array().Contains(janeID)
+	provider.GET(Path+"/Users/"+janeID).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("groups").Array().Path("$..value").Array().ContainsOnly("USER", "ADMIN")
+	provider.PATCH(Path + "/Groups/ADMIN").
+		WithJSON(map[string]interface{}{
+			"schemas":    []string{scim.SchemaPatchOp},
+			"Operations": []map[string]interface{}{{"op": "remove", "path": `members[value eq "` + janeID + `"]`}},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("members").Array().Path("$..value").Array().NotContains(janeID)
+
+	// offboarding deactivates the user
+	provider.PATCH(Path+"/Users/"+janeID).
+		WithJSON(map[string]interface{}{
+			"schemas":    []string{scim.SchemaPatchOp},
+			"Operations": []map[string]interface{}{{"op": "replace", "value": map[string]interface{}{"active": false}}},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("active", false).ValueEqual("displayName", "Jane Doe")
+	var deleted bool
+	if err := scimTest.Dbx.Get(&deleted, `SELECT deleted_at IS NOT NULL FROM users WHERE user_id = $1`, janeID); err != nil {
+		t.Fatal(err)
+	}
+	if !deleted {
+		t.Fatal("expected deactivated user to be deleted")
+	}
+	provider.PATCH(Path+"/Users/"+janeID).
+		WithJSON(map[string]interface{}{
+			"schemas":    []string{scim.SchemaPatchOp},
+			"Operations": []map[string]interface{}{{"op": "replace", "path": "active", "value": true}},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("active", true)
+
+	provider.PATCH(Path+"/Users/"+scimTest.User.UserID).
+		WithJSON(map[string]interface{}{
+			"Operations": []map[string]interface{}{{"op": "remove", "path": "userName"}},
+		}).
+		Expect().Status(http.StatusBadRequest).
+		JSON().Object().ValueEqual("scimType", scim.TypeMutability)
+
+	provider.DELETE(Path + "/Users/" + scimTest.User.UserID).
+		Expect().Status(http.StatusNoContent)
+	userProvider.GET(Path + "/Users").
+		Expect().Status(http.StatusUnauthorized)
+	provider.GET(Path+"/Users/"+scimTest.User.UserID).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("active", false)
+}
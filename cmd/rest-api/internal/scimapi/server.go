@@ -0,0 +1,75 @@
+package scimapi
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/scim"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"os"
+)
+
+// Path is the path SCIM REST API server is mounted at, it is used to build
+// locations of SCIM resources.
+const Path = "/scim/v2"
+
+var (
+	errUserNotFound      = errors.New("user not found")
+	errGroupNotFound     = errors.New("group not found")
+	errMemberNotFound    = errors.New("group member not found in the organization")
+	errUserNameRequired  = errors.New("userName should be the user email")
+	errInvalidPagination = errors.New("startIndex and count should be numbers")
+	errSuperAdminUser    = errors.New("only super admin can manage super admin users")
+)
+
+// Server struct is a SCIM REST API server used by identity providers to
+// provision users and groups.
+type Server struct {
+	userRepo      *user.Repo
+	auditor       audit.Recorder
+	Router        *chi.Mux
+	build         string
+	authenticator *auth.Authenticator
+}
+
+// NewServer is a factory function which creates and initializes new SCIM REST API server.
+func NewServer(build string, shutdown chan os.Signal, db *sqlx.DB) *Server {
+	userRepo := user.NewRepo(db)
+	s := Server{
+		build:         build,
+		authenticator: auth.New(userRepo, nil, auth.NewTokenStore(db), web.Keys, nil),
+		userRepo:      userRepo,
+		auditor:       audit.NewRepo(db),
+	}
+
+	s.initRoutes()
+	return &s
+}
+
+// respond sends SCIM response with application/scim+json content type.
+func respond(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", scim.MediaType)
+	w.WriteHeader(status)
+	if data != nil {
+		if err := json.NewEncoder(w).Encode(data); err != nil {
+			log.Sugar.Errorf("error on encoding SCIM response, error: %s", err)
+		}
+	}
+}
+
+// respondError sends SCIM error response, internal errors are logged and are
+// not exposed to the client.
+func respondError(w http.ResponseWriter, status int, scimType string, err error) {
+	detail := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Sugar.Errorf("SCIM request failed, error: %s", err)
+		detail = http.StatusText(status)
+	}
+	respond(w, status, scim.NewError(status, scimType, detail))
+}
@@ -0,0 +1,312 @@
+package scimapi
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/password"
+	"github.com/remisb/mat/internal/scim"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxCount is the largest page of resources returned by list requests.
+const maxCount = 100
+
+// handleUsersGet lists users of the organization. Users are paginated with
+// 1-based startIndex and count query parameters, only equality filter on
+// userName is supported.
+//
+// endpoint: GET /scim/v2/Users?filter=userName eq "john@example.com"
+func (s *Server) handleUsersGet(w http.ResponseWriter, r *http.Request) {
+	startIndex, count, ok := pagination(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := scim.ParseFilter(r.URL.Query().Get("filter"))
+	if err == nil && filter.Attribute != "" && filter.Attribute != "username" {
+		err = scim.ErrInvalidFilter
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, scim.TypeInvalidFilter, err)
+		return
+	}
+	if filter.Attribute != "" && filter.Value == "" {
+		respond(w, http.StatusOK, scim.NewListResponse([]scim.User{}, 0, 0, startIndex))
+		return
+	}
+
+	users, total, err := s.userRepo.SearchUsers(r.Context(), filter.Value, startIndex-1, count)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	resources := make([]scim.User, 0, len(users))
+	for i := range users {
+		resources = append(resources, toUser(r, &users[i]))
+	}
+	respond(w, http.StatusOK, scim.NewListResponse(resources, len(resources), total, startIndex))
+}
+
+// handleUserCreate provisions a new user with USER role. User without password
+// signs in only through single sign-on.
+//
+// endpoint: POST /scim/v2/Users
+func (s *Server) handleUserCreate(w http.ResponseWriter, r *http.Request) {
+	var su scim.User
+	if err := web.DecodeBody(r, &su); err != nil {
+		respondError(w, http.StatusBadRequest, scim.TypeInvalidSyntax, err)
+		return
+	}
+	if su.UserName == "" {
+		respondError(w, http.StatusBadRequest, scim.TypeInvalidValue, errUserNameRequired)
+		return
+	}
+
+	du := user.DirectoryUser{Name: su.FullName(), Email: su.UserName, Password: su.Password, Active: su.IsActive()}
+	var u *user.User
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if u, err = s.userRepo.CreateDirectoryUser(ctx, du, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionCreate, TargetType: audit.TargetUser, TargetID: u.ID, After: u}, nil
+	})
+	if err != nil {
+		respondUserError(w, err)
+		return
+	}
+
+	created := toUser(r, u)
+	w.Header().Set("Location", created.Meta.Location)
+	respond(w, http.StatusCreated, created)
+}
+
+// handleUserGet gets user of the organization.
+//
+// endpoint: GET /scim/v2/Users/{id}
+func (s *Server) handleUserGet(w http.ResponseWriter, r *http.Request) {
+	u, err := s.userRepo.RetrieveInOrg(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		respondUserError(w, err)
+		return
+	}
+	respond(w, http.StatusOK, toUser(r, u))
+}
+
+// handleUserReplace replaces name, user name and active state of the user.
+//
+// endpoint: PUT /scim/v2/Users/{id}
+func (s *Server) handleUserReplace(w http.ResponseWriter, r *http.Request) {
+	var su scim.User
+	if err := web.DecodeBody(r, &su); err != nil {
+		respondError(w, http.StatusBadRequest, scim.TypeInvalidSyntax, err)
+		return
+	}
+	if su.UserName == "" {
+		respondError(w, http.StatusBadRequest, scim.TypeInvalidValue, errUserNameRequired)
+		return
+	}
+
+	before, ok := s.retrieveManagedUser(w, r)
+	if !ok {
+		return
+	}
+
+	du := user.DirectoryUser{Name: su.FullName(), Email: su.UserName, Password: su.Password, Active: su.IsActive()}
+	s.updateUser(w, r, before, du)
+}
+
+// handleUserPatch modifies the user with patch operations, deactivated user
+// can not sign in anymore.
+//
+// endpoint: PATCH /scim/v2/Users/{id}
+func (s *Server) handleUserPatch(w http.ResponseWriter, r *http.Request) {
+	var req scim.PatchRequest
+	if err := web.DecodeBody(r, &req); err != nil {
+		respondError(w, http.StatusBadRequest, scim.TypeInvalidSyntax, err)
+		return
+	}
+
+	before, ok := s.retrieveManagedUser(w, r)
+	if !ok {
+		return
+	}
+
+	// user name is not split into given and family names, any patched name
+	// attribute replaces the whole name
+	su := toUser(r, before)
+	su.Name, su.DisplayName = nil, ""
+	if err := su.Patch(req.Operations); err != nil {
+		respondError(w, http.StatusBadRequest, scim.ErrorType(err), err)
+		return
+	}
+
+	name := before.Name
+	if su.Name != nil || su.DisplayName != "" {
+		name = su.FullName()
+	}
+	du := user.DirectoryUser{Name: name, Email: su.UserName, Active: su.IsActive()}
+	s.updateUser(w, r, before, du)
+}
+
+// handleUserDelete deactivates the user, votes and restaurants of the user are kept.
+//
+// endpoint: DELETE /scim/v2/Users/{id}
+func (s *Server) handleUserDelete(w http.ResponseWriter, r *http.Request) {
+	before, ok := s.retrieveManagedUser(w, r)
+	if !ok {
+		return
+	}
+
+	du := user.DirectoryUser{Name: before.Name, Email: before.Email, Active: false}
+	if _, err := s.saveUser(r, before, du); err != nil {
+		respondUserError(w, err)
+		return
+	}
+
+	respond(w, http.StatusNoContent, nil)
+}
+
+// retrieveManagedUser gets user of the request path which can be modified by
+// the requesting user. Organization admins can not modify super admin users.
+func (s *Server) retrieveManagedUser(w http.ResponseWriter, r *http.Request) (*user.User, bool) {
+	u, err := s.userRepo.RetrieveInOrg(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		respondUserError(w, err)
+		return nil, false
+	}
+
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	if isSuperAdmin(u.Roles) && !claims.HasRole(auth.RoleSuperAdmin) {
+		respondError(w, http.StatusForbidden, "", errSuperAdminUser)
+		return nil, false
+	}
+	return u, true
+}
+
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request, before *user.User, du user.DirectoryUser) {
+	u, err := s.saveUser(r, before, du)
+	if err != nil {
+		respondUserError(w, err)
+		return
+	}
+
+	respond(w, http.StatusOK, toUser(r, u))
+}
+
+// saveUser updates directory attributes of the user and records the update.
+func (s *Server) saveUser(r *http.Request, before *user.User, du user.DirectoryUser) (*user.User, error) {
+	var u *user.User
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if u, err = s.userRepo.UpdateDirectoryUser(ctx, before.ID, du, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionUpdate, TargetType: audit.TargetUser, TargetID: u.ID,
+			Before: before, After: u}, nil
+	})
+	return u, err
+}
+
+// toUser converts user into SCIM user resource, roles of the user are listed as groups.
+func toUser(r *http.Request, u *user.User) scim.User {
+	active := u.Active
+	created := u.DateCreated
+	updated := u.DateUpdated
+
+	su := scim.User{
+		Schemas:     []string{scim.SchemaUser},
+		ID:          u.ID,
+		UserName:    u.Email,
+		Name:        &scim.Name{Formatted: u.Name},
+		DisplayName: u.Name,
+		Emails:      []scim.Email{{Value: u.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      &created,
+			LastModified: &updated,
+			Location:     location(r, "Users", u.ID),
+		},
+	}
+	for _, role := range u.Roles {
+		if role != auth.RoleSuperAdmin {
+			su.Groups = append(su.Groups, scim.GroupRef{Value: role, Display: role})
+		}
+	}
+	return su
+}
+
+// location returns absolute URL of the SCIM resource.
+func location(r *http.Request, resourceType, id string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + Path + "/" + resourceType + "/" + id
+}
+
+// pagination parses 1-based startIndex and count query parameters. Error
+// response is sent and false is returned for malformed values.
+func pagination(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	startIndex, count := 1, maxCount
+	query := r.URL.Query()
+	if value := query.Get("startIndex"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, scim.TypeInvalidValue, errInvalidPagination)
+			return 0, 0, false
+		}
+		if n > 1 {
+			startIndex = n
+		}
+	}
+	if value := query.Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			respondError(w, http.StatusBadRequest, scim.TypeInvalidValue, errInvalidPagination)
+			return 0, 0, false
+		}
+		switch {
+		case n < 0:
+			count = 0
+		case n < maxCount:
+			count = n
+		}
+	}
+	return startIndex, count, true
+}
+
+func isSuperAdmin(roles []string) bool {
+	for _, role := range roles {
+		if role == auth.RoleSuperAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// respondUserError sends SCIM error response with status matching the user repository error.
+func respondUserError(w http.ResponseWriter, err error) {
+	switch err {
+	case db.ErrInvalidID, db.ErrNotFound:
+		respondError(w, http.StatusNotFound, "", errUserNotFound)
+	case user.ErrEmailTaken:
+		respondError(w, http.StatusConflict, scim.TypeUniqueness, err)
+	case db.ErrVersionConflict, user.ErrAnonymized:
+		respondError(w, http.StatusConflict, "", err)
+	case user.ErrUnknownRole, password.ErrTooShort, password.ErrBreached:
+		respondError(w, http.StatusBadRequest, scim.TypeInvalidValue, err)
+	default:
+		respondError(w, http.StatusInternalServerError, "", err)
+	}
+}
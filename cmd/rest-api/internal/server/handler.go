@@ -1,6 +1,8 @@
 package server
 
 import (
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/auth"
 	"net/http"
 )
 
@@ -8,3 +10,10 @@ import (
 func InfoHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Restaurant Menu Voting Service (c) 2020 Remis B"))
 }
+
+// JWKSHandler creates HTTP handler function publishing token verification keys as JSON Web Key Set.
+func JWKSHandler(keys *auth.KeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		web.Respond(w, r, http.StatusOK, keys.JWKS())
+	}
+}
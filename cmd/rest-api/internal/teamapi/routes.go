@@ -0,0 +1,42 @@
+package teamapi
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/authorize"
+)
+
+func (s *Server) initRoutes() {
+	if s.Router == nil {
+		// /api/v1/teams
+		teams := chi.NewMux()
+		teams.Use(web.CorsHandler)
+
+		authenticator := *s.authenticator
+		teams.Group(func(r chi.Router) {
+			r.Use(web.Verifier(authenticator.Keys(), authenticator))
+			r.Use(web.Authenticator)
+			r.Use(web.Tenant)
+			r.Use(web.RequireScope())
+
+			r.With(web.Allow(authorize.ObjectTeam, authorize.ActionRead)).
+				Get("/", s.handleTeamsGet)
+			r.With(web.Allow(authorize.ObjectTeam, authorize.ActionCreate)).
+				Post("/", s.handleTeamCreate)
+			r.With(web.Allow(authorize.ObjectTeam, authorize.ActionRead)).
+				Get("/{teamID}", s.handleTeamGet)
+			r.With(web.Allow(authorize.ObjectTeam, authorize.ActionUpdate)).
+				Put("/{teamID}", s.handleTeamUpdate)
+			r.With(web.Allow(authorize.ObjectTeam, authorize.ActionDelete)).
+				Delete("/{teamID}", s.handleTeamDelete)
+			r.With(web.Allow(authorize.ObjectTeam, authorize.ActionRead)).
+				Get("/{teamID}/members", s.handleMembersGet)
+			r.With(web.Allow(authorize.ObjectTeam, authorize.ActionUpdate)).
+				Post("/{teamID}/members", s.handleMemberAdd)
+			r.With(web.Allow(authorize.ObjectTeam, authorize.ActionUpdate)).
+				Delete("/{teamID}/members/{userID}", s.handleMemberDelete)
+		})
+
+		s.Router = teams
+	}
+}
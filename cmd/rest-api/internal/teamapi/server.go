@@ -0,0 +1,35 @@
+package teamapi
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/team"
+	"github.com/remisb/mat/internal/user"
+	"os"
+)
+
+// Server struct is a Team REST API server
+type Server struct {
+	teamRepo      *team.Repo
+	auditor       audit.Recorder
+	Router        *chi.Mux
+	build         string
+	authenticator *auth.Authenticator
+}
+
+// NewServer is a factory function which creates and initializes new Team REST API server.
+func NewServer(build string, shutdown chan os.Signal, db *sqlx.DB) *Server {
+	userRepo := user.NewRepo(db)
+	s := Server{
+		build:         build,
+		authenticator: auth.New(userRepo, nil, auth.NewTokenStore(db), web.Keys, nil),
+		teamRepo:      team.NewRepo(db),
+		auditor:       audit.NewRepo(db),
+	}
+
+	s.initRoutes()
+	return &s
+}
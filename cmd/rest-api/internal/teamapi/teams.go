@@ -0,0 +1,298 @@
+package teamapi
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/team"
+	"net/http"
+	"time"
+)
+
+// handleTeamsGet godoc
+// @Summary List teams
+// @Description get teams of the organization, only teams of the signed in user are listed with member=me
+// @Tags teams
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param member query string false "me to list teams of the signed in user"
+// @Success 200 {array} team.Team
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /teams [get]
+func (s *Server) handleTeamsGet(w http.ResponseWriter, r *http.Request) {
+	var memberID string
+	if r.URL.Query().Get("member") == "me" {
+		claims, ok := web.RequestClaims(w, r)
+		if !ok {
+			return
+		}
+		memberID = claims.Subject
+	}
+
+	teams, err := s.teamRepo.List(r.Context(), memberID)
+	if err != nil {
+		respondTeamError(w, r, err)
+		return
+	}
+	web.Respond(w, r, http.StatusOK, teams)
+}
+
+// handleTeamCreate godoc
+// @Summary Create team
+// @Description create a new team with restaurants of its daily poll, all restaurants are included when
+// @Description the list is empty, allowed for admin
+// @Tags teams
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param team body team.NewTeam true "New team"
+// @Success 201 {object} team.Team
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /teams [post]
+func (s *Server) handleTeamCreate(w http.ResponseWriter, r *http.Request) {
+	var nt team.NewTeam
+	if err := web.DecodeBody(r, &nt); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read team from request", err)
+		return
+	}
+
+	var t *team.Team
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if t, err = s.teamRepo.Create(ctx, nt, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionCreate, TargetType: audit.TargetTeam, TargetID: t.ID, After: t}, nil
+	})
+	if err != nil {
+		respondTeamError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusCreated, t)
+}
+
+// handleTeamGet godoc
+// @Summary Get team
+// @Description get team by ID with restaurants of its daily poll
+// @Tags teams
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param teamID path string true "Team ID"
+// @Success 200 {object} team.Team
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /teams/{teamID} [get]
+func (s *Server) handleTeamGet(w http.ResponseWriter, r *http.Request) {
+	t, err := s.teamRepo.Retrieve(r.Context(), chi.URLParam(r, "teamID"))
+	if err != nil {
+		respondTeamError(w, r, err)
+		return
+	}
+	web.Respond(w, r, http.StatusOK, t)
+}
+
+// handleTeamUpdate godoc
+// @Summary Update team
+// @Description update team name and restaurants of its daily poll, poll restaurants are replaced only
+// @Description when the list is provided, allowed for admin
+// @Tags teams
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param teamID path string true "Team ID"
+// @Param team body team.UpdateTeam true "Team update"
+// @Success 200 {object} team.Team
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /teams/{teamID} [put]
+func (s *Server) handleTeamUpdate(w http.ResponseWriter, r *http.Request) {
+	teamID := chi.URLParam(r, "teamID")
+
+	var ut team.UpdateTeam
+	if err := web.DecodeBody(r, &ut); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read team from request", err)
+		return
+	}
+
+	before, err := s.teamRepo.Retrieve(r.Context(), teamID)
+	if err != nil {
+		respondTeamError(w, r, err)
+		return
+	}
+
+	var t *team.Team
+	err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if t, err = s.teamRepo.Update(ctx, teamID, ut, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionUpdate, TargetType: audit.TargetTeam, TargetID: t.ID,
+			Before: before, After: t}, nil
+	})
+	if err != nil {
+		respondTeamError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, t)
+}
+
+// handleTeamDelete godoc
+// @Summary Delete team
+// @Description archive team, votes of the team poll are kept, allowed for admin
+// @Tags teams
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param teamID path string true "Team ID"
+// @Success 204
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /teams/{teamID} [delete]
+func (s *Server) handleTeamDelete(w http.ResponseWriter, r *http.Request) {
+	teamID := chi.URLParam(r, "teamID")
+
+	before, err := s.teamRepo.Retrieve(r.Context(), teamID)
+	if err != nil {
+		respondTeamError(w, r, err)
+		return
+	}
+
+	err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (audit.NewEvent, error) {
+		return audit.NewEvent{Action: audit.ActionDelete, TargetType: audit.TargetTeam, TargetID: teamID,
+			Before: before}, s.teamRepo.Delete(ctx, teamID, time.Now())
+	})
+	if err != nil {
+		respondTeamError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusNoContent, nil)
+}
+
+// handleMembersGet godoc
+// @Summary List team members
+// @Description get users belonging to the team
+// @Tags teams
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param teamID path string true "Team ID"
+// @Success 200 {array} team.Member
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /teams/{teamID}/members [get]
+func (s *Server) handleMembersGet(w http.ResponseWriter, r *http.Request) {
+	members, err := s.teamRepo.Members(r.Context(), chi.URLParam(r, "teamID"))
+	if err != nil {
+		respondTeamError(w, r, err)
+		return
+	}
+	web.Respond(w, r, http.StatusOK, members)
+}
+
+// handleMemberAdd godoc
+// @Summary Add team member
+// @Description add user with specified email to the team, user may belong to several teams, allowed for admin
+// @Tags teams
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param teamID path string true "Team ID"
+// @Param member body team.NewMember true "new member"
+// @Success 201 {object} team.Member
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /teams/{teamID}/members [post]
+func (s *Server) handleMemberAdd(w http.ResponseWriter, r *http.Request) {
+	teamID := chi.URLParam(r, "teamID")
+
+	var nm team.NewMember
+	if err := web.DecodeBody(r, &nm); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read member from request", err)
+		return
+	}
+	if nm.Email == "" {
+		web.RespondError(w, r, http.StatusBadRequest, "member email should not be empty.")
+		return
+	}
+
+	var member *team.Member
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if member, err = s.teamRepo.AddMember(ctx, teamID, nm, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionCreate, TargetType: audit.TargetTeam, TargetID: teamID,
+			After: member}, nil
+	})
+	if err != nil {
+		respondTeamError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusCreated, member)
+}
+
+// handleMemberDelete godoc
+// @Summary Remove team member
+// @Description remove user from the team, allowed for admin
+// @Tags teams
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param teamID path string true "Team ID"
+// @Param userID path string true "User ID"
+// @Success 204
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /teams/{teamID}/members/{userID} [delete]
+func (s *Server) handleMemberDelete(w http.ResponseWriter, r *http.Request) {
+	teamID := chi.URLParam(r, "teamID")
+	userID := chi.URLParam(r, "userID")
+
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (audit.NewEvent, error) {
+		return audit.NewEvent{Action: audit.ActionDelete, TargetType: audit.TargetTeam, TargetID: teamID,
+			Before: team.Member{TeamID: teamID, UserID: userID}}, s.teamRepo.RemoveMember(ctx, teamID, userID)
+	})
+	if err != nil {
+		respondTeamError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusNoContent, nil)
+}
+
+// respondTeamError sends error response with status matching the team repository error.
+func respondTeamError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case db.ErrInvalidID, team.ErrInvalidName, team.ErrUnknownRestaurant:
+		web.RespondError(w, r, http.StatusBadRequest, err)
+	case db.ErrNotFound, team.ErrMemberNotFound:
+		web.RespondError(w, r, http.StatusNotFound, err)
+	case team.ErrTeamExists:
+		web.RespondError(w, r, http.StatusConflict, err)
+	default:
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+	}
+}
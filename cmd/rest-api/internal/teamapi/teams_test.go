@@ -0,0 +1,181 @@
+package teamapi
+
+import (
+	"github.com/gavv/httpexpect/v2"
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/restaurantapi"
+	"github.com/remisb/mat/cmd/rest-api/internal/userapi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/team"
+	"github.com/remisb/mat/internal/tests"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	restaurantLokysID  = "5828612a-1f8a-403c-b6d1-6cb66fbf0c66"
+	restaurantPaikisID = "0ce90028-69cb-4e9c-9af0-7bbada50d5b6"
+	menuLokysID        = "4058d981-0df1-45de-807e-b8e90bcb2d80"
+	menuLokysDate      = "2020-03-01"
+)
+
+func TestTeams(t *testing.T) {
+	teamTest := tests.NewTest(t)
+	t.Cleanup(teamTest.Cleanup)
+	web.Keys = teamTest.Keys
+	r := chi.NewRouter()
+
+	userServer := userapi.NewServer("testing", nil, teamTest.Dbx, userapi.Registration{})
+	restaurantServer := restaurantapi.NewServer("testing", nil, teamTest.Dbx)
+	teamServer := NewServer("testing", nil, teamTest.Dbx)
+	r.Route("/api/v1/", func(r chi.Router) {
+		r.Mount("/users", userServer.Router)
+		r.Mount("/restaurant", restaurantServer.Router)
+		r.Mount("/teams", teamServer.Router)
+	})
+
+	teamTest.SetupTestUsers(t)
+
+	testServer := httptest.NewServer(r)
+	e := httpexpect.New(t, testServer.URL)
+	authAdmin := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+teamTest.Admin.Token)
+	})
+	authUser := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+teamTest.User.Token)
+	})
+	authUser1 := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+teamTest.User1.Token)
+	})
+
+	// only admin manages teams
+	authUser.POST("/api/v1/teams").
+		WithJSON(team.NewTeam{Name: "Backend"}).
+		Expect().Status(http.StatusForbidden)
+
+	backend := authAdmin.POST("/api/v1/teams").
+		WithJSON(team.NewTeam{Name: "Backend", Restaurants: []string{restaurantLokysID}}).
+		Expect().Status(http.StatusCreated).
+		JSON().Object()
+	backend.ValueEqual("restaurants", []string{restaurantLokysID})
+	backendID := backend.Value("id").String().Raw()
+
+	salesID := authAdmin.POST("/api/v1/teams").
+		WithJSON(team.NewTeam{Name: "Sales"}).
+		Expect().Status(http.StatusCreated).
+		JSON().Object().Value("id").String().Raw()
+
+	authAdmin.POST("/api/v1/teams").
+		WithJSON(team.NewTeam{Name: "Sales"}).
+		Expect().Status(http.StatusConflict)
+	authAdmin.POST("/api/v1/teams").
+		WithJSON(team.NewTeam{Name: "Unknown", Restaurants: []string{menuLokysID}}).
+		Expect().Status(http.StatusBadRequest)
+
+	for _, teamID := range []string{backendID, salesID} {
+		authAdmin.POST("/api/v1/teams/{teamID}/members", teamID).
+			WithJSON(team.NewMember{Email: "user@example.com"}).
+			Expect().Status(http.StatusCreated)
+	}
+	authAdmin.POST("/api/v1/teams/{teamID}/members", backendID).
+		WithJSON(team.NewMember{Email: "user1@example.com"}).
+		Expect().Status(http.StatusCreated)
+
+	authUser.GET("/api/v1/teams").
+		Expect().Status(http.StatusOK).
+		JSON().Array().Length().Equal(2)
+	authUser1.GET("/api/v1/teams").
+		WithQuery("member", "me").
+		Expect().Status(http.StatusOK).
+		JSON().Array().Path("$..name").Array().ContainsOnly("Backend")
+	authUser.GET("/api/v1/teams/{teamID}/members", backendID).
+		Expect().Status(http.StatusOK).
+		JSON().Array().Length().Equal(2)
+
+	vote := func(auth *httpexpect.Expect, teamID string) *httpexpect.Response {
+		req := auth.POST("/api/v1/restaurant/{restaurantId}/menu/{menuId}/vote", restaurantLokysID, menuLokysID).
+			WithQuery("date", menuLokysDate)
+		if teamID != "" {
+			req = req.WithQuery("team", teamID)
+		}
+		return req.Expect()
+	}
+
+	// user votes once per day in each poll
+	vote(authUser, backendID).Status(http.StatusCreated)
+	vote(authUser, backendID).Status(http.StatusForbidden)
+	vote(authUser, salesID).Status(http.StatusCreated)
+	vote(authUser, "").Status(http.StatusCreated)
+	vote(authUser1, backendID).Status(http.StatusCreated)
+	vote(authUser1, salesID).Status(http.StatusForbidden)
+	vote(authUser1, "invalid").Status(http.StatusBadRequest)
+
+	// menu of the restaurant outside of the team poll can not be voted
+	authAdmin.PUT("/api/v1/teams/{teamID}", salesID).
+		WithJSON(team.UpdateTeam{Restaurants: []string{restaurantPaikisID}}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("restaurants", []string{restaurantPaikisID})
+	authAdmin.POST("/api/v1/teams/{teamID}/members", salesID).
+		WithJSON(team.NewMember{Email: "user1@example.com"}).
+		Expect().Status(http.StatusCreated)
+	vote(authUser1, salesID).Status(http.StatusBadRequest)
+
+	votes := func(teamID string) *httpexpect.Array {
+		return authUser.GET("/api/v1/restaurant/votes").
+			WithQuery("date", menuLokysDate).
+			WithQuery("team", teamID).
+			Expect().Status(http.StatusOK).
+			JSON().Array()
+	}
+	backendVotes := votes(backendID)
+	backendVotes.Length().Equal(1)
+	backendVotes.Element(0).Object().ValueEqual("id", menuLokysID).ValueEqual("votes", 2)
+	votes(salesID).Empty()
+
+	// organization poll counts only its own votes
+	e.GET("/api/v1/restaurant/votes").
+		WithQuery("date", menuLokysDate).
+		Expect().Status(http.StatusOK).
+		JSON().Array().Element(0).Object().ValueEqual("votes", 1)
+	e.GET("/api/v1/restaurant/votes").
+		WithQuery("date", menuLokysDate).
+		WithQuery("team", backendID).
+		Expect().Status(http.StatusUnauthorized)
+
+	authAdmin.DELETE("/api/v1/teams/{teamID}/members/{userID}", backendID, teamTest.User1.UserID).
+		Expect().Status(http.StatusNoContent)
+	authAdmin.DELETE("/api/v1/teams/{teamID}/members/{userID}", backendID, teamTest.User1.UserID).
+		Expect().Status(http.StatusNotFound)
+
+	// results of the team poll are available to team members and admin
+	authUser1.GET("/api/v1/restaurant/votes").
+		WithQuery("date", menuLokysDate).
+		WithQuery("team", backendID).
+		Expect().Status(http.StatusForbidden)
+	authAdmin.GET("/api/v1/restaurant/votes").
+		WithQuery("date", menuLokysDate).
+		WithQuery("team", backendID).
+		Expect().Status(http.StatusOK).
+		JSON().Array().Element(0).Object().ValueEqual("votes", 2)
+
+	authAdmin.DELETE("/api/v1/teams/{teamID}", backendID).
+		Expect().Status(http.StatusNoContent)
+	authUser.GET("/api/v1/teams/{teamID}", backendID).
+		Expect().Status(http.StatusNotFound)
+	authUser.GET("/api/v1/restaurant/votes").
+		WithQuery("team", backendID).
+		Expect().Status(http.StatusNotFound)
+
+	// archived team keeps its votes, its name can be reused
+	var kept int
+	if err := teamTest.Dbx.Get(&kept, `SELECT COUNT(*) FROM vote WHERE team_id = $1`, backendID); err != nil {
+		t.Fatal(err)
+	}
+	if kept != 2 {
+		t.Fatalf("expected 2 votes of archived team, got %d", kept)
+	}
+	authAdmin.POST("/api/v1/teams").
+		WithJSON(team.NewTeam{Name: "Backend"}).
+		Expect().Status(http.StatusCreated)
+}
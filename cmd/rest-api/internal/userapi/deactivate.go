@@ -0,0 +1,157 @@
+package userapi
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/authorize"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"time"
+)
+
+// handleUserDeactivate godoc
+// @Summary Deactivate user
+// @Description deactivate user, deactivated user can not sign in or vote until reactivated, available only for admin
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param userID path string true "User ID"
+// @Success 200 {object} user.User
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/{userID}/deactivate [post]
+func (s *Server) handleUserDeactivate(w http.ResponseWriter, r *http.Request) {
+	usr, ok := s.targetUser(w, r, authorize.ActionDeactivate)
+	if !ok {
+		return
+	}
+
+	var deactivated *user.User
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if deactivated, err = s.userRepo.Deactivate(ctx, usr.ID, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionDeactivate, TargetType: audit.TargetUser, TargetID: usr.ID,
+			Before: usr, After: deactivated}, nil
+	})
+	if err != nil {
+		respondDeactivateError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, deactivated)
+}
+
+// handleUserReactivate godoc
+// @Summary Reactivate user
+// @Description reactivate deactivated or deleted user, anonymized users can not be reactivated, available only for admin
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param userID path string true "User ID"
+// @Success 200 {object} user.User
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/{userID}/reactivate [post]
+func (s *Server) handleUserReactivate(w http.ResponseWriter, r *http.Request) {
+	usr, ok := s.targetUser(w, r, authorize.ActionReactivate)
+	if !ok {
+		return
+	}
+
+	var reactivated *user.User
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if reactivated, err = s.userRepo.Reactivate(ctx, usr.ID, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionRestore, TargetType: audit.TargetUser, TargetID: usr.ID,
+			Before: usr, After: reactivated}, nil
+	})
+	if err != nil {
+		respondDeactivateError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, reactivated)
+}
+
+// handleUserAnonymize godoc
+// @Summary Anonymize user
+// @Description remove name, email and credentials of deactivated or deleted user, votes and restaurants of the user are kept, available only for admin
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param userID path string true "User ID"
+// @Success 200 {object} user.User
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/{userID}/anonymize [post]
+func (s *Server) handleUserAnonymize(w http.ResponseWriter, r *http.Request) {
+	usr, ok := s.targetUser(w, r, authorize.ActionAnonymize)
+	if !ok {
+		return
+	}
+
+	// personal fields are not copied to the audit trail
+	var anonymized *user.User
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		anonymized, err = s.userRepo.Anonymize(ctx, usr.ID, time.Now())
+		return audit.NewEvent{Action: audit.ActionAnonymize, TargetType: audit.TargetUser, TargetID: usr.ID}, err
+	})
+	if err != nil {
+		respondDeactivateError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, anonymized)
+}
+
+// targetUser returns user of the request when the action is allowed to the
+// signed in user, otherwise error response is sent.
+func (s *Server) targetUser(w http.ResponseWriter, r *http.Request, action authorize.Action) (*user.User, bool) {
+	if _, ok := web.RequestClaims(w, r); !ok {
+		return nil, false
+	}
+
+	usr, ok := r.Context().Value(userCtxKey).(*user.User)
+	if !ok {
+		err := errors.New("User not found")
+		web.RespondError(w, r, http.StatusNotFound, err)
+		return nil, false
+	}
+
+	res := authorize.Resource{Object: authorize.ObjectUser, OwnerID: usr.ID}
+	if !web.Policy.Allowed(web.Subject(r), res, action) {
+		err := errors.Errorf("user %s is available only for admin", action)
+		web.RespondError(w, r, http.StatusForbidden, err)
+		return nil, false
+	}
+	if !allowRoles(w, r, usr.Roles) {
+		return nil, false
+	}
+	return usr, true
+}
+
+// respondDeactivateError sends error response with status matching reactivation
+// or anonymization error.
+func respondDeactivateError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case db.ErrInvalidID:
+		web.RespondError(w, r, http.StatusBadRequest, err)
+	case db.ErrNotFound:
+		web.RespondError(w, r, http.StatusNotFound, err)
+	case user.ErrDeactivated, user.ErrNotDeactivated, user.ErrAnonymized:
+		web.RespondError(w, r, http.StatusConflict, err)
+	default:
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+	}
+}
@@ -2,13 +2,15 @@ package userapi
 
 import (
 	"context"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/go-chi/chi"
-	"github.com/go-chi/jwtauth"
 	"github.com/pkg/errors"
 	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
 	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/authorize"
 	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/password"
 	"github.com/remisb/mat/internal/user"
 	"net/http"
 	"time"
@@ -31,18 +33,19 @@ func (s *Server) userCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userID := chi.URLParam(r, "userID")
 		ctx := r.Context()
-		_, claims, err := jwtauth.FromContext(ctx)
-		if err != nil || claims == nil {
-			web.RespondError(w, r, http.StatusUnauthorized, web.ErrNoTokenFound)
+		if _, ok := web.RequestClaims(w, r); !ok {
 			return
 		}
 
-		rolesSlice, err := rolesFromClaims(claims)
-
-		isAdmin := hasRole(rolesSlice, auth.RoleAdmin)
-		isUser := hasRole(rolesSlice, auth.RoleUser)
+		// users can access their own data, access to other users depends on the policy
+		res := authorize.Resource{Object: authorize.ObjectUser, OwnerID: userID}
+		if !web.Policy.Allowed(web.Subject(r), res, methodAction(r.Method)) {
+			err := web.NewRequestError(db.ErrForbidden, http.StatusForbidden)
+			web.RespondError(w, r, http.StatusForbidden, err)
+			return
+		}
 
-		user, err := s.userRepo.Retrieve(ctx, userID, rolesSlice)
+		user, err := s.userRepo.RetrieveInOrg(ctx, userID)
 		if err != nil {
 			switch err {
 			case db.ErrInvalidID:
@@ -64,18 +67,12 @@ func (s *Server) userCtx(next http.Handler) http.Handler {
 			}
 		}
 
-		if !isAdmin && isUser && user.Email != claims["email"] {
-			err := errors.New("data is available only for owner")
-			web.RespondError(w, r, http.StatusForbidden, err)
-			return
-		}
-
 		ctx = context.WithValue(ctx, userCtxKey, user)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// Delete removes the specified user from the system.
+// Delete soft deletes and deactivates the specified user, history of the user is kept.
 func (s *Server) handleUserDelete() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -87,10 +84,25 @@ func (s *Server) handleUserDelete() http.HandlerFunc {
 			return
 		}
 
-		//userID := chi.URLParam(r, "id")
-		err := s.userRepo.Delete(ctx, usr.ID)
+		version, err := web.IfMatchVersion(r)
+		if err != nil {
+			web.RespondPreconditionError(w, r, err)
+			return
+		}
+		if !allowRoles(w, r, usr.Roles) {
+			return
+		}
+
+		err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (audit.NewEvent, error) {
+			return audit.NewEvent{Action: audit.ActionDelete, TargetType: audit.TargetUser, TargetID: usr.ID,
+				Before: usr}, s.userRepo.Delete(ctx, usr.ID, version, time.Now())
+		})
 		if err != nil {
 			switch err {
+			case db.ErrVersionConflict:
+				err := web.NewRequestError(err, http.StatusPreconditionFailed)
+				web.RespondError(w, r, http.StatusPreconditionFailed, err)
+				return
 			case db.ErrInvalidID:
 				err := web.NewRequestError(err, http.StatusBadRequest)
 				web.RespondError(w, r, http.StatusBadRequest, err)
@@ -114,26 +126,84 @@ func (s *Server) handleUserDelete() http.HandlerFunc {
 	}
 }
 
+// handleUserUpdate godoc
+// @Summary Update user
+// @Description update user, If-Match header with user ETag is required
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param userID path string true "User ID"
+// @Param If-Match header string true "User ETag"
+// @Param user body user.UpdateUser true "update user"
+// @Success 200 {object} user.User
+// @Failure 400 {object} web.APIError
+// @Failure 412 {object} web.APIError
+// @Failure 428 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/{userID} [put]
 func (s *Server) handleUserUpdate() http.HandlerFunc {
-	type request struct {
-		Name            *string  `json:"name"`
-		Email           *string  `json:"email"`
-		Roles           []string `json:"roles"`
-		Password        *string  `json:"password"`
-		PasswordConfirm *string  `json:"password_confirm" validate:"omitempty,eqfield=Password"`
-	}
 	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		usr, ok := ctx.Value(userCtxKey).(*user.User)
+		if !ok {
+			err := errors.New("User not found")
+			web.RespondError(w, r, http.StatusNotFound, err)
+			return
+		}
 
-		var updateUser request
-		err := web.DecodeBody(r, &updateUser)
+		version, err := web.IfMatchVersion(r)
 		if err != nil {
-			web.RespondError(w, r, http.StatusBadRequest, errors.Wrap(err, ""))
+			web.RespondPreconditionError(w, r, err)
 			return
 		}
 
+		var updateUser user.UpdateUser
 		if err := web.DecodeBody(r, &updateUser); err != nil {
+			web.RespondError(w, r, http.StatusBadRequest, "failed to read user from request ", err)
+			return
+		}
+
+		if updateUser.Password != nil &&
+			(updateUser.PasswordConfirm == nil || *updateUser.Password != *updateUser.PasswordConfirm) {
+			web.RespondError(w, r, http.StatusBadRequest, "password and password confirm are not equal")
+			return
+		}
+		if !allowRoles(w, r, usr.Roles, updateUser.Roles) {
+			return
+		}
 
+		var updated *user.User
+		err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+			if updated, err = s.userRepo.Update(ctx, usr.ID, updateUser, version, time.Now()); err != nil {
+				return ne, err
+			}
+			return audit.NewEvent{Action: audit.ActionUpdate, TargetType: audit.TargetUser, TargetID: usr.ID,
+				Before: usr, After: updated}, nil
+		})
+		if err != nil {
+			switch err {
+			case db.ErrVersionConflict:
+				err := web.NewRequestError(err, http.StatusPreconditionFailed)
+				web.RespondError(w, r, http.StatusPreconditionFailed, err)
+				return
+			case db.ErrNotFound:
+				err := web.NewRequestError(err, http.StatusNotFound)
+				web.RespondError(w, r, http.StatusNotFound, err)
+				return
+			case user.ErrUnknownRole, user.ErrUnknownOffice, password.ErrTooShort, password.ErrBreached:
+				err := web.NewRequestError(err, http.StatusBadRequest)
+				web.RespondError(w, r, http.StatusBadRequest, err)
+				return
+			default:
+				err := errors.Wrapf(err, "Id: %s", usr.ID)
+				web.RespondError(w, r, http.StatusInternalServerError, err)
+				return
+			}
 		}
+
+		web.SetETag(w, updated.Version)
+		web.Respond(w, r, http.StatusOK, updated)
 	}
 }
 
@@ -148,23 +218,43 @@ func (s *Server) handleUserCreate(w http.ResponseWriter, r *http.Request) {
 		web.RespondError(w, r, http.StatusBadRequest, "password and password confirm are not equal")
 		return
 	}
+	if !allowRoles(w, r, u.Roles) {
+		return
+	}
 
-	uDb, err := s.userRepo.Create(r.Context(), u.Name, u.Email, u.Password, u.Roles, time.Now())
+	var uDb *user.User
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if uDb, err = s.userRepo.Create(ctx, u.Name, u.Email, u.Password, u.Roles, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionCreate, TargetType: audit.TargetUser, TargetID: uDb.ID,
+			After: uDb}, nil
+	})
 	if err != nil {
-		web.RespondError(w, r, http.StatusInternalServerError, err)
+		switch err {
+		case user.ErrUnknownRole, password.ErrTooShort, password.ErrBreached:
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
 		return
 	}
 
+	web.SetETag(w, uDb.Version)
 	web.Respond(w, r, http.StatusCreated, uDb)
 }
 
 // handleTokenGet godoc
 // @Summary Get JWT token
-// @Description get jwt token
+// @Description get jwt token, MFA challenge is returned with 202 status when two-factor authentication is required
 // @Accept  json
 // @Produce  json
 // @Success 200 {object} web.TokenResult
+// @Success 202 {object} MFAChallengeResult
 // @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 423 {object} web.APIError
+// @Failure 429 {object} web.APIError
 // @Failure 500 {object} web.APIError
 // @Router /users/token [get]
 // @Security BasicAuth
@@ -176,28 +266,88 @@ func (s *Server) handleTokenGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx := r.Context()
+	// failed logins are limited per client IP to slow down password guessing
+	ipKey := "login:" + web.ClientIP(r)
+	if s.loginLimiter.Exceeded(ipKey) {
+		log.Sugar.Warnf("too many failed logins from %s", web.ClientIP(r))
+		web.RespondError(w, r, http.StatusTooManyRequests, web.ErrTooManyRequests)
+		return
+	}
 
 	aut := *s.authenticator
-	token, _, err := aut.NewToken(ctx, email, pass)
+	var pair auth.TokenPair
+	var authUser user.User
+	var authErr error
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		pair, authUser, authErr = aut.NewTokenPair(ctx, email, pass)
+		switch authErr {
+		case nil:
+			return audit.NewEvent{ActorID: authUser.ID, Action: audit.ActionLogin, TargetType: audit.TargetUser,
+				TargetID: authUser.ID}, nil
+		case db.ErrAuthenticationFailure, user.ErrAccountLocked:
+			// failed login counted for the lockout is stored with its event
+			return audit.NewEvent{Action: audit.ActionLoginFailed, TargetType: audit.TargetUser, TargetEmail: email}, nil
+		}
+		return ne, authErr
+	})
+	if err == nil {
+		err = authErr
+	}
 	if err != nil {
-		err = errors.Wrap(err, "token encode")
-		web.RespondError(w, r, http.StatusInternalServerError, err)
+		switch err {
+		case db.ErrAuthenticationFailure:
+			s.loginLimiter.Allow(ipKey)
+			web.RespondError(w, r, http.StatusUnauthorized, err)
+		case user.ErrAccountLocked:
+			s.loginLimiter.Allow(ipKey)
+			web.RespondError(w, r, http.StatusLocked, err)
+		case user.ErrNotVerified, user.ErrDeactivated:
+			web.RespondError(w, r, http.StatusForbidden, err)
+		case auth.ErrMFARequired:
+			s.respondMFAChallenge(w, r, authUser)
+		default:
+			err = errors.Wrap(err, "token encode")
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, tokenResult(pair))
+}
+
+// handleNotificationsGet godoc
+// @Summary List notifications
+// @Description get notifications of the current user, the latest first
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {array} notify.Notification
+// @Failure 401 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/notifications [get]
+func (s *Server) handleNotificationsGet(w http.ResponseWriter, r *http.Request) {
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
 		return
 	}
 
-	tkn := web.TokenResult{
-		Token: token,
+	userID := claims.Subject
+	notifications, err := s.notifyRepo.List(r.Context(), userID)
+	if err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
 	}
-	web.Respond(w, r, http.StatusOK, tkn)
+
+	web.Respond(w, r, http.StatusOK, notifications)
 }
 
 // handleUsersGet godoc
 // @Summary List users
-// @Description get users
+// @Description get users, deactivated and deleted users and users with not verified email are listed with inactive or all status
 // @Accept   json
 // @Produce  json
+// @Param status query string false "user status: active (default), inactive or all"
 // @Success 200 {array} user.User
+// @Failure 400 {object} web.APIError
 // @Failure 401 {object} web.APIError
 // @Failure 403 {object} web.APIError
 // @Failure 500 {object} web.APIError
@@ -205,23 +355,13 @@ func (s *Server) handleTokenGet(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleUsersGet(w http.ResponseWriter, r *http.Request) {
 	// TODO add pagination
 
-	_, claims, err := jwtauth.FromContext(r.Context())
-	if err != nil || claims == nil {
-		web.RespondError(w, r, http.StatusUnauthorized, web.ErrNoTokenFound)
-		return
-	}
-
-	rolesSlice, err := rolesFromClaims(claims)
-
-	isAdmin := hasRole(rolesSlice, auth.RoleAdmin)
-
-	if !isAdmin {
-		err := errors.New("data is available only for admin")
-		web.RespondError(w, r, http.StatusForbidden, err)
+	status, err := user.ParseStatus(r.URL.Query().Get("status"))
+	if err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, err)
 		return
 	}
 
-	users, err := s.userRepo.GetUsers(r.Context())
+	users, err := s.userRepo.GetUsers(r.Context(), status)
 	if err != nil {
 		web.RespondError(w, r, http.StatusInternalServerError, err)
 		return
@@ -238,27 +378,21 @@ func (s *Server) handleUserGet(w http.ResponseWriter, r *http.Request) {
 		web.RespondError(w, r, http.StatusNotFound, err)
 		return
 	}
-	web.Respond(w, r, http.StatusOK, usr)
-}
 
-func rolesFromClaims(claims jwt.MapClaims) ([]string, error) {
-	roles2, ok := claims["roles"].([]interface{})
-	if !ok {
-		return nil, errors.New("invalid token, no roles")
-	}
-
-	rolesSlice := make([]string, len(roles2))
-	for i, v := range roles2 {
-		rolesSlice[i] = v.(string)
+	if web.NotModified(w, r, usr.Version) {
+		return
 	}
-	return rolesSlice, nil
+	web.Respond(w, r, http.StatusOK, usr)
 }
 
-func hasRole(roles []string, want string) bool {
-	for _, role := range roles {
-		if role == want {
-			return true
-		}
+// methodAction maps HTTP method of the request to the policy action.
+func methodAction(method string) authorize.Action {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return authorize.ActionRead
+	case http.MethodDelete:
+		return authorize.ActionDelete
+	default:
+		return authorize.ActionUpdate
 	}
-	return false
 }
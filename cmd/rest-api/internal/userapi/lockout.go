@@ -0,0 +1,66 @@
+package userapi
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/authorize"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+)
+
+// handleUserUnlock godoc
+// @Summary Unlock user account
+// @Description unlock account locked after failed login attempts, available only for admin
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param userID path string true "User ID"
+// @Success 204
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/{userID}/unlock [post]
+func (s *Server) handleUserUnlock(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	usr, ok := ctx.Value(userCtxKey).(*user.User)
+	if !ok {
+		err := errors.New("User not found")
+		web.RespondError(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	res := authorize.Resource{Object: authorize.ObjectUser, OwnerID: usr.ID}
+	if !web.Policy.Allowed(web.Subject(r), res, authorize.ActionUnlock) {
+		err := errors.New("account can be unlocked only by admin")
+		web.RespondError(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (audit.NewEvent, error) {
+		return audit.NewEvent{Action: audit.ActionUnlock, TargetType: audit.TargetUser, TargetID: usr.ID,
+			Before: usr}, s.userRepo.Unlock(ctx, usr.Email)
+	})
+	if err != nil {
+		switch err {
+		case db.ErrNotFound:
+			web.RespondError(w, r, http.StatusNotFound, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	adminID := claims.Subject
+	log.Sugar.Infof("account %s unlocked by admin %s", usr.ID, adminID)
+	web.Respond(w, r, http.StatusNoContent, nil)
+}
@@ -0,0 +1,257 @@
+package userapi
+
+import (
+	"context"
+	"fmt"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/mail"
+	"github.com/remisb/mat/internal/password"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"time"
+)
+
+// votesDefaultPeriod is used when from query parameter of my votes is not provided.
+const votesDefaultPeriod = 30 * 24 * time.Hour
+
+// handleMeGet godoc
+// @Summary Get current user
+// @Description get profile of the authenticated user
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {object} user.User
+// @Failure 401 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/me [get]
+func (s *Server) handleMeGet(w http.ResponseWriter, r *http.Request) {
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	usr, err := s.userRepo.RetrieveByID(r.Context(), claims.Subject)
+	if err != nil {
+		respondMeError(w, r, err)
+		return
+	}
+
+	web.SetETag(w, usr.Version)
+	web.Respond(w, r, http.StatusOK, usr)
+}
+
+// handleMeUpdate godoc
+// @Summary Update current user
+// @Description update name and email of the authenticated user, If-Match header with user ETag is required, new email is applied after it is verified by the link sent to it
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param If-Match header string true "User ETag"
+// @Param user body user.UpdateProfile true "update profile"
+// @Success 200 {object} user.User
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 412 {object} web.APIError
+// @Failure 428 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/me [patch]
+func (s *Server) handleMeUpdate(w http.ResponseWriter, r *http.Request) {
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	version, err := web.IfMatchVersion(r)
+	if err != nil {
+		web.RespondPreconditionError(w, r, err)
+		return
+	}
+
+	var up user.UpdateProfile
+	if err := web.DecodeBody(r, &up); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read profile from request", err)
+		return
+	}
+
+	ctx := r.Context()
+	before, err := s.userRepo.RetrieveByID(ctx, claims.Subject)
+	if err != nil {
+		respondMeError(w, r, err)
+		return
+	}
+
+	// new email is applied only after it is verified, unverified address could be
+	// used to take over accounts linked by email
+	email := ""
+	if up.Email != nil && *up.Email != before.Email {
+		email = *up.Email
+		if len(s.registration.AllowedDomains) > 0 && !s.registration.allowed(email) {
+			web.RespondError(w, r, http.StatusForbidden, ErrRegistrationDomain)
+			return
+		}
+	}
+
+	uu := user.UpdateUser{Name: up.Name, OfficeID: up.OfficeID}
+	var updated *user.User
+	err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if updated, err = s.userRepo.Update(ctx, claims.Subject, uu, version, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionUpdate, TargetType: audit.TargetUser, TargetID: claims.Subject,
+			Before: before, After: updated}, nil
+	})
+	if err != nil {
+		switch err {
+		case db.ErrVersionConflict:
+			web.RespondError(w, r, http.StatusPreconditionFailed, err)
+		case user.ErrUnknownOffice, password.ErrTooShort, password.ErrBreached:
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		default:
+			respondMeError(w, r, err)
+		}
+		return
+	}
+
+	if email != "" {
+		send := func(u user.User, token string) error {
+			msg := mail.Message{
+				To:      u.Email,
+				Subject: "Verify your new email",
+				Body: fmt.Sprintf("Hello %s,\n\nplease confirm your new email by opening the link:\n%s\n",
+					u.Name, s.registration.verifyLink(token)),
+			}
+			return s.registration.Mailer.Send(ctx, msg)
+		}
+		if err := s.userRepo.RequestEmailChange(ctx, claims.Subject, email, time.Now(), send); err != nil {
+			switch err {
+			case user.ErrEmailTaken:
+				web.RespondError(w, r, http.StatusConflict, err)
+			default:
+				respondMeError(w, r, err)
+			}
+			return
+		}
+	}
+
+	web.SetETag(w, updated.Version)
+	web.Respond(w, r, http.StatusOK, updated)
+}
+
+// handleMePasswordChange godoc
+// @Summary Change password
+// @Description change password of the authenticated user, current password is required
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param password body user.PasswordChange true "Current and new password"
+// @Success 204
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 429 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/me/password [post]
+func (s *Server) handleMePasswordChange(w http.ResponseWriter, r *http.Request) {
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	var pc user.PasswordChange
+	if err := web.DecodeBody(r, &pc); err != nil || pc.CurrentPassword == "" || pc.Password == "" {
+		web.RespondError(w, r, http.StatusBadRequest, "current and new password should be provided")
+		return
+	}
+	if pc.Password != pc.PasswordConfirm {
+		web.RespondError(w, r, http.StatusBadRequest, "password and password confirm are not equal")
+		return
+	}
+
+	// wrong current passwords are limited per user to slow down password guessing with a stolen token
+	key := "password:" + claims.Subject
+	if s.loginLimiter.Exceeded(key) {
+		web.RespondError(w, r, http.StatusTooManyRequests, web.ErrTooManyRequests)
+		return
+	}
+
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (audit.NewEvent, error) {
+		_, err := s.userRepo.ChangePassword(ctx, claims.Subject, pc.CurrentPassword, pc.Password, time.Now())
+		return audit.NewEvent{Action: audit.ActionPasswordChange, TargetType: audit.TargetUser,
+			TargetID: claims.Subject}, err
+	})
+	if err != nil {
+		switch err {
+		case user.ErrWrongPassword:
+			s.loginLimiter.Allow(key)
+			web.RespondError(w, r, http.StatusForbidden, err)
+		case password.ErrTooShort, password.ErrBreached:
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		default:
+			respondMeError(w, r, err)
+		}
+		return
+	}
+
+	log.Sugar.Infof("password changed by user %s", claims.Subject)
+	web.Respond(w, r, http.StatusNoContent, nil)
+}
+
+// handleMeVotesGet godoc
+// @Summary Get my votes
+// @Description get voting history of the authenticated user with voted restaurant and menu
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param from query string false "period start date, default 30 days before to" Format(date)
+// @Param to query string false "period end date inclusive, default today" Format(date)
+// @Success 200 {array} restaurant.UserVote
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/me/votes [get]
+func (s *Server) handleMeVotesGet(w http.ResponseWriter, r *http.Request) {
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	to, err := web.QueryDate(r, "to", time.Now())
+	if err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	from, err := web.QueryDate(r, "from", to.Add(-votesDefaultPeriod))
+	if err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if from.After(to) {
+		web.RespondError(w, r, http.StatusBadRequest, "from date should not be after to date")
+		return
+	}
+
+	votes, err := s.restaurantRepo.UserVotes(r.Context(), claims.Subject, from, to)
+	if err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, votes)
+}
+
+// respondMeError sends error response of current user endpoints. The user is
+// identified by the token so missing user means the token is no longer valid.
+func respondMeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case db.ErrInvalidID, db.ErrNotFound:
+		web.RespondError(w, r, http.StatusUnauthorized, err)
+	default:
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+	}
+}
@@ -0,0 +1,390 @@
+package userapi
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/authorize"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"time"
+)
+
+var (
+	errMFATokenRequired = errors.New("mfa token should be provided")
+	errMFACodeRequired  = errors.New("code should be provided")
+	errMFAEnforced      = errors.New("two-factor authentication is required for the user role and can not be disabled")
+	errMFAResetSelf     = errors.New("own two-factor authentication is disabled at /users/me/mfa/disable")
+)
+
+// MFAChallengeResult is returned by token request of the user with two-factor
+// authentication, the token is exchanged for access token at /users/token/mfa.
+// EnrollRequired is set when the user has to enroll at /users/token/mfa/enroll first.
+type MFAChallengeResult struct {
+	MFAToken       string `json:"mfaToken"`
+	ExpiresIn      int    `json:"expiresIn"`
+	EnrollRequired bool   `json:"enrollRequired,omitempty"`
+}
+
+// MFATokenResult is returned when the second factor is verified. Recovery codes
+// are returned once, when two-factor authentication is enabled by enrollment challenge.
+type MFATokenResult struct {
+	web.TokenResult
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+}
+
+// MFAVerification contains MFA challenge token and code of authenticator app or recovery code.
+type MFAVerification struct {
+	MFAToken string `json:"mfaToken"`
+	Code     string `json:"code"`
+}
+
+// MFACode contains code of authenticator app or recovery code.
+type MFACode struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes lists recovery codes, each of them can be used once instead of authenticator app code.
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// respondMFAChallenge creates MFA challenge of the user authenticated by password.
+func (s *Server) respondMFAChallenge(w http.ResponseWriter, r *http.Request, u user.User) {
+	aut := *s.authenticator
+	challenge, err := aut.NewMFAChallenge(r.Context(), u)
+	if err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusAccepted, MFAChallengeResult{
+		MFAToken:       challenge.Token,
+		ExpiresIn:      int(challenge.ExpiresIn.Seconds()),
+		EnrollRequired: challenge.Enroll,
+	})
+}
+
+// handleTokenMFA godoc
+// @Summary Verify second factor
+// @Description exchange MFA token returned by token request and code of authenticator app or recovery code for JWT token
+// @Accept  json
+// @Produce  json
+// @Param verification body MFAVerification true "MFA token and code"
+// @Success 200 {object} MFATokenResult
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 423 {object} web.APIError
+// @Failure 429 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/token/mfa [post]
+func (s *Server) handleTokenMFA(w http.ResponseWriter, r *http.Request) {
+	var mv MFAVerification
+	if err := web.DecodeBody(r, &mv); err != nil || mv.MFAToken == "" || mv.Code == "" {
+		web.RespondError(w, r, http.StatusBadRequest, "mfa token and code should be provided")
+		return
+	}
+
+	// wrong codes are limited per client IP together with failed logins
+	ipKey := "login:" + web.ClientIP(r)
+	if s.loginLimiter.Exceeded(ipKey) {
+		web.RespondError(w, r, http.StatusTooManyRequests, web.ErrTooManyRequests)
+		return
+	}
+
+	aut := *s.authenticator
+	var pair auth.TokenPair
+	var authUser user.User
+	var recoveryCodes []string
+	var verifyErr error
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		pair, authUser, recoveryCodes, verifyErr = aut.VerifyMFA(ctx, mv.MFAToken, mv.Code)
+		switch verifyErr {
+		case nil:
+		case user.ErrInvalidMFACode, user.ErrAccountLocked:
+			// failed attempt of the challenge counted for the lockout is stored with its event
+			return audit.NewEvent{Action: audit.ActionLoginFailed, TargetType: audit.TargetUser, TargetID: authUser.ID}, nil
+		default:
+			return ne, verifyErr
+		}
+
+		if recoveryCodes != nil {
+			ne = audit.NewEvent{ActorID: authUser.ID, Action: audit.ActionMFAEnable, TargetType: audit.TargetUser,
+				TargetID: authUser.ID}
+			if err := s.auditor.Record(ctx, web.AuditEvent(r, ne)); err != nil {
+				return ne, err
+			}
+		}
+		return audit.NewEvent{ActorID: authUser.ID, Action: audit.ActionLogin, TargetType: audit.TargetUser,
+			TargetID: authUser.ID}, nil
+	})
+	if err == nil {
+		err = verifyErr
+	}
+	if err != nil {
+		switch err {
+		case user.ErrInvalidMFACode:
+			s.loginLimiter.Allow(ipKey)
+			web.RespondError(w, r, http.StatusUnauthorized, err)
+		case user.ErrAccountLocked:
+			s.loginLimiter.Allow(ipKey)
+			web.RespondError(w, r, http.StatusLocked, err)
+		case auth.ErrInvalidMFAChallenge:
+			web.RespondError(w, r, http.StatusUnauthorized, err)
+		case auth.ErrMFAEnrollRequired, user.ErrMFANotEnrolled, user.ErrNotVerified:
+			web.RespondError(w, r, http.StatusForbidden, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, MFATokenResult{TokenResult: tokenResult(pair), RecoveryCodes: recoveryCodes})
+}
+
+// handleTokenMFAEnroll godoc
+// @Summary Enroll second factor on sign in
+// @Description start enrollment of the user whose role requires two-factor authentication, MFA token of enrollment challenge is required
+// @Accept  json
+// @Produce  json
+// @Param verification body MFAVerification true "MFA token"
+// @Success 200 {object} user.MFAEnrollment
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/token/mfa/enroll [post]
+func (s *Server) handleTokenMFAEnroll(w http.ResponseWriter, r *http.Request) {
+	var mv MFAVerification
+	if err := web.DecodeBody(r, &mv); err != nil || mv.MFAToken == "" {
+		web.RespondError(w, r, http.StatusBadRequest, errMFATokenRequired)
+		return
+	}
+
+	aut := *s.authenticator
+	enrollment, err := aut.EnrollMFA(r.Context(), mv.MFAToken)
+	if err != nil {
+		respondMFAError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, enrollment)
+}
+
+// handleMeMFAGet godoc
+// @Summary Get two-factor authentication state
+// @Description get two-factor authentication state of the authenticated user and a number of unused recovery codes
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {object} user.MFAStatus
+// @Failure 401 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/me/mfa [get]
+func (s *Server) handleMeMFAGet(w http.ResponseWriter, r *http.Request) {
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	status, err := s.userRepo.MFAStatus(r.Context(), claims.Subject)
+	if err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, status)
+}
+
+// handleMeMFAEnroll godoc
+// @Summary Enroll two-factor authentication
+// @Description generate secret of authenticator app, uri is shown as QR code. Two-factor authentication is enabled after the first code is confirmed
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {object} user.MFAEnrollment
+// @Failure 401 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/me/mfa/enroll [post]
+func (s *Server) handleMeMFAEnroll(w http.ResponseWriter, r *http.Request) {
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	enrollment, err := s.userRepo.EnrollMFA(r.Context(), claims.Subject, time.Now())
+	if err != nil {
+		respondMFAError(w, r, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, enrollment)
+}
+
+// handleMeMFAEnable godoc
+// @Summary Enable two-factor authentication
+// @Description confirm enrolled secret with the code of authenticator app, recovery codes are returned once
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param code body MFACode true "Authenticator app code"
+// @Success 200 {object} RecoveryCodes
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/me/mfa/enable [post]
+func (s *Server) handleMeMFAEnable(w http.ResponseWriter, r *http.Request) {
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	var mc MFACode
+	if err := web.DecodeBody(r, &mc); err != nil || mc.Code == "" {
+		web.RespondError(w, r, http.StatusBadRequest, errMFACodeRequired)
+		return
+	}
+
+	var codes []string
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if codes, err = s.userRepo.EnableMFA(ctx, claims.Subject, mc.Code, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionMFAEnable, TargetType: audit.TargetUser, TargetID: claims.Subject}, nil
+	})
+	if err != nil {
+		respondMFAError(w, r, err)
+		return
+	}
+
+	log.Sugar.Infof("two-factor authentication enabled by user %s", claims.Subject)
+	web.Respond(w, r, http.StatusOK, RecoveryCodes{codes})
+}
+
+// handleMeMFADisable godoc
+// @Summary Disable two-factor authentication
+// @Description disable two-factor authentication of the authenticated user, code of authenticator app or recovery code is required
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param code body MFACode true "Authenticator app code or recovery code"
+// @Success 204
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 429 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/me/mfa/disable [post]
+func (s *Server) handleMeMFADisable(w http.ResponseWriter, r *http.Request) {
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	var mc MFACode
+	if err := web.DecodeBody(r, &mc); err != nil || mc.Code == "" {
+		web.RespondError(w, r, http.StatusBadRequest, errMFACodeRequired)
+		return
+	}
+
+	aut := *s.authenticator
+	if aut.MFAEnforced(user.User{Roles: claims.Roles}) {
+		web.RespondError(w, r, http.StatusForbidden, errMFAEnforced)
+		return
+	}
+
+	// wrong codes are limited per user to slow down code guessing with a stolen token
+	key := "mfa:" + claims.Subject
+	if s.loginLimiter.Exceeded(key) {
+		web.RespondError(w, r, http.StatusTooManyRequests, web.ErrTooManyRequests)
+		return
+	}
+
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (audit.NewEvent, error) {
+		return audit.NewEvent{Action: audit.ActionMFADisable, TargetType: audit.TargetUser, TargetID: claims.Subject},
+			s.userRepo.DisableMFA(ctx, claims.Subject, mc.Code, time.Now())
+	})
+	if err != nil {
+		if err == user.ErrInvalidMFACode {
+			s.loginLimiter.Allow(key)
+		}
+		respondMFAError(w, r, err)
+		return
+	}
+
+	log.Sugar.Infof("two-factor authentication disabled by user %s", claims.Subject)
+	web.Respond(w, r, http.StatusNoContent, nil)
+}
+
+// handleUserMFAReset godoc
+// @Summary Reset two-factor authentication
+// @Description delete two-factor authentication of the user who lost the device and recovery codes, available only for admin
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param userID path string true "User ID"
+// @Success 204
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/{userID}/mfa [delete]
+func (s *Server) handleUserMFAReset(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	usr, ok := ctx.Value(userCtxKey).(*user.User)
+	if !ok {
+		err := errors.New("User not found")
+		web.RespondError(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	if usr.ID == claims.Subject {
+		web.RespondError(w, r, http.StatusForbidden, errMFAResetSelf)
+		return
+	}
+
+	res := authorize.Resource{Object: authorize.ObjectUser, OwnerID: usr.ID}
+	if !web.Policy.Allowed(web.Subject(r), res, authorize.ActionResetMFA) {
+		err := errors.New("two-factor authentication can be reset only by admin")
+		web.RespondError(w, r, http.StatusForbidden, err)
+		return
+	}
+	if !allowRoles(w, r, usr.Roles) {
+		return
+	}
+
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (audit.NewEvent, error) {
+		return audit.NewEvent{Action: audit.ActionMFADisable, TargetType: audit.TargetUser, TargetID: usr.ID},
+			s.userRepo.ResetMFA(ctx, usr.ID)
+	})
+	if err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Sugar.Infof("two-factor authentication of %s reset by admin %s", usr.ID, claims.Subject)
+	web.Respond(w, r, http.StatusNoContent, nil)
+}
+
+// respondMFAError sends error response with status matching two-factor authentication error.
+func respondMFAError(w http.ResponseWriter, r *http.Request, err error) {
+	switch err {
+	case user.ErrInvalidMFACode:
+		web.RespondError(w, r, http.StatusBadRequest, err)
+	case auth.ErrInvalidMFAChallenge:
+		web.RespondError(w, r, http.StatusUnauthorized, err)
+	case user.ErrMFAEnabled, user.ErrMFANotEnrolled:
+		web.RespondError(w, r, http.StatusConflict, err)
+	default:
+		respondMeError(w, r, err)
+	}
+}
@@ -0,0 +1,127 @@
+package userapi
+
+import (
+	"context"
+	"fmt"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/mail"
+	"github.com/remisb/mat/internal/password"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// passwordResetTimeout limits background password reset request including mail delivery.
+const passwordResetTimeout = time.Minute
+
+// handlePasswordForgot godoc
+// @Summary Request password reset
+// @Description send password reset link to the email, response does not reveal if the email is registered
+// @Accept  json
+// @Produce  json
+// @Success 202
+// @Failure 400 {object} web.APIError
+// @Failure 429 {object} web.APIError
+// @Router /users/password/forgot [post]
+func (s *Server) handlePasswordForgot(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Email string `json:"email" validate:"required"`
+	}
+
+	var req request
+	if err := web.DecodeBody(r, &req); err != nil || req.Email == "" {
+		web.RespondError(w, r, http.StatusBadRequest, "email should be provided")
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if !s.resetLimiter.Allow("email:" + email) {
+		web.RespondError(w, r, http.StatusTooManyRequests, web.ErrTooManyRequests)
+		return
+	}
+
+	// reset is requested in background, the same response is sent in the same time
+	// for unknown emails and failures not to reveal registered emails
+	go s.requestPasswordReset(req.Email)
+	web.Respond(w, r, http.StatusAccepted, nil)
+}
+
+// requestPasswordReset creates password reset token of the user with passed email
+// and mails reset link to the user. Errors are only logged.
+func (s *Server) requestPasswordReset(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), passwordResetTimeout)
+	defer cancel()
+
+	u, token, err := s.userRepo.RequestPasswordReset(ctx, email, time.Now())
+	switch err {
+	case nil:
+	case db.ErrNotFound:
+		return
+	default:
+		log.Sugar.Errorf("error on password reset request, error: %s", err)
+		return
+	}
+
+	msg := mail.Message{
+		To:      u.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nuse the link to set a new password:\n%s\n\n"+
+			"Ignore this email if you did not request password reset.\n",
+			u.Name, s.registration.ResetURL+"?token="+url.QueryEscape(token)),
+	}
+	if err := s.registration.Mailer.Send(ctx, msg); err != nil {
+		log.Sugar.Errorf("error on sending password reset mail, error: %s", err)
+	}
+}
+
+// handlePasswordReset godoc
+// @Summary Reset password
+// @Description set new password using single-use reset token received by email
+// @Accept  json
+// @Produce  json
+// @Param reset body user.PasswordReset true "password reset"
+// @Success 204
+// @Failure 400 {object} web.APIError
+// @Failure 429 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/password/reset [post]
+func (s *Server) handlePasswordReset(w http.ResponseWriter, r *http.Request) {
+	var pr user.PasswordReset
+	if err := web.DecodeBody(r, &pr); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read password reset from request", err)
+		return
+	}
+
+	if pr.Token == "" || pr.Password == "" {
+		web.RespondError(w, r, http.StatusBadRequest, "token and password should not be empty")
+		return
+	}
+
+	if pr.Password != pr.PasswordConfirm {
+		web.RespondError(w, r, http.StatusBadRequest, "password and password confirm are not equal")
+		return
+	}
+
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		userID, err := s.userRepo.ResetPassword(ctx, pr.Token, pr.Password, time.Now())
+		return audit.NewEvent{ActorID: userID, Action: audit.ActionPasswordReset, TargetType: audit.TargetUser,
+			TargetID: userID}, err
+	})
+	if err != nil {
+		switch err {
+		case user.ErrInvalidResetToken, password.ErrTooShort, password.ErrBreached:
+			err := web.NewRequestError(err, http.StatusBadRequest)
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	web.Respond(w, r, http.StatusNoContent, nil)
+}
@@ -0,0 +1,159 @@
+package userapi
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/mail"
+	"github.com/remisb/mat/internal/oidc"
+	"github.com/remisb/mat/internal/password"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrRegistrationDomain returned when email domain is not allowed to register.
+var ErrRegistrationDomain = errors.New("email domain is not allowed to register")
+
+// Registration structure stores self-service registration, password reset and
+// sign in settings. Single sign-on is disabled when SSO is nil, Backend verifies
+// credentials of token requests, local users are used when it is nil. Users having
+// one of MFARoles must sign in with two-factor authentication.
+type Registration struct {
+	Mailer         mail.Mailer
+	AllowedDomains []string
+	VerifyURL      string
+	ResetURL       string
+	SSO            *oidc.Client
+	Backend        auth.Backend
+	MFARoles       []string
+}
+
+// allowed checks if passed email belongs to one of allowed domains.
+func (reg Registration) allowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 1 || at == len(email)-1 {
+		return false
+	}
+
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range reg.AllowedDomains {
+		if strings.ToLower(strings.TrimSpace(d)) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+func (reg Registration) verifyLink(token string) string {
+	return reg.VerifyURL + "?token=" + url.QueryEscape(token)
+}
+
+// handleRegister godoc
+// @Summary Register user
+// @Description register new inactive user with corporate email, verification link is sent by email
+// @Accept  json
+// @Produce  json
+// @Param user body user.NewRegistration true "new registration"
+// @Success 201 {object} user.User
+// @Failure 400 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/register [post]
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var nr user.NewRegistration
+	if err := web.DecodeBody(r, &nr); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read registration from request", err)
+		return
+	}
+
+	if nr.Name == "" || nr.Email == "" || nr.Password == "" {
+		web.RespondError(w, r, http.StatusBadRequest, "name, email and password should not be empty")
+		return
+	}
+
+	if nr.Password != nr.PasswordConfirm {
+		web.RespondError(w, r, http.StatusBadRequest, "password and password confirm are not equal")
+		return
+	}
+
+	if !s.registration.allowed(nr.Email) {
+		web.RespondError(w, r, http.StatusForbidden, ErrRegistrationDomain)
+		return
+	}
+
+	ctx := r.Context()
+	send := func(u user.User, token string) error {
+		msg := mail.Message{
+			To:      u.Email,
+			Subject: "Verify your email",
+			Body: fmt.Sprintf("Hello %s,\n\nplease verify your email by opening the link:\n%s\n",
+				u.Name, s.registration.verifyLink(token)),
+		}
+		return s.registration.Mailer.Send(ctx, msg)
+	}
+
+	var uDb *user.User
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if uDb, err = s.userRepo.Register(ctx, nr, time.Now(), send); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{ActorID: uDb.ID, Action: audit.ActionCreate, TargetType: audit.TargetUser,
+			TargetID: uDb.ID, After: uDb}, nil
+	})
+	if err != nil {
+		switch err {
+		case user.ErrEmailTaken:
+			err := web.NewRequestError(err, http.StatusConflict)
+			web.RespondError(w, r, http.StatusConflict, err)
+		case password.ErrTooShort, password.ErrBreached:
+			err := web.NewRequestError(err, http.StatusBadRequest)
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	web.Respond(w, r, http.StatusCreated, uDb)
+}
+
+// handleVerify godoc
+// @Summary Verify user email
+// @Description activate registered user or change email of the user by verification token sent by email
+// @Produce  json
+// @Param token query string true "verification token"
+// @Success 200 {object} user.User
+// @Failure 400 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/verify [get]
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		web.RespondError(w, r, http.StatusBadRequest, user.ErrInvalidToken)
+		return
+	}
+
+	u, err := s.userRepo.Verify(r.Context(), token, time.Now())
+	if err != nil {
+		switch err {
+		case user.ErrInvalidToken:
+			err := web.NewRequestError(err, http.StatusBadRequest)
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		case user.ErrEmailTaken:
+			web.RespondError(w, r, http.StatusConflict, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, u)
+}
@@ -0,0 +1,218 @@
+package userapi
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"time"
+)
+
+// errSuperAdminRole used when super admin role is granted or revoked by user not having it.
+var errSuperAdminRole = errors.New("only super admin can manage super admin users")
+
+// assignRoles contains roles replacing current roles of the user.
+type assignRoles struct {
+	Roles []string `json:"roles" validate:"required"`
+}
+
+// handleRolesGet godoc
+// @Summary List roles
+// @Description get defined roles with their permissions
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {array} user.Role
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/roles [get]
+func (s *Server) handleRolesGet(w http.ResponseWriter, r *http.Request) {
+
+	roles, err := s.userRepo.Roles(r.Context())
+	if err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	web.Respond(w, r, http.StatusOK, roles)
+}
+
+// handleRoleCreate godoc
+// @Summary Create role
+// @Description define a new role with permissions listed as object:action, available only for admin
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param role body user.NewRole true "New role"
+// @Success 201 {object} user.Role
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/roles [post]
+func (s *Server) handleRoleCreate(w http.ResponseWriter, r *http.Request) {
+
+	var nr user.NewRole
+	if err := web.DecodeBody(r, &nr); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read role from request", err)
+		return
+	}
+
+	ctx := r.Context()
+	var role *user.Role
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if role, err = s.userRepo.CreateRole(ctx, nr, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionCreate, TargetType: audit.TargetRole, TargetID: role.Name,
+			After: role}, nil
+	})
+	if err != nil {
+		switch err {
+		case user.ErrInvalidRole:
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		case user.ErrRoleExists:
+			web.RespondError(w, r, http.StatusConflict, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	if err := s.loadRolePermissions(ctx); err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	web.Respond(w, r, http.StatusCreated, role)
+}
+
+// handleUserRolesAssign godoc
+// @Summary Assign user roles
+// @Description replace roles of the user, If-Match header with user ETag is required, new roles are applied on the next login or token refresh
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param userID path string true "User ID"
+// @Param If-Match header string true "User ETag"
+// @Success 200 {object} user.User
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 412 {object} web.APIError
+// @Failure 428 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/{userID}/roles [put]
+func (s *Server) handleUserRolesAssign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	usr, ok := ctx.Value(userCtxKey).(*user.User)
+	if !ok {
+		err := errors.New("User not found")
+		web.RespondError(w, r, http.StatusNotFound, err)
+		return
+	}
+
+	var ar assignRoles
+	if err := web.DecodeBody(r, &ar); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read roles from request", err)
+		return
+	}
+	if !allowRoles(w, r, usr.Roles, ar.Roles) {
+		return
+	}
+
+	version, err := web.IfMatchVersion(r)
+	if err != nil {
+		web.RespondPreconditionError(w, r, err)
+		return
+	}
+
+	var updated *user.User
+	err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		if updated, err = s.userRepo.AssignRoles(ctx, usr.ID, ar.Roles, version, time.Now()); err != nil {
+			return ne, err
+		}
+		return audit.NewEvent{Action: audit.ActionAssignRoles, TargetType: audit.TargetUser, TargetID: usr.ID,
+			Before: usr, After: updated}, nil
+	})
+	if err != nil {
+		switch err {
+		case user.ErrUnknownRole:
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		case db.ErrVersionConflict:
+			web.RespondError(w, r, http.StatusPreconditionFailed, err)
+		case db.ErrNotFound:
+			web.RespondError(w, r, http.StatusNotFound, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	log.Sugar.Infof("roles %v assigned to user %s by %s", ar.Roles, usr.ID, claims.Subject)
+	web.SetETag(w, updated.Version)
+	web.Respond(w, r, http.StatusOK, updated)
+}
+
+// rolePermissionsTTL is how long permissions of roles are cached in the authorization
+// policy, roles changed by other instances are picked up within it.
+const rolePermissionsTTL = 30 * time.Second
+
+// refreshRolePermissions reloads permissions of roles into the authorization policy
+// every rolePermissionsTTL. Previous permissions are kept when reload fails.
+func (s *Server) refreshRolePermissions() {
+	ticker := time.NewTicker(rolePermissionsTTL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), rolePermissionsTTL)
+		if err := s.loadRolePermissions(ctx); err != nil {
+			log.Sugar.Errorf("reloading role permissions, error: %s", err)
+		}
+		cancel()
+	}
+}
+
+// loadRolePermissions loads permissions of defined roles into the authorization policy.
+func (s *Server) loadRolePermissions(ctx context.Context) error {
+	roles, err := s.userRepo.Roles(ctx)
+	if err != nil {
+		return err
+	}
+
+	perms := make(map[string][]string, len(roles))
+	for _, role := range roles {
+		perms[role.Name] = role.Permissions
+	}
+	return web.Policy.SetRolePermissions(perms)
+}
+
+// allowRoles checks that user sending the request can manage users having passed
+// roles. Organization admins can not grant, revoke or use super admin role, error
+// response is sent and false is returned in that case.
+func allowRoles(w http.ResponseWriter, r *http.Request, roles ...[]string) bool {
+	claims, _ := auth.ClaimsFromContext(r.Context())
+	if claims.HasRole(auth.RoleSuperAdmin) {
+		return true
+	}
+
+	for _, list := range roles {
+		for _, role := range list {
+			if role == auth.RoleSuperAdmin {
+				web.RespondError(w, r, http.StatusForbidden, errSuperAdminRole)
+				return false
+			}
+		}
+	}
+	return true
+}
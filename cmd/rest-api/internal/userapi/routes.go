@@ -3,6 +3,7 @@ package userapi
 import (
 	"github.com/go-chi/chi"
 	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/authorize"
 )
 
 func (s *Server) initRoutes() {
@@ -14,20 +15,63 @@ func (s *Server) initRoutes() {
 
 		// /api/v1/users/
 		users.Group(func(r chi.Router) {
-			r.Use(web.Verifier(auth.JWTAuth()))
+			r.Use(web.Verifier(auth.Keys(), auth))
 			r.Use(web.Authenticator)
+			r.Use(web.Tenant)
+			r.Use(web.RequireScope())
 
-			r.Get("/", s.handleUsersGet)
-			r.Post("/", s.handleUserCreate)
+			r.With(web.Allow(authorize.ObjectUser, authorize.ActionRead)).
+				Get("/", s.handleUsersGet)
+			// roles are assigned on creation so only users allowed to create users can do that
+			r.With(web.Allow(authorize.ObjectUser, authorize.ActionCreate)).
+				Post("/", s.handleUserCreate)
+			r.Get("/notifications", s.handleNotificationsGet)
+			r.With(web.Allow(authorize.ObjectRole, authorize.ActionRead)).
+				Get("/roles", s.handleRolesGet)
+			r.With(web.Allow(authorize.ObjectRole, authorize.ActionCreate)).
+				Post("/roles", s.handleRoleCreate)
+			r.Post("/logout", s.handleLogout)
+			r.Get("/me", s.handleMeGet)
+			r.Patch("/me", s.handleMeUpdate)
+			r.Post("/me/password", s.handleMePasswordChange)
+			r.Get("/me/mfa", s.handleMeMFAGet)
+			r.Post("/me/mfa/enroll", s.handleMeMFAEnroll)
+			r.Post("/me/mfa/enable", s.handleMeMFAEnable)
+			r.Post("/me/mfa/disable", s.handleMeMFADisable)
+			r.Get("/me/votes", s.handleMeVotesGet)
+			r.Get("/me/tokens", s.handleAccessTokensGet)
+			r.Post("/me/tokens", s.handleAccessTokenCreate)
+			r.Delete("/me/tokens/{tokenID}", s.handleAccessTokenDelete)
 			r.Route("/{userID}", func(r chi.Router) {
 				r.Use(s.userCtx)
 				r.Get("/", s.handleUserGet)
 				r.Put("/", s.handleUserUpdate())
+				r.Patch("/", s.handleUserUpdate())
 				r.Delete("/", s.handleUserDelete())
+				r.Post("/deactivate", s.handleUserDeactivate)
+				r.Post("/reactivate", s.handleUserReactivate)
+				r.Post("/anonymize", s.handleUserAnonymize)
+				r.Post("/unlock", s.handleUserUnlock)
+				r.Delete("/mfa", s.handleUserMFAReset)
+				r.With(web.Allow(authorize.ObjectRole, authorize.ActionUpdate)).
+					Put("/roles", s.handleUserRolesAssign)
 			})
 		})
 
 		users.Get("/token", s.handleTokenGet)
+		users.Post("/token/refresh", s.handleTokenRefresh)
+		users.Post("/token/mfa", s.handleTokenMFA)
+		users.Post("/token/mfa/enroll", s.handleTokenMFAEnroll)
+		users.Post("/register", s.handleRegister)
+		users.Get("/verify", s.handleVerify)
+		users.Get("/oidc/login", s.handleSSOLogin)
+		users.Get("/oidc/callback", s.handleSSOCallback)
+		users.Group(func(r chi.Router) {
+			r.Use(s.resetLimiter.LimitByIP)
+
+			r.Post("/password/forgot", s.handlePasswordForgot)
+			r.Post("/password/reset", s.handlePasswordReset)
+		})
 
 		s.Router = users
 	}
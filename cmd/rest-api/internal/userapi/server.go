@@ -1,32 +1,54 @@
 package userapi
 
 import (
+	"context"
 	"github.com/go-chi/chi"
 	"github.com/jmoiron/sqlx"
 	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
 	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/notify"
+	"github.com/remisb/mat/internal/restaurant"
 	"github.com/remisb/mat/internal/user"
 	"os"
+	"time"
 )
 
 // Server struct is a User REST API server
 type Server struct {
 	//Router http.Handler
-	userRepo      *user.Repo
-	Router        *chi.Mux
-	build         string
-	authenticator *auth.Authenticator
+	userRepo       *user.Repo
+	notifyRepo     *notify.Repo
+	restaurantRepo *restaurant.Repo
+	auditor        audit.Recorder
+	Router         *chi.Mux
+	build          string
+	authenticator  *auth.Authenticator
+	registration   Registration
+	resetLimiter   *web.RateLimiter
+	loginLimiter   *web.RateLimiter
 }
 
 // NewServer is a factory function which creates and initializes new user REST API server.
-func NewServer(build string, shutdown chan os.Signal, db *sqlx.DB) *Server {
+func NewServer(build string, shutdown chan os.Signal, db *sqlx.DB, reg Registration) *Server {
 	userRepo := user.NewRepo(db)
 	s := Server{
-		build:         build,
-		authenticator: auth.New(userRepo, web.Auth),
-		userRepo:      userRepo,
+		build:          build,
+		authenticator:  auth.New(userRepo, reg.Backend, auth.NewTokenStore(db), web.Keys, reg.MFARoles),
+		userRepo:       userRepo,
+		notifyRepo:     notify.NewRepo(db),
+		restaurantRepo: restaurant.NewRepo(db),
+		auditor:        audit.NewRepo(db),
+		registration:   reg,
+		resetLimiter:   web.NewRateLimiter(5, 15*time.Minute),
+		loginLimiter:   web.NewRateLimiter(20, 15*time.Minute),
 	}
 
+	if err := s.loadRolePermissions(context.Background()); err != nil {
+		log.Sugar.Errorf("loading role permissions, error: %s", err)
+	}
+	go s.refreshRolePermissions()
 	s.initRoutes()
 	return &s
 }
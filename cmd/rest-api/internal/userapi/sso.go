@@ -0,0 +1,121 @@
+package userapi
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/oidc"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"time"
+)
+
+// ErrSSONotConfigured returned when single sign-on endpoints are used without identity provider configured.
+var ErrSSONotConfigured = errors.New("single sign-on is not configured")
+
+// handleSSOLogin godoc
+// @Summary Single sign-on login
+// @Description redirect to corporate identity provider login
+// @Success 302
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/oidc/login [get]
+func (s *Server) handleSSOLogin(w http.ResponseWriter, r *http.Request) {
+	sso := s.registration.SSO
+	if sso == nil {
+		web.RespondError(w, r, http.StatusNotFound, ErrSSONotConfigured)
+		return
+	}
+
+	authURL, err := sso.AuthURL()
+	if err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleSSOCallback godoc
+// @Summary Single sign-on callback
+// @Description complete identity provider login, user is created on the first login
+// @Produce  json
+// @Param code query string true "authorization code"
+// @Param state query string true "login state"
+// @Success 200 {object} web.TokenResult
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 403 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 409 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/oidc/callback [get]
+func (s *Server) handleSSOCallback(w http.ResponseWriter, r *http.Request) {
+	sso := s.registration.SSO
+	if sso == nil {
+		web.RespondError(w, r, http.StatusNotFound, ErrSSONotConfigured)
+		return
+	}
+
+	q := r.URL.Query()
+	if idpErr := q.Get("error"); idpErr != "" {
+		err := errors.Errorf("identity provider login failed: %s", idpErr)
+		web.RespondError(w, r, http.StatusUnauthorized, err)
+		return
+	}
+
+	code, state := q.Get("code"), q.Get("state")
+	if code == "" || state == "" {
+		web.RespondError(w, r, http.StatusBadRequest, "code and state should be provided")
+		return
+	}
+
+	ctx := r.Context()
+	id, err := sso.Exchange(ctx, state, code)
+	if err != nil {
+		switch errors.Cause(err) {
+		case oidc.ErrInvalidState:
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		case oidc.ErrInvalidIDToken, oidc.ErrEmailNotVerified:
+			web.RespondError(w, r, http.StatusUnauthorized, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	eu := user.ExternalUser{
+		Issuer:  sso.Config().Issuer,
+		Subject: id.Subject,
+		Email:   id.Email,
+		Name:    id.Name,
+		Roles:   sso.Config().Roles(id),
+	}
+	u, err := s.userRepo.Provision(ctx, eu, time.Now())
+	if err != nil {
+		switch err {
+		case user.ErrDeactivated:
+			web.RespondError(w, r, http.StatusForbidden, err)
+		case user.ErrAccountNotLinked:
+			web.RespondError(w, r, http.StatusConflict, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	aut := *s.authenticator
+	var pair auth.TokenPair
+	err = web.RecordAudit(s.auditor, r, func(ctx context.Context) (ne audit.NewEvent, err error) {
+		pair, err = aut.IssueTokenPair(ctx, *u)
+		return audit.NewEvent{ActorID: u.ID, Action: audit.ActionLogin, TargetType: audit.TargetUser, TargetID: u.ID}, err
+	})
+	if err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, tokenResult(pair))
+}
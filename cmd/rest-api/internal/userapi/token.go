@@ -0,0 +1,100 @@
+package userapi
+
+import (
+	"context"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/user"
+	"net/http"
+	"time"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func tokenResult(pair auth.TokenPair) web.TokenResult {
+	return web.TokenResult{
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiresIn:    int(pair.ExpiresIn.Seconds()),
+	}
+}
+
+// handleTokenRefresh godoc
+// @Summary Refresh JWT token
+// @Description exchange refresh token for a new access and refresh tokens, refresh token can be used only once
+// @Accept  json
+// @Produce  json
+// @Success 200 {object} web.TokenResult
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/token/refresh [post]
+func (s *Server) handleTokenRefresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := web.DecodeBody(r, &req); err != nil || req.RefreshToken == "" {
+		web.RespondError(w, r, http.StatusBadRequest, "refresh token should be provided")
+		return
+	}
+
+	aut := *s.authenticator
+	pair, err := aut.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		switch err {
+		case auth.ErrInvalidRefreshToken, auth.ErrMFARequired, user.ErrNotVerified:
+			web.RespondError(w, r, http.StatusUnauthorized, err)
+		case user.ErrDeactivated:
+			web.RespondError(w, r, http.StatusForbidden, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, tokenResult(pair))
+}
+
+// handleLogout godoc
+// @Summary Logout
+// @Description revoke access token and, when passed, the refresh token
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 204
+// @Failure 401 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/logout [post]
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+
+	// refresh token is optional, empty body revokes the access token only
+	var req refreshRequest
+	if r.ContentLength != 0 {
+		if err := web.DecodeBody(r, &req); err != nil {
+			web.RespondError(w, r, http.StatusBadRequest, "failed to read refresh token from request", err)
+			return
+		}
+	}
+
+	expires := time.Now().Add(auth.AccessTokenTTL)
+	if claims.ExpiresAt != 0 {
+		expires = time.Unix(claims.ExpiresAt, 0)
+	}
+
+	aut := *s.authenticator
+	err := web.RecordAudit(s.auditor, r, func(ctx context.Context) (audit.NewEvent, error) {
+		return audit.NewEvent{Action: audit.ActionLogout, TargetType: audit.TargetUser, TargetID: claims.Subject},
+			aut.Logout(ctx, claims.Subject, claims.Id, expires, req.RefreshToken)
+	})
+	if err != nil {
+		web.RespondError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	web.Respond(w, r, http.StatusNoContent, nil)
+}
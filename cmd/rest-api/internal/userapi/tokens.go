@@ -0,0 +1,126 @@
+package userapi
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/db"
+	"net/http"
+)
+
+// handleAccessTokensGet godoc
+// @Summary List personal access tokens
+// @Description get personal access tokens of the authenticated user
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Success 200 {array} auth.AccessToken
+// @Failure 401 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/me/tokens [get]
+func (s *Server) handleAccessTokensGet(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+	userID := claims.Subject
+
+	aut := *s.authenticator
+	tokens, err := aut.AccessTokens(ctx, userID)
+	if err != nil {
+		switch err {
+		case db.ErrInvalidID:
+			web.RespondError(w, r, http.StatusUnauthorized, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	web.Respond(w, r, http.StatusOK, tokens)
+}
+
+// handleAccessTokenCreate godoc
+// @Summary Create personal access token
+// @Description create personal access token with scopes, the token value is returned only once
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param token body auth.NewAccessToken true "Name, scopes and optional expiry of the token"
+// @Success 201 {object} auth.CreatedAccessToken
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/me/tokens [post]
+func (s *Server) handleAccessTokenCreate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+	userID := claims.Subject
+
+	var nat auth.NewAccessToken
+	if err := web.DecodeBody(r, &nat); err != nil {
+		web.RespondError(w, r, http.StatusBadRequest, "failed to read access token from request", err)
+		return
+	}
+	if nat.Name == "" {
+		web.RespondError(w, r, http.StatusBadRequest, "token name should be provided")
+		return
+	}
+
+	aut := *s.authenticator
+	token, err := aut.CreateAccessToken(ctx, userID, nat)
+	if err != nil {
+		switch err {
+		case auth.ErrInvalidScope, auth.ErrInvalidExpiry:
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		case db.ErrInvalidID:
+			web.RespondError(w, r, http.StatusUnauthorized, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	web.Respond(w, r, http.StatusCreated, token)
+}
+
+// handleAccessTokenDelete godoc
+// @Summary Revoke personal access token
+// @Description revoke personal access token of the authenticated user
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Param tokenID path string true "Token ID"
+// @Success 204
+// @Failure 400 {object} web.APIError
+// @Failure 401 {object} web.APIError
+// @Failure 404 {object} web.APIError
+// @Failure 500 {object} web.APIError
+// @Router /users/me/tokens/{tokenID} [delete]
+func (s *Server) handleAccessTokenDelete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, ok := web.RequestClaims(w, r)
+	if !ok {
+		return
+	}
+	userID := claims.Subject
+
+	aut := *s.authenticator
+	if err := aut.RevokeAccessToken(ctx, userID, chi.URLParam(r, "tokenID")); err != nil {
+		switch err {
+		case db.ErrInvalidID:
+			web.RespondError(w, r, http.StatusBadRequest, err)
+		case auth.ErrAccessTokenNotFound:
+			web.RespondError(w, r, http.StatusNotFound, err)
+		default:
+			web.RespondError(w, r, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	web.Respond(w, r, http.StatusNoContent, nil)
+}
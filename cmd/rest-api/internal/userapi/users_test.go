@@ -1,20 +1,37 @@
 package userapi
 
 import (
+	"context"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gavv/httpexpect/v2"
 	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/cmd/rest-api/internal/server"
 	"github.com/remisb/mat/cmd/rest-api/internal/web"
 	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/mail"
+	"github.com/remisb/mat/internal/oidc"
+	"github.com/remisb/mat/internal/oidc/oidctest"
+	"github.com/remisb/mat/internal/org"
+	"github.com/remisb/mat/internal/restaurant"
 	"github.com/remisb/mat/internal/tests"
+	"github.com/remisb/mat/internal/totp"
 	"github.com/remisb/mat/internal/user"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
 	userServer *httptest.Server
 	e          *httpexpect.Expect
+	outbox     *mail.Outbox
+	idp        *oidctest.Provider
+	apiURL     string
 )
 
 var userTest *tests.Test
@@ -23,24 +40,59 @@ func TestSuite(t *testing.T) {
 	userTest = tests.NewTest(t)
 	t.Cleanup(userTest.Cleanup)
 
-	web.InitAuth()
+	web.Keys = userTest.Keys
 	r := chi.NewRouter()
 
-	userServer := NewServer("testing", nil, userTest.Dbx)
+	outbox = mail.NewOutbox("")
+
+	idp = oidctest.Start(t, "mat", "mat-secret")
+	sso, err := oidc.New(context.Background(), oidc.Config{
+		Issuer:       idp.Issuer(),
+		ClientID:     "mat",
+		ClientSecret: "mat-secret",
+		RedirectURL:  "http://localhost/api/v1/users/oidc/callback",
+		GroupRoles:   map[string]string{"mat-admins": auth.RoleAdmin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registration := Registration{
+		Mailer:         outbox,
+		AllowedDomains: []string{"example.com"},
+		VerifyURL:      "http://localhost/api/v1/users/verify",
+		ResetURL:       "http://localhost/reset-password",
+		SSO:            sso,
+	}
+	userServer := NewServer("testing", nil, userTest.Dbx, registration)
 	r.Route("/api/v1/", func(r chi.Router) {
 		r.Mount("/users", userServer.Router)
 	})
+	r.Get("/.well-known/jwks.json", server.JWKSHandler(web.Keys))
 
 	userTest.SetupTestUsers(t)
 
 	testServer := httptest.NewServer(r)
+	apiURL = testServer.URL
 	e = httpexpect.New(t, testServer.URL)
 
 	t.Run("get token", TestToken)
+	t.Run("refresh token and logout", TestTokenRefreshLogout)
+	t.Run("key rotation", TestKeyRotation)
 	t.Run("users get by admin", TestUsersGetByAdmin)
 	t.Run("users get by user", TestUsersGetByUser)
 	t.Run("users get", TestUsersGetByAdmin)
 	t.Run("users", TestUsers)
+	t.Run("register", TestRegister)
+	t.Run("password reset", TestPasswordReset)
+	t.Run("single sign-on", TestSSO)
+	t.Run("auth backend chain", TestAuthBackendChain)
+	t.Run("personal access tokens", TestAccessTokens)
+	t.Run("login lockout", TestLoginLockout)
+	t.Run("malformed token claims", TestMalformedClaims)
+	t.Run("roles", TestRoles)
+	t.Run("current user", TestMe)
+	t.Run("two-factor authentication", TestMFA)
 }
 
 func TestUsersGetByUser(t *testing.T) {
@@ -50,7 +102,19 @@ func TestUsersGetByUser(t *testing.T) {
 		Status(http.StatusForbidden).
 		JSON().Object()
 
-	errObj.Path("$.error.message").Equal("data is available only for admin")
+	errObj.Path("$.error.message").Equal(web.ErrForbidden.Error())
+
+	// users are allowed to read their own data only
+	e.GET("/api/v1/users/{userId}", userTest.User.UserID).
+		WithHeader("Authorization", "Bearer "+userTest.User.Token).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().ValueEqual("email", "user@example.com")
+
+	e.GET("/api/v1/users/{userId}", userTest.Admin.UserID).
+		WithHeader("Authorization", "Bearer "+userTest.User.Token).
+		Expect().
+		Status(http.StatusForbidden)
 }
 
 func TestUsersGetByAdmin(t *testing.T) {
@@ -187,6 +251,29 @@ func TestUsers(t *testing.T) {
 
 	// /api/v1/users/{userID}
 	authAdmin.DELETE("/api/v1/users/{userID}", newUserID).
+		Expect().
+		Status(http.StatusPreconditionRequired)
+
+	etag := authAdmin.GET("/api/v1/users/{userID}", newUserID).
+		Expect().
+		Status(http.StatusOK).
+		Header("ETag").NotEmpty().Raw()
+
+	name := "William Kennedy"
+	updatedEtag := authAdmin.PUT("/api/v1/users/{userID}", newUserID).
+		WithHeader("If-Match", etag).
+		WithJSON(user.UpdateUser{Name: &name}).
+		Expect().
+		Status(http.StatusOK).
+		Header("ETag").NotEqual(etag).Raw()
+
+	authAdmin.DELETE("/api/v1/users/{userID}", newUserID).
+		WithHeader("If-Match", etag).
+		Expect().
+		Status(http.StatusPreconditionFailed)
+
+	authAdmin.DELETE("/api/v1/users/{userID}", newUserID).
+		WithHeader("If-Match", updatedEtag).
 		Expect().
 		Status(http.StatusOK)
 
@@ -194,4 +281,873 @@ func TestUsers(t *testing.T) {
 	authAdmin.GET("/api/v1/users").
 		Expect().
 		JSON().Array().Length().Equal(count.Raw())
+	authAdmin.GET("/api/v1/users").WithQuery("status", "all").
+		Expect().
+		JSON().Array().Length().Equal(count.Raw() + 1)
+	authAdmin.GET("/api/v1/users").WithQuery("status", "inactive").
+		Expect().
+		JSON().Array().Length().Equal(1)
+	authAdmin.GET("/api/v1/users").WithQuery("status", "deleted").
+		Expect().
+		Status(http.StatusBadRequest)
+
+	// deleted user keeps the row, but can not sign in
+	authAdmin.GET("/api/v1/users/{userID}", newUserID).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().ValueEqual("emailVerified", true).ValueEqual("active", false).Value("deletedAt").NotNull()
+	e.GET("/api/v1/users/token").
+		WithBasicAuth("bill@ardanlabs.com", "gophers").
+		Expect().
+		Status(http.StatusForbidden)
+
+	authAdmin.POST("/api/v1/users/{userID}/reactivate", newUserID).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().ValueEqual("emailVerified", true).ValueEqual("active", true).NotContainsKey("deletedAt")
+	authAdmin.POST("/api/v1/users/{userID}/reactivate", newUserID).
+		Expect().
+		Status(http.StatusConflict)
+	authAdmin.POST("/api/v1/users/{userID}/anonymize", newUserID).
+		Expect().
+		Status(http.StatusConflict)
+	e.GET("/api/v1/users/token").
+		WithBasicAuth("bill@ardanlabs.com", "gophers").
+		Expect().
+		Status(http.StatusOK)
+
+	// deactivated user is not deleted and can not sign in until reactivated
+	authAdmin.POST("/api/v1/users/{userID}/deactivate", newUserID).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().ValueEqual("active", false).NotContainsKey("deletedAt")
+	authAdmin.POST("/api/v1/users/{userID}/deactivate", newUserID).
+		Expect().
+		Status(http.StatusConflict)
+	e.GET("/api/v1/users/token").
+		WithBasicAuth("bill@ardanlabs.com", "gophers").
+		Expect().
+		Status(http.StatusForbidden)
+	authAdmin.POST("/api/v1/users/{userID}/reactivate", newUserID).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().ValueEqual("active", true)
+
+	etag = authAdmin.GET("/api/v1/users/{userID}", newUserID).
+		Expect().
+		Status(http.StatusOK).
+		Header("ETag").NotEmpty().Raw()
+	authAdmin.DELETE("/api/v1/users/{userID}", newUserID).
+		WithHeader("If-Match", etag).
+		Expect().
+		Status(http.StatusOK)
+
+	anonymized := authAdmin.POST("/api/v1/users/{userID}/anonymize", newUserID).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	anonymized.ValueEqual("name", "Deleted user")
+	anonymized.Value("email").String().NotEqual("bill@ardanlabs.com")
+	authAdmin.POST("/api/v1/users/{userID}/reactivate", newUserID).
+		Expect().
+		Status(http.StatusConflict)
+	e.GET("/api/v1/users/token").
+		WithBasicAuth("bill@ardanlabs.com", "gophers").
+		Expect().
+		Status(http.StatusUnauthorized)
+
+	// audit log can not be erased, so it keeps no personal fields of the user
+	var leaked int
+	const q = `SELECT COUNT(*) FROM audit_event
+		WHERE target_id = $1 OR before::text LIKE '%' || $1 || '%' OR after::text LIKE '%' || $1 || '%'`
+	if err := userTest.Dbx.Get(&leaked, q, "bill@ardanlabs.com"); err != nil {
+		t.Fatal(err)
+	}
+	if leaked != 0 {
+		t.Errorf("expected no audit events with email of anonymized user, got %d", leaked)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	registration := user.NewRegistration{
+		Name:            "New Employee",
+		Email:           "new.employee@example.com",
+		Password:        "gophers",
+		PasswordConfirm: "gophers",
+	}
+
+	foreign := registration
+	foreign.Email = "new.employee@gmail.com"
+	e.POST("/api/v1/users/register").WithJSON(foreign).
+		Expect().Status(http.StatusForbidden)
+
+	short := registration
+	short.Password, short.PasswordConfirm = "gop", "gop"
+	e.POST("/api/v1/users/register").WithJSON(short).
+		Expect().Status(http.StatusBadRequest)
+
+	registered := e.POST("/api/v1/users/register").WithJSON(registration).
+		Expect().Status(http.StatusCreated).
+		JSON().Object().ValueEqual("emailVerified", false)
+
+	e.POST("/api/v1/users/register").WithJSON(registration).
+		Expect().Status(http.StatusConflict)
+
+	// inactive user can't get a token
+	e.GET("/api/v1/users/token").
+		WithBasicAuth(registration.Email, registration.Password).
+		Expect().Status(http.StatusForbidden)
+
+	// reactivated user keeps not verified email
+	authAdmin := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+userTest.Admin.Token)
+	})
+	registeredID := registered.Value("id").String().Raw()
+	etag := authAdmin.GET("/api/v1/users/{userID}", registeredID).
+		Expect().Status(http.StatusOK).
+		Header("ETag").Raw()
+	authAdmin.DELETE("/api/v1/users/{userID}", registeredID).
+		WithHeader("If-Match", etag).
+		Expect().Status(http.StatusOK)
+	authAdmin.POST("/api/v1/users/{userID}/reactivate", registeredID).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("emailVerified", false).NotContainsKey("deletedAt")
+	e.GET("/api/v1/users/token").
+		WithBasicAuth(registration.Email, registration.Password).
+		Expect().Status(http.StatusForbidden).
+		JSON().Path("$.error.message").Equal(user.ErrNotVerified.Error())
+
+	messages := outbox.Messages(registration.Email)
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 verification mail, got %d", len(messages))
+	}
+
+	token := mailToken(t, messages[0])
+
+	e.GET("/api/v1/users/verify").WithQuery("token", "invalid").
+		Expect().Status(http.StatusBadRequest)
+
+	e.GET("/api/v1/users/verify").WithQuery("token", token).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("emailVerified", true)
+
+	// token can be used only once
+	e.GET("/api/v1/users/verify").WithQuery("token", token).
+		Expect().Status(http.StatusBadRequest)
+
+	e.GET("/api/v1/users/token").
+		WithBasicAuth(registration.Email, registration.Password).
+		Expect().Status(http.StatusOK)
+}
+
+func TestPasswordReset(t *testing.T) {
+	const email = "new.employee@example.com"
+
+	// unknown email gets the same response
+	e.POST("/api/v1/users/password/forgot").
+		WithJSON(map[string]string{"email": "nobody@example.com"}).
+		Expect().Status(http.StatusAccepted)
+
+	sent := len(outbox.Messages(email))
+	e.POST("/api/v1/users/password/forgot").
+		WithJSON(map[string]string{"email": email}).
+		Expect().Status(http.StatusAccepted)
+
+	// reset mail is sent in background
+	messages := waitMessages(t, email, sent+1)
+	token := mailToken(t, messages[len(messages)-1])
+
+	if messages := outbox.Messages("nobody@example.com"); len(messages) != 0 {
+		t.Fatalf("expected no mail for unknown email, got %d", len(messages))
+	}
+
+	reset := user.PasswordReset{
+		Token:           token,
+		Password:        "new-gophers",
+		PasswordConfirm: "other-gophers",
+	}
+	e.POST("/api/v1/users/password/reset").WithJSON(reset).
+		Expect().Status(http.StatusBadRequest)
+
+	reset.PasswordConfirm = reset.Password
+	e.POST("/api/v1/users/password/reset").WithJSON(reset).
+		Expect().Status(http.StatusNoContent)
+
+	// token is single-use
+	e.POST("/api/v1/users/password/reset").WithJSON(reset).
+		Expect().Status(http.StatusBadRequest)
+
+	e.GET("/api/v1/users/token").
+		WithBasicAuth(email, "gophers").
+		Expect().Status(http.StatusUnauthorized)
+
+	e.GET("/api/v1/users/token").
+		WithBasicAuth(email, reset.Password).
+		Expect().Status(http.StatusOK)
+}
+
+// waitMessages waits until at least count messages are sent to the address.
+func waitMessages(t *testing.T, to string, count int) []mail.Message {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		messages := outbox.Messages(to)
+		if len(messages) >= count {
+			return messages
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d mails to %s, got %d", count, to, len(messages))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// mailToken extracts token from the link in the mail body.
+func mailToken(t *testing.T, msg mail.Message) string {
+	t.Helper()
+
+	body := msg.Body
+	link := strings.Fields(body[strings.Index(body, "http"):])[0]
+	linkURL, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parsing link %q: %v", link, err)
+	}
+	return linkURL.Query().Get("token")
+}
+
+func TestTokenRefreshLogout(t *testing.T) {
+	tokenObject := e.GET("/api/v1/users/token").
+		WithBasicAuth("user1@example.com", "gophers").
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+
+	refreshToken := tokenObject.Value("refreshToken").String().NotEmpty().Raw()
+
+	e.POST("/api/v1/users/token/refresh").
+		WithJSON(map[string]string{"refreshToken": "unknown"}).
+		Expect().Status(http.StatusUnauthorized)
+
+	refreshed := e.POST("/api/v1/users/token/refresh").
+		WithJSON(map[string]string{"refreshToken": refreshToken}).
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+
+	accessToken := refreshed.Value("token").String().NotEmpty().Raw()
+	rotatedToken := refreshed.Value("refreshToken").String().NotEqual(refreshToken).Raw()
+
+	// reuse of rotated refresh token revokes the whole family
+	e.POST("/api/v1/users/token/refresh").
+		WithJSON(map[string]string{"refreshToken": refreshToken}).
+		Expect().Status(http.StatusUnauthorized)
+	e.POST("/api/v1/users/token/refresh").
+		WithJSON(map[string]string{"refreshToken": rotatedToken}).
+		Expect().Status(http.StatusUnauthorized)
+
+	tokenObject = e.GET("/api/v1/users/token").
+		WithBasicAuth("user1@example.com", "gophers").
+		Expect().
+		Status(http.StatusOK).JSON().Object()
+	refreshToken = tokenObject.Value("refreshToken").String().Raw()
+
+	auth := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+accessToken)
+	})
+
+	auth.GET("/api/v1/users/notifications").
+		Expect().Status(http.StatusOK)
+
+	auth.POST("/api/v1/users/logout").
+		WithJSON(map[string]string{"refreshToken": refreshToken}).
+		Expect().Status(http.StatusNoContent)
+
+	auth.GET("/api/v1/users/notifications").
+		Expect().Status(http.StatusUnauthorized).
+		JSON().Object().
+		Path("$.error.message").Equal("token is revoked")
+
+	e.POST("/api/v1/users/token/refresh").
+		WithJSON(map[string]string{"refreshToken": refreshToken}).
+		Expect().Status(http.StatusUnauthorized)
+}
+
+func TestKeyRotation(t *testing.T) {
+	previous, err := auth.GenerateKeyStore("previous")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := auth.NewClaims(userTest.Admin.UserID, "Admin Gopher", "admin@example.com",
+		[]string{auth.RoleAdmin}, time.Now(), time.Hour)
+	token, err := previous.Encode(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// token signed by unknown key is rejected
+	e.GET("/api/v1/users").
+		WithHeader("Authorization", "Bearer "+token).
+		Expect().Status(http.StatusUnauthorized)
+
+	previousKey, _ := previous.PublicKey("previous")
+	web.Keys.AddPublicKey("previous", previousKey)
+
+	// token signed by previous key is accepted during rotation
+	e.GET("/api/v1/users").
+		WithHeader("Authorization", "Bearer "+token).
+		Expect().Status(http.StatusOK)
+
+	keys := e.GET("/.well-known/jwks.json").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("keys").Array()
+	keys.Length().Equal(2)
+	keys.Element(0).Object().ValueEqual("kid", "previous").ValueEqual("alg", "RS256")
+	keys.Element(1).Object().ValueEqual("kid", "test").ValueEqual("kty", "RSA")
+}
+
+func TestSSO(t *testing.T) {
+	idp.Login(oidc.Identity{
+		Subject: "sso-user-1",
+		Email:   "sso.user@example.com",
+		Name:    "SSO User",
+		Groups:  []string{"mat-admins", "engineering"},
+	})
+
+	noRedirect := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	redirect := func(url string) string {
+		t.Helper()
+		resp, err := noRedirect.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("expected redirect from %s, got status %d", url, resp.StatusCode)
+		}
+		return resp.Header.Get("Location")
+	}
+
+	authURL := redirect(apiURL + "/api/v1/users/oidc/login")
+	if q, _ := url.Parse(authURL); q.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("expected PKCE challenge in %s", authURL)
+	}
+
+	callback, err := url.Parse(redirect(authURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := e.GET(callback.Path).WithQueryString(callback.RawQuery).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("token").String().NotEmpty().Raw()
+
+	// login state is single use
+	e.GET(callback.Path).WithQueryString(callback.RawQuery).
+		Expect().Status(http.StatusBadRequest)
+
+	// user is created on the first login with roles mapped from groups
+	users := e.GET("/api/v1/users").
+		WithHeader("Authorization", "Bearer "+token).
+		Expect().Status(http.StatusOK).
+		JSON().Array()
+
+	created := false
+	for _, u := range users.Iter() {
+		if u.Object().Value("email").String().Raw() == "sso.user@example.com" {
+			u.Object().ValueEqual("name", "SSO User")
+			u.Object().Value("roles").Array().ContainsOnly(auth.RoleUser, auth.RoleAdmin)
+			created = true
+		}
+	}
+	if !created {
+		t.Fatal("single sign-on user was not created")
+	}
+
+	// local account is not taken over by provider asserting its email
+	idp.Login(oidc.Identity{
+		Subject: "sso-user-2",
+		Email:   "admin@example.com",
+		Name:    "Not Admin",
+		Groups:  []string{"mat-admins"},
+	})
+	callback, err = url.Parse(redirect(redirect(apiURL + "/api/v1/users/oidc/login")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.GET(callback.Path).WithQueryString(callback.RawQuery).
+		Expect().Status(http.StatusConflict)
+}
+
+// directory is a fake directory backend syncing its users into local users table.
+type directory struct {
+	repo      *user.Repo
+	reachable bool
+}
+
+func (d directory) Authenticate(ctx context.Context, email, password string) (user.User, error) {
+	if !d.reachable {
+		return user.User{}, errors.New("connecting to ldap: connection refused")
+	}
+	if email != "dir.user@example.com" || password != "directory-secret" {
+		return user.User{}, db.ErrAuthenticationFailure
+	}
+
+	eu := user.ExternalUser{Issuer: "ldap://directory", Subject: "uid=dir.user", Email: email,
+		Name: "Directory User", Roles: []string{auth.RoleUser}}
+	u, err := d.repo.Provision(ctx, eu, time.Now())
+	if err != nil {
+		return user.User{}, err
+	}
+	return *u, nil
+}
+
+func TestAuthBackendChain(t *testing.T) {
+	ctx := context.Background()
+	repo := user.NewRepo(userTest.Dbx)
+
+	for _, reachable := range []bool{true, false} {
+		backend := auth.Chain(directory{repo, reachable}, auth.RequireRole(repo, auth.RoleAdmin))
+
+		u, err := backend.Authenticate(ctx, "dir.user@example.com", "directory-secret")
+		if reachable && (err != nil || u.Name != "Directory User") {
+			t.Fatalf("directory user should be authenticated and synced, user %+v, error: %v", u, err)
+		}
+		if !reachable && err != db.ErrAuthenticationFailure {
+			t.Fatalf("directory user should not be authenticated when directory is down, error: %v", err)
+		}
+
+		// break-glass local admin is accepted even when directory is down
+		if _, err := backend.Authenticate(ctx, "admin@example.com", "gophers"); err != nil {
+			t.Fatalf("local admin should be authenticated, error: %v", err)
+		}
+
+		// local fallback is limited to admins
+		if _, err := backend.Authenticate(ctx, "user@example.com", "gophers"); err != db.ErrAuthenticationFailure {
+			t.Fatalf("local user should not be authenticated, error: %v", err)
+		}
+	}
+}
+
+func TestAccessTokens(t *testing.T) {
+	auth := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+userTest.User1.Token)
+	})
+
+	auth.POST("/api/v1/users/me/tokens").
+		WithJSON(map[string]interface{}{"name": "bot", "scopes": []string{"admin"}}).
+		Expect().Status(http.StatusBadRequest)
+
+	created := auth.POST("/api/v1/users/me/tokens").
+		WithJSON(map[string]interface{}{"name": "bot", "scopes": []string{"votes:write"}}).
+		Expect().Status(http.StatusCreated).
+		JSON().Object()
+
+	tokenID := created.Value("id").String().NotEmpty().Raw()
+	token := created.Value("token").String().NotEmpty().Raw()
+
+	tokens := auth.GET("/api/v1/users/me/tokens").
+		Expect().Status(http.StatusOK).
+		JSON().Array()
+	tokens.Length().Equal(1)
+	tokens.First().Object().NotContainsKey("token").
+		ValueEqual("id", tokenID).
+		ValueEqual("scopes", []string{"votes:write"})
+
+	pat := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+token)
+	})
+
+	// personal access tokens can't manage users or other tokens
+	pat.GET("/api/v1/users/me/tokens").
+		Expect().Status(http.StatusForbidden)
+
+	auth.GET("/api/v1/users/me/tokens").
+		Expect().Status(http.StatusOK).
+		JSON().Array().First().Object().ContainsKey("dateLastUsed")
+
+	auth.DELETE("/api/v1/users/me/tokens/" + tokenID).
+		Expect().Status(http.StatusNoContent)
+	auth.DELETE("/api/v1/users/me/tokens/" + tokenID).
+		Expect().Status(http.StatusNotFound)
+
+	pat.GET("/api/v1/users/me/tokens").
+		Expect().Status(http.StatusUnauthorized)
+}
+
+func TestLoginLockout(t *testing.T) {
+	for i := 0; i < user.LockoutThreshold; i++ {
+		e.GET("/api/v1/users/token").
+			WithBasicAuth("user2@example.com", "wrong").
+			Expect().Status(http.StatusUnauthorized)
+	}
+
+	// locked account rejects even the correct password
+	e.GET("/api/v1/users/token").
+		WithBasicAuth("user2@example.com", "gophers").
+		Expect().Status(http.StatusLocked).
+		JSON().Object().
+		Path("$.error.message").Equal(user.ErrAccountLocked.Error())
+
+	e.POST("/api/v1/users/{userID}/unlock", userTest.User2.UserID).
+		WithHeader("Authorization", "Bearer "+userTest.User1.Token).
+		Expect().Status(http.StatusForbidden)
+
+	e.POST("/api/v1/users/{userID}/unlock", userTest.User2.UserID).
+		WithHeader("Authorization", "Bearer "+userTest.Admin.Token).
+		Expect().Status(http.StatusNoContent)
+
+	e.GET("/api/v1/users/token").
+		WithBasicAuth("user2@example.com", "gophers").
+		Expect().Status(http.StatusOK)
+
+	// unknown email is locked the same way, lock does not reveal registered emails
+	for i := 0; i < user.LockoutThreshold; i++ {
+		e.GET("/api/v1/users/token").
+			WithBasicAuth("nobody@example.com", "wrong").
+			Expect().Status(http.StatusUnauthorized)
+	}
+	e.GET("/api/v1/users/token").
+		WithBasicAuth("nobody@example.com", "wrong").
+		Expect().Status(http.StatusLocked)
+}
+
+func TestMalformedClaims(t *testing.T) {
+	// roles as a string instead of list and no subject used to panic in handlers
+	claims := jwt.MapClaims{
+		"roles": auth.RoleAdmin,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	token, err := web.Keys.Encode(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.GET("/api/v1/users").
+		WithHeader("Authorization", "Bearer "+token).
+		Expect().Status(http.StatusUnauthorized).
+		JSON().Object().
+		Path("$.error.message").String().Contains(auth.ErrInvalidClaims.Error())
+}
+
+func TestRoles(t *testing.T) {
+	authAdmin := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+userTest.Admin.Token)
+	})
+
+	e.GET("/api/v1/users/roles").
+		WithHeader("Authorization", "Bearer "+userTest.User1.Token).
+		Expect().Status(http.StatusForbidden)
+
+	roles := authAdmin.GET("/api/v1/users/roles").
+		Expect().Status(http.StatusOK).
+		JSON().Array()
+	roles.Path("$..name").Array().Contains(auth.RoleAdmin, auth.RoleUser, auth.RoleRestaurantManager)
+
+	newRole := user.NewRole{
+		Name:        "MENU_EDITOR",
+		Description: "edits menus of any restaurant",
+		Permissions: []string{"menu:create", "menu:update"},
+	}
+	authAdmin.POST("/api/v1/users/roles").
+		WithJSON(newRole).
+		Expect().Status(http.StatusCreated).
+		JSON().Object().ValueEqual("permissions", newRole.Permissions)
+
+	authAdmin.POST("/api/v1/users/roles").
+		WithJSON(newRole).
+		Expect().Status(http.StatusConflict)
+
+	authAdmin.POST("/api/v1/users/roles").
+		WithJSON(user.NewRole{Name: "editor", Permissions: []string{"menu"}}).
+		Expect().Status(http.StatusBadRequest)
+
+	etag := authAdmin.GET("/api/v1/users/{userID}", userTest.User2.UserID).
+		Expect().Status(http.StatusOK).
+		Header("ETag").NotEmpty().Raw()
+
+	authAdmin.PUT("/api/v1/users/{userID}/roles", userTest.User2.UserID).
+		WithJSON(map[string][]string{"roles": {auth.RoleUser}}).
+		Expect().Status(http.StatusPreconditionRequired)
+
+	authAdmin.PUT("/api/v1/users/{userID}/roles", userTest.User2.UserID).
+		WithHeader("If-Match", etag).
+		WithJSON(map[string][]string{"roles": {"UNKNOWN"}}).
+		Expect().Status(http.StatusBadRequest).
+		JSON().Object().
+		Path("$.error.message").Equal(user.ErrUnknownRole.Error())
+
+	e.PUT("/api/v1/users/{userID}/roles", userTest.User2.UserID).
+		WithHeader("Authorization", "Bearer "+userTest.User1.Token).
+		WithJSON(map[string][]string{"roles": {auth.RoleAdmin}}).
+		Expect().Status(http.StatusForbidden)
+
+	authAdmin.PUT("/api/v1/users/{userID}/roles", userTest.User2.UserID).
+		WithHeader("If-Match", etag).
+		WithJSON(map[string][]string{"roles": {auth.RoleUser, auth.RoleRestaurantManager}}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().
+		Value("roles").Array().ContainsOnly(auth.RoleUser, auth.RoleRestaurantManager)
+
+	// stale version is rejected
+	authAdmin.PUT("/api/v1/users/{userID}/roles", userTest.User2.UserID).
+		WithHeader("If-Match", etag).
+		WithJSON(map[string][]string{"roles": {auth.RoleUser}}).
+		Expect().Status(http.StatusPreconditionFailed)
+
+	authAdmin.POST("/api/v1/users/").
+		WithJSON(user.NewUser{
+			Name:            "Role Test",
+			Email:           "role.test@example.com",
+			Roles:           []string{"UNKNOWN"},
+			Password:        "gophers",
+			PasswordConfirm: "gophers",
+		}).
+		Expect().Status(http.StatusBadRequest)
+}
+
+func TestMe(t *testing.T) {
+	authUser := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+userTest.User.Token)
+	})
+
+	e.GET("/api/v1/users/me").
+		Expect().Status(http.StatusUnauthorized)
+
+	me := authUser.GET("/api/v1/users/me").
+		Expect().Status(http.StatusOK)
+	me.JSON().Object().ValueEqual("email", "user@example.com")
+	etag := me.Header("ETag").NotEmpty().Raw()
+
+	authUser.PATCH("/api/v1/users/me").
+		WithJSON(map[string]string{"name": "Renamed User"}).
+		Expect().Status(http.StatusPreconditionRequired)
+
+	authUser.PATCH("/api/v1/users/me").
+		WithHeader("If-Match", etag).
+		WithJSON(map[string]interface{}{"name": "Renamed User", "roles": []string{auth.RoleAdmin}}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().
+		ValueEqual("name", "Renamed User").
+		ValueEqual("roles", []string{auth.RoleUser})
+
+	authUser.POST("/api/v1/users/me/password").
+		WithJSON(user.PasswordChange{CurrentPassword: "wrong", Password: "gophers2", PasswordConfirm: "gophers2"}).
+		Expect().Status(http.StatusForbidden)
+
+	refreshToken := e.GET("/api/v1/users/token").
+		WithBasicAuth("user@example.com", "gophers").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("refreshToken").String().NotEmpty().Raw()
+
+	authUser.POST("/api/v1/users/me/password").
+		WithJSON(user.PasswordChange{CurrentPassword: "gophers", Password: "gophers2", PasswordConfirm: "gophers2"}).
+		Expect().Status(http.StatusNoContent)
+
+	// sessions started with the previous password are revoked
+	e.POST("/api/v1/users/token/refresh").
+		WithJSON(refreshRequest{RefreshToken: refreshToken}).
+		Expect().Status(http.StatusUnauthorized)
+
+	e.GET("/api/v1/users/token").
+		WithBasicAuth("user@example.com", "gophers2").
+		Expect().Status(http.StatusOK)
+
+	authUser.POST("/api/v1/users/me/password").
+		WithJSON(user.PasswordChange{CurrentPassword: "gophers2", Password: "gophers", PasswordConfirm: "gophers"}).
+		Expect().Status(http.StatusNoContent)
+
+	// vote for Lokys menu of 2020-03-01
+	date := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	err := restaurant.NewRepo(userTest.Dbx).MenuVote(org.NewContext(context.Background(), org.DefaultID), userTest.User.UserID, "",
+		"5828612a-1f8a-403c-b6d1-6cb66fbf0c66", "4058d981-0df1-45de-807e-b8e90bcb2d80", date, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	votes := authUser.GET("/api/v1/users/me/votes").
+		WithQuery("from", "2020-03-01").
+		WithQuery("to", "2020-03-31").
+		Expect().Status(http.StatusOK).
+		JSON().Array()
+	votes.Length().Equal(1)
+	vote := votes.Element(0).Object()
+	vote.ValueEqual("restaurantName", "Lokys")
+	vote.ValueEqual("menuId", "4058d981-0df1-45de-807e-b8e90bcb2d80")
+	vote.ValueEqual("rating", 4)
+
+	authUser.GET("/api/v1/users/me/votes").
+		WithQuery("from", "2020-03-31").
+		WithQuery("to", "2020-03-01").
+		Expect().Status(http.StatusBadRequest)
+
+	// new email is applied only after it is verified
+	authUser1 := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+userTest.User1.Token)
+	})
+	etag = authUser1.GET("/api/v1/users/me").
+		Expect().Status(http.StatusOK).
+		Header("ETag").NotEmpty().Raw()
+
+	authUser1.PATCH("/api/v1/users/me").
+		WithHeader("If-Match", etag).
+		WithJSON(map[string]string{"email": "user1@gmail.com"}).
+		Expect().Status(http.StatusForbidden)
+
+	authUser1.PATCH("/api/v1/users/me").
+		WithHeader("If-Match", etag).
+		WithJSON(map[string]string{"email": "user1.new@example.com"}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("email", "user1@example.com")
+
+	token := mailToken(t, waitMessages(t, "user1.new@example.com", 1)[0])
+	e.GET("/api/v1/users/verify").WithQuery("token", token).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("email", "user1.new@example.com")
+}
+
+func TestMFA(t *testing.T) {
+	user2 := e.Builder(func(req *httpexpect.Request) {
+		req.WithHeader("Authorization", "Bearer "+userTest.User2.Token)
+	})
+	code := func(secret string, at time.Time) string {
+		c, err := totp.Code(secret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	user2.GET("/api/v1/users/me/mfa").
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("enabled", false)
+	refreshToken := e.GET("/api/v1/users/token").
+		WithBasicAuth("user2@example.com", "gophers").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("refreshToken").String().Raw()
+
+	secret := user2.POST("/api/v1/users/me/mfa/enroll").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("secret").String().Raw()
+
+	// secret is stored encrypted
+	var stored string
+	if err := userTest.Dbx.Get(&stored, `SELECT secret FROM user_mfa WHERE user_id = $1`, userTest.User2.UserID); err != nil {
+		t.Fatal(err)
+	}
+	if stored == secret {
+		t.Fatal("expected encrypted mfa secret")
+	}
+
+	user2.POST("/api/v1/users/me/mfa/enable").WithJSON(MFACode{"000000"}).
+		Expect().Status(http.StatusBadRequest)
+	now := time.Now()
+	recoveryCodes := user2.POST("/api/v1/users/me/mfa/enable").WithJSON(MFACode{code(secret, now)}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("recoveryCodes").Array()
+	recoveryCodes.Length().Equal(10)
+	recoveryCode := recoveryCodes.First().String().Raw()
+
+	user2.POST("/api/v1/users/me/mfa/enroll").
+		Expect().Status(http.StatusConflict)
+
+	// refresh token issued without the second factor is revoked
+	e.POST("/api/v1/users/token/refresh").WithJSON(refreshRequest{refreshToken}).
+		Expect().Status(http.StatusUnauthorized)
+
+	// password alone returns MFA challenge
+	login := func() string {
+		return e.GET("/api/v1/users/token").
+			WithBasicAuth("user2@example.com", "gophers").
+			Expect().Status(http.StatusAccepted).
+			JSON().Object().Value("mfaToken").String().Raw()
+	}
+
+	challenge := login()
+	// code used to enable MFA can't be used again
+	e.POST("/api/v1/users/token/mfa").WithJSON(MFAVerification{challenge, code(secret, now)}).
+		Expect().Status(http.StatusUnauthorized)
+	e.POST("/api/v1/users/token/mfa").WithJSON(MFAVerification{challenge, code(secret, now.Add(totp.Period))}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("token").String().NotEmpty()
+	e.POST("/api/v1/users/token/mfa").WithJSON(MFAVerification{challenge, recoveryCode}).
+		Expect().Status(http.StatusUnauthorized)
+
+	challenge = login()
+	e.POST("/api/v1/users/token/mfa").WithJSON(MFAVerification{challenge, strings.ToUpper(recoveryCode)}).
+		Expect().Status(http.StatusOK)
+	user2.GET("/api/v1/users/me/mfa").
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("enabled", true).ValueEqual("recoveryCodes", 9)
+
+	user2.POST("/api/v1/users/me/mfa/disable").WithJSON(MFACode{recoveryCode}).
+		Expect().Status(http.StatusBadRequest)
+	user2.POST("/api/v1/users/me/mfa/disable").WithJSON(MFACode{recoveryCodes.Last().String().Raw()}).
+		Expect().Status(http.StatusNoContent)
+	e.GET("/api/v1/users/token").
+		WithBasicAuth("user2@example.com", "gophers").
+		Expect().Status(http.StatusOK)
+
+	// admins enroll on sign in when MFA is enforced for their role
+	r := chi.NewRouter()
+	enforced := NewServer("testing", nil, userTest.Dbx, Registration{MFARoles: []string{auth.RoleAdmin}})
+	r.Mount("/api/v1/users", enforced.Router)
+	enforcedServer := httptest.NewServer(r)
+	t.Cleanup(enforcedServer.Close)
+	ee := httpexpect.New(t, enforcedServer.URL)
+
+	admin := ee.GET("/api/v1/users/token").
+		WithBasicAuth("admin@example.com", "gophers").
+		Expect().Status(http.StatusAccepted).
+		JSON().Object()
+	admin.ValueEqual("enrollRequired", true)
+	challenge = admin.Value("mfaToken").String().Raw()
+
+	ee.POST("/api/v1/users/token/mfa").WithJSON(MFAVerification{challenge, "123456"}).
+		Expect().Status(http.StatusForbidden)
+	secret = ee.POST("/api/v1/users/token/mfa/enroll").WithJSON(MFAVerification{MFAToken: challenge}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("secret").String().Raw()
+	adminToken := ee.POST("/api/v1/users/token/mfa").WithJSON(MFAVerification{challenge, code(secret, time.Now())}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().ContainsKey("recoveryCodes").
+		Value("token").String().Raw()
+
+	ee.POST("/api/v1/users/me/mfa/disable").
+		WithHeader("Authorization", "Bearer "+adminToken).
+		WithJSON(MFACode{code(secret, time.Now().Add(totp.Period))}).
+		Expect().Status(http.StatusForbidden)
+
+	// admin resets MFA of the user who lost the device, but not own MFA
+	ee.DELETE("/api/v1/users/{userID}/mfa", userTest.User2.UserID).
+		WithHeader("Authorization", "Bearer "+adminToken).
+		Expect().Status(http.StatusNoContent)
+	ee.DELETE("/api/v1/users/{userID}/mfa", userTest.Admin.UserID).
+		WithHeader("Authorization", "Bearer "+adminToken).
+		Expect().Status(http.StatusForbidden)
+
+	// wrong codes are counted for the account lockout
+	challenge = ee.GET("/api/v1/users/token").
+		WithBasicAuth("admin@example.com", "gophers").
+		Expect().Status(http.StatusAccepted).
+		JSON().Object().Value("mfaToken").String().Raw()
+	for i := 0; i < user.LockoutThreshold; i++ {
+		ee.POST("/api/v1/users/token/mfa").WithJSON(MFAVerification{challenge, "000000"}).
+			Expect().Status(http.StatusUnauthorized)
+	}
+	ee.GET("/api/v1/users/token").
+		WithBasicAuth("admin@example.com", "gophers").
+		Expect().Status(http.StatusLocked)
+
+	userRepo := user.NewRepo(userTest.Dbx)
+	if err := userRepo.ResetLoginFailures(context.Background(), "admin@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := userRepo.ResetMFA(context.Background(), userTest.Admin.UserID); err != nil {
+		t.Fatal(err)
+	}
 }
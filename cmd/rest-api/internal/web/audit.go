@@ -0,0 +1,36 @@
+package web
+
+import (
+	"context"
+	"github.com/go-chi/chi/middleware"
+	"github.com/remisb/mat/internal/audit"
+	"github.com/remisb/mat/internal/auth"
+	"net/http"
+)
+
+// AuditEvent fills client IP, request ID and, when it is not set, actor of the
+// audit event from the request. Actor is taken from authenticated token claims.
+func AuditEvent(r *http.Request, ne audit.NewEvent) audit.NewEvent {
+	if ne.ActorID == "" {
+		if claims, ok := auth.ClaimsFromContext(r.Context()); ok {
+			ne.ActorID = claims.Subject
+		}
+	}
+	ne.IP = ClientIP(r)
+	ne.RequestID = middleware.GetReqID(r.Context())
+	return ne
+}
+
+// RecordAudit runs fn making changes of the request and records audit event
+// returned by fn in one transaction, so changes are not stored without their
+// event. Repositories have to be called by fn with the passed context. Nothing
+// is stored when fn or recording fails, the error is returned.
+func RecordAudit(rec audit.Recorder, r *http.Request, fn func(ctx context.Context) (audit.NewEvent, error)) error {
+	return rec.InTx(r.Context(), func(ctx context.Context) error {
+		ne, err := fn(ctx)
+		if err != nil {
+			return err
+		}
+		return rec.Record(ctx, AuditEvent(r, ne))
+	})
+}
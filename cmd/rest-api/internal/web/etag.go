@@ -0,0 +1,82 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	headerETag        = "ETag"
+	headerIfMatch     = "If-Match"
+	headerIfNoneMatch = "If-None-Match"
+)
+
+var (
+	// ErrPreconditionRequired used when conditional request has no If-Match header.
+	ErrPreconditionRequired = errors.New("missing If-Match header")
+	// ErrInvalidETag used when If-Match header has no valid entity tag.
+	ErrInvalidETag = errors.New("invalid entity tag in If-Match header")
+)
+
+// ETag returns strong entity tag for the passed entity version.
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// SetETag sets ETag response header for the passed entity version.
+func SetETag(w http.ResponseWriter, version int) {
+	w.Header().Set(headerETag, ETag(version))
+}
+
+// IfMatchVersion returns entity version passed by client in the If-Match request header.
+// ErrPreconditionRequired is returned when header is missing.
+func IfMatchVersion(r *http.Request) (int, error) {
+	match := strings.TrimSpace(r.Header.Get(headerIfMatch))
+	if match == "" {
+		return 0, ErrPreconditionRequired
+	}
+
+	if !strings.HasPrefix(match, `"`) || !strings.HasSuffix(match, `"`) || len(match) < 2 {
+		return 0, ErrInvalidETag
+	}
+
+	version, err := strconv.Atoi(match[1 : len(match)-1])
+	if err != nil {
+		return 0, ErrInvalidETag
+	}
+	return version, nil
+}
+
+// NotModified sets ETag response header for the passed entity version and checks
+// request If-None-Match header. When one of passed entity tags matches current one,
+// 304 Not Modified response is sent and true is returned.
+func NotModified(w http.ResponseWriter, r *http.Request, version int) bool {
+	SetETag(w, version)
+
+	noneMatch := r.Header.Get(headerIfNoneMatch)
+	if noneMatch == "" {
+		return false
+	}
+
+	current := ETag(version)
+	for _, tag := range strings.Split(noneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
+// RespondPreconditionError responds with error status matching the passed If-Match
+// header parsing error.
+func RespondPreconditionError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == ErrPreconditionRequired {
+		RespondError(w, r, http.StatusPreconditionRequired, err)
+		return
+	}
+	RespondError(w, r, http.StatusBadRequest, err)
+}
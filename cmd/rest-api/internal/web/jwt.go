@@ -1,24 +1,47 @@
 package web
 
 import (
+	"context"
 	"errors"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/go-chi/jwtauth"
+	"github.com/remisb/mat/internal/auth"
 	"net/http"
+	"strings"
 )
 
 var (
 	// ErrNoTokenFound used when no expected token was found.
 	ErrNoTokenFound = errors.New("no token found")
+	// ErrTokenRevoked used when passed token was revoked by logout.
+	ErrTokenRevoked = errors.New("token is revoked")
+	// ErrInsufficientScope used when personal access token has no scope required by handler.
+	ErrInsufficientScope = errors.New("token scope does not allow this request")
 )
 
-// Auth is global var storing JWTAuth struct.
-var Auth *jwtauth.JWTAuth
+// TokenChecker checks if token with passed jti claim was revoked and
+// resolves personal access tokens into claims of their owners.
+type TokenChecker interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	AuthenticateAccessToken(ctx context.Context, token string) (auth.Claims, error)
+}
+
+// TokenDecoder parses token string and verifies its signature.
+type TokenDecoder interface {
+	Decode(tokenString string) (*jwt.Token, error)
+}
+
+// Keys is global var storing KeyStore used to sign and verify tokens.
+var Keys *auth.KeyStore
 
-// InitAuth creates and initializes new JWTAuth
-func InitAuth() {
-	if Auth == nil {
-		Auth = jwtauth.New("HS256", []byte("secret"), nil)
+// InitAuth loads signing key and additional verification keys into Keys.
+func InitAuth(kid, privateKeyFile string, verifyKeyFiles map[string]string) error {
+	keys, err := auth.LoadKeyStore(kid, privateKeyFile, verifyKeyFiles)
+	if err != nil {
+		return err
 	}
+	Keys = keys
+	return nil
 }
 
 // Verifier http middleware handler will verify a JWT string from a http request.
@@ -37,9 +60,123 @@ func InitAuth() {
 // be the generic `jwtauth.Authenticator` middleware or your own custom handler
 // which checks the request context jwt token and error to prepare a custom
 // http response.
-func Verifier(ja *jwtauth.JWTAuth) func(http.Handler) http.Handler {
+//
+// Verified tokens having jti claim are checked against passed TokenChecker,
+// revoked token is marked with ErrTokenRevoked error on the request context.
+//
+// Personal access tokens, having auth.AccessTokenPrefix, are resolved by TokenChecker
+// into claims of the token owner and set on the request context as a valid token.
+func Verifier(td TokenDecoder, tc TokenChecker) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
-		return jwtauth.Verify(ja, jwtauth.TokenFromHeader)(next)
+		verified := revocation(tc)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tokenString := jwtauth.TokenFromHeader(r); strings.HasPrefix(tokenString, auth.AccessTokenPrefix) {
+				token, err := verifyAccessToken(r.Context(), tc, tokenString)
+				if err != nil && err != auth.ErrInvalidAccessToken {
+					RespondError(w, r, http.StatusInternalServerError, err)
+					return
+				}
+				ctx := jwtauth.NewContext(r.Context(), token, err)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			token, err := verifyRequest(td, r)
+			ctx := jwtauth.NewContext(r.Context(), token, err)
+			verified.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func verifyRequest(td TokenDecoder, r *http.Request) (*jwt.Token, error) {
+	tokenString := jwtauth.TokenFromHeader(r)
+	if tokenString == "" {
+		return nil, jwtauth.ErrNoTokenFound
+	}
+
+	token, err := td.Decode(tokenString)
+	if err != nil {
+		if verr, ok := err.(*jwt.ValidationError); ok {
+			switch {
+			case verr.Errors&jwt.ValidationErrorExpired > 0:
+				return token, jwtauth.ErrExpired
+			case verr.Errors&jwt.ValidationErrorIssuedAt > 0:
+				return token, jwtauth.ErrIATInvalid
+			case verr.Errors&jwt.ValidationErrorNotValidYet > 0:
+				return token, jwtauth.ErrNBFInvalid
+			}
+		}
+		return token, err
+	}
+
+	if token == nil || !token.Valid {
+		return token, jwtauth.ErrUnauthorized
+	}
+	return token, nil
+}
+
+func verifyAccessToken(ctx context.Context, tc TokenChecker, tokenString string) (*jwt.Token, error) {
+	claims, err := tc.AuthenticateAccessToken(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	mapClaims, err := claims.MapClaims()
+	if err != nil {
+		return nil, err
+	}
+	return &jwt.Token{Raw: tokenString, Claims: mapClaims, Valid: true}, nil
+}
+
+// RequireScope http middleware handler limits personal access tokens to handlers
+// allowed by one of passed scopes. Tokens without scopes claim are issued on
+// user login and are not limited. Personal access tokens are rejected when no
+// scopes are passed.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := auth.ClaimsFromContext(r.Context())
+			if len(claims.Scopes) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			for _, ts := range claims.Scopes {
+				for _, scope := range scopes {
+					if ts == scope {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			RespondError(w, r, http.StatusForbidden, ErrInsufficientScope)
+		})
+	}
+}
+
+func revocation(rc TokenChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			token, claims, err := jwtauth.FromContext(ctx)
+			if err != nil || token == nil || !token.Valid {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if jti, _ := claims["jti"].(string); jti != "" {
+				revoked, err := rc.IsRevoked(ctx, jti)
+				if err != nil {
+					RespondError(w, r, http.StatusInternalServerError, err)
+					return
+				}
+				if revoked {
+					ctx = jwtauth.NewContext(ctx, token, ErrTokenRevoked)
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
 	}
 }
 
@@ -47,9 +184,12 @@ func Verifier(ja *jwtauth.JWTAuth) func(http.Handler) http.Handler {
 // Verifier middleware request context values. The Authenticator sends a 401 Unauthorized
 // response for any unverified tokens and passes the good ones through. It's just fine
 // until you decide to write something similar and customize your client response.
+//
+// Claims of the verified token are parsed into auth.Claims and stored in the request
+// context, handlers read them with auth.ClaimsFromContext or RequestClaims.
 func Authenticator(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, _, err := jwtauth.FromContext(r.Context())
+		token, mapClaims, err := jwtauth.FromContext(r.Context())
 
 		if err != nil {
 			if err == jwtauth.ErrNoTokenFound {
@@ -66,7 +206,38 @@ func Authenticator(next http.Handler) http.Handler {
 			return
 		}
 
+		claims, err := auth.ParseClaims(mapClaims)
+		if err != nil {
+			RespondError(w, r, http.StatusUnauthorized, err)
+			return
+		}
+
 		// Token is authenticated, pass it through
-		next.ServeHTTP(w, r)
+		ctx := auth.ContextWithClaims(r.Context(), claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// OptionalAuthenticator is Authenticator passing requests without token through as
+// anonymous. Requests with invalid, expired or revoked token are rejected.
+func OptionalAuthenticator(next http.Handler) http.Handler {
+	authenticated := Authenticator(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, err := jwtauth.FromContext(r.Context()); err == jwtauth.ErrNoTokenFound {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
+// RequestClaims returns claims of the authenticated user sending the request. Error
+// response is sent and false is returned when the request is not authenticated.
+func RequestClaims(w http.ResponseWriter, r *http.Request) (auth.Claims, bool) {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		RespondError(w, r, http.StatusUnauthorized, ErrNoTokenFound)
+		return auth.Claims{}, false
+	}
+	return claims, true
+}
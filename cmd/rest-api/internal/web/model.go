@@ -2,7 +2,9 @@ package web
 
 // TokenResult structure is used to return newly generated token from HTTP REST API endpoint.
 type TokenResult struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ExpiresIn    int    `json:"expiresIn,omitempty"`
 }
 
 // APIError example
@@ -0,0 +1,62 @@
+package web
+
+import (
+	"errors"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/authorize"
+	"net/http"
+)
+
+// ErrForbidden used when action is denied by authorization policy.
+var ErrForbidden = errors.New("action is not allowed")
+
+// Policy is global var storing authorization policy applied by handlers.
+var Policy = authorize.Default()
+
+// InitPolicy replaces Policy with passed rules, default policy is kept when no rules are passed.
+func InitPolicy(rules []string) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	p, err := authorize.Parse(rules)
+	if err != nil {
+		return err
+	}
+	Policy = p
+	return nil
+}
+
+// Subject returns user sending the request taken from authenticated token claims.
+// Anonymous subject is returned for requests without token.
+func Subject(r *http.Request) authorize.Subject {
+	claims, ok := auth.ClaimsFromContext(r.Context())
+	if !ok {
+		return authorize.Subject{}
+	}
+	return authorize.Subject{UserID: claims.Subject, Roles: claims.Roles}
+}
+
+// Authorize checks Policy if user sending the request is allowed to perform action on
+// the resource. Error response is sent and false is returned otherwise.
+func Authorize(w http.ResponseWriter, r *http.Request, res authorize.Resource, action authorize.Action) (authorize.Subject, bool) {
+	sub := Subject(r)
+	if !Policy.Allowed(sub, res, action) {
+		RespondError(w, r, http.StatusForbidden, ErrForbidden)
+		return sub, false
+	}
+	return sub, true
+}
+
+// Allow http middleware handler passes request only when Policy allows action on object
+// without ownership conditions, 403 status is sent otherwise.
+func Allow(object authorize.Object, action authorize.Action) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := Authorize(w, r, authorize.Resource{Object: object}, action); !ok {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
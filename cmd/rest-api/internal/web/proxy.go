@@ -0,0 +1,77 @@
+package web
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies is a factory function creates HTTP Middleware replacing request
+// RemoteAddr with client address from X-Forwarded-For or X-Real-IP header. Headers
+// are used only when request comes from one of proxies listed as IP or CIDR, the
+// rightmost address not belonging to a trusted proxy is the client.
+func TrustedProxies(proxies []string) (func(http.Handler) http.Handler, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing trusted proxy %q", p)
+		}
+		nets = append(nets, n)
+	}
+
+	trusted := func(addr string) bool {
+		ip := net.ParseIP(strings.TrimSpace(addr))
+		if ip == nil {
+			return false
+		}
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if trusted(ClientIP(r)) {
+				if ip := forwardedIP(r, trusted); ip != "" {
+					r.RemoteAddr = ip
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// forwardedIP returns client address set by trusted proxies, empty string is
+// returned when request has no forwarded headers.
+func forwardedIP(r *http.Request, trusted func(string) bool) string {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			return ""
+		}
+		if i == 0 || !trusted(hop) {
+			return hop
+		}
+	}
+
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package web
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrTooManyRequests returned when request rate limit is exceeded.
+var ErrTooManyRequests = errors.New("too many requests, try again later")
+
+// RateLimiter allows limited number of events per key within fixed time window.
+type RateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	windows   map[string]rateWindow
+	lastSweep time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewRateLimiter is a factory function creates RateLimiter allowing limit events per window.
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]rateWindow),
+	}
+}
+
+// Allow registers event for the key and reports if it is within the limit.
+func (l *RateLimiter) Allow(key string) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = rateWindow{start: now}
+	}
+	w.count++
+	l.windows[key] = w
+
+	return w.count <= l.limit
+}
+
+// sweep drops expired windows so map does not grow with every seen key. Map is
+// scanned at most once per window, expired window of the key is reset by Allow.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+
+	for k, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, k)
+		}
+	}
+}
+
+// Exceeded reports if the key is over the limit without registering an event.
+func (l *RateLimiter) Exceeded(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[key]
+	if !ok || time.Since(w.start) >= l.window {
+		return false
+	}
+	return w.count >= l.limit
+}
+
+// LimitByIP is HTTP Middleware responding with 429 status when client IP exceeds the limit.
+func (l *RateLimiter) LimitByIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.Allow("ip:" + ClientIP(r)) {
+			RespondError(w, r, http.StatusTooManyRequests, ErrTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP returns IP address of the client sending the request.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
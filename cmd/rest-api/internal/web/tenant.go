@@ -0,0 +1,46 @@
+package web
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/remisb/mat/internal/auth"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/org"
+	"net/http"
+)
+
+// HeaderOrgID is the request header super admin uses to act in another organization.
+const HeaderOrgID = "X-Org-ID"
+
+// ErrOrgSelect used when organization is selected by user not having super admin role.
+var ErrOrgSelect = errors.New("only super admin can select organization")
+
+// Tenant http middleware handler stores organization of the request in the context,
+// repositories scope their queries to it. Organization is taken from the token
+// claims, anonymous requests and tokens issued without organization claim belong
+// to the default organization. Super admin selects another organization with
+// X-Org-ID header.
+func Tenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orgID := org.DefaultID
+		claims, ok := auth.ClaimsFromContext(r.Context())
+		if ok && claims.OrgID != "" {
+			orgID = claims.OrgID
+		}
+
+		if selected := r.Header.Get(HeaderOrgID); selected != "" {
+			if !ok || !claims.HasRole(auth.RoleSuperAdmin) {
+				RespondError(w, r, http.StatusForbidden, ErrOrgSelect)
+				return
+			}
+			if _, err := uuid.Parse(selected); err != nil {
+				RespondError(w, r, http.StatusBadRequest, db.ErrInvalidID)
+				return
+			}
+			orgID = selected
+		}
+
+		ctx := org.NewContext(r.Context(), orgID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/go-chi/cors"
+	"github.com/pkg/errors"
 	"net/http"
+	"time"
 )
 
 const (
@@ -41,9 +43,9 @@ func (err *Error) Error() string {
 // CorsHandler has default cors settings for HTTP Middleware.
 var CorsHandler = cors.Handler(cors.Options{
 	AllowedOrigins:   []string{"*"},
-	AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-	AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-	ExposedHeaders:   []string{"Link"},
+	AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+	AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match", "If-None-Match", HeaderOrgID},
+	ExposedHeaders:   []string{"Link", "ETag"},
 	AllowCredentials: false,
 	MaxAge:           300, // Maximum value not ignored by any of major browsers
 })
@@ -75,3 +77,17 @@ func DecodeBody(r *http.Request, v interface{}) error {
 	defer r.Body.Close()
 	return json.NewDecoder(r.Body).Decode(v)
 }
+
+// QueryDate parses date query parameter, def is returned when parameter is not provided.
+func QueryDate(r *http.Request, name string, def time.Time) (time.Time, error) {
+	date := r.URL.Query().Get(name)
+	if date == "" {
+		return def, nil
+	}
+
+	parsedDate, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "invalid %s date format", name)
+	}
+	return parsedDate, nil
+}
@@ -2,26 +2,39 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	_ "github.com/remisb/mat/cmd/rest-api/docs"
+	"github.com/remisb/mat/cmd/rest-api/internal/adminapi"
 	"github.com/remisb/mat/cmd/rest-api/internal/conf"
+	"github.com/remisb/mat/cmd/rest-api/internal/officeapi"
 	"github.com/remisb/mat/cmd/rest-api/internal/restaurantapi"
+	"github.com/remisb/mat/cmd/rest-api/internal/scimapi"
 	"github.com/remisb/mat/cmd/rest-api/internal/server"
+	"github.com/remisb/mat/cmd/rest-api/internal/teamapi"
 	"github.com/remisb/mat/cmd/rest-api/internal/userapi"
+	"github.com/remisb/mat/cmd/rest-api/internal/web"
+	"github.com/remisb/mat/internal/auth"
 	"github.com/remisb/mat/internal/db"
 	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/mail"
+	"github.com/remisb/mat/internal/oidc"
+	"github.com/remisb/mat/internal/password"
+	"github.com/remisb/mat/internal/user"
 	"github.com/swaggo/http-swagger"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"io/ioutil"
 	"net/http"
 	_ "net/http/pprof" // Register the pprof handlers
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -53,6 +66,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	if err := initAuth(config.Auth); err != nil {
+		log.Sugar.Errorf("error on loading auth keys, error: %s", err)
+		os.Exit(1)
+	}
+
+	policy, err := password.LoadPolicy(config.Password.MinLength, config.Password.BreachedFile)
+	if err != nil {
+		log.Sugar.Errorf("error on loading password policy, error: %s", err)
+		os.Exit(1)
+	}
+	password.SetPolicy(policy)
+
 	if err := startAPIServerAndWait(*config); err != nil {
 		log.Sugar.Errorf("error on starting api server, error :", err)
 		os.Exit(1)
@@ -90,6 +115,87 @@ func initLogger(config *conf.Config) error {
 	return nil
 }
 
+func initAuth(cfg conf.AuthConfig) error {
+	if cfg.Algorithm != auth.AlgorithmRS256 {
+		return errors.Errorf("unsupported auth algorithm %q, only %s is supported", cfg.Algorithm, auth.AlgorithmRS256)
+	}
+
+	for _, name := range cfg.Backends {
+		switch name {
+		case "local", "local-admin", "ldap":
+		default:
+			return errors.Errorf("unsupported auth backend %q", name)
+		}
+	}
+
+	if err := web.InitPolicy(cfg.Policy); err != nil {
+		return errors.Wrap(err, "loading authorization policy")
+	}
+	if err := initMFAKey(cfg.MFAKeyFile); err != nil {
+		return errors.Wrap(err, "loading mfa key")
+	}
+	return web.InitAuth(cfg.KeyID, cfg.PrivateKeyFile, cfg.VerifyKeyFiles)
+}
+
+// initMFAKey sets key encrypting two-factor authentication secrets from hex encoded key file.
+func initMFAKey(file string) error {
+	if file == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return errors.Wrap(err, "decoding mfa key")
+	}
+	return user.SetMFAKey(key)
+}
+
+// authBackend creates credential backends chain configured by backend names.
+func authBackend(cfg conf.AuthConfig, dbx *sqlx.DB) auth.Backend {
+	userRepo := user.NewRepo(dbx)
+
+	var backends []auth.Backend
+	for _, name := range cfg.Backends {
+		switch name {
+		case "local":
+			backends = append(backends, userRepo)
+		case "local-admin":
+			backends = append(backends, auth.RequireRole(userRepo, auth.RoleAdmin))
+		case "ldap":
+			backends = append(backends, auth.NewLDAP(cfg.LDAP, userRepo))
+		}
+	}
+
+	if len(backends) == 0 {
+		return nil
+	}
+	return auth.Chain(backends...)
+}
+
+// startSSO discovers configured identity provider, single sign-on is disabled
+// when it is not configured or not reachable.
+func startSSO(cfg oidc.Config) *oidc.Client {
+	if cfg.Issuer == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := oidc.New(ctx, cfg)
+	if err != nil {
+		log.Sugar.Errorf("main : single sign-on is disabled, error: %s", err)
+		return nil
+	}
+
+	log.Sugar.Infof("main : single sign-on with %s", cfg.Issuer)
+	return client
+}
+
 func startDatabase(dbConf db.Config) (*sqlx.DB, error) {
 	log.Sugar.Infof("main : Started : Initializing database support")
 
@@ -102,6 +208,11 @@ func startDatabase(dbConf db.Config) (*sqlx.DB, error) {
 }
 
 func startAPIServerAndWait(config conf.Config) error {
+	realIP, err := web.TrustedProxies(config.Server.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
 	dbx, err := startDatabase(config.Db)
 	if err != nil {
 		return err
@@ -123,7 +234,7 @@ func startAPIServerAndWait(config conf.Config) error {
 	// buffered channel so the goroutine can exit if we don't collect this error.
 	serverErrors := make(chan error, 1)
 
-	apiServer := startAPIServer(config, dbx, shutdown, serverErrors)
+	apiServer := startAPIServer(config, dbx, realIP, shutdown, serverErrors)
 	return waitShutdown(config.Server, apiServer, serverErrors, shutdown)
 }
 
@@ -140,13 +251,14 @@ func startDebugService(config conf.Config) {
 }
 
 func startAPIServer(cfg conf.Config, dbx *sqlx.DB,
+	realIP func(http.Handler) http.Handler,
 	shutdownChan chan os.Signal,
 	serverErrors chan error) *http.Server {
 
 	r := chi.NewRouter()
 	// A good base middleware stack
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(realIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
@@ -156,13 +268,31 @@ func startAPIServer(cfg conf.Config, dbx *sqlx.DB,
 	r.Use(middleware.Timeout(60 * time.Second))
 
 	r.Get("/info", server.InfoHandler)
-
-	userServer := userapi.NewServer("development", shutdownChan, dbx)
+	r.Get("/.well-known/jwks.json", server.JWKSHandler(web.Keys))
+
+	registration := userapi.Registration{
+		Mailer:         mail.New(cfg.Mail),
+		AllowedDomains: cfg.Register.AllowedDomains,
+		VerifyURL:      cfg.Register.VerifyURL,
+		ResetURL:       cfg.Register.ResetURL,
+		SSO:            startSSO(cfg.OIDC),
+		Backend:        authBackend(cfg.Auth, dbx),
+		MFARoles:       cfg.Auth.MFARoles,
+	}
+	userServer := userapi.NewServer("development", shutdownChan, dbx, registration)
 	restaurantServer := restaurantapi.NewServer("development", shutdownChan, dbx)
+	adminServer := adminapi.NewServer("development", shutdownChan, dbx)
+	teamServer := teamapi.NewServer("development", shutdownChan, dbx)
+	officeServer := officeapi.NewServer("development", shutdownChan, dbx)
+	scimServer := scimapi.NewServer("development", shutdownChan, dbx)
 	r.Route("/api/v1/", func(r chi.Router) {
 		r.Mount("/users", userServer.Router)
 		r.Mount("/restaurant", restaurantServer.Router)
+		r.Mount("/admin", adminServer.Router)
+		r.Mount("/teams", teamServer.Router)
+		r.Mount("/offices", officeServer.Router)
 	})
+	r.Mount(scimapi.Path, scimServer.Router)
 
 	api := http.Server{
 		Addr:         cfg.Server.Addr(),
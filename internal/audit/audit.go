@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/org"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// PageSize is a count of events returned per page.
+const PageSize = 50
+
+// Recorder interface is used to record audit events. Events recorded with the
+// context of InTx are stored only together with the changes made by fn.
+type Recorder interface {
+	Record(ctx context.Context, ne NewEvent) error
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Repo is an audit event Repository structure. Events are append only, there is
+// no way to modify or remove recorded event.
+type Repo struct {
+	db db.DB
+}
+
+// NewRepo is a factory function used to create new audit event Repository.
+func NewRepo(dbx *sqlx.DB) *Repo {
+	return &Repo{db.Wrap(dbx)}
+}
+
+// Record stores new audit event. Organization of the event is taken from the
+// context, otherwise from the actor or the target user, like on login. Event of
+// unknown organization, like a failed login of unknown email, is not listed.
+// Event is stored in the transaction of the context, if any.
+func (r *Repo) Record(ctx context.Context, ne NewEvent) error {
+	var orgID *string
+	if ne.OrgID == "" {
+		ne.OrgID, _ = org.FromContext(ctx)
+	}
+	if ne.OrgID != "" {
+		if _, err := uuid.Parse(ne.OrgID); err != nil {
+			return db.ErrInvalidID
+		}
+		orgID = &ne.OrgID
+	}
+
+	var actorID *string
+	if ne.ActorID != "" {
+		if _, err := uuid.Parse(ne.ActorID); err != nil {
+			return db.ErrInvalidID
+		}
+		actorID = &ne.ActorID
+	}
+
+	before, err := snapshot(ne.Before)
+	if err != nil {
+		return err
+	}
+	after, err := snapshot(ne.After)
+	if err != nil {
+		return err
+	}
+
+	const q = `INSERT INTO audit_event
+	    (event_id, org_id, actor_id, action, target_type, target_id, ip, request_id, before, after, date_created)
+	    VALUES ($1, COALESCE($2,
+	        (SELECT org_id FROM users WHERE user_id = $3),
+	        (SELECT org_id FROM users WHERE email = $12 AND $5 = 'user')),
+	    $3, $4, $5, COALESCE(NULLIF($6, ''), (SELECT user_id::text FROM users WHERE email = $12 AND $5 = 'user'), ''),
+	    $7, $8, $9, $10, $11)`
+	_, err = r.db.ExecContext(ctx, q, uuid.New().String(), orgID, actorID, ne.Action, ne.TargetType, ne.TargetID,
+		ne.IP, ne.RequestID, before, after, time.Now().UTC(), ne.TargetEmail)
+	if err != nil {
+		return errors.Wrap(err, "inserting audit event")
+	}
+	return nil
+}
+
+// InTx runs fn in a transaction, events recorded with the context passed to fn
+// are committed together with the changes of fn.
+func (r *Repo) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.db.InTx(ctx, fn)
+}
+
+// List retrieves page of events of the context organization matching the filter,
+// the latest first. Pages start from 1.
+func (r *Repo) List(ctx context.Context, f Filter, page int) ([]Event, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if f.ActorID != "" {
+		if _, err := uuid.Parse(f.ActorID); err != nil {
+			return nil, db.ErrInvalidID
+		}
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	add("org_id = $%d", orgID)
+	if f.ActorID != "" {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id = $%d", f.TargetID)
+	}
+	if f.From != nil {
+		add("date_created >= $%d", f.From.UTC())
+	}
+	if f.To != nil {
+		add("date_created < $%d", f.To.UTC())
+	}
+
+	q := `SELECT * FROM audit_event WHERE ` + strings.Join(where, " AND ")
+	q += fmt.Sprintf(` ORDER BY date_created DESC, event_id OFFSET $%d LIMIT $%d`, len(args)+1, len(args)+2)
+	args = append(args, PageSize*(page-1), PageSize)
+
+	events := make([]Event, 0)
+	if err := r.db.SelectContext(ctx, &events, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting audit events")
+	}
+	return events, nil
+}
+
+// snapshot marshals passed value to JSON text, nil is returned for nil value and
+// nil pointer so they are stored as NULL. Redactor is stored redacted.
+func snapshot(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, nil
+	}
+	if red, ok := v.(Redactor); ok {
+		v = red.Redacted()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshalling audit snapshot")
+	}
+	if string(b) == "null" {
+		return nil, nil
+	}
+	return string(b), nil
+}
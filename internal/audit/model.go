@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"github.com/jmoiron/sqlx/types"
+	"time"
+)
+
+// These are the expected values for Event.TargetType.
+const (
+	TargetUser       = "user"
+	TargetRole       = "role"
+	TargetRestaurant = "restaurant"
+	TargetMenu       = "menu"
+	TargetVote       = "vote"
+	TargetMember     = "member"
+	TargetSuggestion = "suggestion"
+	TargetOrg        = "organization"
+	TargetTeam       = "team"
+	TargetOffice     = "office"
+)
+
+// These are the expected values for Event.Action.
+const (
+	ActionLogin          = "login"
+	ActionLoginFailed    = "login_failed"
+	ActionLogout         = "logout"
+	ActionCreate         = "create"
+	ActionUpdate         = "update"
+	ActionDelete         = "delete"
+	ActionRestore        = "restore"
+	ActionPurge          = "purge"
+	ActionUnlock         = "unlock"
+	ActionAssignRoles    = "assign_roles"
+	ActionPasswordChange = "password_change"
+	ActionPasswordReset  = "password_reset"
+	ActionTransfer       = "transfer"
+	ActionApprove        = "approve"
+	ActionReject         = "reject"
+	ActionMFAEnable      = "mfa_enable"
+	ActionMFADisable     = "mfa_disable"
+	ActionAnonymize      = "anonymize"
+	ActionDeactivate     = "deactivate"
+)
+
+// Event is a recorded security relevant action stored in DB. Before and After
+// are JSON snapshots of the target, either of them is empty on create or delete.
+type Event struct {
+	ID          string             `db:"event_id" json:"id"`
+	OrgID       *string            `db:"org_id" json:"orgId"`
+	ActorID     *string            `db:"actor_id" json:"actorId"`
+	Action      string             `db:"action" json:"action"`
+	TargetType  string             `db:"target_type" json:"targetType"`
+	TargetID    string             `db:"target_id" json:"targetId"`
+	IP          string             `db:"ip" json:"ip"`
+	RequestID   string             `db:"request_id" json:"requestId"`
+	Before      types.NullJSONText `db:"before" json:"before"`
+	After       types.NullJSONText `db:"after" json:"after"`
+	DateCreated time.Time          `db:"date_created" json:"dateCreated"`
+}
+
+// NewEvent contains information needed to record an Event. Before and After
+// are marshalled to JSON, nil snapshot is stored as NULL. Event without
+// organization belongs to the organization of the context, if any.
+// TargetEmail identifies target user when its ID is not known, like on failed
+// login, the email is resolved to user ID and is not stored.
+type NewEvent struct {
+	OrgID       string
+	ActorID     string
+	Action      string
+	TargetType  string
+	TargetID    string
+	TargetEmail string
+	IP          string
+	RequestID   string
+	Before      interface{}
+	After       interface{}
+}
+
+// Redactor is implemented by entities holding personal data. Audit log can not
+// be erased, so snapshot of such entity stores the value returned by Redacted.
+type Redactor interface {
+	Redacted() interface{}
+}
+
+// Filter selects recorded events, empty fields match any value.
+type Filter struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+}
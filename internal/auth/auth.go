@@ -2,60 +2,284 @@ package auth
 
 import (
 	"context"
-	"github.com/go-chi/jwtauth"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/log"
 	"github.com/remisb/mat/internal/user"
 	"time"
 )
 
 // Authenticator interface provides Authentication and JWT token generation.
 type Authenticator interface {
-	JWTAuth() *jwtauth.JWTAuth
+	Keys() *KeyStore
 	NewToken(ctx context.Context, email, password string) (string, user.User, error)
+	NewTokenPair(ctx context.Context, email, password string) (TokenPair, user.User, error)
+	IssueTokenPair(ctx context.Context, u user.User) (TokenPair, error)
 	Authenticate(ctx context.Context, email, password string) (Claims, user.User, error)
+	Refresh(ctx context.Context, refreshToken string) (TokenPair, error)
+	Logout(ctx context.Context, userID, jti string, expires time.Time, refreshToken string) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	AuthenticateAccessToken(ctx context.Context, token string) (Claims, error)
+	CreateAccessToken(ctx context.Context, userID string, nat NewAccessToken) (*CreatedAccessToken, error)
+	AccessTokens(ctx context.Context, userID string) ([]AccessToken, error)
+	RevokeAccessToken(ctx context.Context, userID, tokenID string) error
+	MFAEnforced(u user.User) bool
+	NewMFAChallenge(ctx context.Context, u user.User) (MFAChallenge, error)
+	EnrollMFA(ctx context.Context, challenge string) (*user.MFAEnrollment, error)
+	VerifyMFA(ctx context.Context, challenge, code string) (TokenPair, user.User, []string, error)
+}
+
+// TokenPair holds short lived access token and refresh token used to get a new pair.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration
 }
 
 // DefaultAuthenticator is default naive implementation of Authenticator.
 type DefaultAuthenticator struct {
-	tokenAuth *jwtauth.JWTAuth
-	userRepo  *user.Repo
+	keys     *KeyStore
+	userRepo *user.Repo
+	backend  Backend
+	tokens   *TokenStore
+	mfaRoles []string
 }
 
 // NewToken performs user authentication and returns new generated token and user struct.
+// ErrMFARequired is returned when the user has to pass the second factor.
 func (a DefaultAuthenticator) NewToken(ctx context.Context, email, password string) (string, user.User, error) {
 
-	claims, authUser, err := a.Authenticate(ctx, email, password)
+	claims, authUser, err := a.authenticate(ctx, email, password)
 	if err != nil {
 		return "", authUser, err
 	}
-	_, tokenString, _ := a.tokenAuth.Encode(claims)
+	if err := a.checkMFA(ctx, authUser); err != nil {
+		return "", authUser, err
+	}
+	if err := a.userRepo.ResetLoginFailures(ctx, email); err != nil {
+		return "", authUser, err
+	}
+	tokenString, err := a.keys.Encode(claims)
 	return tokenString, authUser, err
 }
 
+// NewTokenPair performs user authentication and returns new access and refresh tokens.
+// ErrMFARequired is returned together with authenticated user when the user has to
+// pass the second factor, tokens are issued by VerifyMFA of NewMFAChallenge then.
+// Failed logins of the account are reset only after the second factor is passed.
+func (a DefaultAuthenticator) NewTokenPair(ctx context.Context, email, password string) (TokenPair, user.User, error) {
+	_, authUser, err := a.authenticate(ctx, email, password)
+	if err != nil {
+		return TokenPair{}, authUser, err
+	}
+	if err := a.checkMFA(ctx, authUser); err != nil {
+		return TokenPair{}, authUser, err
+	}
+	if err := a.userRepo.ResetLoginFailures(ctx, email); err != nil {
+		return TokenPair{}, authUser, err
+	}
+
+	pair, err := a.issueTokenPair(ctx, authUser, false)
+	return pair, authUser, err
+}
+
+// IssueTokenPair returns new access and refresh tokens for already authenticated user
+// who passed the second factor, or signed in with identity provider which is
+// responsible for it.
+func (a DefaultAuthenticator) IssueTokenPair(ctx context.Context, u user.User) (TokenPair, error) {
+	return a.issueTokenPair(ctx, u, true)
+}
+
+// issueTokenPair returns new access and refresh tokens, refresh token family keeps
+// if the second factor was passed.
+func (a DefaultAuthenticator) issueTokenPair(ctx context.Context, u user.User, mfa bool) (TokenPair, error) {
+	now := time.Now()
+	refreshToken, err := a.tokens.CreateRefresh(ctx, u.ID, mfa, now)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	claims := NewClaims(u.ID, u.Name, u.Email, u.Roles, now, AccessTokenTTL)
+	claims.OrgID = u.OrgID
+	tokenString, err := a.keys.Encode(claims)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{tokenString, refreshToken, AccessTokenTTL}, nil
+}
+
 // Authenticate performs user authentication and returns Claims and user struct.
 func (a DefaultAuthenticator) Authenticate(ctx context.Context, email, password string) (Claims, user.User, error) {
+	claims, authenticatedUser, err := a.authenticate(ctx, email, password)
+	if err != nil {
+		return claims, authenticatedUser, err
+	}
+	if err := a.userRepo.ResetLoginFailures(ctx, email); err != nil {
+		return Claims{}, authenticatedUser, err
+	}
+	return claims, authenticatedUser, nil
+}
+
+// authenticate checks password of not locked account, failed login is counted
+// for the lockout. Failed logins are not reset, so they are kept until the second
+// factor is passed.
+func (a DefaultAuthenticator) authenticate(ctx context.Context, email, password string) (Claims, user.User, error) {
+	now := time.Now()
+	lockedUntil, err := a.userRepo.LockedUntil(ctx, email, now)
+	if err != nil {
+		return Claims{}, user.User{}, err
+	}
+	if lockedUntil != nil {
+		log.Sugar.Warnf("login attempt to account %s locked until %s", email, lockedUntil.Format(time.RFC3339))
+		return Claims{}, user.User{}, user.ErrAccountLocked
+	}
+
 	// get user struct
-	authenticatedUser, err := a.userRepo.Authenticate(ctx, email, password)
+	authenticatedUser, err := a.backend.Authenticate(ctx, email, password)
 	if err != nil {
+		if err == db.ErrAuthenticationFailure {
+			lockedUntil, lerr := a.userRepo.RecordLoginFailure(ctx, email, now)
+			if lerr != nil {
+				return Claims{}, authenticatedUser, lerr
+			}
+			if lockedUntil != nil {
+				log.Sugar.Warnf("account %s locked until %s after failed logins", email, lockedUntil.Format(time.RFC3339))
+			}
+		}
 		return Claims{}, authenticatedUser, err
 	}
 
 	// convert user struct into claim
 	claims := NewClaims(authenticatedUser.ID, authenticatedUser.Name, authenticatedUser.Email,
-		authenticatedUser.Roles, time.Now(), time.Hour)
+		authenticatedUser.Roles, now, AccessTokenTTL)
+	claims.OrgID = authenticatedUser.OrgID
 	return claims, authenticatedUser, nil
 }
 
-// JWTAuth returns JWTAuth.
-func (a DefaultAuthenticator) JWTAuth() *jwtauth.JWTAuth {
-	return a.tokenAuth
+// Refresh exchanges refresh token for a new access and refresh tokens. Claims are
+// built from the current user state so role changes are picked up on refresh.
+// Token family started without the second factor is revoked and ErrMFARequired is
+// returned once two-factor authentication is required for the user, as the user
+// enabled it or got MFA enforced role.
+func (a DefaultAuthenticator) Refresh(ctx context.Context, refreshToken string) (TokenPair, error) {
+	now := time.Now()
+	family, newRefreshToken, err := a.tokens.RotateRefresh(ctx, refreshToken, now)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	u, err := a.userRepo.RetrieveByID(ctx, family.UserID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return TokenPair{}, ErrInvalidRefreshToken
+		}
+		return TokenPair{}, err
+	}
+	if !u.Active {
+		return TokenPair{}, user.ErrDeactivated
+	}
+	if !u.EmailVerified {
+		return TokenPair{}, user.ErrNotVerified
+	}
+	if !family.MFA {
+		if err := a.checkMFA(ctx, *u); err != nil {
+			if err == ErrMFARequired {
+				if derr := a.tokens.deleteFamily(ctx, a.tokens.db, family.FamilyID); derr != nil {
+					return TokenPair{}, derr
+				}
+			}
+			return TokenPair{}, err
+		}
+	}
+
+	claims := NewClaims(u.ID, u.Name, u.Email, u.Roles, now, AccessTokenTTL)
+	claims.OrgID = u.OrgID
+	tokenString, err := a.keys.Encode(claims)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{tokenString, newRefreshToken, AccessTokenTTL}, nil
+}
+
+// Logout revokes access token with passed jti and, when passed, the refresh token family.
+func (a DefaultAuthenticator) Logout(ctx context.Context, userID, jti string, expires time.Time, refreshToken string) error {
+	if jti != "" {
+		if err := a.tokens.Revoke(ctx, jti, expires); err != nil {
+			return err
+		}
+	}
+
+	if refreshToken != "" {
+		return a.tokens.RevokeRefresh(ctx, refreshToken, userID)
+	}
+	return nil
+}
+
+// IsRevoked checks if access token with passed jti was revoked by logout.
+func (a DefaultAuthenticator) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	return a.tokens.IsRevoked(ctx, jti)
+}
+
+// AuthenticateAccessToken checks personal access token and returns claims of its owner
+// limited to token scopes. Claims are built from the current user state.
+func (a DefaultAuthenticator) AuthenticateAccessToken(ctx context.Context, token string) (Claims, error) {
+	now := time.Now()
+	at, err := a.tokens.UseAccessToken(ctx, token, now)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	u, err := a.userRepo.RetrieveByID(ctx, at.UserID)
+	if err != nil {
+		if err == db.ErrNotFound {
+			return Claims{}, ErrInvalidAccessToken
+		}
+		return Claims{}, err
+	}
+	if !u.Active || !u.EmailVerified {
+		return Claims{}, ErrInvalidAccessToken
+	}
+
+	claims := NewClaims(u.ID, u.Name, u.Email, u.Roles, now, at.DateExpires.Sub(now))
+	claims.OrgID = u.OrgID
+	claims.Id = at.ID
+	claims.Scopes = at.Scopes
+	return claims, nil
 }
 
-// New is a factory function creates and initializes new Authenticator.
-func New(userRepo *user.Repo, auth *jwtauth.JWTAuth) *Authenticator {
+// CreateAccessToken creates personal access token of the user.
+func (a DefaultAuthenticator) CreateAccessToken(ctx context.Context, userID string, nat NewAccessToken) (*CreatedAccessToken, error) {
+	return a.tokens.CreateAccessToken(ctx, userID, nat, time.Now())
+}
+
+// AccessTokens retrieves personal access tokens of the user.
+func (a DefaultAuthenticator) AccessTokens(ctx context.Context, userID string) ([]AccessToken, error) {
+	return a.tokens.AccessTokens(ctx, userID)
+}
+
+// RevokeAccessToken deletes personal access token of the user.
+func (a DefaultAuthenticator) RevokeAccessToken(ctx context.Context, userID, tokenID string) error {
+	return a.tokens.RevokeAccessToken(ctx, userID, tokenID)
+}
+
+// Keys returns KeyStore used to sign and verify tokens.
+func (a DefaultAuthenticator) Keys() *KeyStore {
+	return a.keys
+}
+
+// New is a factory function creates and initializes new Authenticator. Credentials
+// are verified by passed backend, local users are used when backend is nil. Users
+// having one of mfaRoles must use two-factor authentication.
+func New(userRepo *user.Repo, backend Backend, tokens *TokenStore, keys *KeyStore, mfaRoles []string) *Authenticator {
+	if backend == nil {
+		backend = userRepo
+	}
 
 	da := DefaultAuthenticator{
-		tokenAuth: auth,
-		userRepo:  userRepo,
+		keys:     keys,
+		userRepo: userRepo,
+		backend:  backend,
+		tokens:   tokens,
+		mfaRoles: mfaRoles,
 	}
 	var a Authenticator = da
 	return &a
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/user"
+)
+
+// Backend verifies user credentials. user.Repo is the local backend checking password hashes.
+type Backend interface {
+	Authenticate(ctx context.Context, email, password string) (user.User, error)
+}
+
+type chain []Backend
+
+// Chain creates Backend trying passed backends in order until one of them authenticates user.
+// Errors of failed backends are logged, so the next backend is used when directory is not reachable.
+func Chain(backends ...Backend) Backend {
+	return chain(backends)
+}
+
+// Authenticate authenticates user with the first backend accepting credentials.
+func (c chain) Authenticate(ctx context.Context, email, password string) (user.User, error) {
+	err := db.ErrAuthenticationFailure
+	for _, b := range c {
+		u, berr := b.Authenticate(ctx, email, password)
+		if berr == nil {
+			return u, nil
+		}
+
+		switch berr {
+		case db.ErrAuthenticationFailure, user.ErrAccountNotLinked:
+		case user.ErrNotVerified, user.ErrDeactivated:
+			err = berr
+		default:
+			log.Sugar.Errorf("error on authenticating %s, error: %s", email, berr)
+		}
+	}
+	return user.User{}, err
+}
+
+type requireRole struct {
+	backend Backend
+	role    string
+}
+
+// RequireRole creates Backend accepting only users having passed role. It is used to
+// restrict local fallback to break-glass admin accounts.
+func RequireRole(b Backend, role string) Backend {
+	return requireRole{b, role}
+}
+
+// Authenticate authenticates user with wrapped backend and checks user role.
+func (r requireRole) Authenticate(ctx context.Context, email, password string) (user.User, error) {
+	u, err := r.backend.Authenticate(ctx, email, password)
+	if err != nil {
+		return u, err
+	}
+
+	for _, role := range u.Roles {
+		if role == r.role {
+			return u, nil
+		}
+	}
+	return user.User{}, db.ErrAuthenticationFailure
+}
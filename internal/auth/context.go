@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidClaims returned when token claims can not be parsed into Claims.
+var ErrInvalidClaims = errors.New("invalid token claims")
+
+type ctxKey int
+
+// claimsKey is used to store/retrieve Claims value from context.Context.
+const claimsKey ctxKey = 1
+
+// ParseClaims converts claims of the decoded token into Claims. Token without
+// subject is rejected as it can not be used to identify the user.
+func ParseClaims(m jwt.MapClaims) (Claims, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return Claims{}, errors.Wrap(ErrInvalidClaims, err.Error())
+	}
+
+	var c Claims
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Claims{}, errors.Wrap(ErrInvalidClaims, err.Error())
+	}
+	if c.Subject == "" {
+		return Claims{}, ErrInvalidClaims
+	}
+	return c, nil
+}
+
+// ContextWithClaims returns copy of the context storing passed Claims.
+func ContextWithClaims(ctx context.Context, c Claims) context.Context {
+	return context.WithValue(ctx, claimsKey, c)
+}
+
+// ClaimsFromContext returns Claims of the authenticated user stored in the context.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	c, ok := ctx.Value(claimsKey).(Claims)
+	return c, ok
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"math/big"
+	"sort"
+)
+
+// AlgorithmRS256 is the only supported token signing algorithm.
+const AlgorithmRS256 = "RS256"
+
+var (
+	// ErrUnknownKeyID returned when token is signed by unknown key.
+	ErrUnknownKeyID = errors.New("token is signed by unknown key")
+	// ErrUnsupportedAlgorithm returned when token is signed with other than RS256 algorithm.
+	ErrUnsupportedAlgorithm = errors.New("token signing algorithm is not supported")
+)
+
+// KeyStore signs tokens with the active private key and verifies tokens signed by
+// any of the known keys. Keeping previous keys known allows to rotate signing key
+// without invalidating already issued tokens.
+type KeyStore struct {
+	kid        string
+	signKey    *rsa.PrivateKey
+	publicKeys map[string]*rsa.PublicKey
+}
+
+// NewKeyStore is a factory function creates KeyStore signing with passed private key.
+func NewKeyStore(kid string, signKey *rsa.PrivateKey) *KeyStore {
+	return &KeyStore{
+		kid:        kid,
+		signKey:    signKey,
+		publicKeys: map[string]*rsa.PublicKey{kid: &signKey.PublicKey},
+	}
+}
+
+// GenerateKeyStore creates KeyStore with a new random signing key, it is used in tests.
+func GenerateKeyStore(kid string) (*KeyStore, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating private key")
+	}
+	return NewKeyStore(kid, key), nil
+}
+
+// LoadKeyStore creates KeyStore signing with private key read from PEM file. Additional
+// verification keys are passed as key ID to PEM file path map, files can contain either
+// private or public keys.
+func LoadKeyStore(kid, privateKeyFile string, verifyKeyFiles map[string]string) (*KeyStore, error) {
+	data, err := ioutil.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading private key file")
+	}
+
+	signKey, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing private key")
+	}
+
+	ks := NewKeyStore(kid, signKey)
+	for id, file := range verifyKeyFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading key file of key %q", id)
+		}
+
+		key, err := parsePublicKey(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing key %q", id)
+		}
+		ks.AddPublicKey(id, key)
+	}
+	return ks, nil
+}
+
+// AddPublicKey adds key which is accepted for token verification.
+func (ks *KeyStore) AddPublicKey(kid string, key *rsa.PublicKey) {
+	ks.publicKeys[kid] = key
+}
+
+// PublicKey returns verification key with passed key ID.
+func (ks *KeyStore) PublicKey(kid string) (*rsa.PublicKey, bool) {
+	key, ok := ks.publicKeys[kid]
+	return key, ok
+}
+
+// Encode signs claims with the active key and returns token string with kid header.
+func (ks *KeyStore) Encode(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = ks.kid
+
+	tokenString, err := token.SignedString(ks.signKey)
+	if err != nil {
+		return "", errors.Wrap(err, "signing token")
+	}
+	return tokenString, nil
+}
+
+// Decode parses token string and verifies its signature by the key referenced in kid header.
+func (ks *KeyStore) Decode(tokenString string) (*jwt.Token, error) {
+	parser := jwt.Parser{ValidMethods: []string{AlgorithmRS256}}
+	return parser.Parse(tokenString, ks.keyFunc)
+}
+
+func (ks *KeyStore) keyFunc(t *jwt.Token) (interface{}, error) {
+	if t.Method.Alg() != AlgorithmRS256 {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	key, ok := ks.publicKeys[kid]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	return key, nil
+}
+
+// JWK is a JSON Web Key representation of RSA public key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// JWKSet is a JSON Web Key Set.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns all verification keys as JSON Web Key Set.
+func (ks *KeyStore) JWKS() JWKSet {
+	kids := make([]string, 0, len(ks.publicKeys))
+	for kid := range ks.publicKeys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := JWKSet{Keys: make([]JWK, 0, len(kids))}
+	for _, kid := range kids {
+		key := ks.publicKeys[kid]
+		set.Keys = append(set.Keys, JWK{
+			KeyType:   "RSA",
+			Use:       "sig",
+			Algorithm: AlgorithmRS256,
+			KeyID:     kid,
+			Modulus:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	return set
+}
+
+func parsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return &key.PublicKey, nil
+	default:
+		key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unsupported key %s", block.Type)
+		}
+		return key, nil
+	}
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/user"
+	"net"
+	"strings"
+	"time"
+)
+
+// ldapTimeout limits connecting to the directory and every directory request.
+const ldapTimeout = 10 * time.Second
+
+// LDAPConfig struct is used to store LDAP / Active Directory settings. UserFilter
+// is used to find user entry by email, %s is replaced with escaped email.
+type LDAPConfig struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	BindDN             string
+	BindPassword       string
+	BaseDN             string
+	UserFilter         string
+	AdminGroups        []string
+	UserGroups         []string
+}
+
+// LDAP is a Backend authenticating users by binding to the directory with their
+// credentials. Directory name, email and groups are synced into local users table.
+type LDAP struct {
+	cfg      LDAPConfig
+	userRepo *user.Repo
+}
+
+// NewLDAP is a factory function creates LDAP Backend.
+func NewLDAP(cfg LDAPConfig, userRepo *user.Repo) *LDAP {
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(&(objectClass=person)(mail=%s))"
+	}
+	return &LDAP{cfg, userRepo}
+}
+
+// Authenticate finds user entry by email with service account and binds as the user
+// to verify password. Local user is created or updated from the directory entry.
+func (l *LDAP) Authenticate(ctx context.Context, email, password string) (user.User, error) {
+	// empty password would make unauthenticated bind succeed
+	if email == "" || password == "" {
+		return user.User{}, db.ErrAuthenticationFailure
+	}
+
+	conn, err := l.dial(ctx)
+	if err != nil {
+		return user.User{}, err
+	}
+	defer conn.Close()
+
+	// closing connection aborts pending directory request when the request is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := conn.Bind(l.cfg.BindDN, l.cfg.BindPassword); err != nil {
+		return user.User{}, errors.Wrap(err, "binding ldap service account")
+	}
+
+	search := ldap.NewSearchRequest(
+		l.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(l.cfg.UserFilter, ldap.EscapeFilter(email)),
+		[]string{"dn", "mail", "displayName", "cn", "memberOf"},
+		nil,
+	)
+	result, err := conn.Search(search)
+	if err != nil {
+		return user.User{}, errors.Wrap(err, "searching ldap user")
+	}
+	if len(result.Entries) != 1 {
+		return user.User{}, db.ErrAuthenticationFailure
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return user.User{}, db.ErrAuthenticationFailure
+		}
+		return user.User{}, errors.Wrap(err, "binding ldap user")
+	}
+
+	roles, ok := l.roles(entry.GetAttributeValues("memberOf"))
+	if !ok {
+		return user.User{}, db.ErrAuthenticationFailure
+	}
+
+	mail := entry.GetAttributeValue("mail")
+	if mail == "" {
+		mail = email
+	}
+	name := entry.GetAttributeValue("displayName")
+	if name == "" {
+		name = entry.GetAttributeValue("cn")
+	}
+
+	eu := user.ExternalUser{Issuer: l.cfg.URL, Subject: entry.DN, Email: mail, Name: name, Roles: roles}
+	u, err := l.userRepo.Provision(ctx, eu, time.Now())
+	if err != nil {
+		return user.User{}, err
+	}
+	return *u, nil
+}
+
+// dial connects to the directory within ldapTimeout or the request deadline
+// whichever comes first.
+func (l *LDAP) dial(ctx context.Context) (*ldap.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "connecting to ldap")
+	}
+	timeout := ldapTimeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: l.cfg.InsecureSkipVerify}
+	conn, err := ldap.DialURL(l.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to ldap")
+	}
+	conn.SetTimeout(timeout)
+
+	if l.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "starting ldap tls")
+		}
+	}
+	return conn, nil
+}
+
+// roles maps directory groups to roles. When user groups are configured only members
+// of user or admin groups are allowed to sign in.
+func (l *LDAP) roles(groups []string) ([]string, bool) {
+	admin := memberOf(groups, l.cfg.AdminGroups)
+	if admin {
+		return []string{RoleAdmin, RoleUser}, true
+	}
+
+	if len(l.cfg.UserGroups) > 0 && !memberOf(groups, l.cfg.UserGroups) {
+		return nil, false
+	}
+	return []string{RoleUser}, true
+}
+
+func memberOf(groups, wanted []string) bool {
+	for _, g := range groups {
+		for _, w := range wanted {
+			if strings.EqualFold(g, w) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/user"
+	"time"
+)
+
+const (
+	// MFAChallengeTTL is a duration MFA challenge token stays valid.
+	MFAChallengeTTL = 5 * time.Minute
+
+	// maxMFAAttempts is a number of wrong codes after which MFA challenge is revoked.
+	maxMFAAttempts = 5
+)
+
+var (
+	// ErrMFARequired returned by password login when the second factor is required to issue tokens.
+	ErrMFARequired = errors.New("two-factor authentication is required")
+	// ErrInvalidMFAChallenge returned when MFA challenge token is unknown, expired or revoked.
+	ErrInvalidMFAChallenge = errors.New("two-factor authentication challenge is invalid or expired")
+	// ErrMFAEnrollRequired returned when user of enrollment challenge verifies code before enrollment.
+	ErrMFAEnrollRequired = errors.New("two-factor authentication enrollment is required")
+)
+
+// MFAChallenge is a short lived token issued after successful password login
+// of the user with two-factor authentication. Enroll is set when the user role
+// requires two-factor authentication, but it is not enabled yet.
+type MFAChallenge struct {
+	Token     string
+	ExpiresIn time.Duration
+	Enroll    bool
+}
+
+type mfaChallenge struct {
+	UserID      string    `db:"user_id"`
+	Enroll      bool      `db:"enroll"`
+	Attempts    int       `db:"attempts"`
+	DateExpires time.Time `db:"date_expires"`
+}
+
+// CreateMFAChallenge creates MFA challenge token of the user.
+func (s *TokenStore) CreateMFAChallenge(ctx context.Context, userID string, enroll bool, now time.Time) (string, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	const q = `INSERT INTO mfa_challenge
+		(token_hash, user_id, enroll, date_expires, date_created)
+		VALUES ($1, $2, $3, $4, $5)`
+	_, err = s.db.ExecContext(ctx, q, hashRefreshToken(token), userID, enroll,
+		now.Add(MFAChallengeTTL).UTC(), now.UTC())
+	if err != nil {
+		return "", errors.Wrap(err, "inserting mfa challenge")
+	}
+	return token, nil
+}
+
+// mfaChallenge gets not expired MFA challenge by its token.
+func (s *TokenStore) mfaChallenge(ctx context.Context, token string, now time.Time) (mfaChallenge, error) {
+	var c mfaChallenge
+	const q = `SELECT user_id, enroll, attempts, date_expires FROM mfa_challenge WHERE token_hash = $1`
+	if err := s.db.GetContext(ctx, &c, q, hashRefreshToken(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return c, ErrInvalidMFAChallenge
+		}
+		return c, errors.Wrap(err, "selecting mfa challenge")
+	}
+
+	if !c.DateExpires.After(now.UTC()) || c.Attempts >= maxMFAAttempts {
+		return c, ErrInvalidMFAChallenge
+	}
+	return c, nil
+}
+
+// useMFAChallenge gets not expired MFA challenge by its token and counts the
+// attempt of code verification in the same statement, so concurrent requests can
+// not verify more than maxMFAAttempts codes. Challenge is revoked once
+// maxMFAAttempts is reached, it is deleted after successful verification.
+func (s *TokenStore) useMFAChallenge(ctx context.Context, token string, now time.Time) (mfaChallenge, error) {
+	var c mfaChallenge
+	const q = `UPDATE mfa_challenge SET attempts = attempts + 1
+		WHERE token_hash = $1 AND attempts < $2 AND date_expires > $3
+		RETURNING user_id, enroll, attempts, date_expires`
+	if err := s.db.GetContext(ctx, &c, q, hashRefreshToken(token), maxMFAAttempts, now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return c, ErrInvalidMFAChallenge
+		}
+		return c, errors.Wrap(err, "counting mfa attempt")
+	}
+	return c, nil
+}
+
+// deleteMFAChallenge deletes MFA challenge, ErrInvalidMFAChallenge is returned
+// when it was already used by concurrent request.
+func (s *TokenStore) deleteMFAChallenge(ctx context.Context, token string) error {
+	const q = `DELETE FROM mfa_challenge WHERE token_hash = $1`
+	res, err := s.db.ExecContext(ctx, q, hashRefreshToken(token))
+	if err != nil {
+		return errors.Wrap(err, "deleting mfa challenge")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "rows affected")
+	}
+	if rows == 0 {
+		return ErrInvalidMFAChallenge
+	}
+	return nil
+}
+
+// MFARequired reports if tokens of the user are issued only after the second
+// factor is verified. It is required when user enabled two-factor authentication
+// or has one of MFA enforced roles.
+func (a DefaultAuthenticator) MFARequired(ctx context.Context, u user.User) (bool, error) {
+	if a.MFAEnforced(u) {
+		return true, nil
+	}
+	return a.userRepo.MFAEnabled(ctx, u.ID)
+}
+
+// NewMFAChallenge creates MFA challenge of the user authenticated by password.
+// Enrollment challenge is created for the user of MFA enforced role who has not
+// enabled two-factor authentication yet.
+func (a DefaultAuthenticator) NewMFAChallenge(ctx context.Context, u user.User) (MFAChallenge, error) {
+	enabled, err := a.userRepo.MFAEnabled(ctx, u.ID)
+	if err != nil {
+		return MFAChallenge{}, err
+	}
+
+	enroll := !enabled && a.MFAEnforced(u)
+	token, err := a.tokens.CreateMFAChallenge(ctx, u.ID, enroll, time.Now())
+	if err != nil {
+		return MFAChallenge{}, err
+	}
+	return MFAChallenge{Token: token, ExpiresIn: MFAChallengeTTL, Enroll: enroll}, nil
+}
+
+// EnrollMFA starts two-factor authentication enrollment of the user owning
+// enrollment challenge.
+func (a DefaultAuthenticator) EnrollMFA(ctx context.Context, challenge string) (*user.MFAEnrollment, error) {
+	now := time.Now()
+	c, err := a.tokens.mfaChallenge(ctx, challenge, now)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Enroll {
+		return nil, user.ErrMFAEnabled
+	}
+	return a.userRepo.EnrollMFA(ctx, c.UserID, now)
+}
+
+// VerifyMFA verifies code of the MFA challenge and issues access and refresh
+// tokens. Code of enrollment challenge enables two-factor authentication, its
+// recovery codes are returned once. Wrong code is counted as failed login of the
+// account together with the user, ErrAccountLocked is returned for locked account.
+func (a DefaultAuthenticator) VerifyMFA(ctx context.Context, challenge, code string) (TokenPair, user.User, []string, error) {
+	now := time.Now()
+	c, err := a.tokens.useMFAChallenge(ctx, challenge, now)
+	if err != nil {
+		return TokenPair{}, user.User{}, nil, err
+	}
+
+	u, err := a.userRepo.RetrieveByID(ctx, c.UserID)
+	if err != nil {
+		return TokenPair{}, user.User{}, nil, err
+	}
+	lockedUntil, err := a.userRepo.LockedUntil(ctx, u.Email, now)
+	if err != nil {
+		return TokenPair{}, *u, nil, err
+	}
+	if lockedUntil != nil {
+		return TokenPair{}, *u, nil, user.ErrAccountLocked
+	}
+
+	var recoveryCodes []string
+	if c.Enroll {
+		recoveryCodes, err = a.userRepo.EnableMFA(ctx, c.UserID, code, now)
+		if err == user.ErrMFANotEnrolled {
+			err = ErrMFAEnrollRequired
+		}
+	} else {
+		err = a.userRepo.VerifyMFA(ctx, c.UserID, code, now)
+	}
+	if err != nil {
+		if err == user.ErrInvalidMFACode {
+			lockedUntil, lerr := a.userRepo.RecordLoginFailure(ctx, u.Email, now)
+			if lerr != nil {
+				return TokenPair{}, *u, nil, lerr
+			}
+			if lockedUntil != nil {
+				log.Sugar.Warnf("account %s locked until %s after failed logins", u.Email, lockedUntil.Format(time.RFC3339))
+			}
+		}
+		return TokenPair{}, *u, nil, err
+	}
+
+	if err := a.tokens.deleteMFAChallenge(ctx, challenge); err != nil {
+		return TokenPair{}, *u, nil, err
+	}
+	if !u.Active {
+		return TokenPair{}, *u, nil, user.ErrDeactivated
+	}
+	if !u.EmailVerified {
+		return TokenPair{}, *u, nil, user.ErrNotVerified
+	}
+	if err := a.userRepo.ResetLoginFailures(ctx, u.Email); err != nil {
+		return TokenPair{}, *u, nil, err
+	}
+
+	pair, err := a.IssueTokenPair(ctx, *u)
+	return pair, *u, recoveryCodes, err
+}
+
+// MFAEnforced reports if two-factor authentication is required by one of the user roles.
+func (a DefaultAuthenticator) MFAEnforced(u user.User) bool {
+	for _, role := range u.Roles {
+		for _, enforced := range a.mfaRoles {
+			if role == enforced {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkMFA returns ErrMFARequired when tokens of the user can not be issued by password only.
+func (a DefaultAuthenticator) checkMFA(ctx context.Context, u user.User) error {
+	required, err := a.MFARequired(ctx, u)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequired
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/db"
+	"strings"
+	"time"
+)
+
+// These are the scopes of personal access tokens.
+const (
+	ScopeMenusRead         = "menus:read"
+	ScopeVotesWrite        = "votes:write"
+	ScopeRestaurantsManage = "restaurants:manage"
+	ScopeSCIM              = "scim"
+)
+
+const (
+	// AccessTokenPrefix marks personal access tokens so they can be told apart from JWTs.
+	AccessTokenPrefix = "mat_pat_"
+
+	defaultAccessTokenTTL = 90 * 24 * time.Hour
+	maxAccessTokenTTL     = 366 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidAccessToken returned when personal access token is unknown, revoked or expired.
+	ErrInvalidAccessToken = errors.New("access token is invalid or expired")
+	// ErrAccessTokenNotFound returned when personal access token is not found.
+	ErrAccessTokenNotFound = errors.New("access token not found")
+	// ErrInvalidScope returned when unknown or no scope is requested.
+	ErrInvalidScope = errors.New("scopes should be one or more of: " +
+		strings.Join([]string{ScopeMenusRead, ScopeVotesWrite, ScopeRestaurantsManage, ScopeSCIM}, ", "))
+	// ErrInvalidExpiry returned when requested token expiry is in the past or too far in the future.
+	ErrInvalidExpiry = errors.New("token expiry should be in the future and within a year")
+)
+
+// AccessToken is a personal access token used by bots and integrations instead of password.
+type AccessToken struct {
+	ID           string         `db:"token_id" json:"id"`
+	UserID       string         `db:"user_id" json:"userId"`
+	Name         string         `db:"name" json:"name"`
+	TokenHash    string         `db:"token_hash" json:"-"`
+	Scopes       pq.StringArray `db:"scopes" json:"scopes"`
+	DateExpires  time.Time      `db:"date_expires" json:"dateExpires"`
+	DateLastUsed *time.Time     `db:"date_last_used" json:"dateLastUsed,omitempty"`
+	DateCreated  time.Time      `db:"date_created" json:"dateCreated"`
+}
+
+// NewAccessToken contains information needed to create personal access token.
+// Token expires in 90 days when ExpiresAt is not set.
+type NewAccessToken struct {
+	Name      string     `json:"name" validate:"required"`
+	Scopes    []string   `json:"scopes" validate:"required"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// CreatedAccessToken is returned once on token creation, only token hash is stored.
+type CreatedAccessToken struct {
+	AccessToken
+	Token string `json:"token"`
+}
+
+// CreateAccessToken creates personal access token of the user.
+func (s *TokenStore) CreateAccessToken(ctx context.Context, userID string, nat NewAccessToken, now time.Time) (*CreatedAccessToken, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, db.ErrInvalidID
+	}
+
+	if len(nat.Scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	for _, scope := range nat.Scopes {
+		switch scope {
+		case ScopeMenusRead, ScopeVotesWrite, ScopeRestaurantsManage, ScopeSCIM:
+		default:
+			return nil, ErrInvalidScope
+		}
+	}
+
+	expires := now.Add(defaultAccessTokenTTL)
+	if nat.ExpiresAt != nil {
+		expires = *nat.ExpiresAt
+		if !expires.After(now) || expires.Sub(now) > maxAccessTokenTTL {
+			return nil, ErrInvalidExpiry
+		}
+	}
+
+	secret, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	token := AccessTokenPrefix + secret
+
+	at := AccessToken{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		Name:        nat.Name,
+		TokenHash:   hashRefreshToken(token),
+		Scopes:      nat.Scopes,
+		DateExpires: expires.UTC(),
+		DateCreated: now.UTC(),
+	}
+
+	const q = `INSERT INTO access_token
+		(token_id, user_id, name, token_hash, scopes, date_expires, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = s.db.ExecContext(ctx, q, at.ID, at.UserID, at.Name, at.TokenHash,
+		at.Scopes, at.DateExpires, at.DateCreated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting access token")
+	}
+
+	return &CreatedAccessToken{at, token}, nil
+}
+
+// AccessTokens retrieves personal access tokens of the user, the latest first.
+func (s *TokenStore) AccessTokens(ctx context.Context, userID string) ([]AccessToken, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, db.ErrInvalidID
+	}
+
+	tokens := make([]AccessToken, 0)
+	const q = `SELECT * FROM access_token WHERE user_id = $1 ORDER BY date_created DESC`
+	if err := s.db.SelectContext(ctx, &tokens, q, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting access tokens")
+	}
+	return tokens, nil
+}
+
+// RevokeAccessToken deletes personal access token of the user.
+func (s *TokenStore) RevokeAccessToken(ctx context.Context, userID, tokenID string) error {
+	if _, err := uuid.Parse(tokenID); err != nil {
+		return db.ErrInvalidID
+	}
+
+	const q = `DELETE FROM access_token WHERE token_id = $1 AND user_id = $2`
+	res, err := s.db.ExecContext(ctx, q, tokenID, userID)
+	if err != nil {
+		return errors.Wrap(err, "deleting access token")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "deleted count")
+	}
+	if count == 0 {
+		return ErrAccessTokenNotFound
+	}
+	return nil
+}
+
+// UseAccessToken finds not expired personal access token and updates its last used time.
+func (s *TokenStore) UseAccessToken(ctx context.Context, token string, now time.Time) (*AccessToken, error) {
+	var at AccessToken
+	const q = `UPDATE access_token SET date_last_used = $2
+		WHERE token_hash = $1 AND date_expires > $2
+		RETURNING *`
+	if err := s.db.GetContext(ctx, &at, q, hashRefreshToken(token), now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidAccessToken
+		}
+		return nil, errors.Wrap(err, "using access token")
+	}
+	return &at, nil
+}
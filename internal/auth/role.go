@@ -1,21 +1,28 @@
 package auth
 
 import (
+	"encoding/json"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/remisb/mat/internal/user"
 	"time"
 )
 
-// These are the expected values for Claims.Roles.
+// These are the builtin values for Claims.Roles, more roles can be defined by admin.
 const (
-	RoleAdmin = "ADMIN"
-	RoleUser  = "USER"
+	RoleSuperAdmin        = user.RoleSuperAdmin
+	RoleAdmin             = user.RoleAdmin
+	RoleUser              = user.RoleUser
+	RoleRestaurantManager = user.RoleRestaurantManager
 )
 
 // Claims represents the authorization claims transmitted via a JWT.
 type Claims struct {
-	Roles []string `json:"roles"`
-	Name  string   `json:"name"`
-	Email string   `json:"email"`
+	Roles  []string `json:"roles"`
+	Name   string   `json:"name"`
+	Email  string   `json:"email"`
+	Scopes []string `json:"scopes,omitempty"`
+	OrgID  string   `json:"org,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -28,6 +35,7 @@ func NewClaims(subject, name, email string, roles []string, now time.Time, expir
 		Name:  name,
 		Email: email,
 		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
 			Subject:   subject,
 			IssuedAt:  now.Unix(),
 			ExpiresAt: now.Add(expires).Unix(),
@@ -48,3 +56,17 @@ func (c Claims) HasRole(roles ...string) bool {
 	}
 	return false
 }
+
+// MapClaims converts Claims into jwt.MapClaims having the same shape as claims of a decoded token.
+func (c Claims) MapClaims() (jwt.MapClaims, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
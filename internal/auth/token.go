@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/db"
+	"sync"
+	"time"
+)
+
+const (
+	// AccessTokenTTL is a duration access token stays valid.
+	AccessTokenTTL = time.Hour
+	// RefreshTokenTTL is a duration refresh token stays valid.
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	// revocationCacheTTL is a duration not revoked jti is cached before DB is checked again.
+	revocationCacheTTL = 30 * time.Second
+)
+
+// ErrInvalidRefreshToken returned when refresh token is unknown, expired or already used.
+var ErrInvalidRefreshToken = errors.New("refresh token is invalid or expired")
+
+// refreshFamily describes token family of the rotated refresh token. MFA is set
+// when the family was started after the second factor was verified.
+type refreshFamily struct {
+	FamilyID string `db:"family_id"`
+	UserID   string `db:"user_id"`
+	MFA      bool   `db:"mfa"`
+}
+
+// TokenStore stores hashed refresh tokens and revoked access tokens in DB.
+type TokenStore struct {
+	db db.DB
+}
+
+// NewTokenStore is a factory function used to create new TokenStore.
+func NewTokenStore(dbx *sqlx.DB) *TokenStore {
+	return &TokenStore{db.Wrap(dbx)}
+}
+
+// CreateRefresh creates refresh token starting new token family for the user,
+// mfa is set when the user passed the second factor.
+func (s *TokenStore) CreateRefresh(ctx context.Context, userID string, mfa bool, now time.Time) (string, error) {
+	token, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	f := refreshFamily{FamilyID: uuid.New().String(), UserID: userID, MFA: mfa}
+	if err := s.insertRefresh(ctx, s.db, token, f, now); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefresh exchanges refresh token for a new one of the same family. Token can be
+// used only once, reuse of already used token revokes whole family as it is likely stolen.
+// New token inherits the family, so its second factor state is kept.
+func (s *TokenStore) RotateRefresh(ctx context.Context, token string, now time.Time) (refreshFamily, string, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return refreshFamily{}, "", errors.Wrap(err, "begin refresh")
+	}
+	defer tx.Rollback()
+
+	var rt struct {
+		refreshFamily
+		DateExpires time.Time    `db:"date_expires"`
+		DateUsed    sql.NullTime `db:"date_used"`
+	}
+	const qs = `SELECT family_id, user_id, mfa, date_expires, date_used
+		FROM refresh_token WHERE token_hash = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &rt, qs, hashRefreshToken(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return refreshFamily{}, "", ErrInvalidRefreshToken
+		}
+		return refreshFamily{}, "", errors.Wrap(err, "selecting refresh token")
+	}
+
+	if rt.DateUsed.Valid {
+		if err := s.deleteFamily(ctx, tx, rt.FamilyID); err != nil {
+			return refreshFamily{}, "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return refreshFamily{}, "", errors.Wrap(err, "commit refresh family revocation")
+		}
+		return refreshFamily{}, "", ErrInvalidRefreshToken
+	}
+
+	if !rt.DateExpires.After(now.UTC()) {
+		return refreshFamily{}, "", ErrInvalidRefreshToken
+	}
+
+	const qu = `UPDATE refresh_token SET date_used = $2 WHERE token_hash = $1`
+	if _, err := tx.ExecContext(ctx, qu, hashRefreshToken(token), now.UTC()); err != nil {
+		return refreshFamily{}, "", errors.Wrap(err, "updating refresh token")
+	}
+
+	newToken, err := newRefreshToken()
+	if err != nil {
+		return refreshFamily{}, "", err
+	}
+	if err := s.insertRefresh(ctx, tx, newToken, rt.refreshFamily, now); err != nil {
+		return refreshFamily{}, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return refreshFamily{}, "", errors.Wrap(err, "commit refresh")
+	}
+	return rt.refreshFamily, newToken, nil
+}
+
+// RevokeRefresh revokes whole family of the passed refresh token owned by the user.
+func (s *TokenStore) RevokeRefresh(ctx context.Context, token, userID string) error {
+	const q = `DELETE FROM refresh_token WHERE family_id IN
+		(SELECT family_id FROM refresh_token WHERE token_hash = $1 AND user_id = $2)`
+	if _, err := s.db.ExecContext(ctx, q, hashRefreshToken(token), userID); err != nil {
+		return errors.Wrap(err, "deleting refresh token family")
+	}
+	return nil
+}
+
+// Revoke marks access token with passed jti as revoked until it expires.
+func (s *TokenStore) Revoke(ctx context.Context, jti string, expires time.Time) error {
+	const q = `INSERT INTO revoked_token (jti, date_expires) VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`
+	if _, err := s.db.ExecContext(ctx, q, jti, expires.UTC()); err != nil {
+		return errors.Wrap(err, "inserting revoked token")
+	}
+
+	revocations.set(jti, true, expires)
+	return nil
+}
+
+// IsRevoked checks if access token with passed jti was revoked.
+func (s *TokenStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	if revoked, ok := revocations.get(jti, time.Now()); ok {
+		return revoked, nil
+	}
+
+	var expires time.Time
+	const q = `SELECT date_expires FROM revoked_token WHERE jti = $1`
+	err := s.db.GetContext(ctx, &expires, q, jti)
+	switch err {
+	case nil:
+		revocations.set(jti, true, expires)
+		return true, nil
+	case sql.ErrNoRows:
+		revocations.set(jti, false, time.Now().Add(revocationCacheTTL))
+		return false, nil
+	default:
+		return false, errors.Wrap(err, "selecting revoked token")
+	}
+}
+
+func (s *TokenStore) insertRefresh(ctx context.Context, ex sqlx.ExecerContext,
+	token string, f refreshFamily, now time.Time) error {
+
+	const q = `INSERT INTO refresh_token
+		(token_hash, family_id, user_id, mfa, date_expires, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := ex.ExecContext(ctx, q, hashRefreshToken(token), f.FamilyID, f.UserID, f.MFA,
+		now.Add(RefreshTokenTTL).UTC(), now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "inserting refresh token")
+	}
+	return nil
+}
+
+func (s *TokenStore) deleteFamily(ctx context.Context, ex sqlx.ExecerContext, familyID string) error {
+	const q = `DELETE FROM refresh_token WHERE family_id = $1`
+	if _, err := ex.ExecContext(ctx, q, familyID); err != nil {
+		return errors.Wrap(err, "deleting refresh token family")
+	}
+	return nil
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating refresh token")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// revocations is in-memory cache of revocation checks shared by all token stores
+// of the process. Cache is process-local, so another replica may still serve a
+// token revoked by logout as not revoked for up to revocationCacheTTL.
+var revocations = revocationCache{entries: make(map[string]revocationEntry)}
+
+type revocationEntry struct {
+	revoked bool
+	expires time.Time
+}
+
+type revocationCache struct {
+	mu        sync.Mutex
+	entries   map[string]revocationEntry
+	lastSweep time.Time
+}
+
+func (c *revocationCache) get(jti string, now time.Time) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[jti]
+	if !ok {
+		return false, false
+	}
+	if !now.Before(e.expires) {
+		delete(c.entries, jti)
+		return false, false
+	}
+	return e.revoked, true
+}
+
+func (c *revocationCache) set(jti string, revoked bool, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweep(time.Now())
+	c.entries[jti] = revocationEntry{revoked, expires}
+}
+
+// sweep drops expired entries so map does not grow with every checked token. Map
+// is scanned at most once per revocationCacheTTL, expired entry is dropped by get.
+func (c *revocationCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < revocationCacheTTL {
+		return
+	}
+	c.lastSweep = now
+
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+}
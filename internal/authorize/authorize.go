@@ -1,78 +1,352 @@
 package authorize
 
-import "github.com/remisb/mat/internal/auth"
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+)
+
+// Any matches any role, object or action in the rule.
+const Any = "*"
+
+// Effect of the rule, deny rules take precedence over allow rules.
+type Effect string
 
+// These are the rule effects.
 const (
-	create = "create"
-	update = "update"
-	delete = "delete"
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
 )
 
-type action string
-type object int
+// Object is a type of resource the action is performed on.
+type Object string
 
+// These are the object types known by the policy.
 const (
-	restaurant object = iota
-	menu
-	vote
+	ObjectRestaurant Object = "restaurant"
+	ObjectMenu       Object = "menu"
+	ObjectVote       Object = "vote"
+	ObjectUser       Object = "user"
+	ObjectMember     Object = "member"
+	ObjectSuggestion Object = "suggestion"
+	ObjectRole       Object = "role"
+	ObjectAudit      Object = "audit"
+	ObjectOrg        Object = "organization"
+	ObjectTeam       Object = "team"
+	ObjectOffice     Object = "office"
 )
 
-type effect int
+// Action performed on the object. Besides CRUD actions objects have their own
+// actions like restaurant transfer or user unlock.
+type Action string
 
+// These are the common actions.
 const (
-	allow effect = iota
-	deny
+	ActionRead   Action = "read"
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
 )
 
-func (e effect) String() string {
-	switch e {
-	case allow:
-		return "allow"
-	case deny:
-		return "deny"
-	}
-	return ""
+// These are the object specific actions.
+const (
+	ActionRestore    Action = "restore"
+	ActionStats      Action = "stats"
+	ActionTransfer   Action = "transfer"
+	ActionApprove    Action = "approve"
+	ActionReject     Action = "reject"
+	ActionUpvote     Action = "upvote"
+	ActionDeactivate Action = "deactivate"
+	ActionReactivate Action = "reactivate"
+	ActionAnonymize  Action = "anonymize"
+	ActionUnlock     Action = "unlock"
+	ActionResetMFA   Action = "reset_mfa"
+)
+
+// objects and actions list values accepted in rules, typo in configured rule
+// would silently grant nothing otherwise.
+var (
+	objects = []Object{ObjectRestaurant, ObjectMenu, ObjectVote, ObjectUser, ObjectMember, ObjectSuggestion,
+		ObjectRole, ObjectAudit, ObjectOrg, ObjectTeam, ObjectOffice}
+	actions = []Action{ActionRead, ActionCreate, ActionUpdate, ActionDelete, ActionRestore, ActionStats,
+		ActionTransfer, ActionApprove, ActionReject, ActionUpvote, ActionDeactivate, ActionReactivate,
+		ActionAnonymize, ActionUnlock, ActionResetMFA}
+)
+
+// These are the rule conditions. Owner condition matches when subject owns the
+// resource, member condition matches when subject has one of listed restaurant
+// member roles, as member=owner|editor.
+const (
+	ConditionOwner  = "owner"
+	ConditionMember = "member="
+)
+
+var (
+	// ErrInvalidRule returned when policy rule can not be parsed.
+	ErrInvalidRule = errors.New("rule should be: allow|deny ROLE object action [owner|member=role|role]")
+	// ErrInvalidPermission returned when role permission can not be parsed.
+	ErrInvalidPermission = errors.New("permission should be: object:action")
+)
+
+// DefaultPolicy is used when no policy is configured. Super admin manages all
+// organizations, admin manages data of own organization only, roles are shared by
+// organizations so admin can only read and assign them.
+var DefaultPolicy = []string{
+	"allow SUPER_ADMIN * *",
+	"allow ADMIN restaurant *",
+	"allow ADMIN menu *",
+	"allow ADMIN vote *",
+	"allow ADMIN user *",
+	"allow ADMIN member *",
+	"allow ADMIN suggestion *",
+	"allow ADMIN audit *",
+	"allow ADMIN team *",
+	"allow ADMIN office *",
+	"allow ADMIN role read",
+	"allow ADMIN role update",
+	"allow * restaurant read",
+	"allow * menu read",
+	"allow * vote read",
+	"allow USER vote create",
+	"allow USER team read",
+	"allow USER office read",
+	"allow USER user read owner",
+	"allow USER suggestion read",
+	"allow USER suggestion create",
+	"allow USER suggestion upvote",
+	"allow USER restaurant update member=owner",
+	"allow USER restaurant delete member=owner",
+	"allow USER restaurant stats member=owner",
+	"allow USER restaurant transfer member=owner",
+	"allow USER menu create member=owner|editor",
+	"allow USER menu update member=owner|editor",
+	"allow USER member read member=owner|editor",
+	"allow USER member create member=owner",
+	"allow USER member delete member=owner",
+	"allow USER member delete owner",
 }
 
 // Authorizer is the interface that performs user's authorization.
 type Authorizer interface {
-	Allowed(userID, role, action string)
+	Allowed(sub Subject, res Resource, action Action) bool
 }
 
-type rule struct {
-	effect effect
-	role   string
-	action string
+// Subject is the user performing an action.
+type Subject struct {
+	UserID string
+	Roles  []string
 }
 
-// AuthorizerNaive is minimalistic and naive implementation to perform role based user's authorization.
-type AuthorizerNaive struct {
-	rules map[object]map[action]rule
+// Resource is the object action is performed on. OwnerID and MemberRole of the
+// subject are used by rule conditions and are empty when not known.
+type Resource struct {
+	Object     Object
+	OwnerID    string
+	MemberRole string
 }
 
-// New is a factory function which create new minimalistic, naive Authorization service.
-func New() *AuthorizerNaive {
-	a := AuthorizerNaive{}
-	a.addRule(allow, auth.RoleAdmin, restaurant, create)
-	a.addRule(allow, auth.RoleAdmin, restaurant, update)
-	a.addRule(allow, auth.RoleAdmin, restaurant, delete)
-	a.addRule(allow, auth.RoleAdmin, menu, create)
-	a.addRule(allow, auth.RoleAdmin, menu, update)
-	a.addRule(allow, auth.RoleAdmin, menu, delete)
-	a.addRule(allow, auth.RoleAdmin, vote, delete)
-	return &a
+// Rule allows or denies action on the object for the role when condition is met.
+type Rule struct {
+	Effect    Effect
+	Role      string
+	Object    Object
+	Action    Action
+	Condition string
 }
 
-func (a *AuthorizerNaive) addRule(effect effect, role string, object object, action string) {
-	//a.rules[object]
-	//rule{effect, role, action}
+// ParseRule parses rule in the form of "effect role object action [condition]".
+func ParseRule(s string) (Rule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 4 && len(fields) != 5 {
+		return Rule{}, errors.Wrapf(ErrInvalidRule, "rule %q", s)
+	}
+
+	r := Rule{
+		Effect: Effect(fields[0]),
+		Role:   fields[1],
+		Object: Object(fields[2]),
+		Action: Action(fields[3]),
+	}
+	if len(fields) == 5 {
+		r.Condition = fields[4]
+	}
+
+	if r.Effect != Allow && r.Effect != Deny {
+		return Rule{}, errors.Wrapf(ErrInvalidRule, "rule %q, unknown effect", s)
+	}
+	if !knownObject(r.Object) {
+		return Rule{}, errors.Wrapf(ErrInvalidRule, "rule %q, unknown object", s)
+	}
+	if !knownAction(r.Action) {
+		return Rule{}, errors.Wrapf(ErrInvalidRule, "rule %q, unknown action", s)
+	}
+	if r.Condition != "" && r.Condition != ConditionOwner &&
+		(!strings.HasPrefix(r.Condition, ConditionMember) || r.Condition == ConditionMember) {
+		return Rule{}, errors.Wrapf(ErrInvalidRule, "rule %q, unknown condition", s)
+	}
+	return r, nil
 }
 
-// Allowed checks does the user has requested role.
-func (a AuthorizerNaive) Allowed(userID, role, action string) bool {
-	if role == auth.RoleAdmin {
+// String returns rule in the form accepted by ParseRule.
+func (r Rule) String() string {
+	s := fmt.Sprintf("%s %s %s %s", r.Effect, r.Role, r.Object, r.Action)
+	if r.Condition != "" {
+		s += " " + r.Condition
+	}
+	return s
+}
+
+func (r Rule) matches(sub Subject, res Resource, action Action) bool {
+	if r.Object != Any && r.Object != res.Object {
+		return false
+	}
+	if r.Action != Any && r.Action != action {
+		return false
+	}
+	if r.Role != Any && !hasRole(sub.Roles, r.Role) {
+		return false
+	}
+
+	switch {
+	case r.Condition == "":
 		return true
+	case r.Condition == ConditionOwner:
+		return sub.UserID != "" && sub.UserID == res.OwnerID
+	default:
+		if res.MemberRole == "" {
+			return false
+		}
+		roles := strings.Split(strings.TrimPrefix(r.Condition, ConditionMember), "|")
+		return hasRole(roles, res.MemberRole)
+	}
+}
+
+// PermissionRule converts role permission in the form of object:action into allow rule.
+func PermissionRule(role, permission string) (Rule, error) {
+	parts := strings.Split(permission, ":")
+	if len(parts) != 2 || !knownObject(Object(parts[0])) || !knownAction(Action(parts[1])) {
+		return Rule{}, errors.Wrapf(ErrInvalidPermission, "role %s permission %q", role, permission)
 	}
+	return Rule{Effect: Allow, Role: role, Object: Object(parts[0]), Action: Action(parts[1])}, nil
+}
 
+func knownObject(o Object) bool {
+	if o == Any {
+		return true
+	}
+	for _, known := range objects {
+		if o == known {
+			return true
+		}
+	}
+	return false
+}
+
+func knownAction(a Action) bool {
+	if a == Any {
+		return true
+	}
+	for _, known := range actions {
+		if a == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is a rule based Authorizer. Action is allowed when at least one allow
+// rule and no deny rule matches, everything else is denied. Besides configured
+// rules policy has rules granted by permissions of custom roles.
+type Policy struct {
+	rules []Rule
+
+	mu        sync.RWMutex
+	roleRules []Rule
+}
+
+// New is a factory function which creates new Policy with passed rules.
+func New(rules ...Rule) *Policy {
+	return &Policy{rules: rules}
+}
+
+// Parse is a factory function which creates new Policy from rules in text form.
+func Parse(rules []string) (*Policy, error) {
+	p := Policy{rules: make([]Rule, 0, len(rules))}
+	for _, s := range rules {
+		r, err := ParseRule(s)
+		if err != nil {
+			return nil, err
+		}
+		p.rules = append(p.rules, r)
+	}
+	return &p, nil
+}
+
+// Default creates Policy with DefaultPolicy rules.
+func Default() *Policy {
+	p, err := Parse(DefaultPolicy)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// Rules returns configured rules and rules granted by role permissions.
+func (p *Policy) Rules() []Rule {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	rules := make([]Rule, 0, len(p.rules)+len(p.roleRules))
+	rules = append(rules, p.rules...)
+	return append(rules, p.roleRules...)
+}
+
+// SetRolePermissions replaces rules granted by role permissions, permissions are
+// mapped by role name.
+func (p *Policy) SetRolePermissions(permissions map[string][]string) error {
+	var rules []Rule
+	for role, perms := range permissions {
+		for _, perm := range perms {
+			r, err := PermissionRule(role, perm)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, r)
+		}
+	}
+
+	p.mu.Lock()
+	p.roleRules = rules
+	p.mu.Unlock()
+	return nil
+}
+
+// Allowed checks if the subject is allowed to perform action on the resource.
+func (p *Policy) Allowed(sub Subject, res Resource, action Action) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	allowed := false
+	for _, rules := range [][]Rule{p.rules, p.roleRules} {
+		for _, r := range rules {
+			if !r.matches(sub, res, action) {
+				continue
+			}
+			if r.Effect == Deny {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+func hasRole(roles []string, want string) bool {
+	for _, role := range roles {
+		if role == want {
+			return true
+		}
+	}
 	return false
 }
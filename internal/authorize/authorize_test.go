@@ -0,0 +1,173 @@
+package authorize
+
+import (
+	"testing"
+)
+
+func TestPolicyAllowed(t *testing.T) {
+	const (
+		userID  = "5cf37266-3473-4006-984f-9325122678b7"
+		otherID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+	)
+
+	superAdmin := Subject{UserID: otherID, Roles: []string{"SUPER_ADMIN"}}
+	admin := Subject{UserID: otherID, Roles: []string{"ADMIN", "USER"}}
+	user := Subject{UserID: userID, Roles: []string{"USER"}}
+	anonymous := Subject{}
+
+	policy := Default()
+
+	tests := []struct {
+		name   string
+		sub    Subject
+		res    Resource
+		action Action
+		want   bool
+	}{
+		{"admin creates restaurant", admin, Resource{Object: ObjectRestaurant}, ActionCreate, true},
+		{"admin restores restaurant", admin, Resource{Object: ObjectRestaurant}, "restore", true},
+		{"admin unlocks user", admin, Resource{Object: ObjectUser, OwnerID: userID}, "unlock", true},
+		{"admin assigns roles", admin, Resource{Object: ObjectRole}, ActionUpdate, true},
+		{"admin creates role", admin, Resource{Object: ObjectRole}, ActionCreate, false},
+		{"admin creates organization", admin, Resource{Object: ObjectOrg}, ActionCreate, false},
+		{"super admin creates role", superAdmin, Resource{Object: ObjectRole}, ActionCreate, true},
+		{"super admin creates organization", superAdmin, Resource{Object: ObjectOrg}, ActionCreate, true},
+		{"user creates restaurant", user, Resource{Object: ObjectRestaurant}, ActionCreate, false},
+		{"user restores restaurant", user, Resource{Object: ObjectRestaurant, MemberRole: "owner"}, "restore", false},
+		{"anonymous reads menu", anonymous, Resource{Object: ObjectMenu}, ActionRead, true},
+		{"anonymous votes", anonymous, Resource{Object: ObjectVote}, ActionCreate, false},
+		{"user votes", user, Resource{Object: ObjectVote}, ActionCreate, true},
+		{"user reads self", user, Resource{Object: ObjectUser, OwnerID: userID}, ActionRead, true},
+		{"user reads other user", user, Resource{Object: ObjectUser, OwnerID: otherID}, ActionRead, false},
+		{"user lists users", user, Resource{Object: ObjectUser}, ActionRead, false},
+		{"user updates self", user, Resource{Object: ObjectUser, OwnerID: userID}, ActionUpdate, false},
+		{"owner updates restaurant", user, Resource{Object: ObjectRestaurant, MemberRole: "owner"}, ActionUpdate, true},
+		{"editor updates restaurant", user, Resource{Object: ObjectRestaurant, MemberRole: "editor"}, ActionUpdate, false},
+		{"non member updates restaurant", user, Resource{Object: ObjectRestaurant}, ActionUpdate, false},
+		{"editor updates menu", user, Resource{Object: ObjectMenu, MemberRole: "editor"}, ActionUpdate, true},
+		{"editor invites member", user, Resource{Object: ObjectMember, MemberRole: "editor"}, ActionCreate, false},
+		{"member leaves restaurant", user, Resource{Object: ObjectMember, OwnerID: userID, MemberRole: "editor"}, ActionDelete, true},
+		{"editor removes other member", user, Resource{Object: ObjectMember, OwnerID: otherID, MemberRole: "editor"}, ActionDelete, false},
+		{"owner removes other member", user, Resource{Object: ObjectMember, OwnerID: otherID, MemberRole: "owner"}, ActionDelete, true},
+		{"user approves suggestion", user, Resource{Object: ObjectSuggestion}, "approve", false},
+		{"user upvotes suggestion", user, Resource{Object: ObjectSuggestion}, "upvote", true},
+		{"admin creates team", admin, Resource{Object: ObjectTeam}, ActionCreate, true},
+		{"user reads team", user, Resource{Object: ObjectTeam}, ActionRead, true},
+		{"user updates team", user, Resource{Object: ObjectTeam}, ActionUpdate, false},
+		{"anonymous reads team", anonymous, Resource{Object: ObjectTeam}, ActionRead, false},
+		{"admin updates office", admin, Resource{Object: ObjectOffice}, ActionUpdate, true},
+		{"user reads office", user, Resource{Object: ObjectOffice}, ActionRead, true},
+		{"user creates office", user, Resource{Object: ObjectOffice}, ActionCreate, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Allowed(tt.sub, tt.res, tt.action); got != tt.want {
+				t.Errorf("Allowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyDenyOverridesAllow(t *testing.T) {
+	policy, err := Parse([]string{
+		"allow ADMIN * *",
+		"deny * user delete owner",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := Subject{UserID: "admin", Roles: []string{"ADMIN"}}
+	if policy.Allowed(admin, Resource{Object: ObjectUser, OwnerID: "admin"}, ActionDelete) {
+		t.Error("admin should not be allowed to delete self")
+	}
+	if !policy.Allowed(admin, Resource{Object: ObjectUser, OwnerID: "other"}, ActionDelete) {
+		t.Error("admin should be allowed to delete other user")
+	}
+	if policy.Allowed(Subject{Roles: []string{"USER"}}, Resource{Object: ObjectMenu}, ActionRead) {
+		t.Error("action without matching allow rule should be denied")
+	}
+}
+
+func TestPolicyMemberDeny(t *testing.T) {
+	policy, err := Parse([]string{
+		"allow USER menu update",
+		"deny USER menu update member=editor",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editor := Subject{UserID: "editor", Roles: []string{"USER"}}
+	if policy.Allowed(editor, Resource{Object: ObjectMenu, MemberRole: "editor"}, ActionUpdate) {
+		t.Error("deny rule with member condition should override allow")
+	}
+	if !policy.Allowed(editor, Resource{Object: ObjectMenu}, ActionUpdate) {
+		t.Error("non member should be allowed")
+	}
+}
+
+func TestPolicyRolePermissions(t *testing.T) {
+	policy := Default()
+	manager := Subject{UserID: "manager", Roles: []string{"USER", "RESTAURANT_MANAGER"}}
+	create := Resource{Object: ObjectRestaurant}
+
+	if policy.Allowed(manager, create, ActionCreate) {
+		t.Fatal("manager should not create restaurant before permissions are set")
+	}
+
+	err := policy.SetRolePermissions(map[string][]string{
+		"RESTAURANT_MANAGER": {"restaurant:create", "menu:update"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !policy.Allowed(manager, create, ActionCreate) {
+		t.Error("manager should create restaurant")
+	}
+	if !policy.Allowed(manager, Resource{Object: ObjectMenu}, ActionUpdate) {
+		t.Error("manager should update any menu")
+	}
+	if policy.Allowed(manager, Resource{Object: ObjectUser}, ActionRead) {
+		t.Error("manager should not list users")
+	}
+
+	if err := policy.SetRolePermissions(map[string][]string{"BROKEN": {"restaurant"}}); err == nil {
+		t.Error("malformed permission should be rejected")
+	}
+	if err := policy.SetRolePermissions(map[string][]string{"BROKEN": {"restaurants:create"}}); err == nil {
+		t.Error("permission of unknown object should be rejected")
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rule    string
+		wantErr bool
+	}{
+		{"allow USER menu read", false},
+		{"deny * user delete owner", false},
+		{"allow USER menu update member=owner|editor", false},
+		{"permit USER menu read", true},
+		{"allow USER menu", true},
+		{"allow USER menu read somebody", true},
+		{"allow USER menu read member=", true},
+		{"allow USER menus read", true},
+		{"allow USER menu raed", true},
+		{"allow ADMIN user reset_mfa", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			r, err := ParseRule(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && r.String() != tt.rule {
+				t.Errorf("String() = %q, want %q", r.String(), tt.rule)
+			}
+		})
+	}
+}
@@ -19,6 +19,8 @@ var (
 	ErrForbidden = errors.New("Attempted action is not allowed")
 	// ErrAlreadyVoted returned when user is trying to place second vote for the same date.
 	ErrAlreadyVoted = errors.New("user has already voted today")
+	// ErrVersionConflict returned when entity was modified since the version passed by client.
+	ErrVersionConflict = errors.New("entity was modified, version is stale")
 )
 
 // Config struct is used to to store db connection settings.
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// txKey is the context key of the transaction started by InTx.
+type txKey struct{}
+
+// DB wraps database connection used by repositories. Queries of a context
+// carrying transaction started by InTx run in that transaction, so changes of
+// several repositories are committed together.
+type DB struct {
+	dbx *sqlx.DB
+}
+
+// Wrap creates DB running queries on passed database connection.
+func Wrap(dbx *sqlx.DB) DB {
+	return DB{dbx}
+}
+
+// Tx is a transaction started by DB.BeginTxx. Inside of a context transaction
+// it is a savepoint, its Commit and Rollback release or roll back the savepoint
+// and the context transaction is committed by InTx.
+type Tx struct {
+	*sqlx.Tx
+	savepoint bool
+	done      bool
+}
+
+// conn returns transaction of the context, otherwise the database connection.
+func (d DB) conn(ctx context.Context) sqlx.ExtContext {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return tx
+	}
+	return d.dbx
+}
+
+// GetContext runs query returning single row and scans it into dest.
+func (d DB) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return sqlx.GetContext(ctx, d.conn(ctx), dest, query, args...)
+}
+
+// SelectContext runs query and scans all returned rows into dest slice.
+func (d DB) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	return sqlx.SelectContext(ctx, d.conn(ctx), dest, query, args...)
+}
+
+// ExecContext runs query without returning rows.
+func (d DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return d.conn(ctx).ExecContext(ctx, query, args...)
+}
+
+// QueryRowxContext runs query returning single row.
+func (d DB) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	return d.conn(ctx).QueryRowxContext(ctx, query, args...)
+}
+
+// BeginTxx starts a transaction, a savepoint is started inside of the context transaction.
+func (d DB) BeginTxx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	if tx, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT nested`); err != nil {
+			return nil, errors.Wrap(err, "starting savepoint")
+		}
+		return &Tx{Tx: tx, savepoint: true}, nil
+	}
+
+	tx, err := d.dbx.BeginTxx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx}, nil
+}
+
+// Commit commits the transaction or releases the savepoint.
+func (t *Tx) Commit() error {
+	if !t.savepoint {
+		return t.Tx.Commit()
+	}
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	_, err := t.Tx.Exec(`RELEASE SAVEPOINT nested`)
+	return err
+}
+
+// Rollback rolls back the transaction or the savepoint.
+func (t *Tx) Rollback() error {
+	if !t.savepoint {
+		return t.Tx.Rollback()
+	}
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	_, err := t.Tx.Exec(`ROLLBACK TO SAVEPOINT nested`)
+	return err
+}
+
+// InTx runs fn with a context carrying transaction, repositories called by fn with
+// that context run their queries in it. Transaction is committed when fn returns
+// nil, otherwise it is rolled back. fn runs in the already started transaction of
+// the context when there is one.
+func (d DB) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sqlx.Tx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := d.dbx.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit transaction")
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package mail
+
+import (
+	"context"
+	"time"
+)
+
+// Mailer interface is used to send emails to users.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Message represents single email message.
+type Message struct {
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	Date    time.Time `json:"date"`
+}
+
+// Config struct is used to store mailer settings. When OutboxDir is set
+// messages are written into that directory instead of being sent over SMTP.
+type Config struct {
+	Host      string
+	Port      int
+	User      string
+	Password  string
+	From      string
+	OutboxDir string
+}
+
+// New is a factory function creates Mailer configured by passed Config.
+func New(cfg Config) Mailer {
+	if cfg.OutboxDir != "" {
+		return NewOutbox(cfg.OutboxDir)
+	}
+	return NewSMTP(cfg)
+}
@@ -0,0 +1,70 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Outbox is a Mailer which does not deliver messages. Messages are kept in memory
+// and written as JSON files into the outbox directory, it is used for development and tests.
+type Outbox struct {
+	dir      string
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewOutbox is a factory function creates new Outbox. Messages are not written
+// to the disk when dir is empty.
+func NewOutbox(dir string) *Outbox {
+	return &Outbox{dir: dir}
+}
+
+// Send stores message in the outbox.
+func (o *Outbox) Send(ctx context.Context, msg Message) error {
+	if msg.Date.IsZero() {
+		msg.Date = time.Now()
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.dir != "" {
+		if err := os.MkdirAll(o.dir, 0755); err != nil {
+			return errors.Wrap(err, "creating outbox dir")
+		}
+
+		data, err := json.MarshalIndent(msg, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "encoding message")
+		}
+
+		name := fmt.Sprintf("%d-%d.json", msg.Date.UnixNano(), len(o.messages))
+		if err := ioutil.WriteFile(filepath.Join(o.dir, name), data, 0644); err != nil {
+			return errors.Wrap(err, "writing message")
+		}
+	}
+
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+// Messages returns messages sent to the passed address, the oldest first.
+func (o *Outbox) Messages(to string) []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var messages []Message
+	for _, m := range o.messages {
+		if m.To == to {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
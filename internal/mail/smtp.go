@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP is a Mailer sending messages via SMTP server.
+type SMTP struct {
+	cfg Config
+}
+
+// NewSMTP is a factory function creates new SMTP Mailer.
+func NewSMTP(cfg Config) *SMTP {
+	return &SMTP{cfg}
+}
+
+// Send sends message via configured SMTP server.
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	if msg.Date.IsZero() {
+		msg.Date = time.Now()
+	}
+
+	var auth smtp.Auth
+	if s.cfg.User != "" {
+		auth = smtp.PlainAuth("", s.cfg.User, s.cfg.Password, s.cfg.Host)
+	}
+
+	addr := s.cfg.Host + ":" + strconv.Itoa(s.cfg.Port)
+	if err := smtp.SendMail(addr, auth, s.cfg.From, []string{msg.To}, s.format(msg)); err != nil {
+		return errors.Wrapf(err, "sending mail to %s", msg.To)
+	}
+	return nil
+}
+
+func (s *SMTP) format(msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", msg.Date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	b.WriteString(msg.Body)
+	return []byte(b.String())
+}
@@ -0,0 +1,12 @@
+package notify
+
+import "time"
+
+// Notification is a message for the user stored in DB.
+type Notification struct {
+	ID          string    `db:"notification_id" json:"id"`
+	UserID      string    `db:"user_id" json:"userId"`
+	Subject     string    `db:"subject" json:"subject"`
+	Message     string    `db:"message" json:"message"`
+	DateCreated time.Time `db:"date_created" json:"dateCreated"`
+}
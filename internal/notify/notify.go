@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/db"
+	"time"
+)
+
+// Notifier interface is used to send notifications to users.
+type Notifier interface {
+	Notify(ctx context.Context, userID, subject, message string) error
+}
+
+// Repo is a notification Repository structure. It implements Notifier by storing
+// notifications in DB where users can read them.
+type Repo struct {
+	db db.DB
+}
+
+// NewRepo is a factory function used to create new notification Repository.
+func NewRepo(dbx *sqlx.DB) *Repo {
+	return &Repo{db.Wrap(dbx)}
+}
+
+// Notify stores new notification for the specified user.
+func (r *Repo) Notify(ctx context.Context, userID, subject, message string) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return db.ErrInvalidID
+	}
+
+	const q = `INSERT INTO notification
+	    (notification_id, user_id, subject, message, date_created)
+	    VALUES ($1, $2, $3, $4, $5)`
+	_, err := r.db.ExecContext(ctx, q, uuid.New().String(), userID, subject, message, time.Now().UTC())
+	if err != nil {
+		return errors.Wrap(err, "inserting notification")
+	}
+	return nil
+}
+
+// List retrieves notifications of the specified user, the latest first.
+func (r *Repo) List(ctx context.Context, userID string) ([]Notification, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, db.ErrInvalidID
+	}
+
+	notifications := make([]Notification, 0)
+	const q = `SELECT * FROM notification WHERE user_id = $1 ORDER BY date_created DESC`
+	if err := r.db.SelectContext(ctx, &notifications, q, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting notifications")
+	}
+	return notifications, nil
+}
@@ -0,0 +1,83 @@
+package office
+
+import (
+	"time"
+)
+
+// cutoffLayout is the layout of the office vote cutoff time of the day.
+const cutoffLayout = "15:04"
+
+// Office is a location of the organization with its own timezone and restaurant
+// catalog. Current day of the office users, menu dates and voting cutoff are
+// computed in the office timezone.
+type Office struct {
+	ID          string    `db:"office_id" json:"id"`
+	OrgID       string    `db:"org_id" json:"orgId"`
+	Name        string    `db:"name" json:"name"`
+	Timezone    string    `db:"timezone" json:"timezone"`
+	Location    string    `db:"location" json:"location"`
+	VoteCutoff  *string   `db:"vote_cutoff" json:"voteCutoff,omitempty"`
+	DateCreated time.Time `db:"date_created" json:"dateCreated"`
+	DateUpdated time.Time `db:"date_updated" json:"dateUpdated"`
+}
+
+// NewOffice is what we require from clients when adding an Office. Timezone is
+// an IANA timezone name like Europe/Vilnius, optional vote cutoff is a local
+// time of the day in 15:04 format after which votes for the day are closed.
+type NewOffice struct {
+	Name       string  `json:"name" validate:"required"`
+	Timezone   string  `json:"timezone" validate:"required"`
+	Location   string  `json:"location"`
+	VoteCutoff *string `json:"voteCutoff"`
+}
+
+// UpdateOffice defines what information may be provided to modify an existing
+// Office. All fields are optional, empty vote cutoff removes the cutoff.
+type UpdateOffice struct {
+	Name       *string `json:"name"`
+	Timezone   *string `json:"timezone"`
+	Location   *string `json:"location"`
+	VoteCutoff *string `json:"voteCutoff"`
+}
+
+// TimeLocation returns the office timezone, UTC is returned for unknown timezone.
+func (o *Office) TimeLocation() *time.Location {
+	loc, err := time.LoadLocation(o.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Today returns the current day of the office in the same form as the dates
+// parsed from requests, midnight UTC.
+func (o *Office) Today(now time.Time) time.Time {
+	local := now.In(o.TimeLocation())
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// VotingClosed reports if votes for the day are not accepted anymore. Votes for
+// the past days and for the current day after the vote cutoff are closed, there
+// is no limit for offices without vote cutoff.
+func (o *Office) VotingClosed(day, now time.Time) bool {
+	if o.VoteCutoff == nil {
+		return false
+	}
+
+	cutoff, err := time.Parse(cutoffLayout, *o.VoteCutoff)
+	if err != nil {
+		return false
+	}
+
+	today := o.Today(now)
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+	switch {
+	case day.Before(today):
+		return true
+	case day.After(today):
+		return false
+	}
+
+	local := now.In(o.TimeLocation())
+	return local.Hour()*60+local.Minute() >= cutoff.Hour()*60+cutoff.Minute()
+}
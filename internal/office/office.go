@@ -0,0 +1,233 @@
+package office
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/org"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidName returned when office name is empty.
+	ErrInvalidName = errors.New("office name should not be empty")
+	// ErrInvalidTimezone returned when office timezone is not a known IANA timezone.
+	ErrInvalidTimezone = errors.New("office timezone should be IANA timezone name like Europe/Vilnius")
+	// ErrInvalidCutoff returned when vote cutoff is not a time of the day in 15:04 format.
+	ErrInvalidCutoff = errors.New("office vote cutoff should be time of the day like 11:30")
+	// ErrOfficeExists returned when office with the same name already exists in the organization.
+	ErrOfficeExists = errors.New("office already exists")
+	// ErrVotingClosed returned when vote is sent after the office vote cutoff.
+	ErrVotingClosed = errors.New("voting for the day is closed")
+)
+
+// Repo is an office Repository structure. All queries are limited to the
+// organization stored in the context.
+type Repo struct {
+	db db.DB
+}
+
+// NewRepo is a factory function used to create new office Repository.
+func NewRepo(dbx *sqlx.DB) *Repo {
+	return &Repo{db.Wrap(dbx)}
+}
+
+// List retrieves offices of the organization ordered by name.
+func (r *Repo) List(ctx context.Context) ([]Office, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	offices := make([]Office, 0)
+	const q = `SELECT * FROM office WHERE org_id = $1 ORDER BY name`
+	if err := r.db.SelectContext(ctx, &offices, q, orgID); err != nil {
+		return nil, errors.Wrap(err, "selecting offices")
+	}
+	return offices, nil
+}
+
+// Retrieve gets the specified office from the database.
+func (r *Repo) Retrieve(ctx context.Context, id string) (*Office, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, db.ErrInvalidID
+	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var o Office
+	const q = `SELECT * FROM office WHERE office_id = $1 AND org_id = $2`
+	if err := r.db.GetContext(ctx, &o, q, id, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting office %q", id)
+	}
+	return &o, nil
+}
+
+// HomeOffice gets home office of the specified user, db.ErrNotFound is returned
+// when user has no home office.
+func (r *Repo) HomeOffice(ctx context.Context, userID string) (*Office, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, db.ErrInvalidID
+	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var o Office
+	const q = `SELECT o.* FROM office o
+	    JOIN users u ON u.office_id = o.office_id
+	    WHERE u.user_id = $1 AND o.org_id = $2`
+	if err := r.db.GetContext(ctx, &o, q, userID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting user %q office", userID)
+	}
+	return &o, nil
+}
+
+// RestaurantOffice gets office the specified restaurant is listed in, db.ErrNotFound
+// is returned when restaurant is not in catalog of any office.
+func (r *Repo) RestaurantOffice(ctx context.Context, restaurantID string) (*Office, error) {
+	if _, err := uuid.Parse(restaurantID); err != nil {
+		return nil, db.ErrInvalidID
+	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var o Office
+	const q = `SELECT o.* FROM office o
+	    JOIN restaurant r ON r.office_id = o.office_id
+	    WHERE r.restaurant_id = $1 AND o.org_id = $2`
+	if err := r.db.GetContext(ctx, &o, q, restaurantID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting restaurant %q office", restaurantID)
+	}
+	return &o, nil
+}
+
+// Create inserts a new office into the database.
+func (r *Repo) Create(ctx context.Context, no NewOffice, now time.Time) (*Office, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	o := Office{
+		ID:          uuid.New().String(),
+		OrgID:       orgID,
+		Name:        strings.TrimSpace(no.Name),
+		Timezone:    no.Timezone,
+		Location:    no.Location,
+		VoteCutoff:  cutoff(no.VoteCutoff),
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+	if err := validate(&o); err != nil {
+		return nil, err
+	}
+
+	const q = `INSERT INTO office
+	    (office_id, org_id, name, timezone, location, vote_cutoff, date_created, date_updated)
+	    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err = r.db.ExecContext(ctx, q, o.ID, o.OrgID, o.Name, o.Timezone, o.Location, o.VoteCutoff,
+		o.DateCreated, o.DateUpdated)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrOfficeExists
+		}
+		return nil, errors.Wrap(err, "inserting office")
+	}
+	return &o, nil
+}
+
+// Update modifies the specified office.
+func (r *Repo) Update(ctx context.Context, id string, uo UpdateOffice, now time.Time) (*Office, error) {
+	o, err := r.Retrieve(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if uo.Name != nil {
+		o.Name = strings.TrimSpace(*uo.Name)
+	}
+	if uo.Timezone != nil {
+		o.Timezone = *uo.Timezone
+	}
+	if uo.Location != nil {
+		o.Location = *uo.Location
+	}
+	if uo.VoteCutoff != nil {
+		o.VoteCutoff = cutoff(uo.VoteCutoff)
+	}
+	if err := validate(o); err != nil {
+		return nil, err
+	}
+	o.DateUpdated = now.UTC()
+
+	const q = `UPDATE office SET
+	    name = $3, timezone = $4, location = $5, vote_cutoff = $6, date_updated = $7
+	    WHERE office_id = $1 AND org_id = $2`
+	_, err = r.db.ExecContext(ctx, q, o.ID, o.OrgID, o.Name, o.Timezone, o.Location, o.VoteCutoff, o.DateUpdated)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrOfficeExists
+		}
+		return nil, errors.Wrapf(err, "updating office %s", id)
+	}
+	return o, nil
+}
+
+// Delete removes the office, its restaurants and users are left without office.
+func (r *Repo) Delete(ctx context.Context, id string) error {
+	o, err := r.Retrieve(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	const q = `DELETE FROM office WHERE office_id = $1 AND org_id = $2`
+	if _, err := r.db.ExecContext(ctx, q, o.ID, o.OrgID); err != nil {
+		return errors.Wrapf(err, "deleting office %s", id)
+	}
+	return nil
+}
+
+// cutoff returns nil for the missing or empty vote cutoff.
+func cutoff(s *string) *string {
+	if s == nil || strings.TrimSpace(*s) == "" {
+		return nil
+	}
+	c := strings.TrimSpace(*s)
+	return &c
+}
+
+func validate(o *Office) error {
+	if o.Name == "" {
+		return ErrInvalidName
+	}
+	// empty timezone is loaded as UTC, office timezone should be set explicitly
+	if _, err := time.LoadLocation(o.Timezone); err != nil || o.Timezone == "" {
+		return ErrInvalidTimezone
+	}
+	if o.VoteCutoff != nil {
+		if _, err := time.Parse(cutoffLayout, *o.VoteCutoff); err != nil {
+			return ErrInvalidCutoff
+		}
+	}
+	return nil
+}
@@ -0,0 +1,373 @@
+// Package oidc implements OpenID Connect authorization code flow with PKCE used
+// for single sign-on with corporate identity provider.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/auth"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionTTL is a duration login session waits for the identity provider callback.
+const sessionTTL = 10 * time.Minute
+
+var (
+	// ErrInvalidState returned when callback state is unknown, used or expired.
+	ErrInvalidState = errors.New("login state is invalid or expired")
+	// ErrInvalidIDToken returned when ID token fails verification.
+	ErrInvalidIDToken = errors.New("id token is invalid")
+	// ErrEmailNotVerified returned when identity provider has not verified user email.
+	ErrEmailNotVerified = errors.New("email is not verified by identity provider")
+)
+
+// Config struct is used to store OpenID Connect client settings. GroupRoles maps
+// identity provider groups to the service roles.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	GroupRoles   map[string]string
+}
+
+// Identity represents user identity asserted by the identity provider.
+type Identity struct {
+	Subject string
+	Email   string
+	Name    string
+	Groups  []string
+}
+
+// Roles maps identity groups to the service roles, every user has RoleUser.
+func (c Config) Roles(id Identity) []string {
+	roles := []string{auth.RoleUser}
+	for _, g := range id.Groups {
+		role, ok := c.GroupRoles[g]
+		if !ok || containsString(roles, role) {
+			continue
+		}
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type session struct {
+	nonce    string
+	verifier string
+	expires  time.Time
+}
+
+// Client performs OpenID Connect login with the configured identity provider.
+type Client struct {
+	cfg        Config
+	provider   discovery
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	keys     map[string]*rsa.PublicKey
+	sessions map[string]session
+}
+
+// New is a factory function creates Client. Identity provider endpoints are
+// discovered from issuer /.well-known/openid-configuration document.
+func New(ctx context.Context, cfg Config) (*Client, error) {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile", "groups"}
+	}
+
+	c := Client{
+		cfg:        cfg,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		keys:       make(map[string]*rsa.PublicKey),
+		sessions:   make(map[string]session),
+	}
+
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := c.getJSON(ctx, wellKnown, &c.provider); err != nil {
+		return nil, errors.Wrap(err, "discovering identity provider")
+	}
+	if c.provider.Issuer != cfg.Issuer {
+		return nil, errors.Errorf("issuer %q does not match configured %q", c.provider.Issuer, cfg.Issuer)
+	}
+
+	if err := c.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Config returns client configuration.
+func (c *Client) Config() Config {
+	return c.cfg
+}
+
+// AuthURL starts new login session and returns identity provider URL user should be redirected to.
+func (c *Client) AuthURL() (string, error) {
+	state, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	for k, s := range c.sessions {
+		if now.After(s.expires) {
+			delete(c.sessions, k)
+		}
+	}
+	c.sessions[state] = session{nonce, verifier, now.Add(sessionTTL)}
+	c.mu.Unlock()
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", c.cfg.ClientID)
+	q.Set("redirect_uri", c.cfg.RedirectURL)
+	q.Set("scope", strings.Join(c.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(c.provider.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.provider.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange completes login session identified by state. Authorization code is
+// exchanged for ID token which is verified and returned as Identity.
+func (c *Client) Exchange(ctx context.Context, state, code string) (Identity, error) {
+	c.mu.Lock()
+	s, ok := c.sessions[state]
+	delete(c.sessions, state)
+	c.mu.Unlock()
+
+	if !ok || time.Now().After(s.expires) {
+		return Identity{}, ErrInvalidState
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.cfg.RedirectURL)
+	form.Set("client_id", c.cfg.ClientID)
+	form.Set("client_secret", c.cfg.ClientSecret)
+	form.Set("code_verifier", s.verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "creating token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "exchanging authorization code")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Identity{}, errors.Errorf("token endpoint responded with status %d", resp.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return Identity{}, errors.Wrap(err, "decoding token response")
+	}
+
+	return c.verify(ctx, tokens.IDToken, s.nonce)
+}
+
+func (c *Client) verify(ctx context.Context, idToken, nonce string) (Identity, error) {
+	parser := jwt.Parser{ValidMethods: []string{auth.AlgorithmRS256}}
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return c.key(ctx, kid)
+	})
+	if err != nil {
+		return Identity{}, errors.Wrap(ErrInvalidIDToken, err.Error())
+	}
+
+	if iss, _ := claims["iss"].(string); iss != c.cfg.Issuer {
+		return Identity{}, errors.Wrap(ErrInvalidIDToken, "issuer mismatch")
+	}
+	if !audienceContains(claims["aud"], c.cfg.ClientID) {
+		return Identity{}, errors.Wrap(ErrInvalidIDToken, "audience mismatch")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return Identity{}, errors.Wrap(ErrInvalidIDToken, "nonce mismatch")
+	}
+	// email is used to link directory users, missing claim is not trusted
+	if verified, _ := claims["email_verified"].(bool); !verified {
+		return Identity{}, ErrEmailNotVerified
+	}
+
+	id := Identity{}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.Name, _ = claims["name"].(string)
+	if groups, ok := claims["groups"].([]interface{}); ok {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	}
+
+	if id.Subject == "" {
+		return Identity{}, errors.Wrap(ErrInvalidIDToken, "subject claim is missing")
+	}
+	if id.Email == "" {
+		return Identity{}, errors.Wrap(ErrInvalidIDToken, "email claim is missing")
+	}
+	if id.Name == "" {
+		id.Name = id.Email
+	}
+	return id, nil
+}
+
+// key returns identity provider signing key, keys are refetched once for unknown key ID.
+func (c *Client) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	if err := c.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, auth.ErrUnknownKeyID
+}
+
+func (c *Client) refreshKeys(ctx context.Context) error {
+	var set auth.JWKSet
+	if err := c.getJSON(ctx, c.provider.JWKSURI, &set); err != nil {
+		return errors.Wrap(err, "fetching identity provider keys")
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" {
+			continue
+		}
+		key, err := rsaKey(k)
+		if err != nil {
+			return errors.Wrapf(err, "parsing key %q", k.KeyID)
+		}
+		keys[k.KeyID] = key
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Client) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s responded with status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func rsaKey(k auth.JWK) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.Modulus)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.Exponent)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating random string")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+package org
+
+import "time"
+
+// Organization is a tenant of the service. Users, restaurants, menus and votes
+// belong to a single organization and are not visible to other organizations.
+type Organization struct {
+	ID          string    `db:"org_id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewOrganization contains information needed to create a new Organization.
+type NewOrganization struct {
+	Name string `json:"name" validate:"required"`
+}
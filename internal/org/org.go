@@ -0,0 +1,107 @@
+package org
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/db"
+	"strings"
+	"time"
+)
+
+// DefaultID is the organization existing data, self registered users and
+// anonymous requests belong to.
+const DefaultID = "00000000-0000-0000-0000-000000000001"
+
+var (
+	// ErrNoTenant returned when tenant scoped query is called without
+	// organization in the context.
+	ErrNoTenant = errors.New("organization is not set")
+	// ErrOrgExists returned when organization with the same name already exists.
+	ErrOrgExists = errors.New("organization already exists")
+	// ErrInvalidName returned when organization name is empty.
+	ErrInvalidName = errors.New("organization name should not be empty")
+)
+
+type ctxKey int
+
+// orgKey is used to store/retrieve organization ID from context.Context.
+const orgKey ctxKey = 1
+
+// NewContext returns copy of the context storing passed organization ID.
+func NewContext(ctx context.Context, orgID string) context.Context {
+	return context.WithValue(ctx, orgKey, orgID)
+}
+
+// FromContext returns organization ID stored in the context. ErrNoTenant is
+// returned when there is no organization so queries never run unscoped.
+func FromContext(ctx context.Context) (string, error) {
+	orgID, ok := ctx.Value(orgKey).(string)
+	if !ok || orgID == "" {
+		return "", ErrNoTenant
+	}
+	return orgID, nil
+}
+
+// Repo is an organization Repository structure.
+type Repo struct {
+	db db.DB
+}
+
+// NewRepo is a factory function used to create new organization Repository.
+func NewRepo(dbx *sqlx.DB) *Repo {
+	return &Repo{db.Wrap(dbx)}
+}
+
+// List retrieves all organizations ordered by name.
+func (r *Repo) List(ctx context.Context) ([]Organization, error) {
+	orgs := make([]Organization, 0)
+	const q = `SELECT * FROM organization ORDER BY name`
+	if err := r.db.SelectContext(ctx, &orgs, q); err != nil {
+		return nil, errors.Wrap(err, "selecting organizations")
+	}
+	return orgs, nil
+}
+
+// Retrieve gets the specified organization from the database.
+func (r *Repo) Retrieve(ctx context.Context, id string) (*Organization, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, db.ErrInvalidID
+	}
+
+	var o Organization
+	const q = `SELECT * FROM organization WHERE org_id = $1`
+	if err := r.db.GetContext(ctx, &o, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting organization %q", id)
+	}
+	return &o, nil
+}
+
+// Create inserts a new organization into the database.
+func (r *Repo) Create(ctx context.Context, no NewOrganization, now time.Time) (*Organization, error) {
+	name := strings.TrimSpace(no.Name)
+	if name == "" {
+		return nil, ErrInvalidName
+	}
+
+	o := Organization{
+		ID:          uuid.New().String(),
+		Name:        name,
+		DateCreated: now.UTC(),
+	}
+
+	const q = `INSERT INTO organization (org_id, name, date_created) VALUES ($1, $2, $3)`
+	if _, err := r.db.ExecContext(ctx, q, o.ID, o.Name, o.DateCreated); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrOrgExists
+		}
+		return nil, errors.Wrap(err, "inserting organization")
+	}
+	return &o, nil
+}
@@ -0,0 +1,199 @@
+// Package password hashes and verifies user passwords and checks them against
+// the password policy.
+package password
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+var (
+	// ErrMismatch returned when password does not match the hash.
+	ErrMismatch = errors.New("password does not match")
+	// ErrUnknownHash returned when hash is not encoded by any of supported hashers.
+	ErrUnknownHash = errors.New("password hash algorithm is not supported")
+)
+
+// Hasher hashes passwords with a single algorithm. Encoded hash carries the
+// algorithm and its parameters, so hashes stay verifiable after parameters change.
+type Hasher interface {
+	// Hash returns encoded hash of the password.
+	Hash(password string) ([]byte, error)
+	// Verify checks password against encoded hash, ErrMismatch is returned for wrong password.
+	Verify(hash []byte, password string) error
+	// Supports reports if hash is encoded with the hasher algorithm.
+	Supports(hash []byte) bool
+	// Outdated reports if hash was computed with parameters other than the hasher ones.
+	Outdated(hash []byte) bool
+}
+
+// Default is the hasher used for new passwords.
+var Default Hasher = NewArgon2id()
+
+// legacy hashers are used only to verify hashes created before Default was changed.
+var legacy = []Hasher{NewArgon2id(), Bcrypt{Cost: bcrypt.DefaultCost}}
+
+// Hash returns hash of the password computed with the Default hasher.
+func Hash(password string) ([]byte, error) {
+	return Default.Hash(password)
+}
+
+// Verify checks password against hash computed by Default or legacy hasher.
+// Rehash is true when the password matches and hash should be replaced with a
+// new one, because it was computed by another algorithm or with outdated parameters.
+func Verify(hash []byte, password string) (rehash bool, err error) {
+	if Default.Supports(hash) {
+		if err := Default.Verify(hash, password); err != nil {
+			return false, err
+		}
+		return Default.Outdated(hash), nil
+	}
+
+	for _, h := range legacy {
+		if h.Supports(hash) {
+			if err := h.Verify(hash, password); err != nil {
+				return false, err
+			}
+			return true, nil
+		}
+	}
+	return false, ErrUnknownHash
+}
+
+// argon2idPrefix starts hashes encoded in PHC string format.
+const argon2idPrefix = "$argon2id$"
+
+// Argon2id hashes passwords with argon2id. Memory is set in KiB.
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// NewArgon2id returns argon2id hasher with parameters recommended by RFC 9106
+// for memory constrained environments.
+func NewArgon2id() Argon2id {
+	return Argon2id{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// Hash returns hash encoded as $argon2id$v=19$m=65536,t=3,p=2$salt$key.
+func (a Argon2id) Hash(password string) ([]byte, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "generating salt")
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	return []byte(encoded), nil
+}
+
+// Verify checks password against argon2id hash using parameters stored in the hash.
+func (a Argon2id) Verify(hash []byte, password string) error {
+	p, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// Supports reports if hash is argon2id hash.
+func (a Argon2id) Supports(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte(argon2idPrefix))
+}
+
+// Outdated reports if hash parameters differ from the hasher ones.
+func (a Argon2id) Outdated(hash []byte) bool {
+	p, salt, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	p.SaltLength = uint32(len(salt))
+	return p != a
+}
+
+func decodeArgon2id(hash []byte) (Argon2id, []byte, []byte, error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2id{}, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2id{}, nil, nil, ErrUnknownHash
+	}
+
+	var p Argon2id
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Argon2id{}, nil, nil, ErrUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2id{}, nil, nil, ErrUnknownHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return Argon2id{}, nil, nil, ErrUnknownHash
+	}
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
+
+// Bcrypt hashes passwords with bcrypt, it is kept to verify hashes created before
+// argon2id became the default.
+type Bcrypt struct {
+	Cost int
+}
+
+// Hash returns bcrypt hash, it carries algorithm version and cost.
+func (b Bcrypt) Hash(password string) ([]byte, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating password hash")
+	}
+	return hash, nil
+}
+
+// Verify checks password against bcrypt hash.
+func (b Bcrypt) Verify(hash []byte, password string) error {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrMismatch
+	}
+	return err
+}
+
+// Supports reports if hash is bcrypt hash.
+func (b Bcrypt) Supports(hash []byte) bool {
+	_, err := bcrypt.Cost(hash)
+	return err == nil
+}
+
+// Outdated reports if hash cost differs from the hasher cost.
+func (b Bcrypt) Outdated(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != b.Cost
+}
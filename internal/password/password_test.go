@@ -0,0 +1,111 @@
+package password
+
+import (
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	hash, err := Hash("gophers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(hash), "$argon2id$v=19$m=65536,t=3,p=2$") {
+		t.Fatalf("Hash() = %s, want argon2id hash with parameters", hash)
+	}
+
+	if rehash, err := Verify(hash, "gophers"); err != nil || rehash {
+		t.Errorf("Verify() = %v, %v, want match without rehash", rehash, err)
+	}
+	if _, err := Verify(hash, "gopher"); err != ErrMismatch {
+		t.Errorf("Verify() wrong password error = %v, want %v", err, ErrMismatch)
+	}
+
+	weaker := NewArgon2id()
+	weaker.Iterations = 1
+	outdated, err := weaker.Hash("gophers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rehash, err := Verify(outdated, "gophers"); err != nil || !rehash {
+		t.Errorf("Verify() outdated argon2id = %v, %v, want match with rehash", rehash, err)
+	}
+
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte("gophers"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rehash, err := Verify(legacyHash, "gophers"); err != nil || !rehash {
+		t.Errorf("Verify() bcrypt = %v, %v, want match with rehash", rehash, err)
+	}
+	if _, err := Verify(legacyHash, "gopher"); err != ErrMismatch {
+		t.Errorf("Verify() bcrypt wrong password error = %v, want %v", err, ErrMismatch)
+	}
+
+	if _, err := Verify([]byte("plain"), "plain"); err != ErrUnknownHash {
+		t.Errorf("Verify() unknown hash error = %v, want %v", err, ErrUnknownHash)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	// list sorted by hash includes SHA-1 of "password123" and "letmein1"
+	// among hashes of generated passwords
+	hashes := []string{sha1Hex("password123"), sha1Hex("letmein1") + ":42"}
+	for i := 0; i < 1000; i++ {
+		hashes = append(hashes, fmt.Sprintf("%s:%d", sha1Hex(fmt.Sprintf("breached%d", i)), i))
+	}
+	sort.Strings(hashes)
+
+	p, err := LoadPolicy(8, writeBreached(t, strings.Join(hashes, "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		want     error
+	}{
+		{"gophers", ErrTooShort},
+		{"žąsiukai", nil},
+		{"password123", ErrBreached},
+		{"letmein1", ErrBreached},
+		{"breached0", ErrBreached},
+		{"breached999", ErrBreached},
+		{"breached1000", nil},
+		{"correct horse battery staple", nil},
+	}
+	for _, tt := range tests {
+		if err := p.Check(tt.password); err != tt.want {
+			t.Errorf("Check(%q) = %v, want %v", tt.password, err, tt.want)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		if err := p.Check(fmt.Sprintf("breached%d", i)); err != ErrBreached {
+			t.Fatalf("Check(breached%d) = %v, want %v", i, err, ErrBreached)
+		}
+	}
+
+	if _, err := LoadPolicy(8, writeBreached(t, "password123\n")); err != ErrInvalidBreachedFile {
+		t.Errorf("LoadPolicy() plain list error = %v, want %v", err, ErrInvalidBreachedFile)
+	}
+}
+
+func writeBreached(t *testing.T, list string) string {
+	f, err := ioutil.TempFile("", "breached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	if _, err := f.WriteString(list); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	return f.Name()
+}
@@ -0,0 +1,198 @@
+package password
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultMinLength is the minimal password length used when it is not configured.
+const DefaultMinLength = 6
+
+var (
+	// ErrTooShort returned when password is shorter than the policy minimal length.
+	ErrTooShort = errors.New("password is too short")
+	// ErrBreached returned when password is listed in the breached passwords list.
+	ErrBreached = errors.New("password is found in breached passwords list, choose another one")
+	// ErrInvalidBreachedFile returned when breached passwords file does not list SHA-1 hashes.
+	ErrInvalidBreachedFile = errors.New("breached passwords file should list SHA-1 hashes sorted by hash")
+)
+
+// Policy is a password policy applied when password is set. Breached passwords
+// are looked up in the file of SHA-1 hashes sorted in ascending order, the file is
+// kept open and searched with binary search, so lists of any size can be used.
+type Policy struct {
+	MinLength int
+	breached  *os.File
+	size      int64
+}
+
+// policy is the policy applied by Check.
+var policy = &Policy{MinLength: DefaultMinLength}
+
+// LoadPolicy creates password policy with passed minimal length in characters.
+// Breached passwords are checked when the file is set, the file lists hex encoded
+// SHA-1 hashes of passwords one per line sorted by hash, as in Have I Been Pwned
+// HASH:COUNT lists ordered by hash.
+func LoadPolicy(minLength int, breachedFile string) (*Policy, error) {
+	p := Policy{MinLength: minLength}
+	if breachedFile == "" {
+		return &p, nil
+	}
+
+	f, err := os.Open(breachedFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening breached passwords file")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrap(err, "reading breached passwords file")
+	}
+	p.breached, p.size = f, fi.Size()
+
+	// only the first line is validated, checking order of the whole list would
+	// read all of it
+	if p.size > 0 {
+		line, _, err := p.lineAt(0)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, ok := sha1Line(line); !ok {
+			f.Close()
+			return nil, ErrInvalidBreachedFile
+		}
+	}
+	return &p, nil
+}
+
+// SetPolicy replaces the policy applied by Check.
+func SetPolicy(p *Policy) {
+	policy = p
+}
+
+// Check checks password against the configured policy.
+func Check(password string) error {
+	return policy.Check(password)
+}
+
+// Check returns ErrTooShort or ErrBreached when password does not satisfy the policy.
+func (p *Policy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return ErrTooShort
+	}
+	breached, err := p.isBreached(sha1Hex(password))
+	if err != nil {
+		return err
+	}
+	if breached {
+		return ErrBreached
+	}
+	return nil
+}
+
+// isBreached searches the sorted breached passwords file for the hash. Search
+// keeps lines starting before lo lower than the hash and finds the first line
+// starting at or after lo which is not lower.
+func (p *Policy) isBreached(hash string) (bool, error) {
+	if p.breached == nil {
+		return false, nil
+	}
+
+	lo, hi := int64(0), p.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, next, err := p.lineAt(mid)
+		if err != nil {
+			return false, err
+		}
+		if line != "" && lineHash(line) < hash {
+			lo = next
+		} else {
+			hi = mid
+		}
+	}
+
+	line, _, err := p.lineAt(lo)
+	if err != nil {
+		return false, err
+	}
+	return line != "" && lineHash(line) == hash, nil
+}
+
+// lineAt returns the first line of the breached passwords file starting at or
+// after offset and offset of the following line. Empty line is returned at the
+// end of the file.
+func (p *Policy) lineAt(offset int64) (string, int64, error) {
+	buf := make([]byte, 128)
+	if offset > 0 {
+		// skip the rest of the line unless offset is at the line start
+		offset--
+		for {
+			n, err := p.breached.ReadAt(buf, offset)
+			if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+				offset += int64(i) + 1
+				break
+			}
+			if err == io.EOF {
+				return "", p.size, nil
+			}
+			if err != nil {
+				return "", 0, errors.Wrap(err, "reading breached passwords file")
+			}
+			offset += int64(n)
+		}
+	}
+
+	var line []byte
+	for start := offset; ; {
+		n, err := p.breached.ReadAt(buf, start)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			line = append(line, buf[:i]...)
+			return strings.TrimRight(string(line), "\r"), start + int64(i) + 1, nil
+		}
+		line = append(line, buf[:n]...)
+		if err == io.EOF {
+			return strings.TrimRight(string(line), "\r"), p.size, nil
+		}
+		if err != nil {
+			return "", 0, errors.Wrap(err, "reading breached passwords file")
+		}
+		start += int64(n)
+	}
+}
+
+// lineHash returns upper case hash of the breached passwords list line in HASH
+// or HASH:COUNT form.
+func lineHash(line string) string {
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.ToUpper(line)
+}
+
+// sha1Line returns hash of the breached passwords list line in HASH or HASH:COUNT form.
+func sha1Line(line string) (string, bool) {
+	hash := line
+	if i := strings.IndexByte(line, ':'); i == sha1.Size*2 {
+		hash = line[:i]
+	}
+	if len(hash) != sha1.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	return strings.ToUpper(hash), true
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
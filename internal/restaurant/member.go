@@ -0,0 +1,241 @@
+package restaurant
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/org"
+	"time"
+)
+
+var (
+	// ErrMemberNotFound returned when restaurant member is not found
+	ErrMemberNotFound = errors.New("restaurant member not found")
+	// ErrInvalidMemberRole returned when invalid restaurant member role is passed
+	ErrInvalidMemberRole = errors.New("invalid restaurant member role")
+	// ErrOwnerRemoval returned when restaurant owner is removed without ownership transfer
+	ErrOwnerRemoval = errors.New("restaurant owner can not be removed, transfer ownership first")
+	// ErrTransferNotFound returned when there is no pending ownership transfer for the user
+	ErrTransferNotFound = errors.New("ownership transfer not found")
+)
+
+// MemberRole returns role of the user in the specified restaurant. Empty role is
+// returned when user is not a member of restaurant or restaurant is soft deleted.
+func (r *Repo) MemberRole(ctx context.Context, restaurantID, userID string) (string, error) {
+	if _, err := uuid.Parse(restaurantID); err != nil {
+		return "", db.ErrInvalidID
+	}
+	if _, err := uuid.Parse(userID); err != nil {
+		return "", nil
+	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var role string
+	const q = `SELECT m.role FROM restaurant_member m
+	    JOIN restaurant r ON r.restaurant_id = m.restaurant_id
+	    WHERE m.restaurant_id = $1 AND m.user_id = $2 AND r.org_id = $3 AND r.deleted_at IS NULL`
+	if err := r.db.GetContext(ctx, &role, q, restaurantID, userID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "selecting restaurant %q member %q", restaurantID, userID)
+	}
+	return role, nil
+}
+
+// RetrieveMembers retrieves list of restaurant members from database.
+func (r *Repo) RetrieveMembers(ctx context.Context, restaurantID string) ([]Member, error) {
+	rest, err := r.GetRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]Member, 0)
+	const q = `SELECT m.restaurant_id, m.user_id, u.name, u.email, m.role, m.date_created
+	    FROM restaurant_member m
+	    JOIN users u ON u.user_id = m.user_id
+	    WHERE m.restaurant_id = $1 AND u.org_id = $2
+	    ORDER BY m.date_created`
+	if err := r.db.SelectContext(ctx, &members, q, restaurantID, rest.OrgID); err != nil {
+		return nil, errors.Wrap(err, "selecting restaurant members")
+	}
+	return members, nil
+}
+
+// AddMember adds user with specified email to the restaurant members. Only editor
+// members can be added, owner is changed with ownership transfer.
+func (r *Repo) AddMember(ctx context.Context, restaurantID string, nm NewMember, now time.Time) (*Member, error) {
+	if nm.Role == "" {
+		nm.Role = MemberEditor
+	}
+	if nm.Role != MemberEditor {
+		return nil, ErrInvalidMemberRole
+	}
+
+	rest, err := r.GetRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	// only users of the restaurant organization can become members
+	var m Member
+	const qUser = `SELECT user_id, name, email FROM users WHERE email = $1 AND org_id = $2`
+	if err := r.db.GetContext(ctx, &m, qUser, nm.Email, rest.OrgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting user %q", nm.Email)
+	}
+
+	m.RestaurantID = restaurantID
+	m.Role = nm.Role
+	m.DateCreated = now.UTC()
+
+	const q = `INSERT INTO restaurant_member (restaurant_id, user_id, role, date_created)
+	    VALUES ($1, $2, $3, $4)
+	    ON CONFLICT (restaurant_id, user_id) DO NOTHING`
+	if _, err := r.db.ExecContext(ctx, q, m.RestaurantID, m.UserID, m.Role, m.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting restaurant member")
+	}
+
+	// existing member keeps its role
+	role, err := r.MemberRole(ctx, restaurantID, m.UserID)
+	if err != nil {
+		return nil, err
+	}
+	m.Role = role
+	return &m, nil
+}
+
+// RemoveMember removes user from the restaurant members. Restaurant owner can not be removed.
+func (r *Repo) RemoveMember(ctx context.Context, restaurantID, userID string) error {
+	role, err := r.MemberRole(ctx, restaurantID, userID)
+	if err != nil {
+		return err
+	}
+
+	switch role {
+	case "":
+		return ErrMemberNotFound
+	case MemberOwner:
+		return ErrOwnerRemoval
+	}
+
+	const q = `DELETE FROM restaurant_member WHERE restaurant_id = $1 AND user_id = $2`
+	// membership was checked within the organization by MemberRole
+	if _, err := r.db.ExecContext(ctx, q, restaurantID, userID); err != nil {
+		return errors.Wrapf(err, "deleting restaurant %s member %s", restaurantID, userID)
+	}
+	return nil
+}
+
+// StartOwnershipTransfer creates pending ownership transfer of the restaurant to
+// the specified user. Previously started transfer is replaced.
+func (r *Repo) StartOwnershipTransfer(ctx context.Context, restaurantID, toUserID string, now time.Time) (*OwnershipTransfer, error) {
+	if _, err := uuid.Parse(toUserID); err != nil {
+		return nil, db.ErrInvalidID
+	}
+
+	rest, err := r.GetRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	const qUser = `SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1 AND org_id = $2)`
+	if err := r.db.GetContext(ctx, &exists, qUser, toUserID, rest.OrgID); err != nil {
+		return nil, errors.Wrapf(err, "selecting user %q", toUserID)
+	}
+	if !exists {
+		return nil, db.ErrNotFound
+	}
+
+	t := OwnershipTransfer{
+		RestaurantID: rest.ID,
+		FromUserID:   rest.OwnerUserID,
+		ToUserID:     toUserID,
+		DateCreated:  now.UTC(),
+	}
+
+	const q = `INSERT INTO restaurant_transfer (restaurant_id, from_user_id, to_user_id, date_created)
+	    VALUES ($1, $2, $3, $4)
+	    ON CONFLICT (restaurant_id) DO UPDATE
+	    SET from_user_id = $2, to_user_id = $3, date_created = $4`
+	if _, err := r.db.ExecContext(ctx, q, t.RestaurantID, t.FromUserID, t.ToUserID, t.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting ownership transfer")
+	}
+	return &t, nil
+}
+
+// AcceptOwnershipTransfer completes pending ownership transfer of the restaurant.
+// Only the user the restaurant is transferred to can accept the transfer. Previous
+// owner stays in the restaurant as an editor.
+func (r *Repo) AcceptOwnershipTransfer(ctx context.Context, restaurantID, userID string, now time.Time) (*Restaurant, error) {
+	rest, err := r.GetRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var t OwnershipTransfer
+	const qTransfer = `SELECT * FROM restaurant_transfer WHERE restaurant_id = $1`
+	if err := r.db.GetContext(ctx, &t, qTransfer, rest.ID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTransferNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting restaurant %q transfer", restaurantID)
+	}
+	if t.ToUserID != userID {
+		return nil, ErrTransferNotFound
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// restaurant could be soft deleted after the transfer was started
+	const qOwner = `UPDATE restaurant SET owner_user_id = $2, date_updated = $3, version = version + 1
+	    WHERE restaurant_id = $1 AND org_id = $4 AND deleted_at IS NULL`
+	res, err := tx.ExecContext(ctx, qOwner, restaurantID, t.ToUserID, now.UTC(), rest.OrgID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "transferring restaurant %s ownership", restaurantID)
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, "transferred count")
+	}
+	if count == 0 {
+		return nil, ErrRestaurantNotFound
+	}
+
+	queries := []struct {
+		q    string
+		args []interface{}
+	}{
+		{`UPDATE restaurant_member SET role = $3 WHERE restaurant_id = $1 AND role = $2`,
+			[]interface{}{restaurantID, MemberOwner, MemberEditor}},
+		{`INSERT INTO restaurant_member (restaurant_id, user_id, role, date_created)
+		    VALUES ($1, $2, $3, $4)
+		    ON CONFLICT (restaurant_id, user_id) DO UPDATE SET role = $3`,
+			[]interface{}{restaurantID, t.ToUserID, MemberOwner, now.UTC()}},
+		{`DELETE FROM restaurant_transfer WHERE restaurant_id = $1`,
+			[]interface{}{restaurantID}},
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query.q, query.args...); err != nil {
+			return nil, errors.Wrapf(err, "transferring restaurant %s ownership", restaurantID)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit ownership transfer")
+	}
+
+	return r.GetRestaurant(ctx, restaurantID)
+}
@@ -3,29 +3,53 @@ package restaurant
 import (
 	"context"
 	"database/sql"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
-	"github.com/remisb/mat/internal/auth"
 	"github.com/remisb/mat/internal/db"
-	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/org"
 	"strings"
 	"time"
 )
 
-// ErrMenuNotFound returned when menu is not found
-var ErrMenuNotFound = errors.New("menu not found")
+var (
+	// ErrMenuNotFound returned when menu is not found
+	ErrMenuNotFound = errors.New("menu not found")
+	// ErrMenuExists returned when menu of the restaurant for the date is already added,
+	// existing menu is replaced with versioned ReplaceMenu
+	ErrMenuExists = errors.New("restaurant menu for the date already exists")
+	// ErrInvalidRating returned when menu rating is out of 1 to 5 range
+	ErrInvalidRating = errors.New("menu rating should be from 1 to 5")
+	// ErrNotTeamMember returned when user votes in or reads results of the poll of a team
+	// the user does not belong to
+	ErrNotTeamMember = errors.New("user is not a member of the team")
+	// ErrNotInTeamPoll returned when restaurant is not included in the team poll
+	ErrNotInTeamPoll = errors.New("restaurant is not included in the team poll")
+	// ErrInactiveVoter returned when deactivated or deleted user votes
+	ErrInactiveVoter = errors.New("deactivated user can not vote")
+)
+
+// queryMenusByDate selects menus for the specified date, optionally limited to
+// the office catalog. Menus of soft deleted restaurants are hidden from the
+// deletion day onwards.
+const queryMenusByDate = `SELECT m.* FROM menu m
+	LEFT JOIN restaurant r ON r.restaurant_id = m.restaurant_id
+	WHERE m.date = $1 AND m.org_id = $2 AND (r.deleted_at IS NULL OR m.date < r.deleted_at::date)
+	    AND ($3::uuid IS NULL OR r.office_id = $3::uuid)`
 
 // RetrieveMenu used to retrieve menu from DB by specified menuID
 func (r *Repo) RetrieveMenu(ctx context.Context, menuID string) (*Menu, error) {
 	if _, err := uuid.Parse(menuID); err != nil {
 		return nil, db.ErrInvalidID
 	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	var m Menu
-	const q = `SELECT * FROM menu WHERE menu_id = $1`
-	if err := r.db.GetContext(ctx, &m, q, menuID); err != nil {
+	const q = `SELECT * FROM menu WHERE menu_id = $1 AND org_id = $2`
+	if err := r.db.GetContext(ctx, &m, q, menuID, orgID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, db.ErrNotFound
 		}
@@ -37,10 +61,15 @@ func (r *Repo) RetrieveMenu(ctx context.Context, menuID string) (*Menu, error) {
 }
 
 func (r *Repo) readMenuByRestaurantDate(ctx context.Context, restaurantID string, date time.Time) (*Menu, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var m Menu
 	const q = `SELECT * FROM menu 
-	    WHERE restaurant_id = $1 AND date = $2`
-	if err := r.db.GetContext(ctx, &m, q, restaurantID, date); err != nil {
+	    WHERE restaurant_id = $1 AND date = $2 AND org_id = $3`
+	if err := r.db.GetContext(ctx, &m, q, restaurantID, date, orgID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrMenuNotFound
 		}
@@ -52,7 +81,9 @@ func (r *Repo) readMenuByRestaurantDate(ctx context.Context, restaurantID string
 	return &m, nil
 }
 
-// RetrieveRestaurantMenus retrieves specified menu from database
+// RetrieveRestaurantMenus retrieves specified menu from database. Menus of soft
+// deleted restaurants are not found from the deletion day onwards, like in
+// queryMenusByDate.
 func (r *Repo) RetrieveRestaurantMenus(ctx context.Context, restaurantID, menuID string) (*Menu, error) {
 	if _, err := uuid.Parse(restaurantID); err != nil {
 		return nil, db.ErrInvalidID
@@ -61,10 +92,17 @@ func (r *Repo) RetrieveRestaurantMenus(ctx context.Context, restaurantID, menuID
 	if _, err := uuid.Parse(menuID); err != nil {
 		return nil, db.ErrInvalidID
 	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	var menu Menu
-	const q = `SELECT * FROM menu WHERE restaurant_id = $1 AND menu_id = $2`
-	if err := r.db.GetContext(ctx, &menu, q, restaurantID, menuID); err != nil {
+	const q = `SELECT m.* FROM menu m
+	    JOIN restaurant r ON r.restaurant_id = m.restaurant_id
+	    WHERE m.restaurant_id = $1 AND m.menu_id = $2 AND m.org_id = $3
+	    AND (r.deleted_at IS NULL OR m.date < r.deleted_at::date)`
+	if err := r.db.GetContext(ctx, &menu, q, restaurantID, menuID, orgID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, db.ErrNotFound
 		}
@@ -76,13 +114,18 @@ func (r *Repo) RetrieveRestaurantMenus(ctx context.Context, restaurantID, menuID
 }
 
 // RetrieveMenusByDate retrieves a list of menus from DB for specified date.
+// Only menus of the office catalog restaurants are listed when officeID is passed.
 // NOTE functionality of this func is identical to MenuVotes.
 // QUESTION 1: Do I have to have those two functions?
 // QUESTION 2: Should I modify MenuVotes functionality to be more specific for votes data retrieval?
-func (r *Repo) RetrieveMenusByDate(ctx context.Context, date time.Time) ([]Menu, error) {
+func (r *Repo) RetrieveMenusByDate(ctx context.Context, officeID string, date time.Time) ([]Menu, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var menus = make([]Menu, 0)
-	const q = `SELECT * FROM menu WHERE date = $1`
-	if err := r.db.SelectContext(ctx, &menus, q, date); err != nil {
+	if err := r.db.SelectContext(ctx, &menus, queryMenusByDate, date, orgID, nullID(officeID)); err != nil {
 		return nil, errors.Wrap(err, "retrieving menus for specified date")
 	}
 	return menus, nil
@@ -94,41 +137,117 @@ func (r *Repo) RetrieveMenusByRestaurant(ctx context.Context, restaurantID strin
 		return nil, db.ErrInvalidID
 	}
 
-	_, err := r.GetRestaurant(ctx, restaurantID)
+	rest, err := r.GetRestaurant(ctx, restaurantID)
 	if err != nil {
 		return nil, err
 	}
 
 	var menus = make([]Menu, 0)
-	const q = `SELECT * FROM menu WHERE restaurant_id = $1 ORDER BY date DESC `
-	if err := r.db.SelectContext(ctx, &menus, q, restaurantID); err != nil {
+	const q = `SELECT * FROM menu WHERE restaurant_id = $1 AND org_id = $2 ORDER BY date DESC `
+	if err := r.db.SelectContext(ctx, &menus, q, restaurantID, rest.OrgID); err != nil {
 		return nil, errors.Wrap(err, "retrieving restaurant menus")
 	}
 	return menus, nil
 }
 
-// MenuVotes retrieves list of menu with votes for specified date from database
-func (r *Repo) MenuVotes(ctx context.Context, date time.Time) ([]Menu, error) {
+// queryTeamMenusByDate selects menus of the team poll for the specified date with
+// votes counted from team votes. Poll includes all organization restaurants when
+// team has no poll restaurants.
+const queryTeamMenusByDate = `SELECT m.menu_id, m.restaurant_id, m.date, m.menu, m.tags, m.org_id, m.version,
+	    (SELECT COUNT(*) FROM vote v
+	        WHERE v.team_id = $3 AND v.restaurant_id = m.restaurant_id AND v.date::date = m.date) AS votes
+	FROM menu m
+	LEFT JOIN restaurant r ON r.restaurant_id = m.restaurant_id
+	JOIN team t ON t.team_id = $3 AND t.org_id = m.org_id
+	WHERE m.date = $1 AND m.org_id = $2 AND (r.deleted_at IS NULL OR m.date < r.deleted_at::date)
+	    AND ($4::uuid IS NULL OR r.office_id = $4::uuid)
+	    AND (NOT EXISTS (SELECT 1 FROM team_restaurant tr WHERE tr.team_id = t.team_id)
+	        OR m.restaurant_id IN (SELECT tr.restaurant_id FROM team_restaurant tr WHERE tr.team_id = t.team_id))`
+
+// MenuVotes retrieves list of menu with votes for specified date from database.
+// Votes of the organization poll are counted when teamID is empty, otherwise
+// menus of the team poll with votes of the team are returned. Results of the team
+// poll are returned only to team members when memberID is passed, ErrNotTeamMember
+// is returned otherwise. Menus are limited to the office catalog when officeID is passed.
+func (r *Repo) MenuVotes(ctx context.Context, teamID, memberID, officeID string, date time.Time) ([]Menu, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var menus = make([]Menu, 0)
-	const q = `SELECT * FROM menu WHERE date = $1`
-	if err := r.db.SelectContext(ctx, &menus, q, date); err != nil {
-		return nil, errors.Wrap(err, "retrieving menu votes")
+	if teamID == "" {
+		if err := r.db.SelectContext(ctx, &menus, queryMenusByDate, date, orgID, nullID(officeID)); err != nil {
+			return nil, errors.Wrap(err, "retrieving menu votes")
+		}
+		return menus, nil
+	}
+
+	if _, err := uuid.Parse(teamID); err != nil {
+		return nil, db.ErrInvalidID
+	}
+	var team struct {
+		Exists bool `db:"team_exists"`
+		Member bool `db:"member"`
+	}
+	const qTeam = `SELECT
+	        EXISTS (SELECT 1 FROM team WHERE team_id = $1 AND org_id = $2 AND deleted_at IS NULL) AS team_exists,
+	        EXISTS (SELECT 1 FROM team_member WHERE team_id = $1 AND user_id::text = $3) AS member`
+	if err := r.db.GetContext(ctx, &team, qTeam, teamID, orgID, memberID); err != nil {
+		return nil, errors.Wrapf(err, "selecting team %q", teamID)
+	}
+	switch {
+	case !team.Exists:
+		return nil, db.ErrNotFound
+	case memberID != "" && !team.Member:
+		return nil, ErrNotTeamMember
+	}
+
+	if err := r.db.SelectContext(ctx, &menus, queryTeamMenusByDate, date, orgID, teamID, nullID(officeID)); err != nil {
+		return nil, errors.Wrapf(err, "retrieving team %q menu votes", teamID)
 	}
 	return menus, nil
 }
 
 // MenuVote adds vote for specified restaurant menu on specified date.
 // If user has already voted for specified date then error  ErrAlreadyVoted will be returned.
-func (r *Repo) MenuVote(ctx context.Context, userID, restaurantID, menuID string, date time.Time) error {
-	tx, err := r.db.BeginTx(ctx, nil)
+// Optional menu rating from 1 to 5 can be passed with the vote, 0 means no rating.
+// Vote is added to the organization poll when teamID is empty, otherwise to the
+// poll of the team, user votes once per day in each poll.
+func (r *Repo) MenuVote(ctx context.Context, userID, teamID, restaurantID, menuID string, date time.Time, rating int) error {
+	if rating < 0 || rating > 5 {
+		return ErrInvalidRating
+	}
+
+	// menu of another organization is reported as not found
+	menu, err := r.RetrieveRestaurantMenus(ctx, restaurantID, menuID)
 	if err != nil {
 		return err
 	}
 
+	if err := r.checkVoter(ctx, userID); err != nil {
+		return err
+	}
+
+	var team sql.NullString
+	if teamID != "" {
+		if err := r.checkTeamPoll(ctx, teamID, userID, menu); err != nil {
+			return err
+		}
+		team = sql.NullString{String: teamID, Valid: true}
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	var count int
 
-	const qSelectVote = `SELECT COUNT(*) as count FROM vote WHERE time_voted = $1 AND user_id = $2`
-	err = tx.QueryRow(qSelectVote, date, userID).Scan(&count)
+	const qSelectVote = `SELECT COUNT(*) as count FROM vote
+	    WHERE time_voted = $1 AND user_id = $2 AND team_id IS NOT DISTINCT FROM $3`
+	err = tx.QueryRow(qSelectVote, date, userID, team).Scan(&count)
 	if err != nil {
 		return errors.Wrap(err, "error on vote count scan")
 	}
@@ -136,88 +255,93 @@ func (r *Repo) MenuVote(ctx context.Context, userID, restaurantID, menuID string
 		return db.ErrAlreadyVoted
 	}
 
-	err = txMenuVote(ctx, tx, restaurantID, menuID, userID, date)
-	if err != nil {
-		if errT := tx.Rollback(); errT != nil {
-			log.Sugar.Errorf("error on tx rollback, error: %s", err)
-		}
+	if err := txMenuVote(ctx, tx, menu, userID, team, date, rating); err != nil {
+		return err
 	}
-
-	err = tx.Commit()
-	if err != nil {
-		log.Sugar.Errorf("error on tx commit, error: %s", err)
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit vote")
 	}
 	return nil
 }
 
-func txMenuVote(ctx context.Context, tx *sql.Tx, restaurantID, menuID, userID string, date time.Time) error {
-	const qInsertVote = `INSERT INTO vote (date, user_id, restaurant_id, time_voted)
-	    VALUES ($1, $2, $3, $4)`
-	voteResult, err := tx.ExecContext(ctx, qInsertVote, date, userID, restaurantID, date)
-	if err != nil {
-		return errors.Wrap(err, "inserting restaurant")
+// checkVoter verifies that user is active, votes of deactivated users stay in
+// the history, but new ones are rejected.
+func (r *Repo) checkVoter(ctx context.Context, userID string) error {
+	var active bool
+	const q = `SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1 AND email_verified AND active)`
+	if err := r.db.GetContext(ctx, &active, q, userID); err != nil {
+		return errors.Wrapf(err, "selecting user %q", userID)
 	}
-	if count, err := voteResult.RowsAffected(); err != nil || count < 1 {
-		return errors.Wrap(err, "error on getting rows updated")
+	if !active {
+		return ErrInactiveVoter
 	}
+	return nil
+}
 
-	const qUpdateMenuVote = `UPDATE menu SET votes = votes + 1 WHERE menu_id = $1`
-	updateResult, err := tx.Exec(qUpdateMenuVote, menuID)
-	if err != nil {
-		return errors.Wrapf(err, "error on menu vote update")
+// checkTeamPoll verifies that user is a member of the team of menu organization
+// and menu restaurant is included in the team poll.
+func (r *Repo) checkTeamPoll(ctx context.Context, teamID, userID string, menu *Menu) error {
+	if _, err := uuid.Parse(teamID); err != nil {
+		return db.ErrInvalidID
 	}
 
-	if count, err := updateResult.RowsAffected(); err != nil || count == 0 {
-		return errors.Wrapf(err, "error on getting rows updated")
+	var poll struct {
+		Member bool `db:"member"`
+		InPoll bool `db:"in_poll"`
 	}
-	return nil
-}
-
-//
-func isRestaurantOwner(restaurant *Restaurant, claims jwt.MapClaims) bool {
-	if restaurant == nil {
-		return false
+	const q = `SELECT
+	        EXISTS (SELECT 1 FROM team_member m JOIN team t ON t.team_id = m.team_id
+	            WHERE m.team_id = $1 AND m.user_id = $2 AND t.org_id = $3 AND t.deleted_at IS NULL) AS member,
+	        NOT EXISTS (SELECT 1 FROM team_restaurant WHERE team_id = $1)
+	            OR EXISTS (SELECT 1 FROM team_restaurant WHERE team_id = $1 AND restaurant_id = $4) AS in_poll`
+	if err := r.db.GetContext(ctx, &poll, q, teamID, userID, menu.OrgID, menu.RestaurantID); err != nil {
+		return errors.Wrapf(err, "selecting team %q poll", teamID)
 	}
 
-	return restaurant.OwnerUserID == claims["sub"].(string)
+	switch {
+	case !poll.Member:
+		return ErrNotTeamMember
+	case !poll.InPoll:
+		return ErrNotInTeamPoll
+	}
+	return nil
 }
 
-// MenuUpdate is used to update existing on create new menu for selected restaurant on specified date.
-func (r *Repo) MenuUpdate(ctx context.Context, claims jwt.MapClaims, dbx *sqlx.DB, um UpdateMenu) (*Menu, error) {
-
-	restaurant, err := r.GetRestaurant(ctx, um.RestaurantID)
+func txMenuVote(ctx context.Context, tx *db.Tx, menu *Menu, userID string, team sql.NullString, date time.Time, rating int) error {
+	voteRating := sql.NullInt32{Int32: int32(rating), Valid: rating > 0}
+	const qInsertVote = `INSERT INTO vote (date, user_id, restaurant_id, time_voted, rating, org_id, team_id)
+	    VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	voteResult, err := tx.ExecContext(ctx, qInsertVote, date, userID, menu.RestaurantID, date, voteRating, menu.OrgID, team)
 	if err != nil {
-		return nil, errors.Wrap(db.ErrNotFound, "unable to find restaurant with restaurantID: "+um.RestaurantID)
+		return errors.Wrap(err, "inserting restaurant")
+	}
+	count, err := voteResult.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "error on getting rows updated")
+	}
+	if count < 1 {
+		return errors.New("vote is not inserted")
 	}
 
-	if restaurant == nil {
-		return nil, err
+	// menu votes are counted for the organization poll, team votes are counted by MenuVotes
+	if team.Valid {
+		return nil
 	}
 
-	// does the menu exist for specified date
-	menu, err := r.RetrieveMenu(ctx, um.ID)
+	const qUpdateMenuVote = `UPDATE menu SET votes = votes + 1, version = version + 1
+	    WHERE menu_id = $1 AND org_id = $2`
+	updateResult, err := tx.Exec(qUpdateMenuVote, menu.ID, menu.OrgID)
 	if err != nil {
-		return nil, err
+		return errors.Wrapf(err, "error on menu vote update")
 	}
 
-	// user permission check
-
-	roles := claims["roles"].(string)
-	admin := hasRole(roles, auth.RoleAdmin)
-
-	//admin :=  claims.HasRole(auth.RoleAdmin)
-	owner := isRestaurantOwner(restaurant, claims)
-
-	if !owner || !admin {
-		return nil, db.ErrForbidden
+	if count, err = updateResult.RowsAffected(); err != nil {
+		return errors.Wrap(err, "error on getting rows updated")
 	}
-
-	// only restaurant owner or admin users can perform menu update
-
-	if menu == nil {
-		return r.CreateRestaurantMenu(ctx, um)
+	if count == 0 {
+		return db.ErrNotFound
 	}
-	return menu, nil
+	return nil
 }
 
 // CreateRestaurantMenu is used to create new menu for selected restaurant on specified date.
@@ -233,45 +357,77 @@ func (r *Repo) CreateRestaurantMenu(ctx context.Context, um UpdateMenu) (*Menu,
 	}
 
 	if menu != nil {
-		if um.ID == "" {
-			um.ID = menu.ID
-		}
-		return r.updateRestaurantMenu(ctx, um)
+		return nil, ErrMenuExists
 	}
 
 	return r.insertRestaurantMenu(ctx, um)
 }
 
-func (r *Repo) updateRestaurantMenu(ctx context.Context, um UpdateMenu) (*Menu, error) {
+// ReplaceMenu updates content and date of the existing restaurant menu. Update is
+// performed only when passed version matches the stored one, otherwise
+// db.ErrVersionConflict is returned. Menus of soft deleted restaurants are not
+// updated, ErrRestaurantNotFound is returned.
+func (r *Repo) ReplaceMenu(ctx context.Context, um UpdateMenu, version int) (*Menu, error) {
+	if _, err := r.GetRestaurant(ctx, um.RestaurantID); err != nil {
+		return nil, err
+	}
 
-	const qUpdate = `UPDATE menu SET
-	    menu =  $1, date = $2
-	    WHERE menu_id = $3`
+	menu, err := r.RetrieveRestaurantMenus(ctx, um.RestaurantID, um.ID)
+	if err != nil {
+		return nil, err
+	}
 
-	result, err := r.db.ExecContext(ctx, qUpdate, um.Menu, um.Date, um.ID)
+	if menu.Version != version {
+		return nil, db.ErrVersionConflict
+	}
+
+	if um.Menu != "" {
+		menu.Menu = um.Menu
+	}
+	if !um.Date.IsZero() {
+		menu.Date = um.Date
+	}
+	if um.Tags != nil {
+		menu.Tags = menuTags(um.Tags)
+	}
+
+	const q = `UPDATE menu SET
+	    menu = $2, date = $3, tags = $4, version = version + 1
+	    WHERE menu_id = $1 AND restaurant_id = $7 AND version = $5 AND org_id = $6
+	    AND EXISTS (SELECT 1 FROM restaurant WHERE restaurant_id = menu.restaurant_id AND deleted_at IS NULL)`
+	result, err := r.db.ExecContext(ctx, q, menu.ID, menu.Menu, menu.Date, pq.Array(menu.Tags), version, menu.OrgID, um.RestaurantID)
 	if err != nil {
 		return nil, errors.Wrap(err, "updating menu")
 	}
 
-	updateCount, err := result.RowsAffected()
+	count, err := result.RowsAffected()
 	if err != nil {
 		return nil, errors.Wrap(err, "updated count")
 	}
-	if updateCount == 0 {
-		return nil, errors.New("no updates done")
+	if count == 0 {
+		return nil, db.ErrVersionConflict
 	}
 
-	return r.RetrieveMenu(ctx, um.ID)
+	menu.Version++
+	return menu, nil
 }
 
 func (r *Repo) insertRestaurantMenu(ctx context.Context, um UpdateMenu) (*Menu, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	if um.ID == "" {
 		um.ID = uuid.New().String()
 	}
+	tags := menuTags(um.Tags)
+	// menu is inserted only when restaurant belongs to the same organization
 	const qInsert = `INSERT INTO menu 
-	(menu_id, restaurant_id, date, menu, votes)
-	VALUES ($1, $2, $3, $4, $5)`
-	menuResult, err := r.db.ExecContext(ctx, qInsert, um.ID, um.RestaurantID, um.Date, um.Menu, 0)
+	(menu_id, restaurant_id, date, menu, votes, tags, org_id)
+	SELECT $1::uuid, restaurant_id, $3::date, $4, $5::integer, $6::text[], org_id FROM restaurant
+	WHERE restaurant_id = $2 AND org_id = $7`
+	menuResult, err := r.db.ExecContext(ctx, qInsert, um.ID, um.RestaurantID, um.Date, um.Menu, 0, pq.Array(tags), orgID)
 	if err != nil {
 		return nil, errors.Wrap(err, "inserting menu")
 	}
@@ -282,7 +438,7 @@ func (r *Repo) insertRestaurantMenu(ctx context.Context, um UpdateMenu) (*Menu,
 	}
 
 	if count == 0 {
-		return nil, errors.New("failed to save new menu")
+		return nil, ErrRestaurantNotFound
 	}
 
 	menu := Menu{
@@ -291,18 +447,24 @@ func (r *Repo) insertRestaurantMenu(ctx context.Context, um UpdateMenu) (*Menu,
 		Date:         um.Date,
 		Menu:         um.Menu,
 		Votes:        0,
+		Tags:         tags,
+		OrgID:        orgID,
+		Version:      1,
 	}
 	return &menu, nil
 }
 
-func hasRole(rolesString string, roles ...string) bool {
-	r := strings.Split(rolesString, " ")
-	for _, has := range r {
-		for _, want := range roles {
-			if has == want {
-				return true
-			}
+// menuTags normalizes menu tags to lower case without duplicates.
+func menuTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
 		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
 	}
-	return false
+	return normalized
 }
@@ -1,17 +1,22 @@
 package restaurant
 
 import (
+	"github.com/lib/pq"
 	"time"
 )
 
 // Restaurant entity stored in DB
 type Restaurant struct {
-	ID          string    `db:"restaurant_id" json:"id"`
-	Name        string    `db:"name" json:"name"`
-	Address     string    `db:"address" json:"address"`
-	OwnerUserID string    `db:"owner_user_id" json:"ownerUserId"`
-	DateCreated time.Time `db:"date_created" json:"dateCreated"`
-	DateUpdated time.Time `db:"date_updated" json:"dateUpdated"`
+	ID          string     `db:"restaurant_id" json:"id"`
+	Name        string     `db:"name" json:"name"`
+	Address     string     `db:"address" json:"address"`
+	OwnerUserID string     `db:"owner_user_id" json:"ownerUserId"`
+	OrgID       string     `db:"org_id" json:"orgId"`
+	OfficeID    *string    `db:"office_id" json:"officeId,omitempty"`
+	DateCreated time.Time  `db:"date_created" json:"dateCreated"`
+	DateUpdated time.Time  `db:"date_updated" json:"dateUpdated"`
+	DeletedAt   *time.Time `db:"deleted_at" json:"deletedAt,omitempty"`
+	Version     int        `db:"version" json:"-"`
 }
 
 // NewRestaurant is what we require from clients when adding a Restaurant.
@@ -19,6 +24,7 @@ type NewRestaurant struct {
 	Name    string `json:"name" validate:"required"`
 	Address string `json:"address" validate:"required"`
 	//OwnerUserID string `json:"owner_user_id" validate:"required"`
+	OfficeID *string `json:"officeId"`
 }
 
 // UpdateRestaurant defines what information may be provided to modify an
@@ -28,17 +34,21 @@ type NewRestaurant struct {
 // explicitly blank. Normally we do not want to use pointers to basic types but
 // we make exceptions around marshalling/unmarshalling.
 type UpdateRestaurant struct {
-	Name    *string `json:"name"`
-	Address *string `json:"address"`
+	Name     *string `json:"name"`
+	Address  *string `json:"address"`
+	OfficeID *string `json:"officeId"`
 }
 
 // Menu defines and entity stored in DB.
 type Menu struct {
-	ID           string    `db:"menu_id" json:"id"`
-	RestaurantID string    `db:"restaurant_id" json:"restaurantId"`
-	Date         time.Time `db:"date" json:"date"`
-	Menu         string    `db:"menu" json:"menu"`
-	Votes        int       `db:"votes" json:"votes"`
+	ID           string         `db:"menu_id" json:"id"`
+	RestaurantID string         `db:"restaurant_id" json:"restaurantId"`
+	Date         time.Time      `db:"date" json:"date"`
+	Menu         string         `db:"menu" json:"menu"`
+	Votes        int            `db:"votes" json:"votes"`
+	Tags         pq.StringArray `db:"tags" json:"tags"`
+	OrgID        string         `db:"org_id" json:"orgId"`
+	Version      int            `db:"version" json:"-"`
 }
 
 // UpdateMenu used as an incoming http data to perform menu update or menu create
@@ -47,4 +57,93 @@ type UpdateMenu struct {
 	RestaurantID string    `db:"restaurant_id" json:"restaurantId"`
 	Menu         string    `db:"menu" json:"menu"`
 	Date         time.Time `db:"date" json:"date"`
+	Tags         []string  `db:"tags" json:"tags"`
+}
+
+// PurgeReport describes what a hard purge of a restaurant removes from the database.
+type PurgeReport struct {
+	Restaurant Restaurant `json:"restaurant"`
+	Menus      int        `json:"menus"`
+	Votes      int        `json:"votes"`
+}
+
+// These are the expected values for Member.Role.
+const (
+	MemberOwner  = "owner"
+	MemberEditor = "editor"
+)
+
+// Member is a user allowed to manage restaurant.
+type Member struct {
+	RestaurantID string    `db:"restaurant_id" json:"restaurantId"`
+	UserID       string    `db:"user_id" json:"userId"`
+	Name         string    `db:"name" json:"name"`
+	Email        string    `db:"email" json:"email"`
+	Role         string    `db:"role" json:"role"`
+	DateCreated  time.Time `db:"date_created" json:"dateCreated"`
+}
+
+// Redacted returns the member without name and email of the user, it is stored
+// in audit log snapshots.
+func (m Member) Redacted() interface{} {
+	m.Name, m.Email = "", ""
+	return m
+}
+
+// NewMember is what we require from clients when inviting restaurant member.
+type NewMember struct {
+	Email string `json:"email" validate:"required"`
+	Role  string `json:"role"`
+}
+
+// OwnershipTransfer is a pending transfer of restaurant ownership to another user.
+type OwnershipTransfer struct {
+	RestaurantID string    `db:"restaurant_id" json:"restaurantId"`
+	FromUserID   string    `db:"from_user_id" json:"fromUserId"`
+	ToUserID     string    `db:"to_user_id" json:"toUserId"`
+	DateCreated  time.Time `db:"date_created" json:"dateCreated"`
+}
+
+// These are the expected values for Suggestion.Status.
+const (
+	SuggestionPending  = "pending"
+	SuggestionApproved = "approved"
+	SuggestionRejected = "rejected"
+)
+
+// Suggestion is a restaurant proposed by an employee and waiting for admin approval.
+type Suggestion struct {
+	ID           string    `db:"suggestion_id" json:"id"`
+	Name         string    `db:"name" json:"name"`
+	Address      string    `db:"address" json:"address"`
+	Comment      string    `db:"comment" json:"comment"`
+	SuggestedBy  string    `db:"suggested_by" json:"suggestedBy"`
+	Status       string    `db:"status" json:"status"`
+	RejectReason *string   `db:"reject_reason" json:"rejectReason,omitempty"`
+	RestaurantID *string   `db:"restaurant_id" json:"restaurantId,omitempty"`
+	Upvotes      int       `db:"upvotes" json:"upvotes"`
+	OrgID        string    `db:"org_id" json:"orgId"`
+	DateCreated  time.Time `db:"date_created" json:"dateCreated"`
+	DateUpdated  time.Time `db:"date_updated" json:"dateUpdated"`
+}
+
+// NewSuggestion is what we require from clients when suggesting a Restaurant.
+type NewSuggestion struct {
+	Name    string `json:"name" validate:"required"`
+	Address string `json:"address" validate:"required"`
+	Comment string `json:"comment"`
+}
+
+// UserVote is a vote of the user joined with voted restaurant and its menu of
+// the vote day. Menu fields are empty when restaurant had no menu on that day,
+// team is empty for votes of the organization poll.
+type UserVote struct {
+	Date           time.Time `db:"date" json:"date"`
+	TimeVoted      time.Time `db:"time_voted" json:"timeVoted"`
+	Rating         *int      `db:"rating" json:"rating,omitempty"`
+	TeamID         *string   `db:"team_id" json:"teamId,omitempty"`
+	RestaurantID   string    `db:"restaurant_id" json:"restaurantId"`
+	RestaurantName string    `db:"restaurant_name" json:"restaurantName"`
+	MenuID         *string   `db:"menu_id" json:"menuId,omitempty"`
+	Menu           *string   `db:"menu" json:"menu,omitempty"`
 }
@@ -7,33 +7,45 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/org"
 	"time"
 )
 
 const (
 	pageSize   = 10
-	queryPaged = `SELECT * FROM restaurant OFFSET $1 LIMIT $2`
-	queryAll   = `SELECT * FROM restaurant`
+	queryPaged = `SELECT * FROM restaurant WHERE deleted_at IS NULL AND org_id = $1 OFFSET $2 LIMIT $3`
+	queryAll   = `SELECT * FROM restaurant WHERE deleted_at IS NULL AND org_id = $1`
 )
 
-// ErrRestaurantNotFound returned when restaurant is not found
-var ErrRestaurantNotFound = errors.New("Restaurant not found")
+var (
+	// ErrRestaurantNotFound returned when restaurant is not found
+	ErrRestaurantNotFound = errors.New("Restaurant not found")
+	// ErrUnknownOffice returned when restaurant office is not found in the organization
+	ErrUnknownOffice = errors.New("restaurant office not found")
+)
 
-// Repo is a restaurant Repository structure.
+// Repo is a restaurant Repository structure. Queries are scoped to the
+// organization stored in the context, org.ErrNoTenant is returned without it.
 type Repo struct {
-	db *sqlx.DB
+	db db.DB
 }
 
 // NewRepo is a factory function used to create new restaurant Repository.
-func NewRepo(db *sqlx.DB) *Repo {
-	return &Repo{db}
+func NewRepo(dbx *sqlx.DB) *Repo {
+	return &Repo{db.Wrap(dbx)}
 }
 
 // GetRestaurantsPaged retrieves a list of existing restaurants from the database with pagination.
 func (r *Repo) GetRestaurantsPaged(ctx context.Context, page int) ([]Restaurant, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	offset := pageSize * (page - 1)
 	restaurants := make([]Restaurant, 0)
-	err := r.db.SelectContext(ctx, &restaurants, queryPaged, offset, pageSize)
+	err = r.db.SelectContext(ctx, &restaurants, queryPaged, orgID, offset, pageSize)
 	if err != nil {
 		return nil, errors.Wrap(err, "selecting restaurants")
 	}
@@ -42,24 +54,33 @@ func (r *Repo) GetRestaurantsPaged(ctx context.Context, page int) ([]Restaurant,
 
 // GetRestaurants retrieves a list of existing restaurants from the database.
 func (r *Repo) GetRestaurants(ctx context.Context) ([]Restaurant, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	restaurants := make([]Restaurant, 0)
-	const q = `SELECT * FROM restaurant`
-	if err := r.db.SelectContext(ctx, &restaurants, q); err != nil {
+	if err := r.db.SelectContext(ctx, &restaurants, queryAll, orgID); err != nil {
 		return nil, errors.Wrap(err, "selecting restaurants")
 	}
 	return restaurants, nil
 }
 
-// GetRestaurant gets the specified user from the database.
+// GetRestaurant gets the specified restaurant from the database.
+// Soft deleted restaurants are reported as not found.
 func (r *Repo) GetRestaurant(ctx context.Context, restaurantID string) (*Restaurant, error) {
 	if _, err := uuid.Parse(restaurantID); err != nil {
 		return nil, db.ErrInvalidID
 	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	var restaurant Restaurant
 
-	const q = `SELECT * FROM restaurant WHERE restaurant_id = $1`
-	if err := r.db.GetContext(ctx, &restaurant, q, restaurantID); err != nil {
+	const q = `SELECT * FROM restaurant WHERE restaurant_id = $1 AND org_id = $2 AND deleted_at IS NULL`
+	if err := r.db.GetContext(ctx, &restaurant, q, restaurantID, orgID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrRestaurantNotFound
 		}
@@ -69,12 +90,17 @@ func (r *Repo) GetRestaurant(ctx context.Context, restaurantID string) (*Restaur
 	return &restaurant, nil
 }
 
-// RetrieveRestaurantList retrieves list of restaurants from database
-func (r *Repo) RetrieveRestaurantList(ctx context.Context) ([]Restaurant, error) {
+// RetrieveRestaurantList retrieves list of restaurants from database. Only
+// restaurants of the office catalog are listed when officeID is passed.
+func (r *Repo) RetrieveRestaurantList(ctx context.Context, officeID string) ([]Restaurant, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	restaurants := make([]Restaurant, 0)
-	const q = `SELECT * FROM restaurant`
-	if err := r.db.SelectContext(ctx, &restaurants, q); err != nil {
+	const q = queryAll + ` AND ($2::uuid IS NULL OR office_id = $2::uuid)`
+	if err := r.db.SelectContext(ctx, &restaurants, q, orgID, nullID(officeID)); err != nil {
 		return nil, errors.Wrap(err, "selecting restaurants")
 	}
 	return restaurants, nil
@@ -83,38 +109,276 @@ func (r *Repo) RetrieveRestaurantList(ctx context.Context) ([]Restaurant, error)
 // CreateRestaurant inserts new restaurant into the database.
 //func CreateRestaurant(ctx context.Context, claims auth.Claims, db *sqlx.DB, nr NewRestaurant, now time.Time) (*Restaurant, error) {
 func (r *Repo) CreateRestaurant(ctx context.Context, nr NewRestaurant, now time.Time, userID string) (*Restaurant, error) {
+	rest, err := r.newRestaurant(ctx, nr, now, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := txInsertRestaurant(ctx, tx, rest); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit restaurant")
+	}
+
+	return rest, nil
+}
+
+// newRestaurant returns restaurant of the context organization owned by the user,
+// restaurant is not stored.
+func (r *Repo) newRestaurant(ctx context.Context, nr NewRestaurant, now time.Time, userID string) (*Restaurant, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	officeID, err := r.checkOffice(ctx, orgID, nr.OfficeID)
+	if err != nil {
+		return nil, err
+	}
+
 	currentTime := now.UTC()
-	rest := Restaurant{
+	return &Restaurant{
 		ID:          uuid.New().String(),
 		Name:        nr.Name,
 		Address:     nr.Address,
 		OwnerUserID: userID,
+		OrgID:       orgID,
+		OfficeID:    officeID,
 		DateCreated: currentTime,
 		DateUpdated: currentTime,
-	}
+		Version:     1,
+	}, nil
+}
 
+// txInsertRestaurant inserts restaurant together with its owner member within the transaction.
+func txInsertRestaurant(ctx context.Context, tx *db.Tx, rest *Restaurant) error {
 	const q = `INSERT INTO restaurant
-	    (restaurant_id, name, address, owner_user_id, date_created, date_updated)
-	    VALUES ($1, $2, $3, $4, $5, $6)`
+	    (restaurant_id, name, address, owner_user_id, org_id, office_id, date_created, date_updated)
+	    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := tx.ExecContext(ctx, q, rest.ID, rest.Name, rest.Address, rest.OwnerUserID, rest.OrgID, rest.OfficeID,
+		rest.DateCreated, rest.DateUpdated)
+	if err != nil {
+		return errors.Wrap(err, "inserting restaurant")
+	}
+
+	const qMember = `INSERT INTO restaurant_member
+	    (restaurant_id, user_id, role, date_created)
+	    VALUES ($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, qMember, rest.ID, rest.OwnerUserID, MemberOwner, rest.DateCreated)
+	if err != nil {
+		return errors.Wrap(err, "inserting restaurant owner")
+	}
+	return nil
+}
+
+// UpdateRestaurant modifies the specified restaurant. Update is performed only
+// when passed version matches the stored one, otherwise db.ErrVersionConflict is returned.
+func (r *Repo) UpdateRestaurant(ctx context.Context, restaurantID string, ur UpdateRestaurant, version int, now time.Time) (*Restaurant, error) {
+	rest, err := r.GetRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	if rest.Version != version {
+		return nil, db.ErrVersionConflict
+	}
+
+	if ur.Name != nil {
+		rest.Name = *ur.Name
+	}
+	if ur.Address != nil {
+		rest.Address = *ur.Address
+	}
+	if ur.OfficeID != nil {
+		rest.OfficeID, err = r.checkOffice(ctx, rest.OrgID, ur.OfficeID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rest.DateUpdated = now.UTC()
+
+	const q = `UPDATE restaurant SET
+	    name = $2, address = $3, office_id = $7, date_updated = $4, version = version + 1
+	    WHERE restaurant_id = $1 AND version = $5 AND org_id = $6 AND deleted_at IS NULL`
+	result, err := r.db.ExecContext(ctx, q, rest.ID, rest.Name, rest.Address, rest.DateUpdated, version, rest.OrgID,
+		rest.OfficeID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "updating restaurant %s", restaurantID)
+	}
 
-	_, err := r.db.ExecContext(ctx, q, rest.ID, rest.Name, rest.Address, rest.OwnerUserID, rest.DateCreated, rest.DateUpdated)
+	count, err := result.RowsAffected()
 	if err != nil {
-		return nil, errors.Wrap(err, "inserting restaurant")
+		return nil, errors.Wrap(err, "updated count")
+	}
+	if count == 0 {
+		return nil, db.ErrVersionConflict
 	}
 
-	return &rest, nil
+	rest.Version++
+	return rest, nil
 }
 
-// DeleteRestaurant removes a restaurant from the database.
-func (r *Repo) DeleteRestaurant(ctx context.Context, restaurantID string) error {
-	if _, err := uuid.Parse(restaurantID); err != nil {
-		return db.ErrInvalidID
+// DeleteRestaurant soft deletes a restaurant. The restaurant and its menus
+// from the deletion day onwards are hidden, historical menus and votes are kept.
+// Delete is performed only when passed version matches the stored one.
+func (r *Repo) DeleteRestaurant(ctx context.Context, restaurantID string, version int, now time.Time) error {
+	rest, err := r.GetRestaurant(ctx, restaurantID)
+	if err != nil {
+		return err
+	}
+
+	if rest.Version != version {
+		return db.ErrVersionConflict
 	}
 
-	const q = `DELETE FROM restaurant WHERE restaurant_id = $1`
-	if _, err := r.db.ExecContext(ctx, q, restaurantID); err != nil {
+	const q = `UPDATE restaurant SET
+	    deleted_at = $2, date_updated = $2, version = version + 1
+	    WHERE restaurant_id = $1 AND version = $3 AND org_id = $4 AND deleted_at IS NULL`
+	result, err := r.db.ExecContext(ctx, q, restaurantID, now.UTC(), version, rest.OrgID)
+	if err != nil {
 		return errors.Wrapf(err, "deleting restaurant %s", restaurantID)
 	}
 
+	count, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "deleted count")
+	}
+	if count == 0 {
+		return db.ErrVersionConflict
+	}
+
 	return nil
 }
+
+// RestoreRestaurant reverts soft delete of the specified restaurant.
+func (r *Repo) RestoreRestaurant(ctx context.Context, restaurantID string, now time.Time) (*Restaurant, error) {
+	if _, err := uuid.Parse(restaurantID); err != nil {
+		return nil, db.ErrInvalidID
+	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `UPDATE restaurant SET
+	    deleted_at = NULL, date_updated = $2, version = version + 1
+	    WHERE restaurant_id = $1 AND org_id = $3 AND deleted_at IS NOT NULL`
+	result, err := r.db.ExecContext(ctx, q, restaurantID, now.UTC(), orgID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "restoring restaurant %s", restaurantID)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, "restored count")
+	}
+	if count == 0 {
+		return nil, ErrRestaurantNotFound
+	}
+
+	return r.GetRestaurant(ctx, restaurantID)
+}
+
+// PurgeReport returns what PurgeRestaurant is going to remove for the specified
+// restaurant. Soft deleted restaurants are included.
+func (r *Repo) PurgeReport(ctx context.Context, restaurantID string) (*PurgeReport, error) {
+	if _, err := uuid.Parse(restaurantID); err != nil {
+		return nil, db.ErrInvalidID
+	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var report PurgeReport
+	const qRestaurant = `SELECT * FROM restaurant WHERE restaurant_id = $1 AND org_id = $2`
+	if err := r.db.GetContext(ctx, &report.Restaurant, qRestaurant, restaurantID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRestaurantNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting restaurant %q", restaurantID)
+	}
+
+	const qMenus = `SELECT COUNT(*) FROM menu WHERE restaurant_id = $1 AND org_id = $2`
+	if err := r.db.GetContext(ctx, &report.Menus, qMenus, restaurantID, orgID); err != nil {
+		return nil, errors.Wrap(err, "counting restaurant menus")
+	}
+
+	const qVotes = `SELECT COUNT(*) FROM vote WHERE restaurant_id = $1 AND org_id = $2`
+	if err := r.db.GetContext(ctx, &report.Votes, qVotes, restaurantID, orgID); err != nil {
+		return nil, errors.Wrap(err, "counting restaurant votes")
+	}
+
+	return &report, nil
+}
+
+// PurgeRestaurant permanently removes a restaurant together with its menus and
+// votes from the database.
+func (r *Repo) PurgeRestaurant(ctx context.Context, restaurantID string) error {
+	if _, err := uuid.Parse(restaurantID); err != nil {
+		return db.ErrInvalidID
+	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// restaurant is removed last so members and transfer are checked against its organization
+	const inOrg = `restaurant_id IN (SELECT restaurant_id FROM restaurant WHERE restaurant_id = $1 AND org_id = $2)`
+	queries := []string{
+		`DELETE FROM vote WHERE restaurant_id = $1 AND org_id = $2`,
+		`DELETE FROM menu WHERE restaurant_id = $1 AND org_id = $2`,
+		`DELETE FROM restaurant_transfer WHERE ` + inOrg,
+		`DELETE FROM restaurant_member WHERE ` + inOrg,
+		`DELETE FROM restaurant WHERE restaurant_id = $1 AND org_id = $2`,
+	}
+	for _, q := range queries {
+		if _, err := tx.ExecContext(ctx, q, restaurantID, orgID); err != nil {
+			if errT := tx.Rollback(); errT != nil {
+				log.Sugar.Errorf("error on tx rollback, error: %s", errT)
+			}
+			return errors.Wrapf(err, "purging restaurant %s", restaurantID)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// checkOffice verifies that office of the restaurant catalog belongs to the
+// organization. Nil is returned for missing or empty office.
+func (r *Repo) checkOffice(ctx context.Context, orgID string, officeID *string) (*string, error) {
+	if officeID == nil || *officeID == "" {
+		return nil, nil
+	}
+	if _, err := uuid.Parse(*officeID); err != nil {
+		return nil, ErrUnknownOffice
+	}
+
+	var exists bool
+	const q = `SELECT EXISTS (SELECT 1 FROM office WHERE office_id = $1 AND org_id = $2)`
+	if err := r.db.GetContext(ctx, &exists, q, *officeID, orgID); err != nil {
+		return nil, errors.Wrapf(err, "selecting office %q", *officeID)
+	}
+	if !exists {
+		return nil, ErrUnknownOffice
+	}
+	return officeID, nil
+}
+
+// nullID returns NULL for the empty ID so optional filters are not applied.
+func nullID(id string) sql.NullString {
+	return sql.NullString{String: id, Valid: id != ""}
+}
@@ -0,0 +1,151 @@
+package restaurant
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// DayVotes is a count of restaurant votes on a single day.
+type DayVotes struct {
+	Date  time.Time `db:"day" json:"date"`
+	Votes int       `db:"votes" json:"votes"`
+}
+
+// TagStats describes how menus with a dietary tag perform. Lift is the ratio of
+// average votes of menus with the tag to average votes of all restaurant menus,
+// values above 1 mean the tag correlates with more votes.
+type TagStats struct {
+	Tag      string  `db:"tag" json:"tag"`
+	Menus    int     `db:"menus" json:"menus"`
+	Votes    int     `db:"votes" json:"votes"`
+	AvgVotes float64 `db:"avg_votes" json:"avgVotes"`
+	Lift     float64 `db:"-" json:"lift"`
+}
+
+// Stats is a restaurant performance summary for the specified period. Active days
+// are days with restaurant menu or votes, win rate is a share of active days won.
+type Stats struct {
+	RestaurantID  string     `json:"restaurantId"`
+	From          time.Time  `json:"from"`
+	To            time.Time  `json:"to"`
+	Votes         int        `json:"votes"`
+	VotesPerDay   []DayVotes `json:"votesPerDay"`
+	Menus         int        `json:"menus"`
+	ActiveDays    int        `json:"activeDays"`
+	DaysWon       int        `json:"daysWon"`
+	WinRate       float64    `json:"winRate"`
+	Ratings       int        `json:"ratings"`
+	AverageRating *float64   `json:"averageRating"`
+	Tags          []TagStats `json:"tags"`
+}
+
+// RestaurantStats calculates restaurant statistics for the period from the from
+// day till the to day inclusive.
+func (r *Repo) RestaurantStats(ctx context.Context, restaurantID string, from, to time.Time) (*Stats, error) {
+	rest, err := r.GetRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	from = truncateDay(from)
+	to = truncateDay(to)
+	until := to.AddDate(0, 0, 1)
+
+	stats := Stats{
+		RestaurantID: restaurantID,
+		From:         from,
+		To:           to,
+		VotesPerDay:  make([]DayVotes, 0),
+		Tags:         make([]TagStats, 0),
+	}
+
+	const qVotesPerDay = `SELECT date::date AS day, COUNT(*) AS votes FROM vote
+	    WHERE restaurant_id = $1 AND date >= $2 AND date < $3 AND org_id = $4
+	    GROUP BY day ORDER BY day`
+	if err := r.db.SelectContext(ctx, &stats.VotesPerDay, qVotesPerDay, restaurantID, from, until, rest.OrgID); err != nil {
+		return nil, errors.Wrap(err, "selecting votes per day")
+	}
+	for _, day := range stats.VotesPerDay {
+		stats.Votes += day.Votes
+	}
+
+	// a day is won when restaurant has the most votes of that day in its organization, ties included
+	const qWins = `WITH daily AS (
+	        SELECT date::date AS day, restaurant_id, COUNT(*) AS votes FROM vote
+	        WHERE date >= $2 AND date < $3 AND org_id = $4
+	        GROUP BY day, restaurant_id
+	    ), ranked AS (
+	        SELECT restaurant_id, RANK() OVER (PARTITION BY day ORDER BY votes DESC) AS place
+	        FROM daily
+	    )
+	    SELECT
+	        (SELECT COUNT(*) FROM (
+	            SELECT date FROM menu WHERE restaurant_id = $1 AND date >= $2 AND date < $3 AND org_id = $4
+	            UNION
+	            SELECT day FROM daily WHERE restaurant_id = $1
+	        ) days) AS active_days,
+	        (SELECT COUNT(*) FROM ranked WHERE restaurant_id = $1 AND place = 1) AS days_won`
+	row := r.db.QueryRowxContext(ctx, qWins, restaurantID, from, until, rest.OrgID)
+	if err := row.Scan(&stats.ActiveDays, &stats.DaysWon); err != nil {
+		return nil, errors.Wrap(err, "selecting days won")
+	}
+	if stats.ActiveDays > 0 {
+		stats.WinRate = float64(stats.DaysWon) / float64(stats.ActiveDays)
+	}
+
+	var avgRating sql.NullFloat64
+	const qRating = `SELECT COUNT(rating), AVG(rating) FROM vote
+	    WHERE restaurant_id = $1 AND date >= $2 AND date < $3 AND org_id = $4`
+	row = r.db.QueryRowxContext(ctx, qRating, restaurantID, from, until, rest.OrgID)
+	if err := row.Scan(&stats.Ratings, &avgRating); err != nil {
+		return nil, errors.Wrap(err, "selecting average rating")
+	}
+	if avgRating.Valid {
+		stats.AverageRating = &avgRating.Float64
+	}
+
+	const qDayVotes = `WITH day_votes AS (
+	        SELECT date::date AS day, COUNT(*) AS votes FROM vote
+	        WHERE restaurant_id = $1 AND date >= $2 AND date < $3 AND org_id = $4
+	        GROUP BY day
+	    )`
+
+	var avgVotes sql.NullFloat64
+	const qMenus = qDayVotes + `
+	    SELECT COUNT(*), AVG(COALESCE(v.votes, 0))::float
+	    FROM menu m
+	    LEFT JOIN day_votes v ON v.day = m.date
+	    WHERE m.restaurant_id = $1 AND m.date >= $2 AND m.date < $3 AND m.org_id = $4`
+	row = r.db.QueryRowxContext(ctx, qMenus, restaurantID, from, until, rest.OrgID)
+	if err := row.Scan(&stats.Menus, &avgVotes); err != nil {
+		return nil, errors.Wrap(err, "selecting menu votes")
+	}
+
+	const qTags = qDayVotes + `
+	    SELECT t.tag, COUNT(*) AS menus,
+	        COALESCE(SUM(v.votes), 0) AS votes,
+	        AVG(COALESCE(v.votes, 0))::float AS avg_votes
+	    FROM menu m
+	    CROSS JOIN LATERAL unnest(m.tags) AS t(tag)
+	    LEFT JOIN day_votes v ON v.day = m.date
+	    WHERE m.restaurant_id = $1 AND m.date >= $2 AND m.date < $3 AND m.org_id = $4
+	    GROUP BY t.tag
+	    ORDER BY avg_votes DESC, t.tag`
+	if err := r.db.SelectContext(ctx, &stats.Tags, qTags, restaurantID, from, until, rest.OrgID); err != nil {
+		return nil, errors.Wrap(err, "selecting tag statistics")
+	}
+
+	if avgVotes.Valid && avgVotes.Float64 > 0 {
+		for i := range stats.Tags {
+			stats.Tags[i].Lift = stats.Tags[i].AvgVotes / avgVotes.Float64
+		}
+	}
+
+	return &stats, nil
+}
+
+func truncateDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
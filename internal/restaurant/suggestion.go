@@ -0,0 +1,203 @@
+package restaurant
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/org"
+	"time"
+)
+
+var (
+	// ErrSuggestionNotFound returned when restaurant suggestion is not found
+	ErrSuggestionNotFound = errors.New("restaurant suggestion not found")
+	// ErrSuggestionClosed returned when approved or rejected suggestion is changed
+	ErrSuggestionClosed = errors.New("restaurant suggestion is already closed")
+	// ErrAlreadyUpvoted returned when user upvotes the same suggestion second time
+	ErrAlreadyUpvoted = errors.New("user has already upvoted this suggestion")
+)
+
+const querySuggestions = `SELECT s.*,
+	    (SELECT COUNT(*) FROM suggestion_vote v WHERE v.suggestion_id = s.suggestion_id) AS upvotes
+	FROM restaurant_suggestion s`
+
+// RetrieveSuggestions retrieves list of restaurant suggestions with specified status,
+// all suggestions are returned for empty status. The most upvoted suggestions are first.
+func (r *Repo) RetrieveSuggestions(ctx context.Context, status string) ([]Suggestion, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := make([]Suggestion, 0)
+	const q = querySuggestions + `
+	    WHERE ($1 = '' OR s.status = $1) AND s.org_id = $2
+	    ORDER BY upvotes DESC, s.date_created`
+	if err := r.db.SelectContext(ctx, &suggestions, q, status, orgID); err != nil {
+		return nil, errors.Wrap(err, "selecting restaurant suggestions")
+	}
+	return suggestions, nil
+}
+
+// RetrieveSuggestion gets the specified restaurant suggestion from the database.
+func (r *Repo) RetrieveSuggestion(ctx context.Context, suggestionID string) (*Suggestion, error) {
+	if _, err := uuid.Parse(suggestionID); err != nil {
+		return nil, db.ErrInvalidID
+	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Suggestion
+	const q = querySuggestions + ` WHERE s.suggestion_id = $1 AND s.org_id = $2`
+	if err := r.db.GetContext(ctx, &s, q, suggestionID, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSuggestionNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting restaurant suggestion %q", suggestionID)
+	}
+	return &s, nil
+}
+
+// CreateSuggestion inserts new pending restaurant suggestion into the database.
+func (r *Repo) CreateSuggestion(ctx context.Context, ns NewSuggestion, userID string, now time.Time) (*Suggestion, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s := Suggestion{
+		ID:          uuid.New().String(),
+		Name:        ns.Name,
+		Address:     ns.Address,
+		Comment:     ns.Comment,
+		SuggestedBy: userID,
+		Status:      SuggestionPending,
+		OrgID:       orgID,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO restaurant_suggestion
+	    (suggestion_id, name, address, comment, suggested_by, status, org_id, date_created, date_updated)
+	    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err = r.db.ExecContext(ctx, q, s.ID, s.Name, s.Address, s.Comment,
+		s.SuggestedBy, s.Status, s.OrgID, s.DateCreated, s.DateUpdated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting restaurant suggestion")
+	}
+	return &s, nil
+}
+
+// UpvoteSuggestion adds user upvote to the pending restaurant suggestion.
+func (r *Repo) UpvoteSuggestion(ctx context.Context, suggestionID, userID string, now time.Time) (*Suggestion, error) {
+	s, err := r.RetrieveSuggestion(ctx, suggestionID)
+	if err != nil {
+		return nil, err
+	}
+	if s.Status != SuggestionPending {
+		return nil, ErrSuggestionClosed
+	}
+
+	const q = `INSERT INTO suggestion_vote (suggestion_id, user_id, date_created)
+	    VALUES ($1, $2, $3)
+	    ON CONFLICT DO NOTHING`
+	result, err := r.db.ExecContext(ctx, q, suggestionID, userID, now.UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting suggestion vote")
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, "inserted count")
+	}
+	if count == 0 {
+		return nil, ErrAlreadyUpvoted
+	}
+
+	s.Upvotes++
+	return s, nil
+}
+
+// ApproveSuggestion creates restaurant from the pending suggestion. Approving
+// user becomes an owner of the new restaurant. Suggestion is closed and restaurant
+// is created in a single transaction.
+func (r *Repo) ApproveSuggestion(ctx context.Context, suggestionID, userID string, now time.Time) (*Suggestion, *Restaurant, error) {
+	s, err := r.RetrieveSuggestion(ctx, suggestionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.Status != SuggestionPending {
+		return nil, nil, ErrSuggestionClosed
+	}
+
+	rest, err := r.newRestaurant(ctx, NewRestaurant{Name: s.Name, Address: s.Address}, now, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "begin suggestion approval")
+	}
+	defer tx.Rollback()
+
+	// suggestion is closed first so concurrent approvals do not create duplicate restaurants
+	if err := closeSuggestion(ctx, tx, s, SuggestionApproved, nil, &rest.ID, now); err != nil {
+		return nil, nil, err
+	}
+	if err := txInsertRestaurant(ctx, tx, rest); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, errors.Wrap(err, "commit suggestion approval")
+	}
+	return s, rest, nil
+}
+
+// RejectSuggestion closes the pending suggestion with the specified reason.
+func (r *Repo) RejectSuggestion(ctx context.Context, suggestionID, reason string, now time.Time) (*Suggestion, error) {
+	s, err := r.RetrieveSuggestion(ctx, suggestionID)
+	if err != nil {
+		return nil, err
+	}
+	if s.Status != SuggestionPending {
+		return nil, ErrSuggestionClosed
+	}
+
+	if err := closeSuggestion(ctx, r.db, s, SuggestionRejected, &reason, nil, now); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// closeSuggestion changes status of the pending suggestion, ErrSuggestionClosed
+// is returned when suggestion was already closed.
+func closeSuggestion(ctx context.Context, ex sqlx.ExecerContext, s *Suggestion, status string, reason, restaurantID *string, now time.Time) error {
+	const q = `UPDATE restaurant_suggestion SET
+	    status = $2, reject_reason = $3, restaurant_id = $4, date_updated = $5
+	    WHERE suggestion_id = $1 AND status = $6 AND org_id = $7`
+	result, err := ex.ExecContext(ctx, q, s.ID, status, reason, restaurantID, now.UTC(), SuggestionPending, s.OrgID)
+	if err != nil {
+		return errors.Wrapf(err, "updating restaurant suggestion %s", s.ID)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "updated count")
+	}
+	if count == 0 {
+		return ErrSuggestionClosed
+	}
+
+	s.Status = status
+	s.RejectReason = reason
+	s.RestaurantID = restaurantID
+	s.DateUpdated = now.UTC()
+	return nil
+}
@@ -0,0 +1,33 @@
+package restaurant
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/org"
+	"time"
+)
+
+// UserVotes retrieves votes of the user for the period from the from day till
+// the to day inclusive, latest votes first.
+func (r *Repo) UserVotes(ctx context.Context, userID string, from, to time.Time) ([]UserVote, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	from = truncateDay(from)
+	until := truncateDay(to).AddDate(0, 0, 1)
+
+	votes := make([]UserVote, 0)
+	const q = `SELECT v.date, v.time_voted, v.rating, v.team_id, v.restaurant_id, r.name AS restaurant_name,
+	        m.menu_id, m.menu
+	    FROM vote v
+	    JOIN restaurant r ON r.restaurant_id = v.restaurant_id
+	    LEFT JOIN menu m ON m.restaurant_id = v.restaurant_id AND m.date = v.date::date
+	    WHERE v.user_id = $1 AND v.date >= $2 AND v.date < $3 AND v.org_id = $4
+	    ORDER BY v.date DESC`
+	if err := r.db.SelectContext(ctx, &votes, q, userID, from, until, orgID); err != nil {
+		return nil, errors.Wrap(err, "selecting user votes")
+	}
+	return votes, nil
+}
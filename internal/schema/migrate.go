@@ -66,4 +66,352 @@ CREATE TABLE users (
 	date_updated TIMESTAMP,
 	PRIMARY KEY (user_id)
 );`},
+	{
+		Version:     5,
+		Description: "Add restaurant soft delete",
+		Script: `
+ALTER TABLE restaurant ADD COLUMN deleted_at TIMESTAMP;`},
+	{
+		Version:     6,
+		Description: "Add entity versions",
+		Script: `
+ALTER TABLE restaurant ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE menu ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`},
+	{
+		Version:     7,
+		Description: "Add restaurant members",
+		Script: `
+CREATE TABLE restaurant_member (
+	restaurant_id UUID NOT NULL,
+	user_id       UUID NOT NULL,
+	role          TEXT NOT NULL,
+	date_created  TIMESTAMP,
+	PRIMARY KEY (restaurant_id, user_id),
+	FOREIGN KEY (restaurant_id) REFERENCES restaurant(restaurant_id)
+);
+INSERT INTO restaurant_member (restaurant_id, user_id, role, date_created)
+	SELECT restaurant_id, owner_user_id::UUID, 'owner', date_created FROM restaurant
+	WHERE owner_user_id ~* '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$';
+CREATE TABLE restaurant_transfer (
+	restaurant_id UUID NOT NULL,
+	from_user_id  UUID NOT NULL,
+	to_user_id    UUID NOT NULL,
+	date_created  TIMESTAMP,
+	PRIMARY KEY (restaurant_id),
+	FOREIGN KEY (restaurant_id) REFERENCES restaurant(restaurant_id)
+);`},
+	{
+		Version:     8,
+		Description: "Add menu tags, vote rating and statistics indexes",
+		Script: `
+ALTER TABLE menu ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE vote ADD COLUMN rating SMALLINT CHECK (rating BETWEEN 1 AND 5);
+CREATE INDEX menu_restaurant_date_idx ON menu (restaurant_id, date);
+CREATE INDEX vote_restaurant_date_idx ON vote (restaurant_id, date);
+CREATE INDEX vote_date_idx ON vote (date);`},
+	{
+		Version:     9,
+		Description: "Add restaurant suggestions and notifications",
+		Script: `
+CREATE TABLE restaurant_suggestion (
+	suggestion_id UUID NOT NULL,
+	name          TEXT NOT NULL,
+	address       TEXT,
+	comment       TEXT,
+	suggested_by  UUID NOT NULL,
+	status        TEXT NOT NULL,
+	reject_reason TEXT,
+	restaurant_id UUID,
+	date_created  TIMESTAMP,
+	date_updated  TIMESTAMP,
+	PRIMARY KEY (suggestion_id)
+);
+CREATE TABLE suggestion_vote (
+	suggestion_id UUID NOT NULL,
+	user_id       UUID NOT NULL,
+	date_created  TIMESTAMP,
+	PRIMARY KEY (suggestion_id, user_id),
+	FOREIGN KEY (suggestion_id) REFERENCES restaurant_suggestion(suggestion_id)
+);
+CREATE TABLE notification (
+	notification_id UUID NOT NULL,
+	user_id         UUID NOT NULL,
+	subject         TEXT NOT NULL,
+	message         TEXT,
+	date_created    TIMESTAMP,
+	PRIMARY KEY (notification_id)
+);
+CREATE INDEX notification_user_idx ON notification (user_id, date_created);`},
+	{
+		Version:     10,
+		Description: "Add user activation and email verification",
+		Script: `
+ALTER TABLE users ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE;
+CREATE TABLE user_verification (
+	token_hash   TEXT NOT NULL,
+	user_id      UUID NOT NULL,
+	date_expires TIMESTAMP NOT NULL,
+	date_created TIMESTAMP,
+	PRIMARY KEY (token_hash),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);`},
+	{
+		Version:     11,
+		Description: "Add password reset tokens",
+		Script: `
+CREATE TABLE password_reset (
+	token_hash   TEXT NOT NULL,
+	user_id      UUID NOT NULL,
+	date_expires TIMESTAMP NOT NULL,
+	date_created TIMESTAMP,
+	PRIMARY KEY (token_hash),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);`},
+	{
+		Version:     12,
+		Description: "Add refresh tokens and revoked access tokens",
+		Script: `
+CREATE TABLE refresh_token (
+	token_hash   TEXT NOT NULL,
+	family_id    UUID NOT NULL,
+	user_id      UUID NOT NULL,
+	date_expires TIMESTAMP NOT NULL,
+	date_used    TIMESTAMP,
+	date_created TIMESTAMP,
+	PRIMARY KEY (token_hash),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+CREATE INDEX refresh_token_family_idx ON refresh_token (family_id);
+CREATE TABLE revoked_token (
+	jti          TEXT NOT NULL,
+	date_expires TIMESTAMP NOT NULL,
+	PRIMARY KEY (jti)
+);`},
+	{
+		Version:     13,
+		Description: "Add personal access tokens",
+		Script: `
+CREATE TABLE access_token (
+	token_id       UUID NOT NULL,
+	user_id        UUID NOT NULL,
+	name           TEXT NOT NULL,
+	token_hash     TEXT NOT NULL UNIQUE,
+	scopes         TEXT[] NOT NULL,
+	date_expires   TIMESTAMP NOT NULL,
+	date_last_used TIMESTAMP,
+	date_created   TIMESTAMP,
+	PRIMARY KEY (token_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+CREATE INDEX access_token_user_idx ON access_token (user_id);`},
+	{
+		Version:     14,
+		Description: "Add failed login tracking and account lockout",
+		Script: `
+ALTER TABLE users ADD COLUMN failed_logins INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until TIMESTAMP;
+CREATE TABLE login_failure (
+	email         TEXT NOT NULL,
+	failed_logins INTEGER NOT NULL DEFAULT 0,
+	locked_until  TIMESTAMP,
+	date_updated  TIMESTAMP NOT NULL,
+	PRIMARY KEY (email)
+);
+CREATE INDEX login_failure_updated_idx ON login_failure (date_updated);`},
+	{
+		Version:     15,
+		Description: "Add roles with permission sets",
+		Script: `
+CREATE TABLE roles (
+	name         TEXT NOT NULL,
+	description  TEXT,
+	permissions  TEXT[] NOT NULL DEFAULT '{}',
+	builtin      BOOLEAN NOT NULL DEFAULT FALSE,
+	date_created TIMESTAMP,
+	PRIMARY KEY (name)
+);
+INSERT INTO roles (name, description, permissions, builtin, date_created) VALUES
+	('ADMIN', 'Administrator with full access', '{}', TRUE, NOW()),
+	('USER', 'Employee voting for menus', '{}', TRUE, NOW()),
+	('RESTAURANT_MANAGER', 'Manages restaurants and their menus',
+		'{restaurant:create,restaurant:update,restaurant:delete,menu:create,menu:update,suggestion:approve,suggestion:reject}',
+		TRUE, NOW());`},
+	{
+		Version:     16,
+		Description: "Add append-only security audit log",
+		Script: `
+CREATE TABLE audit_event (
+	event_id     UUID NOT NULL,
+	actor_id     UUID,
+	action       TEXT NOT NULL,
+	target_type  TEXT NOT NULL,
+	target_id    TEXT NOT NULL DEFAULT '',
+	ip           TEXT NOT NULL DEFAULT '',
+	request_id   TEXT NOT NULL DEFAULT '',
+	before       JSONB,
+	after        JSONB,
+	date_created TIMESTAMP NOT NULL,
+	PRIMARY KEY (event_id)
+);
+CREATE INDEX audit_event_date_idx ON audit_event (date_created);
+CREATE INDEX audit_event_actor_idx ON audit_event (actor_id, date_created);
+CREATE INDEX audit_event_target_idx ON audit_event (target_type, target_id);
+CREATE FUNCTION audit_event_append_only() RETURNS TRIGGER AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_event is append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER audit_event_append_only BEFORE UPDATE OR DELETE ON audit_event
+	FOR EACH ROW EXECUTE PROCEDURE audit_event_append_only();`},
+	{
+		Version:     17,
+		Description: "Add organizations",
+		Script: `
+CREATE TABLE organization (
+	org_id       UUID NOT NULL,
+	name         TEXT NOT NULL UNIQUE,
+	date_created TIMESTAMP,
+	PRIMARY KEY (org_id)
+);
+INSERT INTO organization (org_id, name, date_created) VALUES
+	('00000000-0000-0000-0000-000000000001', 'Default', NOW());
+ALTER TABLE users ADD COLUMN org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organization(org_id);
+ALTER TABLE restaurant ADD COLUMN org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organization(org_id);
+ALTER TABLE menu ADD COLUMN org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organization(org_id);
+ALTER TABLE vote ADD COLUMN org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organization(org_id);
+ALTER TABLE restaurant_suggestion ADD COLUMN org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001' REFERENCES organization(org_id);
+ALTER TABLE audit_event ADD COLUMN org_id UUID REFERENCES organization(org_id);
+CREATE INDEX users_org_idx ON users (org_id);
+CREATE INDEX restaurant_org_idx ON restaurant (org_id);
+CREATE INDEX menu_org_date_idx ON menu (org_id, date);
+CREATE INDEX vote_org_date_idx ON vote (org_id, date);
+CREATE INDEX restaurant_suggestion_org_idx ON restaurant_suggestion (org_id);
+CREATE INDEX audit_event_org_idx ON audit_event (org_id, date_created);
+INSERT INTO roles (name, description, permissions, builtin, date_created) VALUES
+	('SUPER_ADMIN', 'Service administrator managing all organizations', '{}', TRUE, NOW());
+UPDATE roles SET description = 'Administrator of the organization' WHERE name = 'ADMIN';`},
+	{
+		Version:     18,
+		Description: "Add teams and team polls",
+		Script: `
+CREATE TABLE team (
+	team_id      UUID NOT NULL,
+	org_id       UUID NOT NULL REFERENCES organization(org_id),
+	name         TEXT NOT NULL,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+	PRIMARY KEY (team_id),
+	UNIQUE (org_id, name)
+);
+CREATE TABLE team_member (
+	team_id      UUID NOT NULL REFERENCES team(team_id) ON DELETE CASCADE,
+	user_id      UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	date_created TIMESTAMP,
+	PRIMARY KEY (team_id, user_id)
+);
+CREATE INDEX team_member_user_idx ON team_member (user_id);
+CREATE TABLE team_restaurant (
+	team_id       UUID NOT NULL REFERENCES team(team_id) ON DELETE CASCADE,
+	restaurant_id UUID NOT NULL REFERENCES restaurant(restaurant_id) ON DELETE CASCADE,
+	PRIMARY KEY (team_id, restaurant_id)
+);
+ALTER TABLE vote ADD COLUMN team_id UUID REFERENCES team(team_id) ON DELETE CASCADE;
+ALTER TABLE vote DROP CONSTRAINT vote_pkey;
+CREATE UNIQUE INDEX vote_date_user_idx ON vote (date, user_id) WHERE team_id IS NULL;
+CREATE UNIQUE INDEX vote_team_date_user_idx ON vote (team_id, date, user_id) WHERE team_id IS NOT NULL;`},
+	{
+		Version:     19,
+		Description: "Add offices",
+		Script: `
+CREATE TABLE office (
+	office_id    UUID NOT NULL,
+	org_id       UUID NOT NULL REFERENCES organization(org_id),
+	name         TEXT NOT NULL,
+	timezone     TEXT NOT NULL,
+	location     TEXT NOT NULL DEFAULT '',
+	vote_cutoff  TEXT,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+	PRIMARY KEY (office_id),
+	UNIQUE (org_id, name)
+);
+ALTER TABLE restaurant ADD COLUMN office_id UUID REFERENCES office(office_id) ON DELETE SET NULL;
+ALTER TABLE users ADD COLUMN office_id UUID REFERENCES office(office_id) ON DELETE SET NULL;
+CREATE INDEX restaurant_office_idx ON restaurant (office_id);`},
+	{
+		Version:     20,
+		Description: "Add TOTP two-factor authentication",
+		Script: `
+CREATE TABLE user_mfa (
+	user_id      UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	secret       TEXT NOT NULL,
+	enabled      BOOLEAN NOT NULL DEFAULT FALSE,
+	last_counter BIGINT NOT NULL DEFAULT 0,
+	date_created TIMESTAMP,
+	date_enabled TIMESTAMP,
+	PRIMARY KEY (user_id)
+);
+CREATE TABLE recovery_code (
+	user_id   UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	code_hash TEXT NOT NULL,
+	date_used TIMESTAMP,
+	PRIMARY KEY (user_id, code_hash)
+);
+CREATE TABLE mfa_challenge (
+	token_hash   TEXT NOT NULL,
+	user_id      UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+	enroll       BOOLEAN NOT NULL DEFAULT FALSE,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	date_expires TIMESTAMP NOT NULL,
+	date_created TIMESTAMP,
+	PRIMARY KEY (token_hash)
+);
+CREATE INDEX mfa_challenge_user_idx ON mfa_challenge (user_id);`},
+	{
+		Version:     21,
+		Description: "Add soft delete of users",
+		Script: `
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;`},
+	{
+		Version:     22,
+		Description: "Add external identity of users",
+		Script: `
+ALTER TABLE users ADD COLUMN origin TEXT NOT NULL DEFAULT 'local';
+ALTER TABLE users ADD COLUMN external_issuer TEXT;
+ALTER TABLE users ADD COLUMN external_subject TEXT;
+CREATE UNIQUE INDEX users_external_subject_idx ON users (external_issuer, external_subject);`},
+	{
+		Version:     23,
+		Description: "Add pending email of user verification",
+		Script: `
+ALTER TABLE user_verification ADD COLUMN email TEXT;`},
+	{
+		Version:     24,
+		Description: "Archive deleted teams",
+		Script: `
+ALTER TABLE team ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE team DROP CONSTRAINT team_org_id_name_key;
+CREATE UNIQUE INDEX team_org_name_idx ON team (org_id, name) WHERE deleted_at IS NULL;
+ALTER TABLE vote DROP CONSTRAINT vote_team_id_fkey;
+ALTER TABLE vote ADD CONSTRAINT vote_team_id_fkey FOREIGN KEY (team_id) REFERENCES team(team_id);`},
+	{
+		Version:     25,
+		Description: "Add second factor of refresh token family",
+		Script: `
+ALTER TABLE refresh_token ADD COLUMN mfa BOOLEAN NOT NULL DEFAULT FALSE;`},
+	{
+		Version:     26,
+		Description: "Separate email verification from user deactivation",
+		Script: `
+UPDATE users SET deleted_at = date_updated WHERE NOT active AND deleted_at IS NULL AND origin = 'directory';
+UPDATE users SET active = TRUE WHERE deleted_at IS NOT NULL AND NOT EXISTS
+	(SELECT 1 FROM user_verification v WHERE v.user_id = users.user_id AND v.email IS NULL);
+ALTER TABLE users RENAME COLUMN active TO email_verified;`},
+	{
+		Version:     27,
+		Description: "Add active state of user",
+		Script: `
+ALTER TABLE users ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE;
+UPDATE users SET active = FALSE WHERE deleted_at IS NOT NULL;`},
 }
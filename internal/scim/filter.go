@@ -0,0 +1,94 @@
+package scim
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrInvalidFilter returned when filter is not an equality filter like userName eq "john@example.com".
+	ErrInvalidFilter = errors.New(`filter should be an equality filter like userName eq "john@example.com"`)
+	// ErrInvalidPath returned when patch path can not be parsed or does not support the operation.
+	ErrInvalidPath = errors.New("patch path is invalid")
+	// ErrInvalidValue returned when patch value does not match its attribute.
+	ErrInvalidValue = errors.New("patch value is invalid")
+	// ErrInvalidOperation returned when patch operation is not add, replace or remove.
+	ErrInvalidOperation = errors.New("patch operation should be add, replace or remove")
+	// ErrImmutable returned when required or read only attribute is modified.
+	ErrImmutable = errors.New("attribute can not be modified or removed")
+)
+
+var (
+	filterRe = regexp.MustCompile(`^\s*([A-Za-z][\w.]*)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*"|true|false)\s*$`)
+	pathRe   = regexp.MustCompile(`^([A-Za-z][\w]*)(?:\[(.+)\])?(?:\.([A-Za-z][\w]*))?$`)
+)
+
+// Filter is an equality filter of the list query. Attribute names are case
+// insensitive and are stored in lower case.
+type Filter struct {
+	Attribute string
+	Value     string
+}
+
+// ParseFilter parses equality filter like userName eq "john@example.com", empty
+// filter is returned for empty string. Other SCIM filter operators are not supported.
+func ParseFilter(s string) (Filter, error) {
+	if strings.TrimSpace(s) == "" {
+		return Filter{}, nil
+	}
+
+	m := filterRe.FindStringSubmatch(s)
+	if m == nil {
+		return Filter{}, ErrInvalidFilter
+	}
+
+	f := Filter{Attribute: strings.ToLower(m[1]), Value: m[2]}
+	if strings.HasPrefix(m[2], `"`) {
+		if err := json.Unmarshal([]byte(m[2]), &f.Value); err != nil {
+			return Filter{}, ErrInvalidFilter
+		}
+	}
+	return f, nil
+}
+
+// ErrorType returns SCIM error type of the filter or patch error.
+func ErrorType(err error) string {
+	switch err {
+	case ErrInvalidFilter:
+		return TypeInvalidFilter
+	case ErrInvalidPath:
+		return TypeInvalidPath
+	case ErrInvalidValue:
+		return TypeInvalidValue
+	case ErrImmutable:
+		return TypeMutability
+	default:
+		return TypeInvalidSyntax
+	}
+}
+
+// path is a parsed patch path like name.givenName or members[value eq "id"].
+type path struct {
+	attribute string
+	filter    *Filter
+	sub       string
+}
+
+func parsePath(s string) (path, error) {
+	m := pathRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return path{}, ErrInvalidPath
+	}
+
+	p := path{attribute: strings.ToLower(m[1]), sub: strings.ToLower(m[3])}
+	if m[2] != "" {
+		f, err := ParseFilter(m[2])
+		if err != nil {
+			return path{}, ErrInvalidPath
+		}
+		p.filter = &f
+	}
+	return p, nil
+}
@@ -0,0 +1,276 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Patch applies patch operations to the user. Operations without path set
+// attributes listed in the value object, attributes which are not stored are
+// ignored.
+func (u *User) Patch(ops []PatchOperation) error {
+	for _, op := range ops {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			if op.Path != "" {
+				if err := u.set(op.Path, op.Value); err != nil {
+					return err
+				}
+				continue
+			}
+
+			var values map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return ErrInvalidValue
+			}
+			for p, value := range values {
+				if err := u.set(p, value); err != nil {
+					return err
+				}
+			}
+		case "remove":
+			if err := u.remove(op.Path); err != nil {
+				return err
+			}
+		default:
+			return ErrInvalidOperation
+		}
+	}
+	return nil
+}
+
+func (u *User) set(s string, value json.RawMessage) error {
+	p, err := parsePath(s)
+	if err != nil {
+		return err
+	}
+
+	switch p.attribute {
+	case "username":
+		if err := decodeString(value, &u.UserName); err != nil || u.UserName == "" {
+			return ErrInvalidValue
+		}
+	case "displayname":
+		return decodeString(value, &u.DisplayName)
+	case "active":
+		active, err := decodeBool(value)
+		if err != nil {
+			return err
+		}
+		u.Active = &active
+	case "name":
+		if p.filter != nil {
+			return ErrInvalidPath
+		}
+		if p.sub == "" {
+			var n Name
+			if err := json.Unmarshal(value, &n); err != nil {
+				return ErrInvalidValue
+			}
+			u.Name = &n
+			return nil
+		}
+		if u.Name == nil {
+			u.Name = &Name{}
+		}
+		switch p.sub {
+		case "formatted":
+			return decodeString(value, &u.Name.Formatted)
+		case "givenname":
+			return decodeString(value, &u.Name.GivenName)
+		case "familyname":
+			return decodeString(value, &u.Name.FamilyName)
+		}
+	}
+	return nil
+}
+
+func (u *User) remove(s string) error {
+	if s == "" {
+		return ErrInvalidPath
+	}
+	p, err := parsePath(s)
+	if err != nil {
+		return err
+	}
+
+	switch p.attribute {
+	case "username", "active":
+		return ErrImmutable
+	case "displayname":
+		u.DisplayName = ""
+	case "name":
+		if p.sub == "" {
+			u.Name = nil
+			return nil
+		}
+		if u.Name == nil {
+			return nil
+		}
+		switch p.sub {
+		case "formatted":
+			u.Name.Formatted = ""
+		case "givenname":
+			u.Name.GivenName = ""
+		case "familyname":
+			u.Name.FamilyName = ""
+		}
+	}
+	return nil
+}
+
+// Patch applies patch operations to the group members. Group display name is
+// the role name and can not be changed.
+func (g *Group) Patch(ops []PatchOperation) error {
+	for _, op := range ops {
+		name := strings.ToLower(op.Op)
+		switch name {
+		case "add", "replace":
+			if op.Path != "" {
+				if err := g.set(name, op.Path, op.Value); err != nil {
+					return err
+				}
+				continue
+			}
+
+			var values map[string]json.RawMessage
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return ErrInvalidValue
+			}
+			for p, value := range values {
+				if err := g.set(name, p, value); err != nil {
+					return err
+				}
+			}
+		case "remove":
+			if err := g.remove(op.Path, op.Value); err != nil {
+				return err
+			}
+		default:
+			return ErrInvalidOperation
+		}
+	}
+	return nil
+}
+
+func (g *Group) set(op, s string, value json.RawMessage) error {
+	p, err := parsePath(s)
+	if err != nil {
+		return err
+	}
+
+	switch p.attribute {
+	case "displayname":
+		var name string
+		if err := decodeString(value, &name); err != nil {
+			return err
+		}
+		if name != g.DisplayName {
+			return ErrImmutable
+		}
+	case "members":
+		if p.filter != nil || p.sub != "" {
+			return ErrInvalidPath
+		}
+		var members []Member
+		if err := json.Unmarshal(value, &members); err != nil {
+			return ErrInvalidValue
+		}
+		if op == "replace" {
+			g.Members = nil
+		}
+		for _, m := range members {
+			if m.Value == "" {
+				return ErrInvalidValue
+			}
+			if !g.hasMember(m.Value) {
+				g.Members = append(g.Members, m)
+			}
+		}
+	}
+	return nil
+}
+
+// remove removes members selected by path filter like members[value eq "id"] or
+// listed in the value, all members are removed when neither is passed.
+func (g *Group) remove(s string, value json.RawMessage) error {
+	if s == "" {
+		return ErrInvalidPath
+	}
+	p, err := parsePath(s)
+	if err != nil {
+		return err
+	}
+	if p.attribute == "displayname" {
+		return ErrImmutable
+	}
+	if p.attribute != "members" || p.sub != "" {
+		return ErrInvalidPath
+	}
+
+	removed := make(map[string]bool)
+	switch {
+	case p.filter != nil:
+		if p.filter.Attribute != "value" {
+			return ErrInvalidPath
+		}
+		removed[p.filter.Value] = true
+	case len(value) > 0 && string(value) != "null":
+		var members []Member
+		if err := json.Unmarshal(value, &members); err != nil {
+			return ErrInvalidValue
+		}
+		for _, m := range members {
+			removed[m.Value] = true
+		}
+	default:
+		g.Members = nil
+		return nil
+	}
+
+	members := make([]Member, 0, len(g.Members))
+	for _, m := range g.Members {
+		if !removed[m.Value] {
+			members = append(members, m)
+		}
+	}
+	g.Members = members
+	return nil
+}
+
+func (g *Group) hasMember(id string) bool {
+	for _, m := range g.Members {
+		if m.Value == id {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeString(value json.RawMessage, s *string) error {
+	if err := json.Unmarshal(value, s); err != nil {
+		return ErrInvalidValue
+	}
+	return nil
+}
+
+// decodeBool decodes boolean value, some identity providers send booleans as
+// "True" and "False" strings.
+func decodeBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, ErrInvalidValue
+	}
+	switch strings.ToLower(s) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, ErrInvalidValue
+}
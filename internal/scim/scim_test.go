@@ -0,0 +1,91 @@
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		want    Filter
+		wantErr bool
+	}{
+		{"empty", "", Filter{}, false},
+		{"user name", `userName eq "john@example.com"`, Filter{"username", "john@example.com"}, false},
+		{"upper case operator", `userName EQ "john@example.com"`, Filter{"username", "john@example.com"}, false},
+		{"escaped quote", `displayName eq "John \"JJ\" Doe"`, Filter{"displayname", `John "JJ" Doe`}, false},
+		{"boolean", `active eq true`, Filter{"active", "true"}, false},
+		{"unquoted string", `userName eq john`, Filter{}, true},
+		{"unsupported operator", `userName sw "john"`, Filter{}, true},
+		{"logical expression", `userName eq "a" or userName eq "b"`, Filter{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilter(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFilter(%q) error = %v, wantErr %v", tt.filter, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFilter(%q) = %+v, want %+v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserPatch(t *testing.T) {
+	var req PatchRequest
+	body := `{"Operations": [
+		{"op": "Replace", "path": "active", "value": "False"},
+		{"op": "replace", "path": "name.givenName", "value": "John"},
+		{"op": "add", "value": {"userName": "john@example.com", "externalId": "42"}}
+	]}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	u := User{UserName: "jd@example.com", DisplayName: "JD"}
+	if err := u.Patch(req.Operations); err != nil {
+		t.Fatalf("Patch() error = %v", err)
+	}
+	if u.IsActive() {
+		t.Error("user should be deactivated")
+	}
+	if u.UserName != "john@example.com" {
+		t.Errorf("UserName = %q, want john@example.com", u.UserName)
+	}
+	if u.Name == nil || u.Name.GivenName != "John" {
+		t.Errorf("Name = %+v, want given name John", u.Name)
+	}
+
+	remove := []PatchOperation{{Op: "remove", Path: "userName"}}
+	if err := u.Patch(remove); err != ErrImmutable {
+		t.Errorf("Patch() removing user name error = %v, want %v", err, ErrImmutable)
+	}
+}
+
+func TestGroupPatch(t *testing.T) {
+	g := Group{DisplayName: "ADMIN", Members: []Member{{Value: "a"}, {Value: "b"}}}
+	ops := []PatchOperation{
+		{Op: "add", Path: "members", Value: json.RawMessage(`[{"value": "c"}, {"value": "a"}]`)},
+		{Op: "remove", Path: `members[value eq "b"]`},
+	}
+	if err := g.Patch(ops); err != nil {
+		t.Fatalf("Patch() error = %v", err)
+	}
+	if len(g.Members) != 2 || g.Members[0].Value != "a" || g.Members[1].Value != "c" {
+		t.Errorf("Members = %+v, want a and c", g.Members)
+	}
+
+	ops = []PatchOperation{{Op: "replace", Path: "displayName", Value: json.RawMessage(`"USER"`)}}
+	if err := g.Patch(ops); err != ErrImmutable {
+		t.Errorf("Patch() renaming group error = %v, want %v", err, ErrImmutable)
+	}
+
+	ops = []PatchOperation{{Op: "remove", Path: "members"}}
+	if err := g.Patch(ops); err != nil || len(g.Members) != 0 {
+		t.Errorf("Patch() removing all members = %+v, error = %v", g.Members, err)
+	}
+}
@@ -0,0 +1,53 @@
+package team
+
+import (
+	"github.com/lib/pq"
+	"time"
+)
+
+// Team is a group of users of the organization running its own daily lunch poll.
+// Poll includes menus of listed restaurants, all organization restaurants are
+// included when the list is empty.
+type Team struct {
+	ID          string         `db:"team_id" json:"id"`
+	OrgID       string         `db:"org_id" json:"orgId"`
+	Name        string         `db:"name" json:"name"`
+	Restaurants pq.StringArray `db:"restaurants" json:"restaurants"`
+	DateCreated time.Time      `db:"date_created" json:"dateCreated"`
+	DateUpdated time.Time      `db:"date_updated" json:"dateUpdated"`
+}
+
+// NewTeam is what we require from clients when adding a Team.
+type NewTeam struct {
+	Name        string   `json:"name" validate:"required"`
+	Restaurants []string `json:"restaurants"`
+}
+
+// UpdateTeam defines what information may be provided to modify an existing
+// Team. Restaurants list replaces the poll restaurants when it is provided,
+// empty list includes all organization restaurants into the poll.
+type UpdateTeam struct {
+	Name        *string  `json:"name"`
+	Restaurants []string `json:"restaurants"`
+}
+
+// Member is a user belonging to the team.
+type Member struct {
+	TeamID      string    `db:"team_id" json:"teamId"`
+	UserID      string    `db:"user_id" json:"userId"`
+	Name        string    `db:"name" json:"name"`
+	Email       string    `db:"email" json:"email"`
+	DateCreated time.Time `db:"date_created" json:"dateCreated"`
+}
+
+// Redacted returns the member without name and email of the user, it is stored
+// in audit log snapshots.
+func (m Member) Redacted() interface{} {
+	m.Name, m.Email = "", ""
+	return m
+}
+
+// NewMember is what we require from clients when adding team member.
+type NewMember struct {
+	Email string `json:"email" validate:"required"`
+}
@@ -0,0 +1,333 @@
+package team
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/org"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidName returned when team name is empty.
+	ErrInvalidName = errors.New("team name should not be empty")
+	// ErrTeamExists returned when team with the same name already exists in the organization.
+	ErrTeamExists = errors.New("team already exists")
+	// ErrUnknownRestaurant returned when poll restaurant is not found in the organization.
+	ErrUnknownRestaurant = errors.New("poll restaurant not found")
+	// ErrMemberNotFound returned when user is not a member of the team.
+	ErrMemberNotFound = errors.New("team member not found")
+)
+
+// selectTeams selects teams with their poll restaurants.
+const selectTeams = `SELECT t.team_id, t.org_id, t.name, t.date_created, t.date_updated,
+	    ARRAY(SELECT tr.restaurant_id::text FROM team_restaurant tr
+	        WHERE tr.team_id = t.team_id ORDER BY tr.restaurant_id) AS restaurants
+	FROM team t`
+
+// Repo is a team Repository structure. All queries are limited to the organization
+// stored in the context.
+type Repo struct {
+	db db.DB
+}
+
+// NewRepo is a factory function used to create new team Repository.
+func NewRepo(dbx *sqlx.DB) *Repo {
+	return &Repo{db.Wrap(dbx)}
+}
+
+// List retrieves teams of the organization ordered by name. Only teams of the
+// user are listed when memberID is passed.
+func (r *Repo) List(ctx context.Context, memberID string) ([]Team, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	teams := make([]Team, 0)
+	if memberID == "" {
+		const q = selectTeams + ` WHERE t.org_id = $1 AND t.deleted_at IS NULL ORDER BY t.name`
+		if err := r.db.SelectContext(ctx, &teams, q, orgID); err != nil {
+			return nil, errors.Wrap(err, "selecting teams")
+		}
+		return teams, nil
+	}
+
+	if _, err := uuid.Parse(memberID); err != nil {
+		return nil, db.ErrInvalidID
+	}
+	const q = selectTeams + ` JOIN team_member m ON m.team_id = t.team_id
+	    WHERE t.org_id = $1 AND m.user_id = $2 AND t.deleted_at IS NULL ORDER BY t.name`
+	if err := r.db.SelectContext(ctx, &teams, q, orgID, memberID); err != nil {
+		return nil, errors.Wrapf(err, "selecting user %q teams", memberID)
+	}
+	return teams, nil
+}
+
+// Retrieve gets the specified team from the database.
+func (r *Repo) Retrieve(ctx context.Context, id string) (*Team, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, db.ErrInvalidID
+	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var t Team
+	const q = selectTeams + ` WHERE t.team_id = $1 AND t.org_id = $2 AND t.deleted_at IS NULL`
+	if err := r.db.GetContext(ctx, &t, q, id, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting team %q", id)
+	}
+	return &t, nil
+}
+
+// Create inserts a new team with its poll restaurants into the database.
+func (r *Repo) Create(ctx context.Context, nt NewTeam, now time.Time) (*Team, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(nt.Name)
+	if name == "" {
+		return nil, ErrInvalidName
+	}
+	restaurants, err := r.pollRestaurants(ctx, orgID, nt.Restaurants)
+	if err != nil {
+		return nil, err
+	}
+
+	t := Team{
+		ID:          uuid.New().String(),
+		OrgID:       orgID,
+		Name:        name,
+		Restaurants: restaurants,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `INSERT INTO team (team_id, org_id, name, date_created, date_updated)
+	    VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.ExecContext(ctx, q, t.ID, t.OrgID, t.Name, t.DateCreated, t.DateUpdated); err != nil {
+		rollback(tx)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrTeamExists
+		}
+		return nil, errors.Wrap(err, "inserting team")
+	}
+
+	if err := setRestaurants(ctx, tx, t.ID, t.Restaurants); err != nil {
+		rollback(tx)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit team")
+	}
+	return &t, nil
+}
+
+// Update modifies team name and poll restaurants. Poll restaurants are replaced
+// only when the list is provided.
+func (r *Repo) Update(ctx context.Context, id string, ut UpdateTeam, now time.Time) (*Team, error) {
+	t, err := r.Retrieve(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if ut.Name != nil {
+		t.Name = strings.TrimSpace(*ut.Name)
+		if t.Name == "" {
+			return nil, ErrInvalidName
+		}
+	}
+	if ut.Restaurants != nil {
+		t.Restaurants, err = r.pollRestaurants(ctx, t.OrgID, ut.Restaurants)
+		if err != nil {
+			return nil, err
+		}
+	}
+	t.DateUpdated = now.UTC()
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `UPDATE team SET name = $3, date_updated = $4 WHERE team_id = $1 AND org_id = $2`
+	if _, err := tx.ExecContext(ctx, q, t.ID, t.OrgID, t.Name, t.DateUpdated); err != nil {
+		rollback(tx)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrTeamExists
+		}
+		return nil, errors.Wrapf(err, "updating team %s", id)
+	}
+
+	if ut.Restaurants != nil {
+		if err := setRestaurants(ctx, tx, t.ID, t.Restaurants); err != nil {
+			rollback(tx)
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit team")
+	}
+	return t, nil
+}
+
+// Delete archives the team, archived team is not listed and its poll is closed.
+// Votes of the team poll are kept, name of archived team can be reused.
+func (r *Repo) Delete(ctx context.Context, id string, now time.Time) error {
+	t, err := r.Retrieve(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	const q = `UPDATE team SET deleted_at = $3, date_updated = $3
+	    WHERE team_id = $1 AND org_id = $2 AND deleted_at IS NULL`
+	if _, err := r.db.ExecContext(ctx, q, t.ID, t.OrgID, now.UTC()); err != nil {
+		return errors.Wrapf(err, "deleting team %s", id)
+	}
+	return nil
+}
+
+// Members retrieves list of team members from the database.
+func (r *Repo) Members(ctx context.Context, id string) ([]Member, error) {
+	t, err := r.Retrieve(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]Member, 0)
+	const q = `SELECT m.team_id, m.user_id, u.name, u.email, m.date_created
+	    FROM team_member m
+	    JOIN users u ON u.user_id = m.user_id
+	    WHERE m.team_id = $1 AND u.org_id = $2
+	    ORDER BY u.name`
+	if err := r.db.SelectContext(ctx, &members, q, t.ID, t.OrgID); err != nil {
+		return nil, errors.Wrap(err, "selecting team members")
+	}
+	return members, nil
+}
+
+// AddMember adds user with specified email to the team. User may belong to
+// several teams of the organization.
+func (r *Repo) AddMember(ctx context.Context, id string, nm NewMember, now time.Time) (*Member, error) {
+	t, err := r.Retrieve(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// only users of the team organization can become members
+	var m Member
+	const qUser = `SELECT user_id, name, email FROM users WHERE email = $1 AND org_id = $2`
+	if err := r.db.GetContext(ctx, &m, qUser, nm.Email, t.OrgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting user %q", nm.Email)
+	}
+	m.TeamID = t.ID
+	m.DateCreated = now.UTC()
+
+	const q = `INSERT INTO team_member (team_id, user_id, date_created)
+	    VALUES ($1, $2, $3)
+	    ON CONFLICT (team_id, user_id) DO NOTHING`
+	if _, err := r.db.ExecContext(ctx, q, m.TeamID, m.UserID, m.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting team member")
+	}
+	return &m, nil
+}
+
+// RemoveMember removes user from the team, votes of the user are kept.
+func (r *Repo) RemoveMember(ctx context.Context, id, userID string) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return db.ErrInvalidID
+	}
+	t, err := r.Retrieve(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	const q = `DELETE FROM team_member WHERE team_id = $1 AND user_id = $2`
+	result, err := r.db.ExecContext(ctx, q, t.ID, userID)
+	if err != nil {
+		return errors.Wrapf(err, "deleting team %s member %s", id, userID)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "deleted count")
+	}
+	if count == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+// pollRestaurants validates that all poll restaurants exist in the organization
+// and returns them without duplicates.
+func (r *Repo) pollRestaurants(ctx context.Context, orgID string, ids []string) (pq.StringArray, error) {
+	restaurants := make(pq.StringArray, 0, len(ids))
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			return nil, db.ErrInvalidID
+		}
+		if !seen[parsed.String()] {
+			seen[parsed.String()] = true
+			restaurants = append(restaurants, parsed.String())
+		}
+	}
+	if len(restaurants) == 0 {
+		return restaurants, nil
+	}
+
+	var count int
+	const q = `SELECT COUNT(*) FROM restaurant
+	    WHERE restaurant_id = ANY($1::uuid[]) AND org_id = $2 AND deleted_at IS NULL`
+	if err := r.db.GetContext(ctx, &count, q, restaurants, orgID); err != nil {
+		return nil, errors.Wrap(err, "selecting poll restaurants")
+	}
+	if count != len(restaurants) {
+		return nil, ErrUnknownRestaurant
+	}
+	return restaurants, nil
+}
+
+func setRestaurants(ctx context.Context, tx *db.Tx, teamID string, restaurants pq.StringArray) error {
+	const qDelete = `DELETE FROM team_restaurant WHERE team_id = $1`
+	if _, err := tx.ExecContext(ctx, qDelete, teamID); err != nil {
+		return errors.Wrapf(err, "deleting team %s restaurants", teamID)
+	}
+
+	const qInsert = `INSERT INTO team_restaurant (team_id, restaurant_id)
+	    SELECT $1::uuid, unnest($2::uuid[])`
+	if _, err := tx.ExecContext(ctx, qInsert, teamID, restaurants); err != nil {
+		return errors.Wrapf(err, "inserting team %s restaurants", teamID)
+	}
+	return nil
+}
+
+func rollback(tx *db.Tx) {
+	if err := tx.Rollback(); err != nil {
+		log.Sugar.Errorf("error on tx rollback, error: %s", err)
+	}
+}
@@ -2,7 +2,6 @@ package tests
 
 import (
 	"context"
-	"github.com/go-chi/jwtauth"
 	"github.com/jmoiron/sqlx"
 	"github.com/remisb/mat/internal/auth"
 	"github.com/remisb/mat/internal/db"
@@ -26,7 +25,7 @@ type Test struct {
 	Log            *zap.SugaredLogger
 	t              *testing.T
 	Cleanup        func()
-	tokenAuth      *jwtauth.JWTAuth
+	Keys           *auth.KeyStore
 	Admin          userToken
 	User           userToken
 	User1          userToken
@@ -42,16 +41,29 @@ type userToken struct {
 func NewTest(t *testing.T) *Test {
 	t.Helper()
 
+	// code under test logs through the global logger which is set up by main
+	if logz.Sugar == nil {
+		logz.Sugar = zap.NewNop().Sugar()
+	}
+
 	db, teardown := setupTestDbContainer(t)
 	if err := schema.Seed(db); err != nil {
 		t.Fatal(err)
 	}
 
 	userRepo := user.NewRepo(db)
-	jwauth := jwtauth.New("HS256", []byte("secret"), nil)
-	authenticator := auth.New(userRepo, jwauth)
+	keys, err := auth.GenerateKeyStore("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := user.SetMFAKey(make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator := auth.New(userRepo, nil, auth.NewTokenStore(db), keys, nil)
 	return &Test{
 		Dbx:            db,
+		Keys:           keys,
 		userRepo:       userRepo,
 		authenticator:  *authenticator,
 		restaurantRepo: restaurant.NewRepo(db),
@@ -0,0 +1,111 @@
+// Package totp implements time-based one-time passwords of RFC 6238 compatible
+// with authenticator apps: HMAC-SHA1, 6 digits and 30 seconds period.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is a number of digits of the code.
+	Digits = 6
+	// Period is a duration a single code is valid.
+	Period = 30 * time.Second
+
+	// secretSize is a length of the secret in bytes, RFC 4226 recommends 160 bits.
+	secretSize = 20
+	// skew is a number of periods before and after the current one accepted to
+	// tolerate clock drift of the device.
+	skew = 1
+)
+
+// ErrInvalidSecret returned when secret is not base32 encoded.
+var ErrInvalidSecret = errors.New("totp secret is not valid base32")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates random base32 encoded secret shared with authenticator app.
+func NewSecret() (string, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating totp secret")
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Code returns code of the secret valid at passed time.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, counter(t)), nil
+}
+
+// Validate checks code against the secret at passed time. Codes of adjacent
+// periods are accepted too. Counter of the matched period is returned, it is
+// used to reject repeated use of the same code.
+func Validate(secret, passcode string, t time.Time) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(passcode) != Digits {
+		return 0, false
+	}
+
+	current := counter(t)
+	for c := current - skew; c <= current+skew; c++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, c)), []byte(passcode)) == 1 {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns otpauth:// key URI of the secret, authenticator apps import it
+// from QR code.
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period.Seconds())))
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.TrimRight(strings.ReplaceAll(secret, " ", ""), "="))
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
+
+func counter(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// code computes HOTP value of RFC 4226 for the counter.
+func code(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of RFC 6238 test vectors.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B values truncated to 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1600000000, 0)
+	current, _ := Code(secret, now)
+	previous, _ := Code(secret, now.Add(-Period))
+	old, _ := Code(secret, now.Add(-3*Period))
+
+	c, ok := Validate(secret, current, now)
+	if !ok || c != now.Unix()/30 {
+		t.Errorf("Validate() current = %d, %v, want %d, true", c, ok, now.Unix()/30)
+	}
+	if c, ok := Validate(secret, previous, now); !ok || c != now.Unix()/30-1 {
+		t.Errorf("Validate() previous = %d, %v, want match of previous period", c, ok)
+	}
+	if _, ok := Validate(secret, old, now); ok {
+		t.Error("Validate() accepted code of expired period")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("Validate() accepted short code")
+	}
+	if _, ok := Validate("not base32!", current, now); ok {
+		t.Error("Validate() accepted invalid secret")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("mat", "admin@example.com", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/mat:admin@example.com?"
+	if !strings.HasPrefix(uri, want) {
+		t.Errorf("URI() = %s, want prefix %s", uri, want)
+	}
+	for _, param := range []string{"secret=JBSWY3DPEHPK3PXP", "issuer=mat", "digits=6", "period=30"} {
+		if !strings.Contains(uri, param) {
+			t.Errorf("URI() = %s, missing %s", uri, param)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package user
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"time"
+)
+
+const (
+	// StatusActive lists users who can sign in, it is the default filter of user list.
+	StatusActive Status = "active"
+	// StatusInactive lists deactivated and deleted users and users who have not
+	// verified email yet.
+	StatusInactive Status = "inactive"
+	// StatusAll lists all users of the organization.
+	StatusAll Status = "all"
+
+	// anonymizedName replaces name of anonymized user.
+	anonymizedName = "Deleted user"
+)
+
+var (
+	// ErrDeactivated returned when deactivated user tries to sign in or is deactivated again.
+	ErrDeactivated = errors.New("user account is deactivated")
+	// ErrNotDeactivated returned on reactivation or anonymization of active user.
+	ErrNotDeactivated = errors.New("user account is not deactivated")
+	// ErrAnonymized returned on reactivation of anonymized user.
+	ErrAnonymized = errors.New("anonymized user can not be reactivated")
+	// ErrInvalidStatus returned when user list filter is not known.
+	ErrInvalidStatus = errors.New("user status should be one of active, inactive or all")
+)
+
+// Status is a filter of user list by user state.
+type Status string
+
+// ParseStatus parses user list filter, empty value means StatusActive.
+func ParseStatus(s string) (Status, error) {
+	switch st := Status(s); st {
+	case "":
+		return StatusActive, nil
+	case StatusActive, StatusInactive, StatusAll:
+		return st, nil
+	}
+	return "", ErrInvalidStatus
+}
+
+// Anonymized reports if personal fields of the user were removed by Anonymize.
+func (u User) Anonymized() bool {
+	return u.Email == anonymizedEmail(u.ID)
+}
+
+// Deactivate marks the specified user inactive, the user is kept in the user list
+// and can not authenticate or vote until reactivated.
+func (r *Repo) Deactivate(ctx context.Context, id string, now time.Time) (*User, error) {
+	u, err := r.RetrieveInOrg(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !u.Active {
+		return nil, ErrDeactivated
+	}
+
+	const q = `UPDATE users SET
+		"active" = FALSE,
+		"date_updated" = $3,
+		"version" = version + 1
+		WHERE user_id = $1 AND org_id = $2 AND active
+		RETURNING *`
+	if err := r.db.GetContext(ctx, u, q, u.ID, u.OrgID, now.UTC()); err != nil {
+		return nil, errors.Wrapf(err, "deactivating user %s", id)
+	}
+	return u, nil
+}
+
+// Reactivate reverts Deactivate and Delete of the specified user, anonymized users
+// stay deleted. Email verification state of the user is kept.
+func (r *Repo) Reactivate(ctx context.Context, id string, now time.Time) (*User, error) {
+	u, err := r.RetrieveInOrg(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Active:
+		return nil, ErrNotDeactivated
+	case u.Anonymized():
+		return nil, ErrAnonymized
+	}
+
+	const q = `UPDATE users SET
+		"active" = TRUE,
+		"deleted_at" = NULL,
+		"date_updated" = $3,
+		"version" = version + 1
+		WHERE user_id = $1 AND org_id = $2 AND NOT active
+		RETURNING *`
+	if err := r.db.GetContext(ctx, u, q, u.ID, u.OrgID, now.UTC()); err != nil {
+		return nil, errors.Wrapf(err, "reactivating user %s", id)
+	}
+	return u, nil
+}
+
+// Anonymize removes personal fields of the deactivated or deleted user and deletes
+// it. User row is kept with placeholder name and email, so votes and restaurants
+// of the user keep their history. Credentials of the user are removed, anonymization can not be reverted.
+// Audit events refer to the user by ID only and their snapshots are stored without
+// name and email, so nothing has to be removed from the audit log.
+func (r *Repo) Anonymize(ctx context.Context, id string, now time.Time) (*User, error) {
+	u, err := r.RetrieveInOrg(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Active {
+		return nil, ErrNotDeactivated
+	}
+
+	hash, err := randomPasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "begin anonymization")
+	}
+	defer tx.Rollback()
+
+	const qu = `UPDATE users SET
+		"name" = $3,
+		"email" = $4,
+		"password_hash" = $5,
+		"office_id" = NULL,
+		"failed_logins" = 0,
+		"locked_until" = NULL,
+		"external_issuer" = NULL,
+		"external_subject" = NULL,
+		"deleted_at" = COALESCE(deleted_at, $6),
+		"date_updated" = $6,
+		"version" = version + 1
+		WHERE user_id = $1 AND org_id = $2 AND NOT active
+		RETURNING *`
+	if err := tx.GetContext(ctx, u, qu, u.ID, u.OrgID, anonymizedName, anonymizedEmail(u.ID), hash, now.UTC()); err != nil {
+		return nil, errors.Wrapf(err, "anonymizing user %s", id)
+	}
+
+	for _, table := range []string{"user_mfa", "recovery_code", "mfa_challenge", "refresh_token",
+		"access_token", "password_reset", "user_verification"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, u.ID); err != nil {
+			return nil, errors.Wrapf(err, "deleting %s of user %s", table, id)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit anonymization")
+	}
+	return u, nil
+}
+
+// anonymizedEmail returns placeholder email of anonymized user, it keeps email
+// unique and can not be delivered.
+func anonymizedEmail(id string) string {
+	return "deleted-" + id + "@invalid"
+}
+
+// statusFilter returns condition of users query matching the status.
+func statusFilter(status Status) (string, error) {
+	switch status {
+	case StatusActive:
+		return `active AND email_verified`, nil
+	case StatusInactive:
+		return `NOT (active AND email_verified)`, nil
+	case StatusAll:
+		return `TRUE`, nil
+	}
+	return "", ErrInvalidStatus
+}
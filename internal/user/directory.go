@@ -0,0 +1,170 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/org"
+	"github.com/remisb/mat/internal/password"
+	"time"
+)
+
+// SearchUsers retrieves a page of the organization users ordered by creation
+// date. Only user with passed email is selected when email is not empty, emails
+// are compared case insensitively. Total count of matching users is returned
+// together with the page.
+func (r *Repo) SearchUsers(ctx context.Context, email string, offset, limit int) ([]User, int, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	const qc = `SELECT COUNT(*) FROM users WHERE org_id = $1 AND ($2 = '' OR lower(email) = lower($2))`
+	if err := r.db.GetContext(ctx, &total, qc, orgID, email); err != nil {
+		return nil, 0, errors.Wrap(err, "counting users")
+	}
+
+	users := make([]User, 0)
+	const q = `SELECT * FROM users WHERE org_id = $1 AND ($2 = '' OR lower(email) = lower($2))
+		ORDER BY date_created, user_id OFFSET $3 LIMIT $4`
+	if err := r.db.SelectContext(ctx, &users, q, orgID, email, offset, limit); err != nil {
+		return nil, 0, errors.Wrap(err, "selecting users")
+	}
+	return users, total, nil
+}
+
+// RoleMembers retrieves users of the organization having passed role.
+func (r *Repo) RoleMembers(ctx context.Context, role string) ([]User, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]User, 0)
+	const q = `SELECT * FROM users WHERE org_id = $1 AND $2 = ANY(roles) ORDER BY email`
+	if err := r.db.SelectContext(ctx, &users, q, orgID, role); err != nil {
+		return nil, errors.Wrapf(err, "selecting %s role members", role)
+	}
+	return users, nil
+}
+
+// CreateDirectoryUser inserts a new user of the context organization provisioned
+// by external identity provider. User gets USER role, random password is set
+// when password is not passed.
+func (r *Repo) CreateDirectoryUser(ctx context.Context, du DirectoryUser, now time.Time) (*User, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := directoryPasswordHash(du.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	u := User{
+		ID:            uuid.New().String(),
+		Name:          du.Name,
+		Email:         du.Email,
+		PasswordHash:  hash,
+		Roles:         []string{RoleUser},
+		OrgID:         orgID,
+		DateCreated:   now.UTC(),
+		DateUpdated:   now.UTC(),
+		EmailVerified: true,
+		Active:        du.Active,
+		Version:       1,
+		Origin:        OriginDirectory,
+	}
+
+	const q = `INSERT INTO users
+		(user_id, name, email, password_hash, roles, org_id, date_created, date_updated, active, origin)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err = r.db.ExecContext(ctx, q,
+		u.ID, u.Name, u.Email,
+		u.PasswordHash, u.Roles, u.OrgID,
+		u.DateCreated, u.DateUpdated, u.Active, u.Origin,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrEmailTaken
+		}
+		return nil, errors.Wrap(err, "inserting directory user")
+	}
+	return &u, nil
+}
+
+// UpdateDirectoryUser replaces name, email and active state of the user managed
+// by external identity provider. Password is changed only when it is passed.
+// Deactivation is the same as Deactivate, deactivated user can not sign in, refresh
+// tokens or use access tokens. Activation reverts Delete too, anonymized user can
+// not be activated.
+func (r *Repo) UpdateDirectoryUser(ctx context.Context, id string, du DirectoryUser, now time.Time) (*User, error) {
+	u, err := r.RetrieveInOrg(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if du.Active && u.Anonymized() {
+		return nil, ErrAnonymized
+	}
+
+	hash := u.PasswordHash
+	if du.Password != "" {
+		if hash, err = directoryPasswordHash(du.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	const q = `UPDATE users SET
+		"name" = $3,
+		"email" = $4,
+		"active" = $5,
+		"deleted_at" = CASE WHEN $5::boolean THEN NULL ELSE deleted_at END,
+		"password_hash" = $6,
+		"date_updated" = $7,
+		"version" = version + 1
+		WHERE user_id = $1 AND org_id = $2
+		RETURNING *`
+	err = r.db.GetContext(ctx, u, q, u.ID, u.OrgID, du.Name, du.Email, du.Active, hash, now.UTC())
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrEmailTaken
+		}
+		return nil, errors.Wrapf(err, "updating directory user %s", id)
+	}
+
+	if du.Password != "" {
+		if err := r.deletePasswordResets(ctx, r.db, id); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// directoryPasswordHash checks passed password against the password policy and
+// hashes it, hash of random password is returned for empty password.
+func directoryPasswordHash(pw string) ([]byte, error) {
+	if pw != "" {
+		return newPasswordHash(pw)
+	}
+	return randomPasswordHash()
+}
+
+// randomPasswordHash returns hash of random password, user having it can sign
+// in only through the identity provider.
+func randomPasswordHash() ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, errors.Wrap(err, "generating password")
+	}
+
+	hash, err := password.Hash(hex.EncodeToString(secret))
+	if err != nil {
+		return nil, errors.Wrap(err, "generating password hash")
+	}
+	return hash, nil
+}
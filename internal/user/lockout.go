@@ -0,0 +1,139 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/org"
+	"time"
+)
+
+const (
+	// LockoutThreshold is a number of consecutive failed logins locking the account.
+	LockoutThreshold = 5
+
+	lockoutDuration    = time.Minute
+	maxLockoutDuration = time.Hour
+
+	// unknownFailuresTTL is how long failed logins of unknown emails are kept.
+	unknownFailuresTTL = 24 * time.Hour
+)
+
+// ErrAccountLocked returned when account is temporarily locked after failed logins.
+var ErrAccountLocked = errors.New("account is temporarily locked due to failed login attempts, try again later")
+
+// LockedUntil returns time until account with passed email is locked, nil is
+// returned when account is not locked. Emails without account are locked the same
+// way so lock does not reveal which emails are registered.
+func (r *Repo) LockedUntil(ctx context.Context, email string, now time.Time) (*time.Time, error) {
+	var until *time.Time
+	const q = `SELECT MAX(locked_until) FROM (
+		SELECT locked_until FROM users WHERE email = $1
+		UNION ALL
+		SELECT locked_until FROM login_failure WHERE email = $1) AS l`
+	if err := r.db.GetContext(ctx, &until, q, email); err != nil {
+		return nil, errors.Wrap(err, "selecting account lock")
+	}
+
+	if until == nil || !until.After(now) {
+		return nil, nil
+	}
+	return until, nil
+}
+
+// RecordLoginFailure counts failed login of the account with passed email. Account is
+// locked when LockoutThreshold is reached, every further failure doubles lock duration
+// up to an hour. Returned time is set when account was locked by this failure.
+// Failures of emails without account are counted in login_failure table.
+func (r *Repo) RecordLoginFailure(ctx context.Context, email string, now time.Time) (*time.Time, error) {
+	table := "users"
+	var failed int
+	const qf = `UPDATE users SET failed_logins = failed_logins + 1
+		WHERE email = $1
+		RETURNING failed_logins`
+	err := r.db.GetContext(ctx, &failed, qf, email)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		table = "login_failure"
+		if failed, err = r.recordUnknownFailure(ctx, email, now); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Wrap(err, "counting failed login")
+	}
+
+	if failed < LockoutThreshold {
+		return nil, nil
+	}
+
+	duration := maxLockoutDuration
+	if shift := uint(failed - LockoutThreshold); shift < 8 {
+		if d := lockoutDuration << shift; d < maxLockoutDuration {
+			duration = d
+		}
+	}
+	until := now.Add(duration).UTC()
+
+	ql := `UPDATE ` + table + ` SET locked_until = $2 WHERE email = $1`
+	if _, err := r.db.ExecContext(ctx, ql, email, until); err != nil {
+		return nil, errors.Wrap(err, "locking account")
+	}
+	return &until, nil
+}
+
+// recordUnknownFailure counts failed login of email without account. Failures not
+// repeated within unknownFailuresTTL are dropped.
+func (r *Repo) recordUnknownFailure(ctx context.Context, email string, now time.Time) (int, error) {
+	const qd = `DELETE FROM login_failure WHERE date_updated < $1`
+	if _, err := r.db.ExecContext(ctx, qd, now.Add(-unknownFailuresTTL).UTC()); err != nil {
+		return 0, errors.Wrap(err, "deleting expired login failures")
+	}
+
+	var failed int
+	const q = `INSERT INTO login_failure (email, failed_logins, date_updated)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (email) DO UPDATE SET
+		failed_logins = login_failure.failed_logins + 1,
+		date_updated = EXCLUDED.date_updated
+		RETURNING failed_logins`
+	if err := r.db.GetContext(ctx, &failed, q, email, now.UTC()); err != nil {
+		return 0, errors.Wrap(err, "counting failed login of unknown email")
+	}
+	return failed, nil
+}
+
+// ResetLoginFailures clears failed logins counter of the account after successful login.
+func (r *Repo) ResetLoginFailures(ctx context.Context, email string) error {
+	const q = `UPDATE users SET failed_logins = 0, locked_until = NULL
+		WHERE email = $1 AND (failed_logins > 0 OR locked_until IS NOT NULL)`
+	if _, err := r.db.ExecContext(ctx, q, email); err != nil {
+		return errors.Wrap(err, "resetting failed logins")
+	}
+	return nil
+}
+
+// Unlock removes lock and clears failed logins counter of the account with passed
+// email. Only accounts of the context organization are unlocked.
+func (r *Repo) Unlock(ctx context.Context, email string) error {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	const q = `UPDATE users SET failed_logins = 0, locked_until = NULL WHERE email = $1 AND org_id = $2`
+	res, err := r.db.ExecContext(ctx, q, email, orgID)
+	if err != nil {
+		return errors.Wrap(err, "unlocking account")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "unlocked count")
+	}
+	if count == 0 {
+		return db.ErrNotFound
+	}
+	return nil
+}
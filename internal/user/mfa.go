@@ -0,0 +1,311 @@
+package user
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/totp"
+	"strings"
+	"time"
+)
+
+const (
+	// MFAIssuer is the issuer shown by authenticator apps next to the account.
+	MFAIssuer = "mat"
+
+	// recoveryCodeCount is a number of recovery codes generated when two-factor
+	// authentication is enabled.
+	recoveryCodeCount = 10
+)
+
+var (
+	// ErrMFAEnabled returned on enrollment when two-factor authentication is already enabled.
+	ErrMFAEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrMFANotEnrolled returned when two-factor authentication is not enrolled or enabled.
+	ErrMFANotEnrolled = errors.New("two-factor authentication is not enrolled")
+	// ErrInvalidMFACode returned when authentication or recovery code does not match.
+	ErrInvalidMFACode = errors.New("two-factor authentication code is not valid")
+	// ErrMFAKeyNotSet returned on enrollment when key encrypting secrets is not configured.
+	ErrMFAKeyNotSet = errors.New("two-factor authentication secret key is not configured")
+	// ErrInvalidMFAKey returned when key encrypting secrets is not 32 bytes long.
+	ErrInvalidMFAKey = errors.New("two-factor authentication secret key should be 32 bytes long")
+)
+
+// mfaKey encrypts two-factor authentication secrets stored in the database.
+var mfaKey cipher.AEAD
+
+// SetMFAKey sets AES-256 key encrypting two-factor authentication secrets. The
+// key can not be changed while users have two-factor authentication enabled.
+func SetMFAKey(key []byte) error {
+	if len(key) != 32 {
+		return ErrInvalidMFAKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return errors.Wrap(err, "creating mfa key cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return errors.Wrap(err, "creating mfa key cipher")
+	}
+	mfaKey = aead
+	return nil
+}
+
+// MFAEnrollment is returned on two-factor authentication enrollment. URI is
+// rendered as QR code scanned by authenticator app, Secret is entered manually.
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// MFAStatus describes two-factor authentication state of the user.
+type MFAStatus struct {
+	Enabled       bool       `db:"enabled" json:"enabled"`
+	DateEnabled   *time.Time `db:"date_enabled" json:"dateEnabled,omitempty"`
+	RecoveryCodes int        `db:"recovery_codes" json:"recoveryCodes"`
+}
+
+// MFAEnabled reports if the user has two-factor authentication enabled.
+func (r *Repo) MFAEnabled(ctx context.Context, userID string) (bool, error) {
+	var enabled bool
+	const q = `SELECT EXISTS (SELECT 1 FROM user_mfa WHERE user_id = $1 AND enabled)`
+	if err := r.db.GetContext(ctx, &enabled, q, userID); err != nil {
+		return false, errors.Wrap(err, "selecting mfa state")
+	}
+	return enabled, nil
+}
+
+// MFAStatus returns two-factor authentication state of the user together with
+// a number of unused recovery codes.
+func (r *Repo) MFAStatus(ctx context.Context, userID string) (*MFAStatus, error) {
+	var s MFAStatus
+	const q = `SELECT
+		COALESCE((SELECT enabled FROM user_mfa WHERE user_id = $1), FALSE) AS enabled,
+		(SELECT date_enabled FROM user_mfa WHERE user_id = $1) AS date_enabled,
+		(SELECT COUNT(*) FROM recovery_code WHERE user_id = $1 AND date_used IS NULL) AS recovery_codes`
+	if err := r.db.GetContext(ctx, &s, q, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting mfa status")
+	}
+	return &s, nil
+}
+
+// EnrollMFA generates a new secret of the user. Two-factor authentication is
+// enabled only after the first code is confirmed with EnableMFA, repeated
+// enrollment replaces not confirmed secret.
+func (r *Repo) EnrollMFA(ctx context.Context, userID string, now time.Time) (*MFAEnrollment, error) {
+	u, err := r.retrieve(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealSecret(u.ID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `INSERT INTO user_mfa (user_id, secret, date_created) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = $2, last_counter = 0, date_created = $3
+		WHERE NOT user_mfa.enabled`
+	res, err := r.db.ExecContext(ctx, q, u.ID, sealed, now.UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting mfa secret")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, "rows affected")
+	}
+	if rows == 0 {
+		return nil, ErrMFAEnabled
+	}
+
+	return &MFAEnrollment{Secret: secret, URI: totp.URI(MFAIssuer, u.Email, secret)}, nil
+}
+
+// EnableMFA confirms enrolled secret with the code of authenticator app and
+// enables two-factor authentication. Recovery codes are returned once, only
+// their hashes are stored.
+func (r *Repo) EnableMFA(ctx context.Context, userID, code string, now time.Time) ([]string, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "begin mfa enable")
+	}
+	defer tx.Rollback()
+
+	var sealed string
+	const qs = `SELECT secret FROM user_mfa WHERE user_id = $1 AND NOT enabled FOR UPDATE`
+	if err := tx.GetContext(ctx, &sealed, qs, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMFANotEnrolled
+		}
+		return nil, errors.Wrap(err, "selecting mfa secret")
+	}
+	secret, err := openSecret(userID, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	counter, ok := totp.Validate(secret, normalizeCode(code), now)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	const qu = `UPDATE user_mfa SET enabled = TRUE, last_counter = $2, date_enabled = $3 WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qu, userID, counter, now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "enabling mfa")
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		c, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		const qc = `INSERT INTO recovery_code (user_id, code_hash) VALUES ($1, $2)`
+		if _, err := tx.ExecContext(ctx, qc, userID, hashToken(normalizeCode(c))); err != nil {
+			return nil, errors.Wrap(err, "inserting recovery code")
+		}
+		codes = append(codes, c)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit mfa enable")
+	}
+	return codes, nil
+}
+
+// VerifyMFA checks the code of authenticator app or unused recovery code of the
+// user. Each code is accepted only once.
+func (r *Repo) VerifyMFA(ctx context.Context, userID, code string, now time.Time) error {
+	var sealed string
+	const qs = `SELECT secret FROM user_mfa WHERE user_id = $1 AND enabled`
+	if err := r.db.GetContext(ctx, &sealed, qs, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrMFANotEnrolled
+		}
+		return errors.Wrap(err, "selecting mfa secret")
+	}
+	secret, err := openSecret(userID, sealed)
+	if err != nil {
+		return err
+	}
+
+	code = normalizeCode(code)
+	if counter, ok := totp.Validate(secret, code, now); ok {
+		// code of already used period is rejected, it could be observed by an attacker
+		const qu = `UPDATE user_mfa SET last_counter = $2 WHERE user_id = $1 AND last_counter < $2`
+		return r.useCode(ctx, qu, userID, counter)
+	}
+
+	const qr = `UPDATE recovery_code SET date_used = $3
+		WHERE user_id = $1 AND code_hash = $2 AND date_used IS NULL`
+	return r.useCode(ctx, qr, userID, hashToken(code), now.UTC())
+}
+
+// DisableMFA disables two-factor authentication of the user when passed code is
+// valid, secret and recovery codes are deleted.
+func (r *Repo) DisableMFA(ctx context.Context, userID, code string, now time.Time) error {
+	if err := r.VerifyMFA(ctx, userID, code, now); err != nil {
+		return err
+	}
+	return r.ResetMFA(ctx, userID)
+}
+
+// ResetMFA deletes two-factor authentication secret and recovery codes of the
+// user without code verification. It is used by admins when user lost the device
+// and recovery codes.
+func (r *Repo) ResetMFA(ctx context.Context, userID string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin mfa reset")
+	}
+	defer tx.Rollback()
+
+	const qm = `DELETE FROM user_mfa WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qm, userID); err != nil {
+		return errors.Wrap(err, "deleting mfa secret")
+	}
+
+	const qc = `DELETE FROM recovery_code WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qc, userID); err != nil {
+		return errors.Wrap(err, "deleting recovery codes")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit mfa reset")
+	}
+	return nil
+}
+
+// useCode executes update marking the code as used, ErrInvalidMFACode is returned
+// when nothing is updated.
+func (r *Repo) useCode(ctx context.Context, q string, args ...interface{}) error {
+	res, err := r.db.ExecContext(ctx, q, args...)
+	if err != nil {
+		return errors.Wrap(err, "using mfa code")
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "rows affected")
+	}
+	if rows == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// sealSecret encrypts two-factor authentication secret of the user with mfaKey,
+// user ID is authenticated together with the secret, so the secret can not be
+// moved to another user.
+func sealSecret(userID, secret string) (string, error) {
+	if mfaKey == nil {
+		return "", ErrMFAKeyNotSet
+	}
+	nonce := make([]byte, mfaKey.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", errors.Wrap(err, "generating mfa secret nonce")
+	}
+	sealed := mfaKey.Seal(nonce, nonce, []byte(secret), []byte(userID))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openSecret decrypts two-factor authentication secret of the user sealed by sealSecret.
+func openSecret(userID, sealed string) (string, error) {
+	if mfaKey == nil {
+		return "", ErrMFAKeyNotSet
+	}
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(b) < mfaKey.NonceSize() {
+		return "", errors.New("decoding mfa secret")
+	}
+	secret, err := mfaKey.Open(nil, b[:mfaKey.NonceSize()], b[mfaKey.NonceSize():], []byte(userID))
+	if err != nil {
+		return "", errors.Wrap(err, "decrypting mfa secret")
+	}
+	return string(secret), nil
+}
+
+// newRecoveryCode generates recovery code formatted as xxxxx-xxxxx.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating recovery code")
+	}
+	c := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return c[:5] + "-" + c[5:], nil
+}
+
+// normalizeCode removes separators users type or copy together with the code.
+func normalizeCode(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return strings.ToLower(code)
+}
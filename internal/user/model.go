@@ -8,15 +8,44 @@ import (
 // User represents someone with access to our system.
 // User example
 type User struct {
-	ID           string         `db:"user_id" json:"id"`
-	Name         string         `db:"name" json:"name"`
-	Email        string         `db:"email" json:"email"`
-	Roles        pq.StringArray `db:"roles" json:"roles"`
-	PasswordHash []byte         `db:"password_hash" json:"-"`
-	DateCreated  time.Time      `db:"date_created" json:"date_created"`
-	DateUpdated  time.Time      `db:"date_updated" json:"date_updated"`
+	ID            string         `db:"user_id" json:"id"`
+	Name          string         `db:"name" json:"name"`
+	Email         string         `db:"email" json:"email"`
+	Roles         pq.StringArray `db:"roles" json:"roles"`
+	OrgID         string         `db:"org_id" json:"orgId"`
+	OfficeID      *string        `db:"office_id" json:"officeId,omitempty"`
+	PasswordHash  []byte         `db:"password_hash" json:"-"`
+	DateCreated   time.Time      `db:"date_created" json:"date_created"`
+	DateUpdated   time.Time      `db:"date_updated" json:"date_updated"`
+	EmailVerified bool           `db:"email_verified" json:"emailVerified"`
+	Active        bool           `db:"active" json:"active"`
+	FailedLogins  int            `db:"failed_logins" json:"-"`
+	LockedUntil   *time.Time     `db:"locked_until" json:"lockedUntil,omitempty"`
+	DeletedAt     *time.Time     `db:"deleted_at" json:"deletedAt,omitempty"`
+	Version       int            `db:"version" json:"-"`
+
+	Origin          string  `db:"origin" json:"-"`
+	ExternalIssuer  *string `db:"external_issuer" json:"-"`
+	ExternalSubject *string `db:"external_subject" json:"-"`
+}
+
+// Redacted returns the user without name and email, it is stored in audit log
+// snapshots which can not be erased on anonymization.
+func (u User) Redacted() interface{} {
+	u.Name, u.Email = "", ""
+	return u
 }
 
+// These are the expected values for User.Origin.
+const (
+	// OriginLocal marks users created by admin or self-service registration.
+	OriginLocal = "local"
+	// OriginDirectory marks users provisioned by identity provider directory with SCIM.
+	OriginDirectory = "directory"
+	// OriginExternal marks users created on the first sign in with identity provider.
+	OriginExternal = "external"
+)
+
 // NewUser contains information needed to create a new User.
 type NewUser struct {
 	Name            string   `json:"name" validate:"required"`
@@ -26,6 +55,58 @@ type NewUser struct {
 	PasswordConfirm string   `json:"password_confirm" validate:"eqfield=Password"`
 }
 
+// DirectoryUser contains user attributes managed by external identity provider
+// through SCIM provisioning. Password is optional, user created without password
+// can sign in only through single sign-on. User is deactivated when Active is not
+// set, email of the directory user is verified by the provider.
+type DirectoryUser struct {
+	Name     string
+	Email    string
+	Password string
+	Active   bool
+}
+
+// ExternalUser contains user identity asserted by external identity provider.
+// Subject identifies the user within the provider Issuer.
+type ExternalUser struct {
+	Issuer  string
+	Subject string
+	Email   string
+	Name    string
+	Roles   []string
+}
+
+// NewRegistration contains information needed for self-service user registration.
+type NewRegistration struct {
+	Name            string `json:"name" validate:"required"`
+	Email           string `json:"email" validate:"required"`
+	Password        string `json:"password" validate:"required"`
+	PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+}
+
+// PasswordReset contains information needed to set new password with reset token.
+type PasswordReset struct {
+	Token           string `json:"token" validate:"required"`
+	Password        string `json:"password" validate:"required"`
+	PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+}
+
+// PasswordChange contains information needed to change password of the signed in user.
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	Password        string `json:"password" validate:"required"`
+	PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+}
+
+// UpdateProfile defines what information users may change in their own profile.
+// Roles and password are changed by dedicated endpoints, empty office ID removes
+// the home office.
+type UpdateProfile struct {
+	Name     *string `json:"name"`
+	Email    *string `json:"email"`
+	OfficeID *string `json:"officeId"`
+}
+
 // UpdateUser defines what information may be provided to modify an existing
 // User. All fields are optional so clients can send just the fields they want
 // changed. It uses pointer fields so we can differentiate between a field that
@@ -38,4 +119,5 @@ type UpdateUser struct {
 	Roles           []string `json:"roles"`
 	Password        *string  `json:"password"`
 	PasswordConfirm *string  `json:"password_confirm" validate:"omitempty,eqfield=Password"`
+	OfficeID        *string  `json:"officeId"`
 }
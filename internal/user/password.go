@@ -0,0 +1,168 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/db"
+	"github.com/remisb/mat/internal/password"
+	"time"
+)
+
+// passwordResetTTL is a duration password reset token stays valid.
+const passwordResetTTL = time.Hour
+
+var (
+	// ErrInvalidResetToken returned when password reset token is unknown, used or expired.
+	ErrInvalidResetToken = errors.New("password reset token is invalid or expired")
+	// ErrWrongPassword returned when current password passed on password change does not match.
+	ErrWrongPassword = errors.New("current password is not correct")
+)
+
+// RequestPasswordReset creates password reset token for the active user with passed email.
+// Only hash of the token is stored, the token itself is returned to be sent to the user.
+// db.ErrNotFound is returned when there is no active user with such email.
+func (r *Repo) RequestPasswordReset(ctx context.Context, email string, now time.Time) (*User, string, error) {
+	var u User
+	const qu = `SELECT * FROM users WHERE email = $1 AND email_verified AND active`
+	if err := r.db.GetContext(ctx, &u, qu, email); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", db.ErrNotFound
+		}
+		return nil, "", errors.Wrap(err, "selecting user by email")
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	const qr = `INSERT INTO password_reset
+		(token_hash, user_id, date_expires, date_created)
+		VALUES ($1, $2, $3, $4)`
+	_, err = r.db.ExecContext(ctx, qr, hashToken(token), u.ID, now.Add(passwordResetTTL).UTC(), now.UTC())
+	if err != nil {
+		return nil, "", errors.Wrap(err, "inserting password reset")
+	}
+
+	return &u, token, nil
+}
+
+// ResetPassword sets new password for the user owning passed reset token.
+// All outstanding reset tokens, refresh tokens and personal access tokens of the user
+// are invalidated, ID of the user is returned.
+func (r *Repo) ResetPassword(ctx context.Context, token, pw string, now time.Time) (string, error) {
+	hash, err := newPasswordHash(pw)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "begin password reset")
+	}
+	defer tx.Rollback()
+
+	var userID string
+	const qd = `DELETE FROM password_reset
+		WHERE token_hash = $1 AND date_expires > $2
+		RETURNING user_id`
+	if err := tx.GetContext(ctx, &userID, qd, hashToken(token), now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrInvalidResetToken
+		}
+		return "", errors.Wrap(err, "deleting password reset")
+	}
+
+	const qu = `UPDATE users SET
+		"password_hash" = $2,
+		"date_updated" = $3,
+		"version" = version + 1
+		WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qu, userID, hash, now.UTC()); err != nil {
+		return "", errors.Wrap(err, "updating password")
+	}
+
+	if err := r.deletePasswordResets(ctx, tx, userID); err != nil {
+		return "", err
+	}
+	if err := r.deleteSessions(ctx, tx, userID); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", errors.Wrap(err, "commit password reset")
+	}
+	return userID, nil
+}
+
+// ChangePassword sets new password for the user when passed current password matches.
+// All outstanding reset tokens, refresh tokens and personal access tokens of the user
+// are invalidated.
+func (r *Repo) ChangePassword(ctx context.Context, id, current, pw string, now time.Time) (*User, error) {
+	u, err := r.retrieve(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := password.Verify(u.PasswordHash, current); err != nil {
+		return nil, ErrWrongPassword
+	}
+
+	hash, err := newPasswordHash(pw)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "begin password change")
+	}
+	defer tx.Rollback()
+
+	const q = `UPDATE users SET
+		"password_hash" = $2,
+		"date_updated" = $3,
+		"version" = version + 1
+		WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, q, id, hash, now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "updating password")
+	}
+
+	if err := r.deletePasswordResets(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	if err := r.deleteSessions(ctx, tx, id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit password change")
+	}
+
+	u.PasswordHash = hash
+	u.DateUpdated = now.UTC()
+	u.Version++
+	return u, nil
+}
+
+// deleteSessions revokes refresh tokens and personal access tokens of the user, so
+// credentials issued before password change can not be used anymore.
+func (r *Repo) deleteSessions(ctx context.Context, ex sqlx.ExecerContext, userID string) error {
+	for _, table := range []string{"refresh_token", "access_token"} {
+		if _, err := ex.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, userID); err != nil {
+			return errors.Wrapf(err, "deleting %s of user %s", table, userID)
+		}
+	}
+	return nil
+}
+
+// deletePasswordResets invalidates all outstanding password reset tokens of the user.
+func (r *Repo) deletePasswordResets(ctx context.Context, ex sqlx.ExecerContext, userID string) error {
+	const q = `DELETE FROM password_reset WHERE user_id = $1`
+	if _, err := ex.ExecContext(ctx, q, userID); err != nil {
+		return errors.Wrap(err, "deleting password resets")
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/org"
+	"time"
+)
+
+// verificationTTL is a duration verification token stays valid.
+const verificationTTL = 48 * time.Hour
+
+var (
+	// ErrEmailTaken returned when user with the same email already exists.
+	ErrEmailTaken = errors.New("email is already registered")
+	// ErrInvalidToken returned when verification token is unknown or expired.
+	ErrInvalidToken = errors.New("verification token is invalid or expired")
+	// ErrNotVerified returned when user has not verified email yet.
+	ErrNotVerified = errors.New("user email is not verified")
+)
+
+// Register inserts a new inactive user with USER role of the default organization
+// into the database and creates email verification token. The send function is called with the token
+// before registration is committed, failed send cancels the registration.
+func (r *Repo) Register(ctx context.Context, nr NewRegistration, now time.Time,
+	send func(u User, token string) error) (*User, error) {
+
+	hash, err := newPasswordHash(nr.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	u := User{
+		ID:            uuid.New().String(),
+		Name:          nr.Name,
+		Email:         nr.Email,
+		PasswordHash:  hash,
+		Roles:         []string{RoleUser},
+		OrgID:         org.DefaultID,
+		DateCreated:   now.UTC(),
+		DateUpdated:   now.UTC(),
+		EmailVerified: false,
+		Active:        true,
+		Version:       1,
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "begin registration")
+	}
+	defer tx.Rollback()
+
+	const qu = `INSERT INTO users
+		(user_id, name, email, password_hash, roles, org_id, date_created, date_updated, email_verified)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, FALSE)`
+	_, err = tx.ExecContext(ctx, qu,
+		u.ID, u.Name, u.Email,
+		u.PasswordHash, u.Roles, u.OrgID,
+		u.DateCreated, u.DateUpdated,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrEmailTaken
+		}
+		return nil, errors.Wrap(err, "inserting user")
+	}
+
+	const qv = `INSERT INTO user_verification
+		(token_hash, user_id, date_expires, date_created)
+		VALUES ($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, qv, hashToken(token), u.ID, now.Add(verificationTTL).UTC(), now.UTC())
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting verification")
+	}
+
+	if err := send(u, token); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit registration")
+	}
+	return &u, nil
+}
+
+// RequestEmailChange creates verification token of the new email of the user, email
+// is changed only when the token is verified. The send function is called with the
+// user having the new email and the token before the request is committed, failed
+// send cancels the request. Previous pending email change of the user is replaced.
+func (r *Repo) RequestEmailChange(ctx context.Context, id, email string, now time.Time,
+	send func(u User, token string) error) error {
+
+	u, err := r.retrieve(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	var taken bool
+	const qe = `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`
+	if err := r.db.GetContext(ctx, &taken, qe, email); err != nil {
+		return errors.Wrap(err, "selecting email")
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin email change")
+	}
+	defer tx.Rollback()
+
+	const qd = `DELETE FROM user_verification WHERE user_id = $1 AND email IS NOT NULL`
+	if _, err := tx.ExecContext(ctx, qd, u.ID); err != nil {
+		return errors.Wrap(err, "deleting pending email change")
+	}
+
+	const qv = `INSERT INTO user_verification
+		(token_hash, user_id, email, date_expires, date_created)
+		VALUES ($1, $2, $3, $4, $5)`
+	_, err = tx.ExecContext(ctx, qv, hashToken(token), u.ID, email, now.Add(verificationTTL).UTC(), now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "inserting email verification")
+	}
+
+	u.Email = email
+	if err := send(*u, token); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit email change")
+	}
+	return nil
+}
+
+// Verify marks email of the user owning passed verification token as verified or changes email of
+// the user when token verifies the new email. Token can be used only once.
+func (r *Repo) Verify(ctx context.Context, token string, now time.Time) (*User, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "begin verification")
+	}
+	defer tx.Rollback()
+
+	var v struct {
+		UserID string  `db:"user_id"`
+		Email  *string `db:"email"`
+	}
+	const qd = `DELETE FROM user_verification
+		WHERE token_hash = $1 AND date_expires > $2
+		RETURNING user_id, email`
+	if err := tx.GetContext(ctx, &v, qd, hashToken(token), now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidToken
+		}
+		return nil, errors.Wrap(err, "deleting verification")
+	}
+
+	var u User
+	if v.Email != nil {
+		const qe = `UPDATE users SET
+			"email" = $2,
+			"date_updated" = $3,
+			"version" = version + 1
+			WHERE user_id = $1
+			RETURNING *`
+		if err := tx.GetContext(ctx, &u, qe, v.UserID, *v.Email, now.UTC()); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return nil, ErrEmailTaken
+			}
+			return nil, errors.Wrap(err, "changing email")
+		}
+	} else {
+		const qu = `UPDATE users SET
+			"email_verified" = TRUE,
+			"date_updated" = $2,
+			"version" = version + 1
+			WHERE user_id = $1
+			RETURNING *`
+		if err := tx.GetContext(ctx, &u, qu, v.UserID, now.UTC()); err != nil {
+			return nil, errors.Wrap(err, "verifying user email")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit verification")
+	}
+	return &u, nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating token")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
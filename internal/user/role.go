@@ -0,0 +1,143 @@
+package user
+
+import (
+	"context"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/authorize"
+	"github.com/remisb/mat/internal/db"
+	"regexp"
+	"time"
+)
+
+// RoleRestaurantManager is used to mark user managing restaurants and their menus.
+const RoleRestaurantManager = "RESTAURANT_MANAGER"
+
+var (
+	// ErrUnknownRole returned when user is assigned a role which is not defined.
+	ErrUnknownRole = errors.New("role is not defined")
+	// ErrRoleExists returned when role with the same name is already defined.
+	ErrRoleExists = errors.New("role already exists")
+	// ErrInvalidRole returned when role name or permissions are malformed.
+	ErrInvalidRole = errors.New("role name should be upper case letters and underscores, permissions should be object:action of known objects and actions")
+)
+
+var roleNameRe = regexp.MustCompile(`^[A-Z][A-Z_]*$`)
+
+// Role is a named set of permissions assigned to users.
+type Role struct {
+	Name        string         `db:"name" json:"name"`
+	Description string         `db:"description" json:"description"`
+	Permissions pq.StringArray `db:"permissions" json:"permissions"`
+	Builtin     bool           `db:"builtin" json:"builtin"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+}
+
+// NewRole contains information needed to define a new Role. Permissions are
+// listed as object:action, like restaurant:update.
+type NewRole struct {
+	Name        string   `json:"name" validate:"required"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// Roles retrieves all defined roles.
+func (r *Repo) Roles(ctx context.Context) ([]Role, error) {
+	roles := make([]Role, 0)
+	const q = `SELECT * FROM roles ORDER BY name`
+	if err := r.db.SelectContext(ctx, &roles, q); err != nil {
+		return nil, errors.Wrap(err, "selecting roles")
+	}
+	return roles, nil
+}
+
+// CreateRole defines a new role.
+func (r *Repo) CreateRole(ctx context.Context, nr NewRole, now time.Time) (*Role, error) {
+	if !roleNameRe.MatchString(nr.Name) {
+		return nil, ErrInvalidRole
+	}
+	for _, p := range nr.Permissions {
+		if _, err := authorize.PermissionRule(nr.Name, p); err != nil {
+			return nil, ErrInvalidRole
+		}
+	}
+
+	role := Role{
+		Name:        nr.Name,
+		Description: nr.Description,
+		Permissions: nr.Permissions,
+		DateCreated: now.UTC(),
+	}
+	if role.Permissions == nil {
+		role.Permissions = pq.StringArray{}
+	}
+
+	const q = `INSERT INTO roles (name, description, permissions, builtin, date_created)
+		VALUES ($1, $2, $3, FALSE, $4)`
+	_, err := r.db.ExecContext(ctx, q, role.Name, role.Description, role.Permissions, role.DateCreated)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrRoleExists
+		}
+		return nil, errors.Wrap(err, "inserting role")
+	}
+	return &role, nil
+}
+
+// AssignRoles replaces roles of the user. Roles are replaced only when passed version
+// matches the stored one, otherwise db.ErrVersionConflict is returned. New roles are
+// put into access tokens issued on the next login or token refresh.
+func (r *Repo) AssignRoles(ctx context.Context, id string, roles []string, version int, now time.Time) (*User, error) {
+	if err := r.checkRoles(ctx, roles); err != nil {
+		return nil, err
+	}
+
+	u, err := r.RetrieveInOrg(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Version != version {
+		return nil, db.ErrVersionConflict
+	}
+
+	const q = `UPDATE users SET roles = $2, date_updated = $3, version = version + 1
+		WHERE user_id = $1 AND org_id = $4 AND version = $5`
+	res, err := r.db.ExecContext(ctx, q, id, pq.StringArray(roles), now.UTC(), u.OrgID, version)
+	if err != nil {
+		return nil, errors.Wrap(err, "assigning roles")
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, "assigned roles count")
+	}
+	if count == 0 {
+		return nil, db.ErrVersionConflict
+	}
+
+	u.Roles = roles
+	u.DateUpdated = now.UTC()
+	u.Version++
+	return u, nil
+}
+
+// checkRoles returns ErrUnknownRole when any of passed roles is not defined.
+func (r *Repo) checkRoles(ctx context.Context, roles []string) error {
+	if len(roles) == 0 {
+		return nil
+	}
+
+	var count int
+	const qc = `SELECT COUNT(*) FROM roles WHERE name = ANY($1)`
+	if err := r.db.GetContext(ctx, &count, qc, pq.StringArray(roles)); err != nil {
+		return errors.Wrap(err, "counting roles")
+	}
+
+	unique := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		unique[role] = true
+	}
+	if count != len(unique) {
+		return ErrUnknownRole
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/remisb/mat/internal/org"
+	"time"
+)
+
+// ErrAccountNotLinked returned when external identity provider signs in user with
+// email of the account not linked to the provider.
+var ErrAccountNotLinked = errors.New("account with this email is not linked to the identity provider")
+
+// Provision finds user signed in by external identity provider by provider subject.
+// User is created in the default organization on the first sign in, such user has
+// random password so it can sign in only through the identity provider, name and
+// roles of the user are updated from the provider on every sign in. Existing
+// account is linked to the provider by email only when it was provisioned by the
+// directory and is not linked yet, other accounts are rejected with
+// ErrAccountNotLinked. Deactivated users are not reactivated, ErrDeactivated is
+// returned.
+func (r *Repo) Provision(ctx context.Context, eu ExternalUser, now time.Time) (*User, error) {
+	if eu.Issuer == "" || eu.Subject == "" {
+		return nil, errors.New("external user issuer and subject should be provided")
+	}
+
+	var u User
+	const qs = `SELECT * FROM users WHERE external_issuer = $1 AND external_subject = $2`
+	err := r.db.GetContext(ctx, &u, qs, eu.Issuer, eu.Subject)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return r.linkExternal(ctx, eu, now)
+	default:
+		return nil, errors.Wrap(err, "selecting user by external subject")
+	}
+	if !u.Active {
+		return nil, ErrDeactivated
+	}
+
+	// name and roles of the users provisioned by the directory are managed by the directory
+	if u.Origin != OriginExternal || (u.Name == eu.Name && equalRoles(u.Roles, eu.Roles)) {
+		return &u, nil
+	}
+
+	const qu = `UPDATE users SET
+		"name" = $2,
+		"roles" = $3,
+		"date_updated" = $4,
+		"version" = version + 1
+		WHERE user_id = $1
+		RETURNING *`
+	if err := r.db.GetContext(ctx, &u, qu, u.ID, eu.Name, pq.StringArray(eu.Roles), now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "updating provisioned user")
+	}
+	return &u, nil
+}
+
+// linkExternal links the directory user having email of external user to the
+// identity provider subject, user is created when email is not registered.
+func (r *Repo) linkExternal(ctx context.Context, eu ExternalUser, now time.Time) (*User, error) {
+	var u User
+	const qs = `SELECT * FROM users WHERE email = $1`
+	err := r.db.GetContext(ctx, &u, qs, eu.Email)
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		return r.createExternal(ctx, eu, now)
+	default:
+		return nil, errors.Wrap(err, "selecting user by email")
+	}
+
+	// local accounts keep signing in with password, linking them by email would let
+	// the provider take over the account
+	if u.Origin != OriginDirectory || u.ExternalSubject != nil {
+		return nil, ErrAccountNotLinked
+	}
+	if !u.Active {
+		return nil, ErrDeactivated
+	}
+
+	const qu = `UPDATE users SET
+		"external_issuer" = $2,
+		"external_subject" = $3,
+		"date_updated" = $4,
+		"version" = version + 1
+		WHERE user_id = $1 AND external_subject IS NULL
+		RETURNING *`
+	if err := r.db.GetContext(ctx, &u, qu, u.ID, eu.Issuer, eu.Subject, now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAccountNotLinked
+		}
+		return nil, errors.Wrap(err, "linking directory user")
+	}
+	return &u, nil
+}
+
+func (r *Repo) createExternal(ctx context.Context, eu ExternalUser, now time.Time) (*User, error) {
+	hash, err := randomPasswordHash()
+	if err != nil {
+		return nil, err
+	}
+
+	u := User{
+		ID:              uuid.New().String(),
+		Name:            eu.Name,
+		Email:           eu.Email,
+		PasswordHash:    hash,
+		Roles:           eu.Roles,
+		OrgID:           org.DefaultID,
+		DateCreated:     now.UTC(),
+		DateUpdated:     now.UTC(),
+		EmailVerified:   true,
+		Active:          true,
+		Origin:          OriginExternal,
+		ExternalIssuer:  &eu.Issuer,
+		ExternalSubject: &eu.Subject,
+		Version:         1,
+	}
+
+	const q = `INSERT INTO users
+		(user_id, name, email, password_hash, roles, org_id, date_created, date_updated,
+		origin, external_issuer, external_subject)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	_, err = r.db.ExecContext(ctx, q,
+		u.ID, u.Name, u.Email,
+		u.PasswordHash, u.Roles, u.OrgID,
+		u.DateCreated, u.DateUpdated,
+		u.Origin, u.ExternalIssuer, u.ExternalSubject,
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrAccountNotLinked
+		}
+		return nil, errors.Wrap(err, "inserting provisioned user")
+	}
+	return &u, nil
+}
+
+func equalRoles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, role := range a {
+		if !containsRole(b, role) {
+			return false
+		}
+	}
+	return true
+}
@@ -7,45 +7,68 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/remisb/mat/internal/db"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/remisb/mat/internal/log"
+	"github.com/remisb/mat/internal/org"
+	"github.com/remisb/mat/internal/password"
 	"time"
 )
 
 const (
-	// RoleAdmin is used to mark user to have Admin role.
+	// RoleSuperAdmin is used to mark service administrator managing all organizations.
+	RoleSuperAdmin = "SUPER_ADMIN"
+	// RoleAdmin is used to mark user to have Admin role within the organization.
 	RoleAdmin = "ADMIN"
 	// RoleUser is used to mark user to have a regular User role.
 	RoleUser = "USER"
 
 	pageSize   = 10
-	queryPaged = `SELECT * FROM users OFFSET $1 LIMIT $2`
-	queryAll   = `SELECT * FROM users`
+	queryPaged = `SELECT * FROM users WHERE org_id = $1 OFFSET $2 LIMIT $3`
+	queryAll   = `SELECT * FROM users WHERE org_id = $1`
 )
 
-// Repo is a user Repository structure.
+// ErrUnknownOffice returned when home office of the user is not found in the organization.
+var ErrUnknownOffice = errors.New("home office not found")
+
+// Repo is a user Repository structure. User management queries are scoped to
+// the organization stored in the context, authentication queries identify the
+// user by email, token or ID across organizations.
 type Repo struct {
-	db *sqlx.DB
+	db db.DB
 }
 
 // NewRepo is a factory function used to create new user Repository.
-func NewRepo(db *sqlx.DB) *Repo {
-	return &Repo{db}
+func NewRepo(dbx *sqlx.DB) *Repo {
+	return &Repo{db.Wrap(dbx)}
 }
 
 // ListPaged retrieves a list of existing users from the database with pagination.
 func ListPaged(ctx context.Context, db *sqlx.DB, page int) ([]User, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	offset := pageSize * (page - 1)
 	users := []User{}
-	if err := db.SelectContext(ctx, &users, queryPaged, offset, pageSize); err != nil {
+	if err := db.SelectContext(ctx, &users, queryPaged, orgID, offset, pageSize); err != nil {
 		return nil, errors.Wrap(err, "selecting users")
 	}
 	return users, nil
 }
 
-// GetUsers retrieves a list of existing users from the database.
-func (r *Repo) GetUsers(ctx context.Context) ([]User, error) {
+// GetUsers retrieves a list of existing users matching the status from the database.
+func (r *Repo) GetUsers(ctx context.Context, status Status) ([]User, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := statusFilter(status)
+	if err != nil {
+		return nil, err
+	}
+
 	users := make([]User, 0)
-	if err := r.db.SelectContext(ctx, &users, queryAll); err != nil {
+	if err := r.db.SelectContext(ctx, &users, queryAll+` AND `+filter, orgID); err != nil {
 		return nil, errors.Wrap(err, "selecting users")
 	}
 	return users, nil
@@ -58,10 +81,25 @@ func (r *Repo) Retrieve(ctx context.Context, id string, roles []string) (*User,
 	}
 
 	// If you are not an admin and looking to retrieve someone else then you are rejected.
-	if !containsRole(roles, RoleAdmin) {
+	if !containsRole(roles, RoleAdmin) && !containsRole(roles, RoleSuperAdmin) {
 		return nil, db.ErrForbidden
 	}
 
+	return r.RetrieveInOrg(ctx, id)
+}
+
+// RetrieveByID gets the specified user from the database without authorization
+// and organization check. It is used by authentication when user is already
+// identified by a token.
+func (r *Repo) RetrieveByID(ctx context.Context, id string) (*User, error) {
+	return r.retrieve(ctx, id)
+}
+
+func (r *Repo) retrieve(ctx context.Context, id string) (*User, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, db.ErrInvalidID
+	}
+
 	var u User
 	const q = `SELECT * FROM users WHERE user_id = $1`
 	if err := r.db.GetContext(ctx, &u, q, id); err != nil {
@@ -75,6 +113,30 @@ func (r *Repo) Retrieve(ctx context.Context, id string, roles []string) (*User,
 	return &u, nil
 }
 
+// RetrieveInOrg gets the specified user of the context organization. Users of
+// other organizations are reported as not found.
+func (r *Repo) RetrieveInOrg(ctx context.Context, id string) (*User, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, db.ErrInvalidID
+	}
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var u User
+	const q = `SELECT * FROM users WHERE user_id = $1 AND org_id = $2`
+	if err := r.db.GetContext(ctx, &u, q, id, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, db.ErrNotFound
+		}
+
+		return nil, errors.Wrapf(err, "selecting user %q", id)
+	}
+
+	return &u, nil
+}
+
 func containsRole(roles []string, role string) bool {
 	for _, r := range roles {
 		if r == role {
@@ -84,32 +146,43 @@ func containsRole(roles []string, role string) bool {
 	return false
 }
 
-// Create inserts a new user into the database.
+// Create inserts a new user of the context organization into the database.
 //func Create(ctx context.Context, db *sqlx.DB, n NewUser, now time.Time) (*User, error) {
-func (r *Repo) Create(ctx context.Context, name, email, password string, roles []string, now time.Time) (*User, error) {
+func (r *Repo) Create(ctx context.Context, name, email, pw string, roles []string, now time.Time) (*User, error) {
+	orgID, err := org.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkRoles(ctx, roles); err != nil {
+		return nil, err
+	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := newPasswordHash(pw)
 	if err != nil {
-		return nil, errors.Wrap(err, "generating password hash")
+		return nil, err
 	}
 
 	u := User{
-		ID:           uuid.New().String(),
-		Name:         name,
-		Email:        email,
-		PasswordHash: hash,
-		Roles:        roles,
-		DateCreated:  now.UTC(),
-		DateUpdated:  now.UTC(),
+		ID:            uuid.New().String(),
+		Name:          name,
+		Email:         email,
+		PasswordHash:  hash,
+		Roles:         roles,
+		OrgID:         orgID,
+		DateCreated:   now.UTC(),
+		DateUpdated:   now.UTC(),
+		EmailVerified: true,
+		Active:        true,
+		Version:       1,
 	}
 
 	const q = `INSERT INTO users
-		(user_id, name, email, password_hash, roles, date_created, date_updated)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+		(user_id, name, email, password_hash, roles, org_id, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
 	res, err := r.db.ExecContext(
 		ctx, q,
 		u.ID, u.Name, u.Email,
-		u.PasswordHash, u.Roles,
+		u.PasswordHash, u.Roles, u.OrgID,
 		u.DateCreated, u.DateUpdated,
 	)
 	if err != nil {
@@ -130,7 +203,7 @@ func (r *Repo) Create(ctx context.Context, name, email, password string, roles [
 // Authenticate finds a user by their email and verifies their password. On
 // success it returns a Claims value representing this user. The claims can be
 // used to generate a token for future authentication.
-func (r *Repo) Authenticate(ctx context.Context, email, password string) (User, error) {
+func (r *Repo) Authenticate(ctx context.Context, email, pw string) (User, error) {
 	const q = `SELECT * FROM users WHERE email = $1`
 
 	var u User
@@ -145,66 +218,188 @@ func (r *Repo) Authenticate(ctx context.Context, email, password string) (User,
 		return User{}, errors.Wrap(err, "selecting single user")
 	}
 
-	// Compare the provided password with the saved hash. Use the bcrypt
-	// comparison function so it is cryptographically secure.
-	if err := bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)); err != nil {
+	// Compare the provided password with the saved hash. Hash carries the
+	// algorithm and its parameters, so hashes computed by legacy hashers match too.
+	rehash, err := password.Verify(u.PasswordHash, pw)
+	if err != nil {
 		return User{}, db.ErrAuthenticationFailure
 	}
+
+	if !u.Active {
+		return User{}, ErrDeactivated
+	}
+	if !u.EmailVerified {
+		return User{}, ErrNotVerified
+	}
+
+	if rehash {
+		if err := r.rehashPassword(ctx, &u, pw); err != nil {
+			log.Sugar.Errorf("error on password rehash of user %s, error: %s", u.ID, err)
+		}
+	}
 	return u, nil
 }
 
-// Update replaces a user document in the database.
-func (r *Repo) Update(ctx context.Context, id string, email *string, roles []string,
-	password *string, now time.Time) error {
-	u, err := r.Retrieve(ctx, id, roles)
+// rehashPassword replaces outdated password hash of the user with the hash
+// computed by the default hasher. Password is not changed, so user version
+// and update date are kept.
+func (r *Repo) rehashPassword(ctx context.Context, u *User, pw string) error {
+	hash, err := password.Hash(pw)
 	if err != nil {
 		return err
 	}
 
-	if email != nil {
-		u.Email = *email
+	const q = `UPDATE users SET "password_hash" = $3 WHERE user_id = $1 AND password_hash = $2`
+	if _, err := r.db.ExecContext(ctx, q, u.ID, u.PasswordHash, hash); err != nil {
+		return errors.Wrap(err, "updating password hash")
 	}
-	if roles != nil {
-		u.Roles = roles
+	u.PasswordHash = hash
+	return nil
+}
+
+// newPasswordHash checks password against the password policy and returns its hash.
+func newPasswordHash(pw string) ([]byte, error) {
+	if err := password.Check(pw); err != nil {
+		return nil, err
+	}
+	hash, err := password.Hash(pw)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating password hash")
+	}
+	return hash, nil
+}
+
+// Update modifies the specified user in the database. Update is performed only
+// when passed version matches the stored one, otherwise db.ErrVersionConflict is returned.
+func (r *Repo) Update(ctx context.Context, id string, uu UpdateUser, version int, now time.Time) (*User, error) {
+	u, err := r.RetrieveInOrg(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Version != version {
+		return nil, db.ErrVersionConflict
+	}
+
+	if uu.Name != nil {
+		u.Name = *uu.Name
 	}
-	if password != nil {
-		pw, err := bcrypt.GenerateFromPassword([]byte(*password), bcrypt.DefaultCost)
+	if uu.Email != nil {
+		u.Email = *uu.Email
+	}
+	if uu.Roles != nil {
+		if err := r.checkRoles(ctx, uu.Roles); err != nil {
+			return nil, err
+		}
+		u.Roles = uu.Roles
+	}
+	if uu.Password != nil {
+		hash, err := newPasswordHash(*uu.Password)
 		if err != nil {
-			return errors.Wrap(err, "generating password hash")
+			return nil, err
+		}
+		u.PasswordHash = hash
+	}
+	if uu.OfficeID != nil {
+		if err := r.checkOffice(ctx, u.OrgID, *uu.OfficeID); err != nil {
+			return nil, err
+		}
+		u.OfficeID = nil
+		if *uu.OfficeID != "" {
+			u.OfficeID = uu.OfficeID
 		}
-		u.PasswordHash = pw
 	}
 
-	u.DateUpdated = now
+	u.DateUpdated = now.UTC()
 
 	const q = `UPDATE users SET
 		"name" = $2,
 		"email" = $3,
 		"roles" = $4,
 		"password_hash" = $5,
-		"date_updated" = $6
-		WHERE user_id = $1`
-	_, err = r.db.ExecContext(ctx, q, id,
+		"date_updated" = $6,
+		"office_id" = $9,
+		"version" = version + 1
+		WHERE user_id = $1 AND version = $7 AND org_id = $8`
+	result, err := r.db.ExecContext(ctx, q, id,
 		u.Name, u.Email, u.Roles,
-		u.PasswordHash, u.DateUpdated,
+		u.PasswordHash, u.DateUpdated, version, u.OrgID, u.OfficeID,
 	)
 	if err != nil {
-		return errors.Wrap(err, "updating user")
+		return nil, errors.Wrap(err, "updating user")
 	}
 
-	return nil
+	count, err := result.RowsAffected()
+	if err != nil {
+		return nil, errors.Wrap(err, "updated count")
+	}
+	if count == 0 {
+		return nil, db.ErrVersionConflict
+	}
+
+	if uu.Password != nil {
+		if err := r.deletePasswordResets(ctx, r.db, id); err != nil {
+			return nil, err
+		}
+	}
+
+	u.Version++
+	return u, nil
 }
 
-// Delete removes a user from the database.
-func (r *Repo) Delete(ctx context.Context, id string) error {
-	if _, err := uuid.Parse(id); err != nil {
-		return db.ErrInvalidID
+// Delete soft deletes a user, the row is kept so votes and restaurants of the user
+// keep their history. Deleted user is deactivated too, it can not authenticate or
+// vote until reactivated. Delete is performed only when passed version matches the
+// stored one, otherwise db.ErrVersionConflict is returned.
+func (r *Repo) Delete(ctx context.Context, id string, version int, now time.Time) error {
+	u, err := r.RetrieveInOrg(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if u.Version != version {
+		return db.ErrVersionConflict
 	}
 
-	const q = `DELETE FROM users WHERE user_id = $1`
-	if _, err := r.db.ExecContext(ctx, q, id); err != nil {
+	const q = `UPDATE users SET
+		"active" = FALSE,
+		"deleted_at" = $4,
+		"date_updated" = $4,
+		"version" = version + 1
+		WHERE user_id = $1 AND version = $2 AND org_id = $3 AND deleted_at IS NULL`
+	result, err := r.db.ExecContext(ctx, q, id, version, u.OrgID, now.UTC())
+	if err != nil {
 		return errors.Wrapf(err, "deleting user %s", id)
 	}
 
+	count, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "deleted count")
+	}
+	if count == 0 {
+		return db.ErrVersionConflict
+	}
+
+	return nil
+}
+
+// checkOffice verifies that home office belongs to the organization of the user,
+// empty office ID is accepted and removes the home office.
+func (r *Repo) checkOffice(ctx context.Context, orgID, officeID string) error {
+	if officeID == "" {
+		return nil
+	}
+	if _, err := uuid.Parse(officeID); err != nil {
+		return ErrUnknownOffice
+	}
+
+	var exists bool
+	const q = `SELECT EXISTS (SELECT 1 FROM office WHERE office_id = $1 AND org_id = $2)`
+	if err := r.db.GetContext(ctx, &exists, q, officeID, orgID); err != nil {
+		return errors.Wrapf(err, "selecting office %q", officeID)
+	}
+	if !exists {
+		return ErrUnknownOffice
+	}
 	return nil
 }